@@ -8,7 +8,6 @@ import (
 	"io"
 	"io/fs"
 	"log"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -31,7 +30,7 @@ import (
 
 var (
 	// Store for session management
-	cookieStore *sessions.CookieStore
+	sessionStore *auth.SessionStore
 )
 
 const (
@@ -53,60 +52,6 @@ const (
 
 // -- Logging Middleware --
 
-// Placeholder for trusted proxy IPs. Configure this based on your environment.
-// Example: var trustedProxies = []string{"192.168.1.1", "10.0.0.1"}
-var trustedProxies = []string{"127.0.0.1", "::1"} // Trust localhost/loopback by default
-
-// isTrustedProxy checks if a given remote address belongs to the trusted list
-func isTrustedProxy(remoteAddr string) bool {
-	// Attempt to split host and port, ignore port
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err != nil {
-		// If splitting fails, assume it might be just an IP
-		host = remoteAddr
-	}
-
-	for _, trusted := range trustedProxies {
-		if host == trusted {
-			return true
-		}
-	}
-	return false
-}
-
-// getClientIP extracts the client IP, considering X-Forwarded-For from trusted proxies
-func getClientIP(r *http.Request) string {
-	remoteAddr := r.RemoteAddr
-
-	if isTrustedProxy(remoteAddr) {
-		xff := r.Header.Get("X-Forwarded-For")
-		if xff != "" {
-			// X-Forwarded-For can be a comma-separated list (client, proxy1, proxy2)
-			ips := strings.Split(xff, ",")
-			if len(ips) > 0 {
-				clientIP := strings.TrimSpace(ips[0])
-				if clientIP != "" {
-					return clientIP // Return the first IP in the list
-				}
-			}
-		}
-
-		// Fallback: Check X-Real-IP if X-Forwarded-For is not useful
-		xRealIP := r.Header.Get("X-Real-IP")
-		if xRealIP != "" {
-			return strings.TrimSpace(xRealIP)
-		}
-	}
-
-	// Default to RemoteAddr if not proxied or header is missing/invalid
-	// Attempt to split host and port, return only host if successful
-	host, _, err := net.SplitHostPort(remoteAddr)
-	if err == nil {
-		return host
-	}
-	return remoteAddr // Return the raw RemoteAddr if splitting fails
-}
-
 // responseWriterWrapper wraps http.ResponseWriter to capture the status code
 type responseWriterWrapper struct {
 	http.ResponseWriter
@@ -134,7 +79,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(wrapper, r)
 
 		duration := time.Since(start)
-		clientIP := getClientIP(r) // Get client IP considering proxy headers
+		clientIP := middleware.ClientIP(r) // Get client IP considering proxy headers
 
 		// Log the request details including the captured status code and client IP
 		log.Printf(
@@ -151,8 +96,9 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 // -- End Logging Middleware --
 
-// initSessionStore initializes the cookie store for session management
-func initSessionStore() {
+// initSessionStore initializes the session store, which keeps sessions in the
+// database so that they can be revoked
+func initSessionStore(database *db.DB) {
 	// Get session key from environment variable
 	sessionKey := os.Getenv("SESSION_KEY")
 	if sessionKey == "" {
@@ -163,16 +109,16 @@ func initSessionStore() {
 	}
 
 	// Ensure the key length is appropriate if a default wasn't used,
-	// though CookieStore handles different key lengths.
+	// though the store handles different key lengths.
 	// Using a key derived potentially from a random source if needed,
 	// but here we just use the provided/default key directly.
 	// It's better to generate a strong key externally and set it via env var.
 
-	// Initialize the cookie store
-	cookieStore = sessions.NewCookieStore([]byte(sessionKey))
+	// Initialize the session store; the key signs its cookies
+	sessionStore = auth.NewSessionStore(models.NewSessionService(database), []byte(sessionKey))
 
 	// Configure session options (optional but recommended)
-	cookieStore.Options = &sessions.Options{
+	sessionStore.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   86400 * 7, // 7 days
 		HttpOnly: true,      // Prevent client-side script access
@@ -180,19 +126,124 @@ func initSessionStore() {
 		SameSite: http.SameSiteLaxMode,
 	}
 
+	// Signed cookies expire with the sessions
+	sessionStore.MaxAge(sessionStore.Options.MaxAge)
+
 	// Register types that will be stored in the session.
 	// We need to register basic types like int for the user ID.
 	gob.Register(0) // Register int type (specifically 0, but registers int generally)
 }
 
+// configureLogin sets up single sign-on and restricts password login as
+// configured in the environment:
+//
+//	OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_REDIRECT_URL  enable OIDC single sign-on
+//	OIDC_CLIENT_SECRET    client secret (omit for public clients)
+//	OIDC_NAME             name on the login button (default "Single sign-on")
+//	OIDC_SCOPES           space-separated scopes (default "openid profile email")
+//	OIDC_GROUPS_CLAIM     claim with the user's groups (default "groups")
+//	OIDC_ROLE_MAPPINGS    "group=role,group=role"; if set, roles follow groups at every login
+//	OIDC_DEFAULT_ROLE     role when no mapping matches (default "user")
+//	OIDC_PROVISION        "false" to only let existing users log in (default "true")
+//	LDAP_URL              ldap:// or ldaps:// URL; enables LDAP password login
+//	LDAP_START_TLS        "true" to upgrade ldap:// connections with StartTLS
+//	LDAP_INSECURE_SKIP_VERIFY  "true" to skip certificate verification (testing only)
+//	LDAP_BIND_DN_TEMPLATE DN to bind as, with {username}, for direct binds
+//	LDAP_BIND_DN, LDAP_BIND_PASSWORD  service account for search-then-bind
+//	LDAP_BASE_DN          where users are searched
+//	LDAP_USER_FILTER      filter finding users, with {username} (default "(uid={username})")
+//	LDAP_USERNAME_ATTRIBUTE  attribute with the username (default: the one LDAP_USER_FILTER matches)
+//	LDAP_EMAIL_ATTRIBUTE  attribute with the email (default "mail")
+//	LDAP_GROUP_ATTRIBUTE  attribute with the groups (default "memberOf")
+//	LDAP_ROLE_MAPPINGS    "group=role,group=role"; if set, roles follow groups at every login
+//	LDAP_DEFAULT_ROLE     role when no mapping matches (default "user")
+//	PASSWORD_LOGIN_DISABLED  "true" to allow password login only for BREAK_GLASS_USERNAME
+//	BREAK_GLASS_USERNAME  user who can always log in with a local password
+//	TWO_FACTOR_ISSUER     name of the app in authenticator apps (default "CyberAI")
+func configureLogin(authHandlers *auth.AuthHandlers) {
+	if issuer := os.Getenv("OIDC_ISSUER"); issuer != "" {
+		mappings, err := auth.ParseRoleMappings(os.Getenv("OIDC_ROLE_MAPPINGS"))
+		if err != nil {
+			log.Fatalf("Invalid OIDC_ROLE_MAPPINGS: %v", err)
+		}
+		scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "profile", "email"}
+		}
+		provider, err := auth.NewOIDCProvider(auth.OIDCConfig{
+			Name:         os.Getenv("OIDC_NAME"),
+			Issuer:       issuer,
+			ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+			ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+			RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+			Scopes:       scopes,
+			GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+			RoleMappings: mappings,
+			DefaultRole:  os.Getenv("OIDC_DEFAULT_ROLE"),
+			Provision:    os.Getenv("OIDC_PROVISION") != "false",
+		})
+		if err != nil {
+			log.Fatalf("Invalid OIDC configuration: %v", err)
+		}
+		authHandlers.OIDC = provider
+		log.Printf("OIDC single sign-on enabled with issuer %s", provider.Config.Issuer)
+	}
+
+	if url := os.Getenv("LDAP_URL"); url != "" {
+		mappings, err := auth.ParseRoleMappings(os.Getenv("LDAP_ROLE_MAPPINGS"))
+		if err != nil {
+			log.Fatalf("Invalid LDAP_ROLE_MAPPINGS: %v", err)
+		}
+		backend, err := auth.NewLDAPBackend(auth.LDAPConfig{
+			URL:                url,
+			StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+			InsecureSkipVerify: os.Getenv("LDAP_INSECURE_SKIP_VERIFY") == "true",
+			BindDNTemplate:     os.Getenv("LDAP_BIND_DN_TEMPLATE"),
+			BindDN:             os.Getenv("LDAP_BIND_DN"),
+			BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+			BaseDN:             os.Getenv("LDAP_BASE_DN"),
+			UserFilter:         os.Getenv("LDAP_USER_FILTER"),
+			UsernameAttribute:  os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+			EmailAttribute:     os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+			GroupAttribute:     os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		})
+		if err != nil {
+			log.Fatalf("Invalid LDAP configuration: %v", err)
+		}
+		defaultRole := os.Getenv("LDAP_DEFAULT_ROLE")
+		if defaultRole == "" {
+			defaultRole = "user"
+		}
+		authHandlers.UserService.Directory = &models.DirectoryLogin{
+			Backend:            backend,
+			RoleMappings:       mappings,
+			DefaultRole:        defaultRole,
+			BreakGlassUsername: os.Getenv("BREAK_GLASS_USERNAME"),
+		}
+		log.Printf("LDAP login enabled with server %s, local accounts as the fallback", url)
+	}
+
+	if os.Getenv("PASSWORD_LOGIN_DISABLED") == "true" {
+		authHandlers.PasswordLoginDisabled = true
+		authHandlers.BreakGlassUsername = os.Getenv("BREAK_GLASS_USERNAME")
+		if authHandlers.BreakGlassUsername == "" {
+			log.Printf("WARNING: Password login is disabled and BREAK_GLASS_USERNAME is not set. Nobody can log in with a password.")
+		} else {
+			log.Printf("Password login is disabled except for break-glass user %s", authHandlers.BreakGlassUsername)
+		}
+		if authHandlers.OIDC == nil {
+			log.Printf("WARNING: Password login is disabled but OIDC single sign-on is not configured.")
+		}
+	}
+
+	authHandlers.TwoFactorIssuer = os.Getenv("TWO_FACTOR_ISSUER")
+}
+
 func main() {
 	// Log startup information
 	log.Printf("Starting CyberAI Server")
 	log.Printf("OS: %s, Architecture: %s", runtime.GOOS, runtime.GOARCH)
 
-	// Initialize session store early
-	initSessionStore()
-
 	// Create a new WebSocket hub
 	log.Println("Creating WebSocket hub")
 	hub := ws.NewHub()
@@ -205,16 +256,24 @@ func main() {
 	}
 	log.Println("Database initialized successfully")
 
+	// Sessions are kept in the database
+	initSessionStore(database)
+
 	// Initialize services
 	modelService := models.NewModelService(database)
 	agentService := models.NewAgentService(database)
 	chatService := models.NewChatService(database, hub)
 	providerService := models.NewProviderService(database)
+	projectService := models.NewProjectService(database)
+	profileService := models.NewProfileService(database)
+	// MCP tool manager keeps connections to registered MCP servers
+	mcpToolManager := llm.NewMCPToolManager(models.NewMCPService(database))
+	defer mcpToolManager.Close()
 	// Pass chatService and agentService to ConnectorService constructor
-	connectorService := llm.NewConnectorService(modelService, providerService, chatService, agentService)
+	connectorService := llm.NewConnectorService(modelService, providerService, chatService, agentService, projectService, profileService, mcpToolManager)
 
 	// Create and start HTTP server
-	server := setupServer(hub, database, modelService, chatService, connectorService, cookieStore)
+	server := setupServer(hub, database, modelService, chatService, projectService, profileService, connectorService, sessionStore)
 
 	// Get port, defaulting to 8080 if not specified
 	port := os.Getenv("PORT")
@@ -304,7 +363,7 @@ func serveFileFromFS(fsys fs.FS, fileName string, w http.ResponseWriter, r *http
 	http.ServeContent(w, r, stat.Name(), stat.ModTime(), seeker)
 }
 
-func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService, chatService *models.ChatService, connectorService *llm.ConnectorService, store *sessions.CookieStore) *http.Server {
+func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService, chatService *models.ChatService, projectService *models.ProjectService, profileService *models.ProfileService, connectorService *llm.ConnectorService, store *auth.SessionStore) *http.Server {
 	// Create router
 	mux := http.NewServeMux()
 
@@ -320,12 +379,19 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 	// Initialize services needed by handlers
 	userService := models.NewUserService(database)
 	authHandlers := auth.NewAuthHandlers(store, userService)
+	authHandlers.Audit = models.NewAuditService(database)
+	authHandlers.Sessions = store.Sessions
+	authHandlers.Hub = hub
+	authHandlers.Tokens = models.NewAPITokenService(database)
+	configureLogin(authHandlers)
 
 	// Create handlers
-	adminHandlers := handlers.NewAdminHandlers(database, templatesFS)
+	adminHandlers := handlers.NewAdminHandlers(database, hub, templatesFS)
+	mcpHandlers := handlers.NewMCPHandlers(connectorService.GetToolManager())
 	modelHandlers := handlers.NewModelHandlers(modelService)
-	chatHandlers := handlers.NewChatHandlers(chatService, hub, connectorService)
-	userHandlers := handlers.NewUserHandlers(userService)
+	chatHandlers := handlers.NewChatHandlers(chatService, projectService, modelService, hub, connectorService)
+	hub.SetChatAuthorizer(chatHandlers.CanAccessChat) // Participants of shared chats follow them live
+	userHandlers := handlers.NewUserHandlers(userService, profileService)
 	// Create other handlers (e.g., auth) here later
 
 	// Define Middleware
@@ -334,7 +400,15 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 	// authMiddleware := middleware.TempAdminAuthMiddleware
 	// NEW Middleware will be defined here using the store
 	sessionAuth := middleware.SessionAuthMiddleware(store, userService)
-	adminRequired := middleware.AdminRequiredMiddleware(store, userService)
+	// The user API also accepts API tokens; account security (2FA, sessions,
+	// tokens) and the admin area need a session
+	apiAuth := middleware.APITokenAuthMiddleware(authHandlers.Tokens, userService, sessionAuth)
+	// The admin area is open to every role with an admin permission; each
+	// admin route then checks the permission it needs
+	adminRequired := middleware.PermissionRequiredMiddleware(store, userService, models.AdminPermissions...)
+	requirePermission := func(permissions ...string) func(http.Handler) http.Handler {
+		return middleware.RequirePermission(userService, permissions...)
+	}
 
 	// Custom 404 handler using embedded file
 	notFoundHandler := func(w http.ResponseWriter, r *http.Request) {
@@ -370,8 +444,9 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 	// Admin API routes (Protected by adminRequired middleware)
 	// We wrap the registration function with the middleware
 	adminMux := http.NewServeMux()
-	// Pass the adminRequired middleware to the registration function
-	adminHandlers.RegisterAdminRoutes(adminMux, adminRequired)
+	// Pass the per-route permission middleware to the registration function
+	adminHandlers.RegisterAdminRoutes(adminMux, requirePermission)
+	mcpHandlers.RegisterAdminRoutes(adminMux, requirePermission)
 
 	// Explicitly handle the GET /admin route for the page, protected by middleware
 	mux.Handle("GET /admin", adminRequired(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -381,20 +456,37 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 	// Attach the adminMux handlers under the /api/admin/ prefix, as per API.md
 	mux.Handle("/api/admin/", adminRequired(http.StripPrefix("/api/admin", adminMux))) // NOTE: Using StripPrefix
 
-	// User API routes (Protected by sessionAuth middleware)
+	// User API routes (Protected by apiAuth middleware: a session or an API token)
 	userApiMux := http.NewServeMux()
-	modelHandlers.RegisterUserRoutes(userApiMux, sessionAuth) // Pass middleware to handler registration if needed, or wrap here
-	chatHandlers.RegisterUserRoutes(userApiMux, sessionAuth)  // Pass middleware to handler registration if needed, or wrap here
-	// userHandlers.RegisterUserSelfRoutes(userApiMux, sessionAuth) // REMOVE - Register /api/user/me directly below
-	// Handle API base paths with the user mux protected by sessionAuth
-	// mux.Handle("/api/users/", sessionAuth(userApiMux)) // REMOVE - No longer needed if /api/user/me is separate
-	mux.Handle("/api/chats", sessionAuth(userApiMux)) // Assuming chat routes start with /api/chats
-	mux.Handle("/api/chats/", sessionAuth(userApiMux))
-	mux.Handle("/api/models", sessionAuth(userApiMux)) // Assuming model routes start with /api/models
-	mux.Handle("/api/models/", sessionAuth(userApiMux))
-
-	// Register the /api/user/me route directly and apply sessionAuth middleware
-	mux.Handle("GET /api/user/me", sessionAuth(http.HandlerFunc(userHandlers.GetCurrentUser)))
+	modelHandlers.RegisterUserRoutes(userApiMux, apiAuth) // Pass middleware to handler registration if needed, or wrap here
+	chatHandlers.RegisterUserRoutes(userApiMux, apiAuth)  // Pass middleware to handler registration if needed, or wrap here
+	// Handle API base paths with the user mux protected by apiAuth
+	mux.Handle("/api/chats", apiAuth(userApiMux)) // Assuming chat routes start with /api/chats
+	mux.Handle("/api/chats/", apiAuth(userApiMux))
+	mux.Handle("/api/models", apiAuth(userApiMux)) // Assuming model routes start with /api/models
+	mux.Handle("/api/models/", apiAuth(userApiMux))
+	mux.Handle("/api/folders", apiAuth(userApiMux)) // Chat folders and tags
+	mux.Handle("/api/folders/", apiAuth(userApiMux))
+	mux.Handle("/api/tags", apiAuth(userApiMux))
+	mux.Handle("/api/projects", apiAuth(userApiMux))
+	mux.Handle("/api/projects/", apiAuth(userApiMux))
+
+	// The current user, their custom instructions and memories, protected by apiAuth
+	userHandlers.RegisterUserSelfRoutes(mux, apiAuth)
+	// Two-factor authentication settings of the current user
+	mux.Handle("GET /api/user/me/2fa", sessionAuth(http.HandlerFunc(authHandlers.GetTwoFactorStatus)))
+	mux.Handle("POST /api/user/me/2fa/enroll", sessionAuth(http.HandlerFunc(authHandlers.StartTwoFactorEnrollment)))
+	mux.Handle("POST /api/user/me/2fa/enable", sessionAuth(http.HandlerFunc(authHandlers.EnableTwoFactor)))
+	mux.Handle("POST /api/user/me/2fa/recovery-codes", sessionAuth(http.HandlerFunc(authHandlers.RegenerateRecoveryCodes)))
+	mux.Handle("DELETE /api/user/me/2fa", sessionAuth(http.HandlerFunc(authHandlers.DisableTwoFactor)))
+	// Where the current user is logged in
+	mux.Handle("GET /api/user/me/sessions", sessionAuth(http.HandlerFunc(authHandlers.ListSessions)))
+	mux.Handle("DELETE /api/user/me/sessions", sessionAuth(http.HandlerFunc(authHandlers.RevokeOtherSessions)))
+	mux.Handle("DELETE /api/user/me/sessions/{session_id}", sessionAuth(http.HandlerFunc(authHandlers.RevokeSession)))
+	// API tokens of the current user
+	mux.Handle("GET /api/user/me/tokens", sessionAuth(http.HandlerFunc(authHandlers.ListAPITokens)))
+	mux.Handle("POST /api/user/me/tokens", sessionAuth(requirePermission(models.PermUseAPITokens)(http.HandlerFunc(authHandlers.CreateAPIToken))))
+	mux.Handle("DELETE /api/user/me/tokens/{token_id}", sessionAuth(http.HandlerFunc(authHandlers.RevokeAPIToken)))
 
 	// Register API endpoint for basic info (Public - No auth middleware)
 	mux.HandleFunc("/api/info", func(w http.ResponseWriter, r *http.Request) {
@@ -422,9 +514,13 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 		// Add userID to context for the WS handler (though ServeWS might need direct passing)
 		ctx := context.WithValue(r.Context(), middleware.UserIDContextKey, userID)
 		log.Printf("WebSocket: Authorized access for User ID: %d", userID)
-		ws.ServeWS(hub, w, r.WithContext(ctx)) // Pass context
+		ws.ServeWS(hub, w, r.WithContext(ctx), models.HashSessionToken(session.ID)) // Pass context
 	})
 
+	// Register public share links; the optional session only identifies
+	// logged-in visitors for links restricted to them
+	chatHandlers.RegisterShareRoutes(mux, middleware.OptionalSessionMiddleware(store))
+
 	// --- End API Routes ---
 
 	// --- Add Login/Logout routes ---
@@ -436,6 +532,15 @@ func setupServer(hub *ws.Hub, database *db.DB, modelService *models.ModelService
 	mux.HandleFunc("POST /login", authHandlers.Login)
 	// /logout can be GET or POST based on frontend implementation
 	mux.HandleFunc("/logout", authHandlers.Logout)
+	// Single sign-on (only active if OIDC is configured) and the login options
+	mux.HandleFunc("GET /auth/config", authHandlers.LoginOptions)
+	mux.HandleFunc("GET /auth/oidc/login", authHandlers.OIDCLogin)
+	mux.HandleFunc("GET /auth/oidc/callback", authHandlers.OIDCCallback)
+	// The second login step of users with two-factor authentication, using
+	// the pending login that POST /login stored in the session
+	mux.HandleFunc("POST /auth/2fa/verify", authHandlers.VerifyTwoFactor)
+	mux.HandleFunc("POST /auth/2fa/enroll", authHandlers.EnrollTwoFactorAtLogin)
+	mux.HandleFunc("POST /auth/2fa/enroll/confirm", authHandlers.ConfirmTwoFactorAtLogin)
 
 	// Main handler for root and other paths - this is the catch-all handler
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+	"github.com/ramborogers/cyberai/server/llm"
+	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/ws"
+)
+
+// newTestServer serves the routes of setupServer from a new database, which
+// has the default admin user
+func newTestServer(t *testing.T) (*httptest.Server, *db.DB) {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "cyberai.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	initSessionStore(database)
+
+	hub := ws.NewHub()
+	go hub.Run()
+	modelService := models.NewModelService(database)
+	chatService := models.NewChatService(database, hub)
+	projectService := models.NewProjectService(database)
+	profileService := models.NewProfileService(database)
+	mcpToolManager := llm.NewMCPToolManager(models.NewMCPService(database))
+	t.Cleanup(mcpToolManager.Close)
+	connectorService := llm.NewConnectorService(modelService, models.NewProviderService(database), chatService,
+		models.NewAgentService(database), projectService, profileService, mcpToolManager)
+
+	server := httptest.NewServer(setupServer(hub, database, modelService, chatService, projectService, profileService, connectorService, sessionStore).Handler)
+	t.Cleanup(server.Close)
+	return server, database
+}
+
+// login returns a client logged in to the server with the password, which
+// does not follow redirects
+func login(t *testing.T, server *httptest.Server, username, password string) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("cookiejar.New: %v", err)
+	}
+	client := &http.Client{
+		Jar:           jar,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	resp, err := client.Post(server.URL+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login status = %d", resp.StatusCode)
+	}
+	return client
+}
+
+// do sends the request with a JSON body, if any, decoding the JSON response
+// into out, if any, and returns the status
+func do(t *testing.T, client *http.Client, method, url string, body, out interface{}) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestUserRoutes checks that the user API is mounted: logged-in users reach
+// each route, and anonymous requests are sent to the login page
+func TestUserRoutes(t *testing.T) {
+	server, _ := newTestServer(t)
+	client := login(t, server, "admin", "admin")
+	anonymous := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	var folder struct{ ID int64 }
+	if status := do(t, client, http.MethodPost, server.URL+"/api/folders", map[string]string{"name": "Work"}, &folder); status != http.StatusCreated {
+		t.Fatalf("POST /api/folders = %d, want %d", status, http.StatusCreated)
+	}
+	var project struct{ ID int64 }
+	if status := do(t, client, http.MethodPost, server.URL+"/api/projects", map[string]string{"name": "Launch"}, &project); status != http.StatusCreated {
+		t.Fatalf("POST /api/projects = %d, want %d", status, http.StatusCreated)
+	}
+	var file struct{ ID int64 }
+	projectPath := fmt.Sprintf("/api/projects/%d", project.ID)
+	if status := do(t, client, http.MethodPost, server.URL+projectPath+"/files", map[string]string{"name": "notes.md", "content": "Ship it"}, &file); status != http.StatusCreated {
+		t.Fatalf("POST %s/files = %d, want %d", projectPath, status, http.StatusCreated)
+	}
+	filePath := fmt.Sprintf("%s/files/%d", projectPath, file.ID)
+	var memory struct{ ID int64 }
+	if status := do(t, client, http.MethodPost, server.URL+"/api/user/me/memories", map[string]string{"content": "Lives in Paris"}, &memory); status != http.StatusCreated {
+		t.Fatalf("POST /api/user/me/memories = %d, want %d", status, http.StatusCreated)
+	}
+	memoryPath := fmt.Sprintf("/api/user/me/memories/%d", memory.ID)
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{http.MethodGet, "/api/chats", nil, http.StatusOK},
+		{http.MethodGet, "/api/folders", nil, http.StatusOK},
+		{http.MethodPut, fmt.Sprintf("/api/folders/%d", folder.ID), map[string]string{"name": "Home"}, http.StatusOK},
+		{http.MethodDelete, fmt.Sprintf("/api/folders/%d", folder.ID), nil, http.StatusNoContent},
+		{http.MethodGet, "/api/tags", nil, http.StatusOK},
+		{http.MethodGet, "/api/projects", nil, http.StatusOK},
+		{http.MethodGet, projectPath, nil, http.StatusOK},
+		{http.MethodPut, projectPath, map[string]string{"name": "Launch", "instructions": "Be brief"}, http.StatusOK},
+		{http.MethodGet, filePath, nil, http.StatusOK},
+		{http.MethodDelete, filePath, nil, http.StatusNoContent},
+		{http.MethodDelete, projectPath, nil, http.StatusNoContent},
+		{http.MethodGet, "/api/user/me", nil, http.StatusOK},
+		{http.MethodGet, "/api/user/me/instructions", nil, http.StatusOK},
+		{http.MethodPut, "/api/user/me/instructions", map[string]string{"custom_instructions": "Answer in French"}, http.StatusOK},
+		{http.MethodGet, "/api/user/me/memories", nil, http.StatusOK},
+		{http.MethodPut, memoryPath, map[string]string{"content": "Lives in Lyon"}, http.StatusOK},
+		{http.MethodDelete, memoryPath, nil, http.StatusNoContent},
+		{http.MethodDelete, "/api/user/me/memories", nil, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		if got := do(t, anonymous, tt.method, server.URL+tt.path, tt.body, nil); got != http.StatusFound {
+			t.Errorf("anonymous %s %s = %d, want %d", tt.method, tt.path, got, http.StatusFound)
+		}
+		if got := do(t, client, tt.method, server.URL+tt.path, tt.body, nil); got != tt.want {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// bearer is a client authenticating with an API token
+type bearer struct {
+	token string
+}
+
+func (b bearer) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", "Bearer "+b.token)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+// tokenClient returns a client authenticating with the token, which does not
+// follow redirects
+func tokenClient(token string) *http.Client {
+	return &http.Client{
+		Transport:     bearer{token},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	server, database := newTestServer(t)
+	user := &models.User{Username: "uma", Email: "uma@example.com", RoleID: models.UserRoleID, IsActive: true}
+	if err := models.NewUserService(database).CreateUser(user, "password1"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	client := login(t, server, "uma", "password1")
+
+	var created struct {
+		ID    int64
+		Token string
+	}
+	if status := do(t, client, http.MethodPost, server.URL+"/api/user/me/tokens", map[string]interface{}{"name": "CI", "expires_in_days": 30}, &created); status != http.StatusCreated {
+		t.Fatalf("POST /api/user/me/tokens = %d, want %d", status, http.StatusCreated)
+	}
+	withToken := tokenClient(created.Token)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/api/chats", http.StatusOK},
+		{http.MethodGet, "/api/user/me", http.StatusOK},
+		{http.MethodGet, "/api/user/me/memories", http.StatusOK},
+		// Account security and the admin area need a session
+		{http.MethodGet, "/api/user/me/tokens", http.StatusFound},
+		{http.MethodPost, "/api/user/me/tokens", http.StatusFound},
+		{http.MethodGet, "/api/user/me/sessions", http.StatusFound},
+		{http.MethodGet, "/api/admin/users", http.StatusFound},
+	}
+	for _, tt := range tests {
+		if got := do(t, withToken, tt.method, server.URL+tt.path, nil, nil); got != tt.want {
+			t.Errorf("%s %s with the token = %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+	if got := do(t, tokenClient(created.Token+"x"), http.MethodGet, server.URL+"/api/chats", nil, nil); got != http.StatusUnauthorized {
+		t.Errorf("GET /api/chats with a wrong token = %d, want %d", got, http.StatusUnauthorized)
+	}
+
+	// Taking the permission away stops the token, and creating others
+	if _, err := database.Exec(`UPDATE roles SET permissions = json_remove(permissions, '$.use_api_tokens') WHERE id = ?`, models.UserRoleID); err != nil {
+		t.Fatalf("removing the permission: %v", err)
+	}
+	if got := do(t, withToken, http.MethodGet, server.URL+"/api/chats", nil, nil); got != http.StatusForbidden {
+		t.Errorf("GET /api/chats without the permission = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := do(t, client, http.MethodPost, server.URL+"/api/user/me/tokens", map[string]string{"name": "CI"}, nil); got != http.StatusForbidden {
+		t.Errorf("POST /api/user/me/tokens without the permission = %d, want %d", got, http.StatusForbidden)
+	}
+	if _, err := database.Exec(`UPDATE roles SET permissions = json_set(permissions, '$.use_api_tokens', json('true')) WHERE id = ?`, models.UserRoleID); err != nil {
+		t.Fatalf("granting the permission: %v", err)
+	}
+
+	// Deactivated users' tokens stop working
+	if _, err := database.Exec(`UPDATE users SET is_active = 0 WHERE id = ?`, user.ID); err != nil {
+		t.Fatalf("deactivating: %v", err)
+	}
+	if got := do(t, withToken, http.MethodGet, server.URL+"/api/chats", nil, nil); got != http.StatusUnauthorized {
+		t.Errorf("GET /api/chats as a deactivated user = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if _, err := database.Exec(`UPDATE users SET is_active = 1 WHERE id = ?`, user.ID); err != nil {
+		t.Fatalf("reactivating: %v", err)
+	}
+
+	// And so do revoked tokens
+	tokenPath := fmt.Sprintf("/api/user/me/tokens/%d", created.ID)
+	if got := do(t, client, http.MethodDelete, server.URL+tokenPath, nil, nil); got != http.StatusNoContent {
+		t.Fatalf("DELETE %s = %d, want %d", tokenPath, got, http.StatusNoContent)
+	}
+	if got := do(t, withToken, http.MethodGet, server.URL+"/api/chats", nil, nil); got != http.StatusUnauthorized {
+		t.Errorf("GET /api/chats with a revoked token = %d, want %d", got, http.StatusUnauthorized)
+	}
+}
+
+// fakeOllama answers chat requests like Ollama. The reply of the "slow"
+// model stops after its first chunk until release is closed. It records the
+// requests that are not streamed (e.g. for titles) and how many streams were
+// in progress when each arrived.
+type fakeOllama struct {
+	release chan struct{}
+
+	mu        sync.Mutex
+	streaming int
+	requests  []string // Model and streams in progress of each request not streamed
+}
+
+func (f *fakeOllama) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	line := func(content string, done bool) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": map[string]string{"role": "assistant", "content": content},
+			"done":    done,
+		})
+		w.(http.Flusher).Flush()
+	}
+
+	f.mu.Lock()
+	if !req.Stream {
+		f.requests = append(f.requests, fmt.Sprintf("%s during %d streams", req.Model, f.streaming))
+		f.mu.Unlock()
+		line("Greetings", true)
+		return
+	}
+	f.streaming++
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.streaming--
+		f.mu.Unlock()
+	}()
+
+	line("Reply from ", false)
+	if req.Model == "slow" {
+		<-f.release
+	}
+	line(req.Model, true)
+}
+
+// eventually waits for the condition to hold
+func eventually(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(10 * time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+// TestCompareResponses checks that replies compared side by side leave the
+// active branch alone until all are done, and that only the chosen one is
+// used to title the chat
+func TestCompareResponses(t *testing.T) {
+	server, database := newTestServer(t)
+	client := login(t, server, "admin", "admin")
+	ollama := &fakeOllama{release: make(chan struct{})}
+	ollamaServer := httptest.NewServer(ollama)
+	t.Cleanup(ollamaServer.Close)
+	t.Cleanup(func() {
+		select {
+		case <-ollama.release:
+		default:
+			close(ollama.release)
+		}
+	})
+
+	provider := &models.Provider{Name: "Ollama", Type: models.ProviderOllama, BaseURL: ollamaServer.URL}
+	if err := models.NewProviderService(database).CreateProvider(provider); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	modelService := models.NewModelService(database)
+	var modelIDs []int64
+	for _, name := range []string{"slow", "fast"} {
+		model := &models.Model{ProviderID: provider.ID, Name: name, ModelID: name, MaxTokens: 100, IsActive: true}
+		if err := modelService.CreateModel(model); err != nil {
+			t.Fatalf("CreateModel: %v", err)
+		}
+		modelIDs = append(modelIDs, model.ID)
+	}
+
+	var chat struct{ ID int64 }
+	if status := do(t, client, http.MethodPost, server.URL+"/api/chats", map[string]string{}, &chat); status != http.StatusCreated {
+		t.Fatalf("POST /api/chats = %d, want %d", status, http.StatusCreated)
+	}
+	var question struct{ ID int64 }
+	messagesURL := fmt.Sprintf("%s/api/chats/%d/messages", server.URL, chat.ID)
+	if status := do(t, client, http.MethodPost, messagesURL, map[string]interface{}{"content": "Hello", "model_ids": modelIDs}, &question); status != http.StatusAccepted {
+		t.Fatalf("POST %s = %d, want %d", messagesURL, status, http.StatusAccepted)
+	}
+
+	activeMessage := func() int64 {
+		var id int64
+		if err := database.QueryRow(`SELECT active_message_id FROM chats WHERE id = ?`, chat.ID).Scan(&id); err != nil {
+			t.Fatalf("reading the active message: %v", err)
+		}
+		return id
+	}
+	reply := func(model string) (id int64, content string) {
+		err := database.QueryRow(`
+			SELECT m.id, m.content FROM messages m JOIN models md ON md.id = m.model_id
+			WHERE m.chat_id = ? AND md.model_id = ?
+		`, chat.ID, model).Scan(&id, &content)
+		if err != nil {
+			return 0, ""
+		}
+		return id, content
+	}
+
+	// The fast reply is done and the slow one has started
+	eventually(t, "the fast reply", func() bool {
+		_, fast := reply("fast")
+		slowID, _ := reply("slow")
+		return fast == "Reply from fast" && slowID != 0
+	})
+	if got := activeMessage(); got != question.ID {
+		t.Errorf("active message while comparing = %d, want the question %d", got, question.ID)
+	}
+
+	close(ollama.release)
+	slowID, _ := reply("slow")
+	eventually(t, "the comparison to settle", func() bool { return activeMessage() != question.ID })
+	if got := activeMessage(); got != slowID {
+		t.Errorf("active message = %d, want the reply of the first model %d", got, slowID)
+	}
+
+	eventually(t, "the title", func() bool {
+		var title string
+		database.QueryRow(`SELECT title FROM chats WHERE id = ?`, chat.ID).Scan(&title)
+		return title == "Greetings"
+	})
+	ollama.mu.Lock()
+	defer ollama.mu.Unlock()
+	if want := []string{"slow during 0 streams"}; fmt.Sprint(ollama.requests) != fmt.Sprint(want) {
+		t.Errorf("requests not streamed = %q, want %q", ollama.requests, want)
+	}
+}
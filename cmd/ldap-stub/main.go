@@ -0,0 +1,45 @@
+// Command ldap-stub is a minimal LDAP server for trying CyberAI's LDAP login
+// locally. It serves a small built-in directory under dc=example,dc=com:
+//
+//	uid=alice,ou=people  password "alice", member of cn=admins and cn=staff
+//	uid=bob,ou=people    password "bob", member of cn=staff
+//	cn=reader            password "reader", a service account for searches
+//
+// It implements simple binds and searches with equality, presence, and, or
+// and not filters, and nothing else: no TLS, no writes, no access control.
+// Do not use it for anything but testing. The server itself is in
+// server/auth/ldaptest.
+//
+// Run it and point CyberAI at it, with a direct bind:
+//
+//	go run ./cmd/ldap-stub -addr :3389
+//	LDAP_URL=ldap://localhost:3389 \
+//	LDAP_BIND_DN_TEMPLATE='uid={username},ou=people,dc=example,dc=com' \
+//	LDAP_ROLE_MAPPINGS=admins=admin go run ./cmd/cyberai
+//
+// or with search-then-bind:
+//
+//	LDAP_URL=ldap://localhost:3389 LDAP_BASE_DN=dc=example,dc=com \
+//	LDAP_BIND_DN=cn=reader,dc=example,dc=com LDAP_BIND_PASSWORD=reader \
+//	LDAP_ROLE_MAPPINGS=admins=admin go run ./cmd/cyberai
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/ramborogers/cyberai/server/auth/ldaptest"
+)
+
+func main() {
+	addr := flag.String("addr", ":3389", "address to listen on")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v", err)
+	}
+	log.Printf("LDAP stub listening on %s with base DN dc=example,dc=com", *addr)
+	log.Fatalf("Failed to accept connection: %v", ldaptest.NewServer().Serve(listener))
+}
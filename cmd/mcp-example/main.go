@@ -0,0 +1,150 @@
+// Command mcp-example is a minimal stdio MCP server exposing a couple of
+// trivial tools. It is intended for exercising CyberAI's MCP integration
+// locally: register it as an MCP server with transport "stdio" and command
+// set to the path of the built binary.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type textContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+var tools = []map[string]interface{}{
+	{
+		"name":        "echo",
+		"description": "Echoes the given text back.",
+		"inputSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"text": map[string]interface{}{"type": "string", "description": "Text to echo"},
+			},
+			"required": []string{"text"},
+		},
+	},
+	{
+		"name":        "add",
+		"description": "Adds two numbers.",
+		"inputSchema": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"a": map[string]interface{}{"type": "number"},
+				"b": map[string]interface{}{"type": "number"},
+			},
+			"required": []string{"a", "b"},
+		},
+	},
+	{
+		"name":        "current_time",
+		"description": "Returns the current server time in RFC 3339 format.",
+		"inputSchema": map[string]interface{}{"type": "object", "properties": map[string]interface{}{}},
+	},
+}
+
+func main() {
+	// Logs go to stderr; stdout is reserved for protocol messages.
+	log.SetOutput(os.Stderr)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	encoder := json.NewEncoder(os.Stdout)
+
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Printf("invalid message: %v", err)
+			continue
+		}
+		if len(req.ID) == 0 {
+			continue // Notification
+		}
+
+		resp := response{JSONRPC: "2.0", ID: req.ID}
+		result, err := handle(req)
+		if err != nil {
+			resp.Error = err
+		} else {
+			resp.Result = result
+		}
+		if err := encoder.Encode(resp); err != nil {
+			log.Fatalf("failed to write response: %v", err)
+		}
+	}
+}
+
+func handle(req request) (interface{}, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"protocolVersion": "2025-03-26",
+			"capabilities":    map[string]interface{}{"tools": map[string]interface{}{}},
+			"serverInfo":      map[string]string{"name": "mcp-example", "version": "0.1.0"},
+		}, nil
+	case "ping":
+		return map[string]interface{}{}, nil
+	case "tools/list":
+		return map[string]interface{}{"tools": tools}, nil
+	case "tools/call":
+		var params struct {
+			Name      string                 `json:"name"`
+			Arguments map[string]interface{} `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &rpcError{Code: -32602, Message: "invalid params"}
+		}
+		text, isError := callTool(params.Name, params.Arguments)
+		return map[string]interface{}{
+			"content": []textContent{{Type: "text", Text: text}},
+			"isError": isError,
+		}, nil
+	default:
+		return nil, &rpcError{Code: -32601, Message: "method not found: " + req.Method}
+	}
+}
+
+func callTool(name string, args map[string]interface{}) (string, bool) {
+	switch name {
+	case "echo":
+		text, _ := args["text"].(string)
+		return text, false
+	case "add":
+		a, okA := args["a"].(float64)
+		b, okB := args["b"].(float64)
+		if !okA || !okB {
+			return "both 'a' and 'b' must be numbers", true
+		}
+		return strings.TrimSuffix(fmt.Sprintf("%g", a+b), ".0"), false
+	case "current_time":
+		return time.Now().Format(time.RFC3339), false
+	default:
+		return "unknown tool: " + name, true
+	}
+}
@@ -0,0 +1,41 @@
+// Command oidc-stub is a minimal OpenID Connect identity provider for trying
+// CyberAI's single sign-on locally. Its login page lets you sign in as any
+// user with any groups, and it signs ID tokens with a key generated at start.
+// It implements discovery, the authorization code flow with PKCE, JWKS and
+// userinfo, and nothing else: do not use it for anything but testing. The
+// provider itself is in server/auth/oidctest.
+//
+// Run it and point CyberAI at it:
+//
+//	go run ./cmd/oidc-stub -addr :9000
+//	OIDC_ISSUER=http://localhost:9000 OIDC_CLIENT_ID=cyberai \
+//	OIDC_REDIRECT_URL=http://localhost:8080/auth/oidc/callback \
+//	OIDC_ROLE_MAPPINGS=admins=admin go run ./cmd/cyberai
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ramborogers/cyberai/server/auth/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9000", "address to listen on")
+	issuer := flag.String("issuer", "", "issuer URL (default http://localhost<addr>)")
+	clientID := flag.String("client-id", "cyberai", "the only client ID accepted")
+	secret := flag.String("client-secret", "", "client secret to require (none by default)")
+	flag.Parse()
+
+	if *issuer == "" {
+		*issuer = "http://localhost" + *addr
+	}
+	provider, err := oidctest.NewProvider(*issuer, *clientID, *secret)
+	if err != nil {
+		log.Fatalf("Failed to create the provider: %v", err)
+	}
+
+	log.Printf("OIDC stub listening on %s with issuer %s and client ID %s", *addr, provider.Issuer, provider.ClientID)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
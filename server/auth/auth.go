@@ -8,12 +8,27 @@ import (
 	"github.com/gorilla/sessions"
 	"github.com/ramborogers/cyberai/server/middleware" // For context key and session name
 	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/ws"
 )
 
 // AuthHandlers provides handlers for authentication.
 type AuthHandlers struct {
 	Store       sessions.Store
 	UserService *models.UserService
+	Sessions    *models.SessionService  // Lists and revokes the user's sessions
+	Hub         *ws.Hub                 // Closes the WebSockets of revoked sessions; may be nil
+	Tokens      *models.APITokenService // Lists, creates and revokes the user's API tokens
+	OIDC        *OIDCProvider           // Single sign-on; nil if not configured
+
+	// PasswordLoginDisabled restricts password login to BreakGlassUsername,
+	// an admin account for when single sign-on is unavailable
+	PasswordLoginDisabled bool
+	BreakGlassUsername    string
+
+	// Audit records security events; nil disables recording
+	Audit *models.AuditService
+	// TwoFactorIssuer names the app in authenticator apps (default "CyberAI")
+	TwoFactorIssuer string
 }
 
 // NewAuthHandlers creates new authentication handlers.
@@ -43,6 +58,12 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if h.PasswordLoginDisabled && (h.BreakGlassUsername == "" || creds.Username != h.BreakGlassUsername) {
+		log.Printf("Login refused for user '%s': password login is disabled", creds.Username)
+		http.Error(w, "Password login is disabled, please sign in with single sign-on", http.StatusForbidden)
+		return
+	}
+
 	// Authenticate user
 	user, err := h.UserService.Authenticate(creds.Username, creds.Password)
 	if err != nil {
@@ -65,22 +86,47 @@ func (h *AuthHandlers) Login(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Users with two-factor authentication, or whose role requires it, need a
+	// second step before the session logs them in
+	pending, err := h.startSecondFactor(w, r, session, user)
+	if err != nil {
+		log.Printf("Error starting two-factor login for user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	if pending {
+		return
+	}
+
 	// Store user ID in the session
-	session.Values[string(middleware.UserIDContextKey)] = int(user.ID) // Ensure type matches middleware expectations (int)
 	// Use default session options set during store initialization (MaxAge, HttpOnly etc.)
-
-	if err := session.Save(r, w); err != nil {
-		log.Printf("Error saving session for user %d: %v", user.ID, err)
-		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+	if !h.completeLogin(w, r, session, user) {
 		return
 	}
-
-	log.Printf("Login successful for User ID: %d (%s)", user.ID, user.Username)
 	// Return success status. Frontend will handle redirect.
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(`{"message": "Login successful"}`))
 }
 
+// LoginOptions handles GET /auth/config, telling the login page how users
+// can log in.
+func (h *AuthHandlers) LoginOptions(w http.ResponseWriter, r *http.Request) {
+	type ssoOptions struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"login_url"`
+	}
+	options := struct {
+		SSO           *ssoOptions `json:"sso"`
+		PasswordLogin bool        `json:"password_login"`
+	}{PasswordLogin: !h.PasswordLoginDisabled}
+	if h.OIDC != nil {
+		options.SSO = &ssoOptions{Name: h.OIDC.Config.Name, LoginURL: "/auth/oidc/login"}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(options)
+}
+
 // Logout handles user logout.
 func (h *AuthHandlers) Logout(w http.ResponseWriter, r *http.Request) {
 	session, err := h.Store.Get(r, middleware.SessionName)
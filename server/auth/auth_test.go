@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/ramborogers/cyberai/server/db"
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// newTestHandlers returns handlers backed by a new database, which has the
+// default roles and admin user
+func newTestHandlers(t *testing.T) *AuthHandlers {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "cyberai.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	sessionService := models.NewSessionService(database)
+	h := NewAuthHandlers(NewSessionStore(sessionService, []byte("test-session-key-0123456789abcdef")), models.NewUserService(database))
+	h.Sessions = sessionService
+	h.Audit = models.NewAuditService(database)
+	return h
+}
+
+// createUser creates an active user with the password and role
+func createUser(t *testing.T, h *AuthHandlers, username, email, password string, roleID int64) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: email, RoleID: roleID, IsActive: true}
+	if err := h.UserService.CreateUser(user, password); err != nil {
+		t.Fatalf("CreateUser(%s): %v", username, err)
+	}
+	return user
+}
+
+// sessionUserID returns the ID of the user logged in with the cookies, or 0
+func sessionUserID(t *testing.T, h *AuthHandlers, cookies []*http.Cookie) int64 {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	session, err := h.Store.Get(r, middleware.SessionName)
+	if err != nil {
+		t.Fatalf("Store.Get: %v", err)
+	}
+	userID, _ := session.Values[string(middleware.UserIDContextKey)].(int)
+	return int64(userID)
+}
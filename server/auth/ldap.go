@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// LDAPConfig configures password logins against an LDAP directory, such as
+// Active Directory. Users are found either by binding directly with a DN built
+// from BindDNTemplate, or by searching BaseDN with UserFilter (as BindDN if
+// set) and then binding as the entry found.
+type LDAPConfig struct {
+	URL                string // ldap://host:389 or ldaps://host:636
+	StartTLS           bool   // Upgrade ldap:// connections with StartTLS
+	InsecureSkipVerify bool   // Do not verify the server's certificate (testing only)
+	// BindDNTemplate is the DN to bind as for direct binds, with {username}
+	// replaced, e.g. "uid={username},ou=people,dc=example,dc=com" or
+	// "{username}@corp.example.com" for Active Directory
+	BindDNTemplate string
+	BindDN         string // Service account for search-then-bind; anonymous if empty
+	BindPassword   string
+	BaseDN         string // Where users are searched
+	// UserFilter finds the user's entry, with {username} replaced by the
+	// escaped username, e.g. "(sAMAccountName={username})"
+	UserFilter string
+	// UsernameAttribute holds the username as the directory spells it, which
+	// is what links to local users. Defaults to the attribute UserFilter
+	// matches {username} against.
+	UsernameAttribute string
+	EmailAttribute    string // Defaults to mail
+	GroupAttribute    string // Defaults to memberOf
+	Timeout           time.Duration
+}
+
+// LDAPBackend authenticates passwords against an LDAP directory
+type LDAPBackend struct {
+	Config LDAPConfig
+}
+
+// NewLDAPBackend returns a backend for the directory, filling in defaults
+func NewLDAPBackend(config LDAPConfig) (*LDAPBackend, error) {
+	if config.URL == "" {
+		return nil, errors.New("LDAP URL is required")
+	}
+	if config.BindDNTemplate == "" && config.BaseDN == "" {
+		return nil, errors.New("either an LDAP bind DN template or a base DN to search is required")
+	}
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid={username})"
+	}
+	if _, err := ldap.CompileFilter(strings.ReplaceAll(config.UserFilter, "{username}", "x")); err != nil {
+		return nil, fmt.Errorf("invalid LDAP user filter: %w", err)
+	}
+	if config.UsernameAttribute == "" {
+		config.UsernameAttribute = filterAttribute(config.UserFilter)
+	}
+	if config.EmailAttribute == "" {
+		config.EmailAttribute = "mail"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &LDAPBackend{Config: config}, nil
+}
+
+// filterAttribute returns the attribute a user filter matches {username}
+// against, e.g. sAMAccountName for "(sAMAccountName={username})", or uid if
+// there is none
+func filterAttribute(filter string) string {
+	before, _, ok := strings.Cut(filter, "={username}")
+	if !ok {
+		return "uid"
+	}
+	if attribute := before[strings.LastIndex(before, "(")+1:]; attribute != "" {
+		return attribute
+	}
+	return "uid"
+}
+
+// Issuer identifies the directory in the identities of its users
+func (b *LDAPBackend) Issuer() string {
+	return b.Config.URL
+}
+
+// AuthenticatePassword binds as the user and returns their identity, read from
+// their entry
+func (b *LDAPBackend) AuthenticatePassword(username, password string) (*models.ExternalIdentity, error) {
+	username = strings.TrimSpace(username)
+	// An empty password would be an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return nil, models.ErrInvalidCredentials
+	}
+
+	conn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var entry *ldap.Entry
+	if b.Config.BindDNTemplate != "" {
+		bindDN := strings.ReplaceAll(b.Config.BindDNTemplate, "{username}", ldap.EscapeDN(username))
+		if err := bindUser(conn, bindDN, password); err != nil {
+			return nil, err
+		}
+		// Read the entry as the user: found by the filter if there is a base
+		// DN (needed when the template is not a DN), or the bound DN itself
+		if b.Config.BaseDN != "" {
+			entry, err = b.findUser(conn, username)
+		} else {
+			entry, err = b.readEntry(conn, bindDN)
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if b.Config.BindDN != "" {
+			if err := conn.Bind(b.Config.BindDN, b.Config.BindPassword); err != nil {
+				return nil, fmt.Errorf("failed to bind as the LDAP service account: %w", err)
+			}
+		}
+		if entry, err = b.findUser(conn, username); err != nil {
+			return nil, err
+		}
+		if err := bindUser(conn, entry.DN, password); err != nil {
+			return nil, err
+		}
+	}
+
+	// Link by the directory's spelling of the username, not the one typed,
+	// since the directory matches usernames case-insensitively
+	if canonical := entry.GetEqualFoldAttributeValue(b.Config.UsernameAttribute); canonical != "" {
+		username = canonical
+	}
+	return &models.ExternalIdentity{
+		Issuer:        b.Issuer(),
+		Subject:       strings.ToLower(entry.DN),
+		Email:         entry.GetEqualFoldAttributeValue(b.Config.EmailAttribute),
+		EmailVerified: true, // The directory is authoritative for its users
+		Username:      username,
+		FirstName:     entry.GetEqualFoldAttributeValue("givenName"),
+		LastName:      entry.GetEqualFoldAttributeValue("sn"),
+		Groups:        groupNames(entry.GetEqualFoldAttributeValues(b.Config.GroupAttribute)),
+	}, nil
+}
+
+// dial connects to the directory, upgrading the connection with StartTLS if configured
+func (b *LDAPBackend) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: b.Config.InsecureSkipVerify}
+	conn, err := ldap.DialURL(b.Config.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: b.Config.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %w", err)
+	}
+	conn.SetTimeout(b.Config.Timeout)
+	if b.Config.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with LDAP server: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// bindUser binds as the user, mapping a rejected password to ErrInvalidCredentials
+func bindUser(conn *ldap.Conn, dn, password string) error {
+	err := conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return fmt.Errorf("%w: %v", models.ErrInvalidCredentials, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind as %s: %w", dn, err)
+	}
+	return nil
+}
+
+func (b *LDAPBackend) attributes() []string {
+	return []string{b.Config.UsernameAttribute, b.Config.EmailAttribute, "givenName", "sn", b.Config.GroupAttribute}
+}
+
+// findUser searches the base DN for the user's entry, which must be unique
+func (b *LDAPBackend) findUser(conn *ldap.Conn, username string) (*ldap.Entry, error) {
+	filter := strings.ReplaceAll(b.Config.UserFilter, "{username}", ldap.EscapeFilter(username))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		b.Config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(b.Config.Timeout.Seconds()), false,
+		filter, b.attributes(), nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for LDAP user: %w", err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, fmt.Errorf("%w: no LDAP entry matches %s", models.ErrInvalidCredentials, filter)
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("more than one LDAP entry matches %s", filter)
+	}
+}
+
+// readEntry reads the entry with the DN
+func (b *LDAPBackend) readEntry(conn *ldap.Conn, dn string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(b.Config.Timeout.Seconds()), false,
+		"(objectClass=*)", b.attributes(), nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LDAP entry %s: %w", dn, err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, fmt.Errorf("LDAP entry %s not found", dn)
+	}
+	return result.Entries[0], nil
+}
+
+// groupNames returns the groups for role mappings: each group as given (a DN
+// for memberOf) and, for DNs, also its common name
+func groupNames(values []string) []string {
+	groups := make([]string, 0, 2*len(values))
+	for _, value := range values {
+		groups = append(groups, value)
+		dn, err := ldap.ParseDN(value)
+		if err != nil || len(dn.RDNs) == 0 {
+			continue
+		}
+		for _, attr := range dn.RDNs[0].Attributes {
+			if strings.EqualFold(attr.Type, "cn") {
+				groups = append(groups, attr.Value)
+			}
+		}
+	}
+	return groups
+}
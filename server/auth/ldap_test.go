@@ -0,0 +1,312 @@
+package auth
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/ramborogers/cyberai/server/auth/ldaptest"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// newLDAPTest returns handlers whose users log in through the stub directory,
+// with "admin" as the break-glass user
+func newLDAPTest(t *testing.T, config LDAPConfig) (*AuthHandlers, *ldaptest.Server) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	directory := ldaptest.NewServer()
+	go directory.Serve(listener)
+
+	config.URL = "ldap://" + listener.Addr().String()
+	backend, err := NewLDAPBackend(config)
+	if err != nil {
+		t.Fatalf("NewLDAPBackend: %v", err)
+	}
+	h := newTestHandlers(t)
+	h.UserService.Directory = &models.DirectoryLogin{
+		Backend:            backend,
+		RoleMappings:       []models.RoleMapping{{Group: "admins", Role: "admin"}},
+		DefaultRole:        "user",
+		BreakGlassUsername: "admin",
+	}
+	return h, directory
+}
+
+// directBind binds as the DN of the username
+var directBind = LDAPConfig{BindDNTemplate: "uid={username},ou=people,dc=example,dc=com"}
+
+// addPerson adds a user to the directory
+func addPerson(t *testing.T, directory *ldaptest.Server, uid, email, password string) {
+	t.Helper()
+	if err := directory.AddEntry("uid="+uid+",ou=people,dc=example,dc=com", map[string][]string{
+		"objectClass":  {"inetOrgPerson"},
+		"uid":          {uid},
+		"mail":         {email},
+		"userPassword": {password},
+	}); err != nil {
+		t.Fatalf("AddEntry: %v", err)
+	}
+}
+
+// linkedIdentities counts the identities linked to the user
+func linkedIdentities(t *testing.T, h *AuthHandlers, userID int64) int {
+	t.Helper()
+	var count int
+	if err := h.UserService.DB.QueryRow(`SELECT COUNT(*) FROM user_identities WHERE user_id = ?`, userID).Scan(&count); err != nil {
+		t.Fatalf("counting identities: %v", err)
+	}
+	return count
+}
+
+// removePassword leaves the user able to log in through the directory only,
+// like a user provisioned by single sign-on
+func removePassword(t *testing.T, h *AuthHandlers, userID int64) {
+	t.Helper()
+	if _, err := h.UserService.DB.Exec(`UPDATE users SET password_hash = '' WHERE id = ?`, userID); err != nil {
+		t.Fatalf("removing password: %v", err)
+	}
+}
+
+func TestLDAPLogin(t *testing.T) {
+	configs := map[string]LDAPConfig{
+		"direct bind": directBind,
+		"search then bind": {
+			BaseDN:       "dc=example,dc=com",
+			BindDN:       "cn=reader,dc=example,dc=com",
+			BindPassword: "reader",
+			UserFilter:   "(sAMAccountName={username})",
+		},
+	}
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			h, _ := newLDAPTest(t, config)
+
+			tests := []struct {
+				username string
+				roleID   int64
+			}{
+				{"alice", models.AdminRoleID}, // Member of cn=admins
+				{"bob", models.UserRoleID},
+			}
+			for _, tt := range tests {
+				user, err := h.UserService.Authenticate(tt.username, tt.username)
+				if err != nil {
+					t.Fatalf("Authenticate(%s): %v", tt.username, err)
+				}
+				if user.Username != tt.username || user.Email != tt.username+"@example.com" || user.LastName != "Example" {
+					t.Errorf("provisioned user = %+v", user)
+				}
+				if user.RoleID != tt.roleID {
+					t.Errorf("%s has role %d, want %d", tt.username, user.RoleID, tt.roleID)
+				}
+				if n := linkedIdentities(t, h, user.ID); n != 1 {
+					t.Errorf("%s has %d identities, want 1", tt.username, n)
+				}
+			}
+		})
+	}
+}
+
+func TestLDAPBindFailure(t *testing.T) {
+	h, _ := newLDAPTest(t, directBind)
+
+	tests := []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"nobody", "nobody"},
+	}
+	for _, tt := range tests {
+		if _, err := h.UserService.Authenticate(tt.username, tt.password); !errors.Is(err, models.ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q) = %v, want %v", tt.username, tt.password, err, models.ErrInvalidCredentials)
+		}
+	}
+	if _, err := h.UserService.GetUserByUsername("alice"); err == nil {
+		t.Error("a failed login provisioned alice")
+	}
+}
+
+func TestLDAPRoleFollowsGroups(t *testing.T) {
+	h, directory := newLDAPTest(t, directBind)
+
+	alice, err := h.UserService.Authenticate("alice", "alice")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	alice.RoleID = models.UserRoleID
+	if err := h.UserService.UpdateUser(alice); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	// The next login sets the role from the groups again
+	if alice, err = h.UserService.Authenticate("alice", "alice"); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if alice.RoleID != models.AdminRoleID {
+		t.Errorf("role = %d, want %d", alice.RoleID, models.AdminRoleID)
+	}
+
+	// Without a mapped group, the default role
+	addPerson(t, directory, "carol", "carol@example.com", "carol")
+	carol, err := h.UserService.Authenticate("carol", "carol")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if carol.RoleID != models.UserRoleID {
+		t.Errorf("role = %d, want %d", carol.RoleID, models.UserRoleID)
+	}
+}
+
+func TestLDAPDeactivatedUser(t *testing.T) {
+	h, _ := newLDAPTest(t, directBind)
+
+	bob, err := h.UserService.Authenticate("bob", "bob")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	bob.IsActive = false
+	if err := h.UserService.UpdateUser(bob); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+	if _, err := h.UserService.Authenticate("bob", "bob"); !errors.Is(err, models.ErrAccountInactive) {
+		t.Errorf("Authenticate = %v, want %v", err, models.ErrAccountInactive)
+	}
+}
+
+func TestLDAPLinkedUserHasNoLocalPassword(t *testing.T) {
+	h, _ := newLDAPTest(t, directBind)
+
+	bob, err := h.UserService.Authenticate("bob", "bob")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if err := h.UserService.SetUserPassword(bob.ID, "local-password"); err != nil {
+		t.Fatalf("SetUserPassword: %v", err)
+	}
+	if _, err := h.UserService.Authenticate("bob", "local-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("Authenticate with the local password = %v, want %v", err, models.ErrInvalidCredentials)
+	}
+}
+
+func TestLDAPBreakGlass(t *testing.T) {
+	h, directory := newLDAPTest(t, directBind)
+	addPerson(t, directory, "admin", "it@example.com", "directory-password")
+
+	// The break-glass user never asks the directory
+	if _, err := h.UserService.Authenticate("admin", "directory-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("Authenticate with the directory password = %v, want %v", err, models.ErrInvalidCredentials)
+	}
+	admin, err := h.UserService.Authenticate("admin", "admin")
+	if err != nil {
+		t.Fatalf("Authenticate with the local password: %v", err)
+	}
+	if admin.RoleID != models.AdminRoleID {
+		t.Errorf("role = %d, want %d", admin.RoleID, models.AdminRoleID)
+	}
+	if n := linkedIdentities(t, h, admin.ID); n != 0 {
+		t.Errorf("admin has %d identities, want 0", n)
+	}
+
+	// Nor does another spelling of it link the directory's admin to it
+	if _, err := h.UserService.Authenticate("ADMIN", "directory-password"); !errors.Is(err, models.ErrInvalidCredentials) {
+		t.Errorf("Authenticate as ADMIN = %v, want %v", err, models.ErrInvalidCredentials)
+	}
+	if n := linkedIdentities(t, h, admin.ID); n != 0 {
+		t.Errorf("admin has %d identities, want 0", n)
+	}
+}
+
+func TestLDAPUsernameLinking(t *testing.T) {
+	tests := []struct {
+		name     string
+		local    func(t *testing.T, h *AuthHandlers) *models.User
+		login    string // Username typed at login
+		linked   bool
+		password string // Local password that still works, if not linked
+	}{
+		{
+			name: "no password",
+			local: func(t *testing.T, h *AuthHandlers) *models.User {
+				user := createUser(t, h, "dave", "dave@old.example.com", "unused", models.UserRoleID)
+				removePassword(t, h, user.ID)
+				return user
+			},
+			login:  "dave",
+			linked: true,
+		},
+		{
+			name: "typed in another case",
+			local: func(t *testing.T, h *AuthHandlers) *models.User {
+				user := createUser(t, h, "dave", "dave@old.example.com", "unused", models.UserRoleID)
+				removePassword(t, h, user.ID)
+				return user
+			},
+			login:  "DAVE",
+			linked: true,
+		},
+		{
+			name: "local password",
+			local: func(t *testing.T, h *AuthHandlers) *models.User {
+				return createUser(t, h, "dave", "dave@old.example.com", "local-password", models.UserRoleID)
+			},
+			login:    "dave",
+			password: "local-password",
+		},
+		{
+			name: "admin role",
+			local: func(t *testing.T, h *AuthHandlers) *models.User {
+				user := createUser(t, h, "dave", "dave@old.example.com", "unused", models.AdminRoleID)
+				removePassword(t, h, user.ID)
+				return user
+			},
+			login: "dave",
+		},
+		{
+			name: "same email",
+			local: func(t *testing.T, h *AuthHandlers) *models.User {
+				return createUser(t, h, "david", "dave@example.com", "local-password", models.UserRoleID)
+			},
+			login:    "dave",
+			password: "local-password",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, directory := newLDAPTest(t, directBind)
+			addPerson(t, directory, "dave", "dave@example.com", "directory-password")
+			local := tt.local(t, h)
+
+			user, err := h.UserService.Authenticate(tt.login, "directory-password")
+			if tt.linked {
+				if err != nil {
+					t.Fatalf("Authenticate: %v", err)
+				}
+				if user.ID != local.ID {
+					t.Errorf("logged in as user %d, want %d", user.ID, local.ID)
+				}
+				if user.Email != "dave@example.com" {
+					t.Errorf("email = %q, want the directory's", user.Email)
+				}
+				return
+			}
+
+			if !errors.Is(err, models.ErrInvalidCredentials) {
+				t.Errorf("Authenticate = %v, want %v", err, models.ErrInvalidCredentials)
+			}
+			if n := linkedIdentities(t, h, local.ID); n != 0 {
+				t.Errorf("local user has %d identities, want 0", n)
+			}
+			if tt.password != "" {
+				user, err := h.UserService.Authenticate(local.Username, tt.password)
+				if err != nil {
+					t.Fatalf("Authenticate with the local password: %v", err)
+				}
+				if user.ID != local.ID {
+					t.Errorf("logged in as user %d, want %d", user.ID, local.ID)
+				}
+			}
+		})
+	}
+}
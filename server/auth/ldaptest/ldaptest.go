@@ -0,0 +1,317 @@
+// Package ldaptest is a minimal LDAP server for testing LDAP logins. It
+// serves a small built-in directory under dc=example,dc=com:
+//
+//	uid=alice,ou=people  password "alice", member of cn=admins and cn=staff
+//	uid=bob,ou=people    password "bob", member of cn=staff
+//	cn=reader            password "reader", a service account for searches
+//
+// It implements simple binds and searches with equality, presence, and, or
+// and not filters, and nothing else: no TLS, no writes, no access control.
+// Do not use it for anything but testing.
+//
+// It serves cmd/ldap-stub and the tests of server/auth.
+package ldaptest
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// entry is a directory entry; attribute names are lowercase
+type entry struct {
+	dn         *ldap.DN
+	attributes map[string][]string
+}
+
+// builtinEntries is the directory served by NewServer
+func builtinEntries() []*entry {
+	return []*entry{
+		mustEntry("dc=example,dc=com", map[string][]string{
+			"objectclass": {"domain"},
+			"dc":          {"example"},
+		}),
+		mustEntry("cn=reader,dc=example,dc=com", map[string][]string{
+			"objectclass":  {"person"},
+			"cn":           {"reader"},
+			"userpassword": {"reader"},
+		}),
+		mustEntry("uid=alice,ou=people,dc=example,dc=com", map[string][]string{
+			"objectclass":    {"inetOrgPerson"},
+			"uid":            {"alice"},
+			"samaccountname": {"alice"},
+			"mail":           {"alice@example.com"},
+			"givenname":      {"Alice"},
+			"sn":             {"Example"},
+			"userpassword":   {"alice"},
+			"memberof":       {"cn=admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		}),
+		mustEntry("uid=bob,ou=people,dc=example,dc=com", map[string][]string{
+			"objectclass":    {"inetOrgPerson"},
+			"uid":            {"bob"},
+			"samaccountname": {"bob"},
+			"mail":           {"bob@example.com"},
+			"givenname":      {"Bob"},
+			"sn":             {"Example"},
+			"userpassword":   {"bob"},
+			"memberof":       {"cn=staff,ou=groups,dc=example,dc=com"},
+		}),
+	}
+}
+
+func newEntry(dn string, attributes map[string][]string) (*entry, error) {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DN %q: %w", dn, err)
+	}
+	lower := make(map[string][]string, len(attributes))
+	for name, values := range attributes {
+		lower[strings.ToLower(name)] = values
+	}
+	return &entry{dn: parsed, attributes: lower}, nil
+}
+
+func mustEntry(dn string, attributes map[string][]string) *entry {
+	e, err := newEntry(dn, attributes)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// Server is the stub directory
+type Server struct {
+	mu      sync.Mutex
+	entries []*entry
+}
+
+// NewServer returns a server with the built-in directory
+func NewServer() *Server {
+	return &Server{entries: builtinEntries()}
+}
+
+// AddEntry adds an entry to the directory. A userPassword attribute lets
+// clients bind as it.
+func (s *Server) AddEntry(dn string, attributes map[string][]string) error {
+	e, err := newEntry(dn, attributes)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.entries = append(s.entries, e)
+	s.mu.Unlock()
+	return nil
+}
+
+// directory returns the entries, which are never modified once added
+func (s *Server) directory() []*entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+// Serve answers the connections of the listener until it is closed
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serve(conn)
+	}
+}
+
+// serve answers the requests of a connection until it unbinds or closes
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("Failed to read request: %v", err)
+			}
+			return
+		}
+		if len(packet.Children) < 2 {
+			log.Printf("Malformed request")
+			return
+		}
+		messageID, _ := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			code, message := s.bind(request)
+			respond(conn, messageID, ldap.ApplicationBindResponse, code, message)
+		case ldap.ApplicationSearchRequest:
+			s.search(conn, messageID, request)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			log.Printf("Unsupported operation %d", request.Tag)
+			respond(conn, messageID, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "operation not supported by the stub")
+		}
+	}
+}
+
+// respond writes an LDAPResult of the operation
+func respond(w io.Writer, messageID int64, operation ber.Tag, code uint16, message string) {
+	response := ber.Encode(ber.ClassApplication, ber.TypeConstructed, operation, nil, "Response")
+	response.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	response.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	write(w, messageID, response)
+}
+
+func write(w io.Writer, messageID int64, operation *ber.Packet) {
+	envelope := ber.NewSequence("LDAP Response")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	envelope.AppendChild(operation)
+	if _, err := w.Write(envelope.Bytes()); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// bind checks a simple bind; an empty password is an anonymous bind
+func (s *Server) bind(request *ber.Packet) (uint16, string) {
+	if len(request.Children) < 3 || request.Children[2].Tag != 0 {
+		return ldap.LDAPResultAuthMethodNotSupported, "only simple binds are supported"
+	}
+	name, _ := request.Children[1].Value.(string)
+	password := request.Children[2].Data.String()
+	if password == "" {
+		log.Printf("Anonymous bind")
+		return ldap.LDAPResultSuccess, ""
+	}
+	dn, err := ldap.ParseDN(name)
+	if err == nil {
+		for _, e := range s.directory() {
+			if e.dn.EqualFold(dn) && len(e.attributes["userpassword"]) > 0 && e.attributes["userpassword"][0] == password {
+				log.Printf("Bound as %s", name)
+				return ldap.LDAPResultSuccess, ""
+			}
+		}
+	}
+	log.Printf("Rejected bind as %q", name)
+	return ldap.LDAPResultInvalidCredentials, "invalid credentials"
+}
+
+// search returns the entries under the base matching the filter
+func (s *Server) search(w io.Writer, messageID int64, request *ber.Packet) {
+	if len(request.Children) < 8 {
+		respond(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "malformed search request")
+		return
+	}
+	baseName, _ := request.Children[0].Value.(string)
+	scope, _ := request.Children[1].Value.(int64)
+	filter := request.Children[6]
+	var requested []string
+	for _, attribute := range request.Children[7].Children {
+		if name, ok := attribute.Value.(string); ok {
+			requested = append(requested, strings.ToLower(name))
+		}
+	}
+	base, err := ldap.ParseDN(baseName)
+	if err != nil {
+		respond(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultInvalidDNSyntax, err.Error())
+		return
+	}
+	filterString, _ := ldap.DecompileFilter(filter)
+
+	found := false
+	returned := 0
+	for _, e := range s.directory() {
+		inScope := e.dn.EqualFold(base) || (scope != ldap.ScopeBaseObject && base.AncestorOfFold(e.dn))
+		if !inScope {
+			continue
+		}
+		found = true
+		if !matches(e, filter) {
+			continue
+		}
+		returned++
+		write(w, messageID, encodeEntry(e, requested))
+	}
+	log.Printf("Search of %s for %s returned %d entries", baseName, filterString, returned)
+	if !found {
+		respond(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "no such object")
+		return
+	}
+	respond(w, messageID, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+}
+
+// encodeEntry encodes the requested attributes of the entry, all but the
+// password if none are requested
+func encodeEntry(e *entry, requested []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn.String(), "Object Name"))
+	attributes := ber.NewSequence("Attributes")
+	for name, values := range e.attributes {
+		if name == "userpassword" || (len(requested) > 0 && !contains(requested, name)) {
+			continue
+		}
+		attribute := ber.NewSequence("Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// matches evaluates a filter against the entry, case-insensitively
+func matches(e *entry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matches(e, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matches(e, child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return len(filter.Children) == 1 && !matches(e, filter.Children[0])
+	case ldap.FilterPresent:
+		return len(e.attributes[strings.ToLower(filter.Data.String())]) > 0
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false
+		}
+		name, _ := filter.Children[0].Value.(string)
+		value, _ := filter.Children[1].Value.(string)
+		for _, v := range e.attributes[strings.ToLower(name)] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		log.Printf("Unsupported filter type %d", filter.Tag)
+		return false
+	}
+}
@@ -0,0 +1,626 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/sessions"
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// OIDCConfig configures single sign-on with an OpenID Connect identity provider
+type OIDCConfig struct {
+	Name         string // Shown on the login page, e.g. "Company SSO"
+	Issuer       string // Discovered at Issuer + "/.well-known/openid-configuration"
+	ClientID     string
+	ClientSecret string               // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string               // The public URL of GET /auth/oidc/callback
+	Scopes       []string             // Requested scopes; "openid" is always included
+	GroupsClaim  string               // Claim listing the user's groups
+	RoleMappings []models.RoleMapping // The first mapping whose group the user is in sets their role
+	DefaultRole  string               // Role name when no mapping matches
+	Provision    bool                 // Create users at their first login
+}
+
+// ParseRoleMappings parses role mappings written as "group=role,group=role"
+func ParseRoleMappings(s string) ([]models.RoleMapping, error) {
+	var mappings []models.RoleMapping
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, role, ok := strings.Cut(entry, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" || role == "" {
+			return nil, fmt.Errorf("invalid role mapping %q (expected group=role)", entry)
+		}
+		mappings = append(mappings, models.RoleMapping{Group: group, Role: role})
+	}
+	return mappings, nil
+}
+
+// Session values held between the redirect to the identity provider and
+// the callback
+const (
+	oidcStateKey    = "oidc_state"
+	oidcNonceKey    = "oidc_nonce"
+	oidcVerifierKey = "oidc_verifier"
+	oidcStartedKey  = "oidc_started"
+
+	// oidcLoginTimeout is how long the user has to log in at the identity provider
+	oidcLoginTimeout = 10 * time.Minute
+	// jwksRefreshInterval limits refetching the signing keys for unknown key IDs
+	jwksRefreshInterval = time.Minute
+)
+
+// Signing algorithms accepted for ID tokens
+var idTokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// oidcDiscovery is the part of the provider metadata CyberAI uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider logs users in with the authorization code flow and PKCE.
+// Provider metadata and signing keys are fetched on first use, so the server
+// starts even while the identity provider is unreachable.
+type OIDCProvider struct {
+	Config OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]interface{} // Signing keys by key ID
+	keysFetched time.Time
+}
+
+// NewOIDCProvider creates a provider, checking the configuration and filling
+// in defaults
+func NewOIDCProvider(cfg OIDCConfig) (*OIDCProvider, error) {
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+	if cfg.Issuer == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC issuer, client ID and redirect URL are required")
+	}
+	if cfg.Name == "" {
+		cfg.Name = "Single sign-on"
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.DefaultRole == "" {
+		cfg.DefaultRole = "user"
+	}
+	hasOpenID := false
+	for _, scope := range cfg.Scopes {
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	return &OIDCProvider{Config: cfg, client: &http.Client{Timeout: 15 * time.Second}}, nil
+}
+
+// getJSON fetches a JSON document into v
+func (p *OIDCProvider) getJSON(rawURL, bearer string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", rawURL, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// getDiscovery returns the provider metadata, fetching it on first use
+func (p *OIDCProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(p.Config.Issuer+"/.well-known/openid-configuration", "", &d); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if strings.TrimSuffix(d.Issuer, "/") != p.Config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, not %q", d.Issuer, p.Config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document lacks the authorization, token or JWKS endpoint")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// signingKey returns the identity provider's public key with the key ID,
+// refetching the keys (at most once per jwksRefreshInterval) when the ID is
+// unknown, as after a key rotation
+func (p *OIDCProvider) signingKey(kid string) (interface{}, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	p.keysFetched = time.Now()
+	if err := p.getJSON(d.JWKSURI, "", &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC signing keys: %w", err)
+	}
+	p.keys = make(map[string]interface{})
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("OIDC: Skipping signing key %q: %v", jwk.Kid, err)
+			continue
+		}
+		p.keys[jwk.Kid] = key
+	}
+
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey returns the cached key with the ID, or the only key if the token
+// names none. The caller holds p.mu.
+func (p *OIDCProvider) lookupKey(kid string) interface{} {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// jsonWebKey is an RSA or elliptic curve public key in JWK format (RFC 7517)
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey decodes the key into an *rsa.PublicKey or *ecdsa.PublicKey
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decode(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// authCodeURL returns the URL of the identity provider's login page
+func (p *OIDCProvider) authCodeURL(state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.Config.ClientID},
+		"redirect_uri":          {p.Config.RedirectURL},
+		"scope":                 {strings.Join(p.Config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// exchange trades the authorization code for the ID and access tokens
+func (p *OIDCProvider) exchange(code, verifier string) (idToken, accessToken string, err error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return "", "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"client_id":     {p.Config.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", "", fmt.Errorf("failed to decode token response (status %s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", "", fmt.Errorf("token request failed (status %s): %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", "", errors.New("token response has no ID token")
+	}
+	return body.IDToken, body.AccessToken, nil
+}
+
+// verifyIDToken checks the ID token's signature, issuer, audience, expiry
+// and nonce, and returns its claims
+func (p *OIDCProvider) verifyIDToken(raw, nonce string) (jwt.MapClaims, error) {
+	d, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(idTokenMethods),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	claims := jwt.MapClaims{}
+	_, err = parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	// A token for several audiences must have been issued to us
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.Config.ClientID {
+			return nil, errors.New("invalid ID token: authorized party mismatch")
+		}
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	return claims, nil
+}
+
+// addUserinfo fills in claims the ID token lacks (such as the email or
+// groups, which some providers only return from the userinfo endpoint)
+func (p *OIDCProvider) addUserinfo(claims jwt.MapClaims, accessToken string) error {
+	_, hasEmail := claims["email"]
+	_, hasGroups := claims[p.Config.GroupsClaim]
+	if (hasEmail && hasGroups) || accessToken == "" {
+		return nil
+	}
+	d, err := p.getDiscovery()
+	if err != nil || d.UserinfoEndpoint == "" {
+		return err
+	}
+
+	var userinfo map[string]interface{}
+	if err := p.getJSON(d.UserinfoEndpoint, accessToken, &userinfo); err != nil {
+		return fmt.Errorf("failed to fetch userinfo: %w", err)
+	}
+	if userinfo["sub"] != claims["sub"] {
+		return errors.New("userinfo is for another subject")
+	}
+	for name, value := range userinfo {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
+// identity converts the claims of a verified ID token
+func (p *OIDCProvider) identity(claims jwt.MapClaims) models.ExternalIdentity {
+	str := func(name string) string {
+		s, _ := claims[name].(string)
+		return s
+	}
+	verified := false
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		verified = v
+	case string: // Some providers send it as a string
+		verified = v == "true"
+	}
+	return models.ExternalIdentity{
+		Issuer:        str("iss"),
+		Subject:       str("sub"),
+		Email:         str("email"),
+		EmailVerified: verified,
+		Username:      str("preferred_username"),
+		FirstName:     str("given_name"),
+		LastName:      str("family_name"),
+		Groups:        p.groups(claims),
+	}
+}
+
+// groups returns the groups in the configured claim, a list of strings or a
+// single comma- or space-separated string
+func (p *OIDCProvider) groups(claims jwt.MapClaims) []string {
+	switch v := claims[p.Config.GroupsClaim].(type) {
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	case string:
+		return strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	return nil
+}
+
+// randomToken returns a random URL-safe string for the state, nonce and PKCE verifier
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// OIDCLogin handles GET /auth/oidc/login by redirecting to the identity
+// provider, remembering the state, nonce and PKCE verifier in the session
+func (h *AuthHandlers) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	values := make(map[string]string)
+	for _, key := range []string{oidcStateKey, oidcNonceKey, oidcVerifierKey} {
+		token, err := randomToken()
+		if err != nil {
+			log.Printf("OIDC: Failed to generate %s: %v", key, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		values[key] = token
+	}
+	target, err := h.OIDC.authCodeURL(values[oidcStateKey], values[oidcNonceKey], values[oidcVerifierKey])
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		h.oidcFailed(w, r, nil, "The identity provider is unavailable")
+		return
+	}
+
+	session, err := h.Store.Get(r, middleware.SessionName)
+	if err != nil {
+		log.Printf("Error getting session store in OIDCLogin: %v", err)
+		if session == nil {
+			http.Error(w, "Session initialization failed", http.StatusInternalServerError)
+			return
+		}
+	}
+	for key, value := range values {
+		session.Values[key] = value
+	}
+	session.Values[oidcStartedKey] = time.Now().Unix()
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Error saving OIDC session: %v", err)
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback handles GET /auth/oidc/callback, where the identity provider
+// sends the user back with an authorization code. It logs the user in
+// (linking or provisioning them, see models.UserService.LoginExternal) and
+// redirects to the app, to the login page for the second factor, or back to
+// the login page with an error.
+func (h *AuthHandlers) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.OIDC == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, err := h.Store.Get(r, middleware.SessionName)
+	if err != nil || session == nil {
+		log.Printf("Error getting session store in OIDCCallback: %v", err)
+		h.oidcFailed(w, r, nil, "Your login session expired, please try again")
+		return
+	}
+	state, _ := session.Values[oidcStateKey].(string)
+	nonce, _ := session.Values[oidcNonceKey].(string)
+	verifier, _ := session.Values[oidcVerifierKey].(string)
+	started, _ := session.Values[oidcStartedKey].(int64)
+	// Each login attempt can only be completed once
+	for _, key := range []string{oidcStateKey, oidcNonceKey, oidcVerifierKey, oidcStartedKey} {
+		delete(session.Values, key)
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("OIDC: Identity provider returned error %q: %s", errCode, query.Get("error_description"))
+		h.oidcFailed(w, r, session, "Login was cancelled or denied by the identity provider")
+		return
+	}
+	if state == "" || query.Get("state") != state || time.Since(time.Unix(started, 0)) > oidcLoginTimeout {
+		log.Printf("OIDC: Callback with missing, mismatched or expired state")
+		h.oidcFailed(w, r, session, "Your login session expired, please try again")
+		return
+	}
+
+	idToken, accessToken, err := h.OIDC.exchange(query.Get("code"), verifier)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		h.oidcFailed(w, r, session, "Single sign-on failed")
+		return
+	}
+	claims, err := h.OIDC.verifyIDToken(idToken, nonce)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		h.oidcFailed(w, r, session, "Single sign-on failed")
+		return
+	}
+	if err := h.OIDC.addUserinfo(claims, accessToken); err != nil {
+		log.Printf("OIDC: %v", err) // The ID token's claims may still suffice
+	}
+
+	identity := h.OIDC.identity(claims)
+	roleID, err := h.UserService.RoleIDForGroups(h.OIDC.Config.RoleMappings, identity.Groups, h.OIDC.Config.DefaultRole)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		h.oidcFailed(w, r, session, "Single sign-on is misconfigured, please contact an administrator")
+		return
+	}
+	user, err := h.UserService.LoginExternal(identity, models.ExternalLoginOptions{
+		Provision:  h.OIDC.Config.Provision,
+		RoleID:     roleID,
+		SyncRole:   len(h.OIDC.Config.RoleMappings) > 0,
+		KeepRoleOf: h.BreakGlassUsername,
+	})
+	if err != nil {
+		log.Printf("OIDC: Login failed for subject %q: %v", identity.Subject, err)
+		switch {
+		case errors.Is(err, models.ErrUserNotProvisioned):
+			h.oidcFailed(w, r, session, "You do not have an account yet, please contact an administrator")
+		case errors.Is(err, models.ErrAccountInactive):
+			h.oidcFailed(w, r, session, "Your account is inactive")
+		case errors.Is(err, models.ErrInvalidIdentity):
+			h.oidcFailed(w, r, session, "Your account could not be created, please contact an administrator")
+		default:
+			h.oidcFailed(w, r, session, "Single sign-on failed")
+		}
+		return
+	}
+
+	// The identity provider is the first factor: users with two-factor
+	// authentication, or whose role requires it, finish on the login page
+	step, err := h.beginSecondFactor(w, r, session, user)
+	if err != nil {
+		log.Printf("Error starting two-factor login for user %d: %v", user.ID, err)
+		h.oidcFailed(w, r, session, "Single sign-on failed")
+		return
+	}
+	if step != "" {
+		http.Redirect(w, r, "/login?two_factor="+step, http.StatusFound)
+		return
+	}
+	if !h.completeLogin(w, r, session, user) {
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcFailed sends the user back to the login page with the message, saving
+// the session (if any) so that the failed attempt cannot be completed later
+func (h *AuthHandlers) oidcFailed(w http.ResponseWriter, r *http.Request, session *sessions.Session, message string) {
+	if session != nil {
+		if err := session.Save(r, w); err != nil {
+			log.Printf("Error saving session after failed OIDC login: %v", err)
+		}
+	}
+	http.Redirect(w, r, "/login?error="+url.QueryEscape(message), http.StatusFound)
+}
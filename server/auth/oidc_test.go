@@ -0,0 +1,453 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/auth/oidctest"
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+const testRedirectURL = "http://cyberai.test/auth/oidc/callback"
+
+// oidcTest logs in to handlers configured for single sign-on with the stub
+// identity provider
+type oidcTest struct {
+	h   *AuthHandlers
+	idp *oidctest.Provider
+}
+
+func newOIDCTest(t *testing.T, provision bool) *oidcTest {
+	t.Helper()
+	idp, err := oidctest.NewProvider("", "cyberai", "secret")
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	server := httptest.NewServer(idp)
+	t.Cleanup(server.Close)
+	idp.Issuer = server.URL
+
+	provider, err := NewOIDCProvider(OIDCConfig{
+		Issuer:       server.URL,
+		ClientID:     "cyberai",
+		ClientSecret: "secret",
+		RedirectURL:  testRedirectURL,
+		RoleMappings: []models.RoleMapping{{Group: "admins", Role: "admin"}},
+		Provision:    provision,
+	})
+	if err != nil {
+		t.Fatalf("NewOIDCProvider: %v", err)
+	}
+	h := newTestHandlers(t)
+	h.OIDC = provider
+	return &oidcTest{h: h, idp: idp}
+}
+
+// identityForm returns what is entered on the stub's login page
+func identityForm(subject, username, email string, verified bool, groups string) url.Values {
+	form := url.Values{
+		"sub":                {subject},
+		"preferred_username": {username},
+		"email":              {email},
+		"given_name":         {"Test"},
+		"family_name":        {"User"},
+		"groups":             {groups},
+	}
+	if verified {
+		form.Set("email_verified", "true")
+	}
+	return form
+}
+
+// start begins a login, returning the identity provider's authorization URL
+// and the session cookies
+func (o *oidcTest) start(t *testing.T) (*url.URL, []*http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	o.h.OIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	target, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(target.String(), o.idp.Issuer+"/authorize?") {
+		t.Fatalf("login redirects to %q, want the authorization endpoint", rec.Header().Get("Location"))
+	}
+	return target, rec.Result().Cookies()
+}
+
+// authorize submits the stub's login page, with form overriding the
+// authorization request's parameters, and returns the callback URL
+func (o *oidcTest) authorize(t *testing.T, target *url.URL, form url.Values) *url.URL {
+	t.Helper()
+	values := target.Query()
+	for name, value := range form {
+		values[name] = value
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(o.idp.Issuer+"/authorize", values)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+	callback, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize status = %d, want a redirect: %v", resp.StatusCode, err)
+	}
+	if !strings.HasPrefix(callback.String(), testRedirectURL+"?") {
+		t.Fatalf("authorize redirects to %q, want the callback", callback)
+	}
+	return callback
+}
+
+// callback completes the login, returning where the user is sent and the
+// session cookies
+func (o *oidcTest) callback(t *testing.T, callback *url.URL, cookies []*http.Cookie) (string, []*http.Cookie) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, callback.String(), nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	o.h.OIDCCallback(rec, r)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("callback status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	if len(rec.Result().Cookies()) > 0 {
+		cookies = rec.Result().Cookies()
+	}
+	return rec.Header().Get("Location"), cookies
+}
+
+// login logs in as the identity, returning the logged in user's ID (0 if
+// none) and where the user is sent
+func (o *oidcTest) login(t *testing.T, form url.Values) (int64, string) {
+	t.Helper()
+	target, cookies := o.start(t)
+	location, cookies := o.callback(t, o.authorize(t, target, form), cookies)
+	return sessionUserID(t, o.h, cookies), location
+}
+
+// wantLoginError checks that a login was refused with the message
+func wantLoginError(t *testing.T, userID int64, location, message string) {
+	t.Helper()
+	if userID != 0 {
+		t.Errorf("logged in as user %d, want no login", userID)
+	}
+	if want := "/login?error=" + url.QueryEscape(message); location != want {
+		t.Errorf("redirected to %q, want %q", location, want)
+	}
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	o := newOIDCTest(t, true)
+
+	userID, location := o.login(t, identityForm("alice-sub", "alice", "alice@example.com", true, "staff"))
+	if location != "/" {
+		t.Fatalf("redirected to %q, want /", location)
+	}
+	if userID == 0 {
+		t.Fatal("not logged in")
+	}
+	user, err := o.h.UserService.GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.Username != "alice" || user.Email != "alice@example.com" || user.FirstName != "Test" || user.LastName != "User" {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	if user.PasswordHash != "" {
+		t.Error("provisioned user has a password")
+	}
+	if user.RoleID != 2 {
+		t.Errorf("role = %d, want the default role 2", user.RoleID)
+	}
+
+	// The identity is linked, so the next login finds the same user even
+	// with another username
+	again, _ := o.login(t, identityForm("alice-sub", "alice.renamed", "alice@example.com", true, "staff"))
+	if again != userID {
+		t.Errorf("second login as user %d, want %d", again, userID)
+	}
+}
+
+func TestOIDCLoginUsernameTaken(t *testing.T) {
+	o := newOIDCTest(t, true)
+	createUser(t, o.h, "bob", "bob@example.com", "password", 2)
+
+	userID, location := o.login(t, identityForm("bob-sub", "bob", "bob@corp.example.com", true, ""))
+	if location != "/" {
+		t.Fatalf("redirected to %q, want /", location)
+	}
+	user, err := o.h.UserService.GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	// Usernames of the identity provider do not link to local users
+	if user.Username != "bob2" {
+		t.Errorf("username = %q, want bob2", user.Username)
+	}
+}
+
+func TestOIDCRoleMapping(t *testing.T) {
+	o := newOIDCTest(t, true)
+
+	tests := []struct {
+		name   string
+		groups string
+		roleID int64
+	}{
+		{"mapped group", "staff,admins", 1},
+		{"no mapped group", "staff", 2},
+		{"back in the mapped group", "admins", 1},
+	}
+	// The same identity logs in each time, so its role is synced
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, location := o.login(t, identityForm("carol-sub", "carol", "carol@example.com", true, tt.groups))
+			if location != "/" {
+				t.Fatalf("redirected to %q, want /", location)
+			}
+			user, err := o.h.UserService.GetUserByID(userID)
+			if err != nil {
+				t.Fatalf("GetUserByID: %v", err)
+			}
+			if user.RoleID != tt.roleID {
+				t.Errorf("role = %d, want %d", user.RoleID, tt.roleID)
+			}
+		})
+	}
+}
+
+func TestOIDCBreakGlassKeepsRole(t *testing.T) {
+	o := newOIDCTest(t, true)
+	o.h.BreakGlassUsername = "admin"
+
+	// The default admin has admin@example.com; the verified email links it
+	userID, location := o.login(t, identityForm("admin-sub", "admin", "admin@example.com", true, "staff"))
+	if location != "/" {
+		t.Fatalf("redirected to %q, want /", location)
+	}
+	user, err := o.h.UserService.GetUserByID(userID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.Username != "admin" || user.RoleID != 1 {
+		t.Errorf("logged in as %s with role %d, want admin with role 1", user.Username, user.RoleID)
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t, true)
+	dave := createUser(t, o.h, "dave", "Dave@Example.com", "password", 2)
+
+	userID, location := o.login(t, identityForm("dave-sub", "david", "dave@example.com", true, ""))
+	if location != "/" {
+		t.Fatalf("redirected to %q, want /", location)
+	}
+	if userID != dave.ID {
+		t.Errorf("logged in as user %d, want dave (%d)", userID, dave.ID)
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	o := newOIDCTest(t, true)
+	erin := createUser(t, o.h, "erin", "erin@example.com", "password", 2)
+
+	// The provider does not vouch for the email, so it does not link to
+	// erin, and no other user can have it
+	userID, location := o.login(t, identityForm("erin-sub", "erin", "erin@example.com", false, ""))
+	wantLoginError(t, userID, location, "Your account could not be created, please contact an administrator")
+
+	// Once verified, it links
+	userID, location = o.login(t, identityForm("erin-sub", "erin", "erin@example.com", true, ""))
+	if location != "/" || userID != erin.ID {
+		t.Errorf("logged in as user %d (redirected to %q), want erin (%d)", userID, location, erin.ID)
+	}
+}
+
+func TestOIDCProvisioningOff(t *testing.T) {
+	o := newOIDCTest(t, false)
+
+	userID, location := o.login(t, identityForm("frank-sub", "frank", "frank@example.com", true, ""))
+	wantLoginError(t, userID, location, "You do not have an account yet, please contact an administrator")
+	if _, err := o.h.UserService.GetUserByUsername("frank"); err == nil {
+		t.Error("user was provisioned")
+	}
+}
+
+func TestOIDCInactiveUser(t *testing.T) {
+	o := newOIDCTest(t, true)
+	grace := createUser(t, o.h, "grace", "grace@example.com", "password", 2)
+	grace.IsActive = false
+	if err := o.h.UserService.UpdateUser(grace); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	userID, location := o.login(t, identityForm("grace-sub", "grace", "grace@example.com", true, ""))
+	wantLoginError(t, userID, location, "Your account is inactive")
+}
+
+func TestOIDCCallbackRefused(t *testing.T) {
+	const expired = "Your login session expired, please try again"
+	const failed = "Single sign-on failed"
+	form := identityForm("heidi-sub", "heidi", "heidi@example.com", true, "")
+
+	tests := []struct {
+		name    string
+		form    url.Values // Overrides of the authorization request
+		tamper  func(t *testing.T, o *oidcTest, callback *url.URL, cookies []*http.Cookie) []*http.Cookie
+		message string
+	}{
+		{
+			name:    "denied",
+			form:    url.Values{"deny": {"1"}},
+			message: "Login was cancelled or denied by the identity provider",
+		},
+		{
+			name: "state mismatch",
+			tamper: func(t *testing.T, o *oidcTest, callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				query := callback.Query()
+				query.Set("state", "forged")
+				callback.RawQuery = query.Encode()
+				return cookies
+			},
+			message: expired,
+		},
+		{
+			name: "no login session",
+			tamper: func(t *testing.T, o *oidcTest, callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				return nil
+			},
+			message: expired,
+		},
+		{
+			name: "expired",
+			tamper: func(t *testing.T, o *oidcTest, callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				r := httptest.NewRequest(http.MethodGet, "/", nil)
+				for _, cookie := range cookies {
+					r.AddCookie(cookie)
+				}
+				session, err := o.h.Store.Get(r, middleware.SessionName)
+				if err != nil {
+					t.Fatalf("Store.Get: %v", err)
+				}
+				session.Values[oidcStartedKey] = time.Now().Add(-oidcLoginTimeout - time.Minute).Unix()
+				if err := session.Save(r, httptest.NewRecorder()); err != nil {
+					t.Fatalf("Save: %v", err)
+				}
+				return cookies
+			},
+			message: expired,
+		},
+		{
+			name:    "nonce mismatch",
+			form:    url.Values{"nonce": {"forged"}},
+			message: failed,
+		},
+		{
+			name:    "PKCE challenge mismatch",
+			form:    url.Values{"code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}},
+			message: failed,
+		},
+		{
+			name: "forged code",
+			tamper: func(t *testing.T, o *oidcTest, callback *url.URL, cookies []*http.Cookie) []*http.Cookie {
+				query := callback.Query()
+				query.Set("code", "forged")
+				callback.RawQuery = query.Encode()
+				return cookies
+			},
+			message: failed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t, true)
+			target, cookies := o.start(t)
+			values := url.Values{}
+			for name, value := range form {
+				values[name] = value
+			}
+			for name, value := range tt.form {
+				values[name] = value
+			}
+			callback := o.authorize(t, target, values)
+			if tt.tamper != nil {
+				cookies = tt.tamper(t, o, callback, cookies)
+			}
+			location, cookies := o.callback(t, callback, cookies)
+			wantLoginError(t, sessionUserID(t, o.h, cookies), location, tt.message)
+			if _, err := o.h.UserService.GetUserByUsername("heidi"); err == nil {
+				t.Error("user was provisioned")
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackReplay(t *testing.T) {
+	o := newOIDCTest(t, true)
+	target, cookies := o.start(t)
+	callback := o.authorize(t, target, identityForm("ivan-sub", "ivan", "ivan@example.com", true, ""))
+
+	location, _ := o.callback(t, callback, cookies)
+	if location != "/" {
+		t.Fatalf("redirected to %q, want /", location)
+	}
+	// The session of the login attempt is gone once it completed
+	location, replayed := o.callback(t, callback, cookies)
+	wantLoginError(t, sessionUserID(t, o.h, replayed), location, "Your login session expired, please try again")
+}
+
+func TestOIDCLoginSecondFactor(t *testing.T) {
+	o := newOIDCTest(t, true)
+	user := createUser(t, o.h, "judy", "judy@example.com", "password1", models.UserRoleID)
+	secret := enableTwoFactor(t, o.h, user.ID)
+
+	// The identity provider is only the first factor
+	target, cookies := o.start(t)
+	location, cookies := o.callback(t, o.authorize(t, target, identityForm("judy-sub", "judy", "judy@example.com", true, "")), cookies)
+	if want := "/login?two_factor=" + twoFactorVerify; location != want {
+		t.Fatalf("redirected to %q, want %q", location, want)
+	}
+	if id := sessionUserID(t, o.h, cookies); id != 0 {
+		t.Fatalf("logged in as user %d before the second factor", id)
+	}
+
+	rec, cookies := verifyCode(o.h, cookies, wrongCode(t, secret))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("verify with a wrong code: status = %d", rec.Code)
+	}
+	rec, cookies = verifyCode(o.h, cookies, totpCode(t, secret, 0))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verify status = %d: %s", rec.Code, rec.Body)
+	}
+	if id := sessionUserID(t, o.h, cookies); id != user.ID {
+		t.Errorf("logged in as user %d, want %d", id, user.ID)
+	}
+}
+
+func TestOIDCLoginTwoFactorRequired(t *testing.T) {
+	o := newOIDCTest(t, true)
+	role, err := o.h.UserService.GetRole(models.UserRoleID)
+	if err != nil {
+		t.Fatalf("GetRole: %v", err)
+	}
+	role.RequireTwoFactor = true
+	if err := o.h.UserService.UpdateRole(role); err != nil {
+		t.Fatalf("UpdateRole: %v", err)
+	}
+
+	target, cookies := o.start(t)
+	location, cookies := o.callback(t, o.authorize(t, target, identityForm("kim-sub", "kim", "kim@example.com", true, "")), cookies)
+	if want := "/login?two_factor=" + twoFactorEnroll; location != want {
+		t.Fatalf("redirected to %q, want %q", location, want)
+	}
+	if id := sessionUserID(t, o.h, cookies); id != 0 {
+		t.Errorf("logged in as user %d before enrolling", id)
+	}
+}
@@ -0,0 +1,298 @@
+// Package oidctest is a minimal OpenID Connect identity provider for testing
+// single sign-on. Its login page lets you sign in as any user with any
+// groups, and it signs ID tokens with a key generated when it is created.
+// It implements discovery, the authorization code flow with PKCE, JWKS and
+// userinfo, and nothing else: do not use it for anything but testing.
+//
+// It serves cmd/oidc-stub and the tests of server/auth.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "oidc-stub"
+
+// authorization is what an authorization code stands for
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	claims      jwt.MapClaims
+	expires     time.Time
+}
+
+// Provider is the stub identity provider. Set Issuer to the URL it is served
+// at before it handles requests.
+type Provider struct {
+	Issuer       string
+	ClientID     string // The only client ID accepted
+	ClientSecret string // Client secret to require, none if empty
+
+	key     *rsa.PrivateKey
+	handler http.Handler
+
+	mu     sync.Mutex
+	codes  map[string]*authorization
+	tokens map[string]jwt.MapClaims // Userinfo by access token
+}
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><head><title>OIDC stub login</title></head>
+<body style="font-family: monospace">
+<h1>OIDC stub login</h1>
+<p>Sign in to {{.ClientID}} as:</p>
+<form method="post">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}">
+{{end}}
+<p><label>Subject <input name="sub" value="alice" required></label></p>
+<p><label>Username <input name="preferred_username" value="alice"></label></p>
+<p><label>Email <input name="email" value="alice@example.com"></label>
+<label><input type="checkbox" name="email_verified" value="true" checked> verified</label></p>
+<p><label>First name <input name="given_name" value="Alice"></label>
+<label>Last name <input name="family_name" value="Example"></label></p>
+<p><label>Groups (comma-separated) <input name="groups" value="staff"></label></p>
+<p><button type="submit">Sign in</button> <button type="submit" name="deny" value="1">Deny</button></p>
+</form>
+</body></html>`))
+
+// NewProvider creates a provider for the client, generating its signing key
+func NewProvider(issuer, clientID, clientSecret string) (*Provider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	p := &Provider{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]*authorization),
+		tokens:       make(map[string]jwt.MapClaims),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /userinfo", p.userinfo)
+	p.handler = mux
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	log.Printf("Token request refused: %s: %s", code, description)
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b) // Never fails
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"userinfo_endpoint":                     p.Issuer + "/userinfo",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize shows the login page (GET) and issues a code for the entered user (POST)
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	params := map[string]string{}
+	for _, name := range []string{"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method"} {
+		params[name] = r.Form.Get(name)
+	}
+	if params["client_id"] != p.ClientID {
+		http.Error(w, "Unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(params["redirect_uri"])
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if params["response_type"] != "code" || params["code_challenge_method"] != "S256" || params["code_challenge"] == "" {
+		http.Error(w, "Only the code flow with S256 PKCE is supported", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		loginPage.Execute(w, map[string]interface{}{"ClientID": p.ClientID, "Params": params})
+		return
+	}
+
+	query := redirect.Query()
+	query.Set("state", params["state"])
+	if r.Form.Get("deny") != "" {
+		query.Set("error", "access_denied")
+		query.Set("error_description", "The user denied the request")
+	} else {
+		var groups []string
+		for _, g := range strings.Split(r.Form.Get("groups"), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+		claims := jwt.MapClaims{
+			"sub":                r.Form.Get("sub"),
+			"preferred_username": r.Form.Get("preferred_username"),
+			"email":              r.Form.Get("email"),
+			"email_verified":     r.Form.Get("email_verified") == "true",
+			"given_name":         r.Form.Get("given_name"),
+			"family_name":        r.Form.Get("family_name"),
+			"groups":             groups,
+		}
+		code := randomString()
+		p.mu.Lock()
+		p.codes[code] = &authorization{
+			clientID:    params["client_id"],
+			redirectURI: params["redirect_uri"],
+			challenge:   params["code_challenge"],
+			nonce:       params["nonce"],
+			claims:      claims,
+			expires:     time.Now().Add(time.Minute),
+		}
+		p.mu.Unlock()
+		query.Set("code", code)
+		log.Printf("Issued code for subject %q with groups %v", claims["sub"], groups)
+	}
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token exchanges a code for an ID token, checking the client, redirect URI
+// and PKCE verifier
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+	clientID, secret, hasBasic := r.BasicAuth()
+	if hasBasic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.Form.Get("client_id"), r.Form.Get("client_secret")
+	}
+	if clientID != p.ClientID || secret != p.ClientSecret {
+		tokenError(w, "invalid_client", "unknown client or wrong secret")
+		return
+	}
+
+	p.mu.Lock()
+	auth := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code")) // Codes are single use
+	p.mu.Unlock()
+	if auth == nil || time.Now().After(auth.expires) || auth.clientID != clientID {
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	}
+	if r.Form.Get("redirect_uri") != auth.redirectURI {
+		tokenError(w, "invalid_grant", "redirect_uri mismatch")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		tokenError(w, "invalid_grant", "PKCE verification failed")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer,
+		"aud":   clientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(10 * time.Minute).Unix(),
+		"nonce": auth.nonce,
+	}
+	for name, value := range auth.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	accessToken := randomString()
+	p.mu.Lock()
+	p.tokens[accessToken] = auth.claims
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   600,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": keyID,
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	accessToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	claims, ok := p.tokens[accessToken]
+	p.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	writeJSON(w, http.StatusOK, claims)
+}
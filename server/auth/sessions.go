@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// currentSessionToken returns the token of the request's session
+func (h *AuthHandlers) currentSessionToken(r *http.Request) string {
+	session, err := h.Store.Get(r, middleware.SessionName)
+	if err != nil || session == nil {
+		return ""
+	}
+	return session.ID
+}
+
+// ListSessions handles GET /api/user/me/sessions, listing where the user is
+// logged in
+func (h *AuthHandlers) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	sessions, err := h.Sessions.ListUserSessions(user.ID, h.currentSessionToken(r))
+	if err != nil {
+		log.Printf("Error listing sessions of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, sessions)
+}
+
+// RevokeSession handles DELETE /api/user/me/sessions/{session_id}, logging
+// the user out of one session. Revoking the current session logs them out.
+func (h *AuthHandlers) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	sessionID, err := strconv.ParseInt(r.PathValue("session_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid session ID format", http.StatusBadRequest)
+		return
+	}
+	tokenHash, err := h.Sessions.DeleteUserSession(user.ID, sessionID)
+	if errors.Is(err, models.ErrSessionNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking session %d of user %d: %v", sessionID, user.ID, err)
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+	if h.Hub != nil {
+		h.Hub.DisconnectSession(user.ID, tokenHash)
+	}
+	h.audit(r, models.AuditSessionsRevoked, user, fmt.Sprintf("session %d", sessionID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions handles DELETE /api/user/me/sessions, logging the user
+// out everywhere but the current session
+func (h *AuthHandlers) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	current := h.currentSessionToken(r)
+	if current == "" {
+		http.Error(w, "Unauthorized: No session", http.StatusUnauthorized)
+		return
+	}
+	revoked, err := h.Sessions.DeleteUserSessions(user.ID, current)
+	if err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	if revoked > 0 {
+		if h.Hub != nil {
+			h.Hub.DisconnectOtherSessions(user.ID, models.HashSessionToken(current))
+		}
+		h.audit(r, models.AuditSessionsRevoked, user, fmt.Sprintf("%d other sessions", revoked))
+	}
+	writeJSON(w, http.StatusOK, map[string]int64{"revoked": revoked})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"encoding/base32"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// SessionStore is a sessions.Store keeping sessions in the database, so that
+// they can be listed and revoked. The cookie holds a random token signed with
+// the session key, which is also the session's ID; the values are stored with
+// it, signed the same way.
+//
+// The token changes whenever the user logged in with the session does (at
+// login, logout or the start of a second login step), so that a token known
+// before a login cannot be used after it.
+type SessionStore struct {
+	Codecs   []securecookie.Codec
+	Options  *sessions.Options // Default configuration
+	Sessions *models.SessionService
+}
+
+// NewSessionStore creates a session store. See sessions.NewCookieStore for
+// the key pairs.
+func NewSessionStore(sessionService *models.SessionService, keyPairs ...[]byte) *SessionStore {
+	store := &SessionStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 7,
+		},
+		Sessions: sessionService,
+	}
+	store.MaxAge(store.Options.MaxAge)
+	return store
+}
+
+// MaxAge sets the maximum age of sessions and their cookies
+func (s *SessionStore) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry.
+func (s *SessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the request's cookie, or a new session if it has
+// none or its session was revoked, expired, or belongs to a deactivated user.
+func (s *SessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	var token string
+	if err := securecookie.DecodeMulti(name, cookie.Value, &token, s.Codecs...); err != nil {
+		return session, err
+	}
+	stored, err := s.Sessions.GetSessionByToken(token)
+	if err != nil || stored == nil {
+		return session, err
+	}
+	if err := securecookie.DecodeMulti(name, stored.Data, &session.Values, s.Codecs...); err != nil {
+		return session, err
+	}
+	session.ID = token
+	session.IsNew = false
+
+	if err := s.Sessions.TouchSession(stored, middleware.ClientIP(r), r.UserAgent()); err != nil {
+		log.Printf("Error recording activity of session %d: %v", stored.ID, err)
+	}
+	return session, nil
+}
+
+// Save stores the session and sets its cookie. A session with a MaxAge <= 0
+// is deleted.
+func (s *SessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.Sessions.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	userID, _ := session.Values[string(middleware.UserIDContextKey)].(int)
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second)
+
+	if session.ID != "" {
+		storedUserID, exists, err := s.Sessions.GetSessionUserID(session.ID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !exists && userID != 0:
+			// Revoked while the request was handled
+			log.Printf("Session of user %d was revoked, not saving it", userID)
+			http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+			return nil
+		case exists && storedUserID == int64(userID):
+			if err := s.Sessions.UpdateSession(session.ID, data, expiresAt); err != nil {
+				return err
+			}
+			return s.setCookie(w, session)
+		case exists:
+			if err := s.Sessions.DeleteSession(session.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	session.ID = base32NoPadding.EncodeToString(securecookie.GenerateRandomKey(32))
+	if _, err := s.Sessions.CreateSession(session.ID, int64(userID), data,
+		middleware.ClientIP(r), r.UserAgent(), expiresAt); err != nil {
+		return err
+	}
+	return s.setCookie(w, session)
+}
+
+func (s *SessionStore) setCookie(w http.ResponseWriter, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
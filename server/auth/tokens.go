@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// maxAPITokenNameLength limits the names of API tokens
+const maxAPITokenNameLength = 100
+
+// CreateAPITokenRequest is the body of POST /api/user/me/tokens
+type CreateAPITokenRequest struct {
+	Name          string `json:"name"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"` // Never expires if 0
+}
+
+// CreatedAPIToken is the response to creating an API token. The token is
+// not shown again.
+type CreatedAPIToken struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// ListAPITokens handles GET /api/user/me/tokens, listing the user's API tokens
+func (h *AuthHandlers) ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	tokens, err := h.Tokens.ListAPITokens(user.ID)
+	if err != nil {
+		log.Printf("Error listing API tokens of user %d: %v", user.ID, err)
+		http.Error(w, "Failed to list API tokens", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// CreateAPIToken handles POST /api/user/me/tokens. The route requires the
+// use_api_tokens permission.
+func (h *AuthHandlers) CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAPITokenNameLength {
+		http.Error(w, fmt.Sprintf("Bad Request: name is required, up to %d characters", maxAPITokenNameLength), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "Bad Request: expires_in_days cannot be negative", http.StatusBadRequest)
+		return
+	}
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	apiToken, token, err := h.Tokens.CreateAPIToken(user.ID, req.Name, expiresAt)
+	if errors.Is(err, models.ErrTooManyAPITokens) {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating API token for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to create API token", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, models.AuditAPITokenCreated, user, fmt.Sprintf("token %d (%s)", apiToken.ID, apiToken.Name))
+	writeJSON(w, http.StatusCreated, CreatedAPIToken{APIToken: *apiToken, Token: token})
+}
+
+// RevokeAPIToken handles DELETE /api/user/me/tokens/{token_id}
+func (h *AuthHandlers) RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	tokenID, err := strconv.ParseInt(r.PathValue("token_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID format", http.StatusBadRequest)
+		return
+	}
+	err = h.Tokens.DeleteAPIToken(user.ID, tokenID)
+	if errors.Is(err, models.ErrAPITokenNotFound) {
+		http.Error(w, "API token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error revoking API token %d of user %d: %v", tokenID, user.ID, err)
+		http.Error(w, "Failed to revoke API token", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, models.AuditAPITokenRevoked, user, fmt.Sprintf("token %d", tokenID))
+	w.WriteHeader(http.StatusNoContent)
+}
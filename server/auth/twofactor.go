@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/utils"
+)
+
+// Session values of a login waiting for its second factor. The session only
+// grants access once the user ID is moved to middleware.UserIDContextKey.
+const (
+	pendingUserIDKey = "pending_2fa_user_id"
+	pendingSinceKey  = "pending_2fa_since"
+)
+
+// pendingLoginTimeout is how long a user has to enter their second factor
+const pendingLoginTimeout = 5 * time.Minute
+
+// Second login steps, told to the login page by POST /login, or in the
+// two_factor query parameter after single sign-on
+const (
+	twoFactorVerify = "verify" // Enter a code
+	twoFactorEnroll = "enroll" // Set up two-factor authentication, required by the user's role
+)
+
+// TwoFactorRequest is the body of the requests confirming a second factor:
+// either a code from the authenticator app or a recovery code
+type TwoFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// TwoFactorEnrollment is the response to starting an enrollment. The
+// otpauth URI is what authenticator apps read from a QR code.
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// audit records a security event of the user, logging failures to record it
+func (h *AuthHandlers) audit(r *http.Request, event string, user *models.User, details string) {
+	if h.Audit == nil {
+		return
+	}
+	if err := h.Audit.Record(event, user, 0, middleware.ClientIP(r), details); err != nil {
+		log.Printf("Error recording audit event: %v", err)
+	}
+}
+
+// beginSecondFactor checks whether the user, who passed their first factor,
+// needs a second login step, and if so stores the pending login in the
+// session and returns the step. It returns "" if the login can complete now.
+func (h *AuthHandlers) beginSecondFactor(w http.ResponseWriter, r *http.Request, session *sessions.Session, user *models.User) (string, error) {
+	status, err := h.UserService.GetTwoFactorStatus(user.ID)
+	if err != nil {
+		return "", err
+	}
+	if !status.Enabled && !status.Required {
+		return "", nil
+	}
+
+	step := twoFactorVerify
+	if !status.Enabled {
+		step = twoFactorEnroll
+	}
+	// Whoever was logged in with this session is not anymore
+	delete(session.Values, string(middleware.UserIDContextKey))
+	session.Values[pendingUserIDKey] = int(user.ID)
+	session.Values[pendingSinceKey] = time.Now().Unix()
+	if err := session.Save(r, w); err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	log.Printf("First factor verified for User ID %d (%s), two-factor step: %s", user.ID, user.Username, step)
+	return step, nil
+}
+
+// startSecondFactor begins the second login step of a password login, if
+// the user needs one, and tells the login page which step. It returns false
+// if the login can complete now.
+func (h *AuthHandlers) startSecondFactor(w http.ResponseWriter, r *http.Request, session *sessions.Session, user *models.User) (bool, error) {
+	step, err := h.beginSecondFactor(w, r, session, user)
+	if err != nil || step == "" {
+		return false, err
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"message":    "Two-factor authentication required",
+		"two_factor": step,
+	})
+	return true, nil
+}
+
+// completeLogin logs the user in with the session
+func (h *AuthHandlers) completeLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session, user *models.User) bool {
+	delete(session.Values, pendingUserIDKey)
+	delete(session.Values, pendingSinceKey)
+	session.Values[string(middleware.UserIDContextKey)] = int(user.ID) // Ensure type matches middleware expectations (int)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Error saving session for user %d: %v", user.ID, err)
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return false
+	}
+	log.Printf("Login successful for User ID: %d (%s)", user.ID, user.Username)
+	return true
+}
+
+// pendingLogin returns the session and user of a login waiting for its second
+// factor, writing an error if there is none or it has expired
+func (h *AuthHandlers) pendingLogin(w http.ResponseWriter, r *http.Request) (*sessions.Session, *models.User, bool) {
+	session, err := h.Store.Get(r, middleware.SessionName)
+	if err != nil && session == nil {
+		http.Error(w, "Session initialization failed", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	userID, _ := session.Values[pendingUserIDKey].(int)
+	since, _ := session.Values[pendingSinceKey].(int64)
+	if userID <= 0 {
+		http.Error(w, "Unauthorized: No login is waiting for a second factor, please log in again", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	if time.Since(time.Unix(since, 0)) > pendingLoginTimeout {
+		h.abandonPendingLogin(w, r, session)
+		http.Error(w, "Unauthorized: Your login has expired, please log in again", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+
+	user, err := h.UserService.GetUserByID(int64(userID))
+	if err != nil || !user.IsActive {
+		log.Printf("Pending login of user %d refused: %v", userID, err)
+		h.abandonPendingLogin(w, r, session)
+		http.Error(w, "Unauthorized: Please log in again", http.StatusUnauthorized)
+		return nil, nil, false
+	}
+	return session, user, true
+}
+
+func (h *AuthHandlers) abandonPendingLogin(w http.ResponseWriter, r *http.Request, session *sessions.Session) {
+	delete(session.Values, pendingUserIDKey)
+	delete(session.Values, pendingSinceKey)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Error saving session: %v", err)
+	}
+}
+
+// failSecondFactor handles a code of a pending login that was wrong, or not
+// checked because the user's second factor is locked, abandoning the login
+// if it is locked. Failures are counted by user (see
+// models.MaxTwoFactorFailures), not by session, so that replaying an older
+// session cookie does not reset them.
+func (h *AuthHandlers) failSecondFactor(w http.ResponseWriter, r *http.Request, session *sessions.Session, user *models.User, err error, details string) {
+	h.auditRejectedCode(r, user, err, details)
+	if !errors.Is(err, models.ErrTwoFactorLocked) {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	h.abandonPendingLogin(w, r, session)
+	http.Error(w, fmt.Sprintf("Unauthorized: Too many invalid codes, please log in again in %d minutes",
+		int(models.TwoFactorLockout.Minutes())), http.StatusUnauthorized)
+}
+
+// auditRejectedCode records a wrong code, and the lockout if it caused one
+func (h *AuthHandlers) auditRejectedCode(r *http.Request, user *models.User, err error, details string) {
+	if !errors.Is(err, models.ErrInvalidTwoFactorCode) {
+		return
+	}
+	h.audit(r, models.AuditTwoFactorFailed, user, details)
+	if errors.Is(err, models.ErrTwoFactorLocked) {
+		h.audit(r, models.AuditTwoFactorLockedOut, user, fmt.Sprintf("%d failed codes in a row", models.MaxTwoFactorFailures))
+	}
+}
+
+// isCodeRejected reports whether err is from a wrong code or a locked second factor
+func isCodeRejected(err error) bool {
+	return errors.Is(err, models.ErrInvalidTwoFactorCode) || errors.Is(err, models.ErrTwoFactorLocked)
+}
+
+// VerifyTwoFactor handles POST /auth/2fa/verify, the second login step of
+// users with two-factor authentication. It accepts a code from the
+// authenticator app or a recovery code.
+func (h *AuthHandlers) VerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.RecoveryCode != "" {
+		remaining, err := h.UserService.UseRecoveryCode(user.ID, req.RecoveryCode)
+		if isCodeRejected(err) || errors.Is(err, models.ErrTwoFactorNotEnabled) {
+			h.failSecondFactor(w, r, session, user, err, "invalid recovery code")
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking recovery code of user %d: %v", user.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, models.AuditRecoveryCodeUsed, user, fmt.Sprintf("%d recovery codes left", remaining))
+		if h.completeLogin(w, r, session, user) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"message":                  "Login successful",
+				"recovery_codes_remaining": remaining,
+			})
+		}
+		return
+	}
+
+	err := h.UserService.VerifyTwoFactorCode(user.ID, req.Code)
+	if isCodeRejected(err) || errors.Is(err, models.ErrTwoFactorNotEnabled) {
+		h.failSecondFactor(w, r, session, user, err, "invalid code")
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking two-factor code of user %d: %v", user.ID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, models.AuditTwoFactorVerified, user, "")
+	if h.completeLogin(w, r, session, user) {
+		writeJSON(w, http.StatusOK, map[string]string{"message": "Login successful"})
+	}
+}
+
+// EnrollTwoFactorAtLogin handles POST /auth/2fa/enroll, starting the
+// enrollment of a user whose role requires two-factor authentication in the
+// middle of their login
+func (h *AuthHandlers) EnrollTwoFactorAtLogin(w http.ResponseWriter, r *http.Request) {
+	_, user, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	h.startEnrollment(w, r, user)
+}
+
+// ConfirmTwoFactorAtLogin handles POST /auth/2fa/enroll/confirm, enabling
+// two-factor authentication with a code of the new secret and completing the
+// login. The response has the user's recovery codes.
+func (h *AuthHandlers) ConfirmTwoFactorAtLogin(w http.ResponseWriter, r *http.Request) {
+	session, user, ok := h.pendingLogin(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	codes, err := h.UserService.EnableTwoFactor(user.ID, req.Code)
+	if isCodeRejected(err) {
+		h.failSecondFactor(w, r, session, user, err, "invalid code confirming enrollment")
+		return
+	}
+	if !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	h.audit(r, models.AuditTwoFactorEnabled, user, "at login")
+	if h.completeLogin(w, r, session, user) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"message":        "Login successful",
+			"recovery_codes": codes,
+		})
+	}
+}
+
+// startEnrollment generates a secret for the user and writes it with its
+// otpauth URI
+func (h *AuthHandlers) startEnrollment(w http.ResponseWriter, r *http.Request, user *models.User) {
+	secret, err := h.UserService.StartTwoFactorEnrollment(user.ID)
+	if !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	h.audit(r, models.AuditTwoFactorEnrollmentStarted, user, "")
+	issuer := h.TwoFactorIssuer
+	if issuer == "" {
+		issuer = "CyberAI"
+	}
+	writeJSON(w, http.StatusOK, TwoFactorEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPProvisioningURI(issuer, user.Username, secret),
+	})
+}
+
+// writeTwoFactorError writes the response for an error of the two-factor
+// methods of UserService, and returns whether there was none
+func (h *AuthHandlers) writeTwoFactorError(w http.ResponseWriter, err error, userID int64) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, models.ErrTwoFactorLocked):
+		http.Error(w, "Too many invalid codes, please try again later", http.StatusTooManyRequests)
+	case errors.Is(err, models.ErrInvalidTwoFactorCode):
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+	case errors.Is(err, models.ErrTwoFactorEnabled):
+		http.Error(w, "Conflict: Two-factor authentication is already enabled", http.StatusConflict)
+	case errors.Is(err, models.ErrTwoFactorNotEnabled):
+		http.Error(w, "Conflict: Two-factor authentication is not enabled, or its enrollment was not started", http.StatusConflict)
+	case errors.Is(err, models.ErrTwoFactorRequired):
+		http.Error(w, "Forbidden: Two-factor authentication is required for your role", http.StatusForbidden)
+	default:
+		log.Printf("Two-factor error for user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+// currentUser returns the logged-in user, writing an error if there is none
+func (h *AuthHandlers) currentUser(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return nil, false
+	}
+	user, err := h.UserService.GetUserByID(int64(userID))
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, false
+	}
+	return user, true
+}
+
+// checkSecondFactor checks the code or recovery code of a logged-in user
+// changing their two-factor settings, writing an error if it is wrong
+func (h *AuthHandlers) checkSecondFactor(w http.ResponseWriter, r *http.Request, user *models.User, req TwoFactorRequest) bool {
+	var err error
+	if req.RecoveryCode != "" {
+		var remaining int
+		remaining, err = h.UserService.UseRecoveryCode(user.ID, req.RecoveryCode)
+		if err == nil {
+			h.audit(r, models.AuditRecoveryCodeUsed, user, fmt.Sprintf("%d recovery codes left", remaining))
+		}
+	} else {
+		err = h.UserService.VerifyTwoFactorCode(user.ID, req.Code)
+	}
+	h.auditRejectedCode(r, user, err, "invalid code changing settings")
+	return h.writeTwoFactorError(w, err, user.ID)
+}
+
+// GetTwoFactorStatus handles GET /api/user/me/2fa
+func (h *AuthHandlers) GetTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	status, err := h.UserService.GetTwoFactorStatus(user.ID)
+	if !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// StartTwoFactorEnrollment handles POST /api/user/me/2fa/enroll, generating
+// a secret to add to an authenticator app. Two-factor authentication is
+// enabled once a code is confirmed with POST /api/user/me/2fa/enable.
+func (h *AuthHandlers) StartTwoFactorEnrollment(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	h.startEnrollment(w, r, user)
+}
+
+// EnableTwoFactor handles POST /api/user/me/2fa/enable, confirming the
+// enrollment with a code of the new secret. The response has the user's
+// recovery codes, which are not shown again.
+func (h *AuthHandlers) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	codes, err := h.UserService.EnableTwoFactor(user.ID, req.Code)
+	h.auditRejectedCode(r, user, err, "invalid code confirming enrollment")
+	if !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	h.audit(r, models.AuditTwoFactorEnabled, user, "")
+	writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// RegenerateRecoveryCodes handles POST /api/user/me/2fa/recovery-codes,
+// replacing the user's recovery codes after checking a code
+func (h *AuthHandlers) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkSecondFactor(w, r, user, req) {
+		return
+	}
+	codes, err := h.UserService.RegenerateRecoveryCodes(user.ID)
+	if !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	h.audit(r, models.AuditRecoveryCodesRegenerated, user, "")
+	writeJSON(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// DisableTwoFactor handles DELETE /api/user/me/2fa after checking a code,
+// unless the user's role requires two-factor authentication
+func (h *AuthHandlers) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.currentUser(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if user.Role != nil && user.Role.RequireTwoFactor {
+		h.writeTwoFactorError(w, models.ErrTwoFactorRequired, user.ID)
+		return
+	}
+	if !h.checkSecondFactor(w, r, user, req) {
+		return
+	}
+	if err := h.UserService.DisableTwoFactor(user.ID); !h.writeTwoFactorError(w, err, user.ID) {
+		return
+	}
+	h.audit(r, models.AuditTwoFactorDisabled, user, "")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/utils"
+)
+
+// enableTwoFactor enrolls the user in two-factor authentication, returning
+// their TOTP secret. The code confirming it is of the previous time step, so
+// that the current one can still log in.
+func enableTwoFactor(t *testing.T, h *AuthHandlers, userID int64) string {
+	t.Helper()
+	secret, err := h.UserService.StartTwoFactorEnrollment(userID)
+	if err != nil {
+		t.Fatalf("StartTwoFactorEnrollment: %v", err)
+	}
+	if _, err := h.UserService.EnableTwoFactor(userID, totpCode(t, secret, -1)); err != nil {
+		t.Fatalf("EnableTwoFactor: %v", err)
+	}
+	return secret
+}
+
+// totpCode returns the code of the secret offset time steps from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := utils.TOTPCode(secret, utils.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	return code
+}
+
+// wrongCode returns a code of the secret that is not accepted now
+func wrongCode(t *testing.T, secret string) string {
+	return totpCode(t, secret, 100)
+}
+
+// serveJSON calls the handler with the body as JSON and the cookies
+func serveJSON(handler http.HandlerFunc, path string, body interface{}, cookies []*http.Cookie) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	return rec
+}
+
+// passwordLogin logs in with the password, expecting a second factor step,
+// and returns the session cookies
+func passwordLogin(t *testing.T, h *AuthHandlers, username, password, step string) []*http.Cookie {
+	t.Helper()
+	rec := serveJSON(h.Login, "/login", map[string]string{"username": username, "password": password}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login status = %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		TwoFactor string `json:"two_factor"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.TwoFactor != step {
+		t.Fatalf("login response = %s, want two-factor step %q", rec.Body, step)
+	}
+	return rec.Result().Cookies()
+}
+
+// verifyCode submits a code of a pending login, returning the response and
+// the session cookies after it
+func verifyCode(h *AuthHandlers, cookies []*http.Cookie, code string) (*httptest.ResponseRecorder, []*http.Cookie) {
+	rec := serveJSON(h.VerifyTwoFactor, "/auth/2fa/verify", TwoFactorRequest{Code: code}, cookies)
+	if len(rec.Result().Cookies()) > 0 {
+		cookies = rec.Result().Cookies()
+	}
+	return rec, cookies
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	h := newTestHandlers(t)
+	user := createUser(t, h, "lena", "lena@example.com", "password1", models.UserRoleID)
+	secret := enableTwoFactor(t, h, user.ID)
+
+	cookies := passwordLogin(t, h, "lena", "password1", twoFactorVerify)
+	if id := sessionUserID(t, h, cookies); id != 0 {
+		t.Fatalf("logged in as user %d before the second factor", id)
+	}
+	rec, cookies := verifyCode(h, cookies, totpCode(t, secret, 0))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verify status = %d: %s", rec.Code, rec.Body)
+	}
+	if id := sessionUserID(t, h, cookies); id != user.ID {
+		t.Errorf("logged in as user %d, want %d", id, user.ID)
+	}
+}
+
+func TestTwoFactorLockoutSurvivesCookieReplay(t *testing.T) {
+	h := newTestHandlers(t)
+	user := createUser(t, h, "mia", "mia@example.com", "password1", models.UserRoleID)
+	secret := enableTwoFactor(t, h, user.ID)
+
+	// Every attempt replays the cookie of the pending login, which used to
+	// carry the failure count
+	cookies := passwordLogin(t, h, "mia", "password1", twoFactorVerify)
+	for i := 1; i <= models.MaxTwoFactorFailures; i++ {
+		rec, _ := verifyCode(h, cookies, wrongCode(t, secret))
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i, rec.Code, http.StatusUnauthorized)
+		}
+		locked := strings.Contains(rec.Body.String(), "Too many invalid codes")
+		if locked != (i == models.MaxTwoFactorFailures) {
+			t.Fatalf("attempt %d: response %q", i, rec.Body)
+		}
+	}
+
+	// Neither a new login nor the right code gets through while locked
+	cookies = passwordLogin(t, h, "mia", "password1", twoFactorVerify)
+	rec, cookies := verifyCode(h, cookies, totpCode(t, secret, 0))
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Too many invalid codes") {
+		t.Fatalf("verify while locked: status = %d: %s", rec.Code, rec.Body)
+	}
+	if id := sessionUserID(t, h, cookies); id != 0 {
+		t.Fatalf("logged in as user %d while locked", id)
+	}
+	if _, err := h.UserService.UseRecoveryCode(user.ID, "any-code"); err != models.ErrTwoFactorLocked {
+		t.Errorf("UseRecoveryCode while locked = %v, want %v", err, models.ErrTwoFactorLocked)
+	}
+
+	// Once the lockout is over, the right code logs in
+	if _, err := h.UserService.DB.Exec(`UPDATE user_two_factor SET locked_until = ? WHERE user_id = ?`,
+		time.Now().Add(-time.Second), user.ID); err != nil {
+		t.Fatalf("ending lockout: %v", err)
+	}
+	cookies = passwordLogin(t, h, "mia", "password1", twoFactorVerify)
+	rec, cookies = verifyCode(h, cookies, totpCode(t, secret, 0))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("verify after lockout: status = %d: %s", rec.Code, rec.Body)
+	}
+	if id := sessionUserID(t, h, cookies); id != user.ID {
+		t.Errorf("logged in as user %d, want %d", id, user.ID)
+	}
+}
+
+func TestTwoFactorFailuresResetOnSuccess(t *testing.T) {
+	h := newTestHandlers(t)
+	user := createUser(t, h, "noah", "noah@example.com", "password1", models.UserRoleID)
+	secret := enableTwoFactor(t, h, user.ID)
+
+	for i := 0; i < models.MaxTwoFactorFailures-1; i++ {
+		if err := h.UserService.VerifyTwoFactorCode(user.ID, wrongCode(t, secret)); err != models.ErrInvalidTwoFactorCode {
+			t.Fatalf("VerifyTwoFactorCode = %v, want %v", err, models.ErrInvalidTwoFactorCode)
+		}
+	}
+	if err := h.UserService.VerifyTwoFactorCode(user.ID, totpCode(t, secret, 0)); err != nil {
+		t.Fatalf("VerifyTwoFactorCode: %v", err)
+	}
+	// The count starts over, so one more wrong code does not lock
+	if err := h.UserService.VerifyTwoFactorCode(user.ID, wrongCode(t, secret)); err != models.ErrInvalidTwoFactorCode {
+		t.Errorf("VerifyTwoFactorCode = %v, want %v", err, models.ErrInvalidTwoFactorCode)
+	}
+}
@@ -14,7 +14,7 @@ import (
 
 const (
 	// Schema version
-	SchemaVersion = 1
+	SchemaVersion = 21
 
 	// Default database file
 	DefaultDBPath = "./data/cyberai.db"
@@ -36,8 +36,13 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to create database directory: %w", err)
 	}
 
-	// Open database with foreign key constraints enabled
-	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_foreign_keys=on", dbPath))
+	// Open the database. The busy timeout is set in the DSN so that it applies
+	// to every pooled connection, since concurrent responses write to the
+	// database at the same time.
+	//
+	// Foreign key constraints are not enforced, so their ON DELETE clauses do
+	// nothing: deleting a row must delete or detach the rows referring to it.
+	db, err := sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(5000)", dbPath))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -83,11 +88,19 @@ func (db *DB) Initialize() error {
 		return fmt.Errorf("failed to query schema version: %w", err)
 	}
 
-	if err == sql.ErrNoRows || version < SchemaVersion {
-		// Apply migrations
+	if err == sql.ErrNoRows || version < 1 {
+		// Apply the baseline schema
 		if err := db.migrate(); err != nil {
 			return err
 		}
+		version = 1
+	}
+
+	if version < SchemaVersion {
+		// Apply incremental migrations on top of the baseline
+		if err := db.applyMigrations(version); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -271,11 +284,11 @@ func (db *DB) migrate() error {
 		return fmt.Errorf("failed to create default admin user: %w", err)
 	}
 
-	// Insert schema version record
+	// Insert schema version record for the baseline schema
 	_, err = db.Exec(`
 		INSERT INTO schema_versions (version)
 		VALUES (?)
-	`, SchemaVersion)
+	`, 1)
 
 	if err != nil {
 		// If the error is a constraint violation, the version is likely already there
@@ -366,6 +379,40 @@ func (db *DB) DeleteChatsAndMessagesByUserID(userID int64) error {
 		msgsDeleted, _ := result.RowsAffected()
 		log.Printf("Deleted %d messages for user %d", msgsDeleted, userID)
 
+		// Delete tool invocation log entries for those chats
+		query = fmt.Sprintf("DELETE FROM tool_invocations WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete tool invocations for user %d: %w", userID, err)
+		}
+
+		// Delete rolling summaries of those chats
+		query = fmt.Sprintf("DELETE FROM chat_summaries WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete chat summaries for user %d: %w", userID, err)
+		}
+
+		// Delete share links of those chats
+		query = fmt.Sprintf("DELETE FROM chat_shares WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete chat shares for user %d: %w", userID, err)
+		}
+
+		// Remove everyone the user shared those chats with
+		query = fmt.Sprintf("DELETE FROM chat_participants WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete chat participants for user %d: %w", userID, err)
+		}
+
+		// Remove how anyone had organised those chats
+		query = fmt.Sprintf("DELETE FROM chat_user_settings WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete chat settings for user %d: %w", userID, err)
+		}
+		query = fmt.Sprintf("DELETE FROM chat_tags WHERE chat_id IN (%s)", strings.Join(placeholders, ","))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("failed to delete chat tags for user %d: %w", userID, err)
+		}
+
 		// Second, delete the user's chats
 		result, err = tx.Exec("DELETE FROM chats WHERE user_id = ?", userID)
 		if err != nil {
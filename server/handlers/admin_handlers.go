@@ -3,17 +3,23 @@ package handlers
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log"
 	"net/http"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/ramborogers/cyberai/server/db"
+	"github.com/ramborogers/cyberai/server/llm"
 	"github.com/ramborogers/cyberai/server/middleware"
 	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/ws"
 )
 
 // AdminHandlers provides handlers for admin-related endpoints
@@ -21,52 +27,81 @@ type AdminHandlers struct {
 	ModelService    *models.ModelService
 	ProviderService *models.ProviderService
 	UserService     *models.UserService
+	ChatService     *models.ChatService
+	AuditService    *models.AuditService
+	SessionService  *models.SessionService
 	DB              *db.DB
+	Hub             *ws.Hub // Tells connected users when the models they can use change, disconnects logged out users
 	TemplatesFS     fs.FS
 }
 
 // NewAdminHandlers creates a new instance of AdminHandlers
-func NewAdminHandlers(database *db.DB, templatesFS fs.FS) *AdminHandlers {
+func NewAdminHandlers(database *db.DB, hub *ws.Hub, templatesFS fs.FS) *AdminHandlers {
 	return &AdminHandlers{
 		ModelService:    models.NewModelService(database),
 		ProviderService: models.NewProviderService(database),
 		UserService:     models.NewUserService(database),
+		ChatService:     models.NewChatService(database, nil),
+		AuditService:    models.NewAuditService(database),
+		SessionService:  models.NewSessionService(database),
 		DB:              database,
+		Hub:             hub,
 		TemplatesFS:     templatesFS,
 	}
 }
 
-// RegisterAdminRoutes registers the admin routes with the server mux
-// UPDATED: Apply middleware directly to handlers here.
-// UPDATED: Paths are relative to the /admin/ prefix handled in main.go
-func (h *AdminHandlers) RegisterAdminRoutes(mux *http.ServeMux, adminRequired func(http.Handler) http.Handler) {
+// RegisterAdminRoutes registers the admin routes with the server mux.
+// Paths are relative to the /api/admin/ prefix handled in main.go; each route
+// requires one of the given permissions of the user's role.
+func (h *AdminHandlers) RegisterAdminRoutes(mux *http.ServeMux, requirePermission func(permissions ...string) func(http.Handler) http.Handler) {
+	manageModels := requirePermission(models.PermManageModels)
+	manageUsers := requirePermission(models.PermManageUsers)
+	manageProviders := requirePermission(models.PermManageProviders)
 
 	// Model routes (relative to /admin/)
-	mux.Handle("GET /models", adminRequired(http.HandlerFunc(h.ListModels)))
-	mux.Handle("POST /models", adminRequired(http.HandlerFunc(h.CreateModel)))
-	mux.Handle("GET /models/{id}", adminRequired(http.HandlerFunc(h.GetModel)))
-	mux.Handle("PUT /models/{id}", adminRequired(http.HandlerFunc(h.UpdateModel)))
-	mux.Handle("DELETE /models/{id}", adminRequired(http.HandlerFunc(h.DeleteModel)))
+	mux.Handle("GET /models", manageModels(http.HandlerFunc(h.ListModels)))
+	mux.Handle("POST /models", manageModels(http.HandlerFunc(h.CreateModel)))
+	mux.Handle("GET /models/{id}", manageModels(http.HandlerFunc(h.GetModel)))
+	mux.Handle("PUT /models/{id}", manageModels(http.HandlerFunc(h.UpdateModel)))
+	mux.Handle("DELETE /models/{id}", manageModels(http.HandlerFunc(h.DeleteModel)))
+	mux.Handle("GET /models/{id}/access", manageModels(http.HandlerFunc(h.GetModelAccess)))
+	mux.Handle("PUT /models/{id}/access", manageModels(http.HandlerFunc(h.SetModelAccess)))
 
 	// User routes (relative to /admin/)
-	mux.Handle("GET /users", adminRequired(http.HandlerFunc(h.ListUsers)))
-	mux.Handle("POST /users", adminRequired(http.HandlerFunc(h.CreateUser)))
-	mux.Handle("GET /users/{id}", adminRequired(http.HandlerFunc(h.GetUser)))
-	mux.Handle("PUT /users/{id}", adminRequired(http.HandlerFunc(h.UpdateUser)))
-	mux.Handle("DELETE /users/{id}", adminRequired(http.HandlerFunc(h.DeleteUser)))
-	mux.Handle("POST /users/{id}/password", adminRequired(http.HandlerFunc(h.SetUserPasswordAdmin)))
-
-	// Role routes (relative to /admin/)
-	mux.Handle("GET /roles", adminRequired(http.HandlerFunc(h.ListRoles)))
-	mux.Handle("GET /roles/{id}/users", adminRequired(http.HandlerFunc(h.GetUsersByRole)))
+	mux.Handle("GET /users", manageUsers(http.HandlerFunc(h.ListUsers)))
+	mux.Handle("POST /users", manageUsers(http.HandlerFunc(h.CreateUser)))
+	mux.Handle("GET /users/{id}", manageUsers(http.HandlerFunc(h.GetUser)))
+	mux.Handle("PUT /users/{id}", manageUsers(http.HandlerFunc(h.UpdateUser)))
+	mux.Handle("DELETE /users/{id}", manageUsers(http.HandlerFunc(h.DeleteUser)))
+	mux.Handle("POST /users/{id}/password", manageUsers(http.HandlerFunc(h.SetUserPasswordAdmin)))
+	mux.Handle("DELETE /users/{id}/2fa", manageUsers(http.HandlerFunc(h.ResetUserTwoFactor)))
+	mux.Handle("GET /users/{id}/sessions", manageUsers(http.HandlerFunc(h.ListUserSessions)))
+	mux.Handle("DELETE /users/{id}/sessions", manageUsers(http.HandlerFunc(h.ForceLogoutUser)))
+	mux.Handle("GET /audit-log", manageUsers(http.HandlerFunc(h.ListAuditLog)))
+	mux.Handle("GET /users/{id}/models", requirePermission(models.PermManageUsers, models.PermManageModels)(http.HandlerFunc(h.ListUserModels)))
+
+	// Role routes (relative to /admin/). Roles are listed to everyone managing
+	// users or models, who assign them users and models.
+	mux.Handle("GET /roles", requirePermission(models.PermManageUsers, models.PermManageModels)(http.HandlerFunc(h.ListRoles)))
+	mux.Handle("POST /roles", manageUsers(http.HandlerFunc(h.CreateRole)))
+	mux.Handle("PUT /roles/{id}", manageUsers(http.HandlerFunc(h.UpdateRole)))
+	mux.Handle("DELETE /roles/{id}", manageUsers(http.HandlerFunc(h.DeleteRole)))
+	mux.Handle("GET /roles/{id}/users", manageUsers(http.HandlerFunc(h.GetUsersByRole)))
+	mux.Handle("GET /permissions", requirePermission(models.AdminPermissions...)(http.HandlerFunc(h.ListPermissions)))
+
+	// Chat routes (relative to /admin/)
+	mux.Handle("GET /chats/search", manageUsers(http.HandlerFunc(h.SearchChats)))
+
+	// Usage routes (relative to /admin/)
+	mux.Handle("GET /usage", requirePermission(models.PermViewUsage)(http.HandlerFunc(h.GetUsage)))
 
 	// Provider Routes (relative to /admin/)
-	mux.Handle("GET /providers", adminRequired(http.HandlerFunc(h.ListProviders)))
-	mux.Handle("POST /providers", adminRequired(http.HandlerFunc(h.CreateProvider)))
-	mux.Handle("GET /providers/{id}", adminRequired(http.HandlerFunc(h.GetProvider)))
-	mux.Handle("PUT /providers/{id}", adminRequired(http.HandlerFunc(h.UpdateProvider)))
-	mux.Handle("DELETE /providers/{id}", adminRequired(http.HandlerFunc(h.DeleteProvider)))
-	mux.Handle("POST /providers/{id}/sync", adminRequired(http.HandlerFunc(h.SyncProviderModels)))
+	mux.Handle("GET /providers", manageProviders(http.HandlerFunc(h.ListProviders)))
+	mux.Handle("POST /providers", manageProviders(http.HandlerFunc(h.CreateProvider)))
+	mux.Handle("GET /providers/{id}", manageProviders(http.HandlerFunc(h.GetProvider)))
+	mux.Handle("PUT /providers/{id}", manageProviders(http.HandlerFunc(h.UpdateProvider)))
+	mux.Handle("DELETE /providers/{id}", manageProviders(http.HandlerFunc(h.DeleteProvider)))
+	mux.Handle("POST /providers/{id}/sync", manageProviders(http.HandlerFunc(h.SyncProviderModels)))
 }
 
 // serveFileFromFS serves a file from the embedded filesystem
@@ -137,12 +172,18 @@ func (h *AdminHandlers) CreateModel(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if _, err := llm.SamplingParamsFromConfig(model.Configuration); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
 
+	before := h.modelListSnapshot()
 	if err := h.ModelService.CreateModel(&model); err != nil {
 		log.Printf("Error creating model: %v", err)
 		http.Error(w, "Failed to create model", http.StatusInternalServerError)
 		return
 	}
+	h.notifyModelListChanges(before)
 
 	// Don't return the API key in the response
 	// model.APIKey = "" // No longer exists on model
@@ -201,12 +242,18 @@ func (h *AdminHandlers) UpdateModel(w http.ResponseWriter, r *http.Request) {
 
 	// Ensure the ID from the path matches the body
 	model.ID = modelID
+	if _, err := llm.SamplingParamsFromConfig(model.Configuration); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
 
+	before := h.modelListSnapshot()
 	if err := h.ModelService.UpdateModel(&model); err != nil {
 		log.Printf("Error updating model %d: %v", modelID, err)
 		http.Error(w, "Failed to update model", http.StatusInternalServerError)
 		return
 	}
+	h.notifyModelListChanges(before)
 
 	// Don't return the API key in the response
 	// model.APIKey = "" // No longer exists on model
@@ -226,15 +273,82 @@ func (h *AdminHandlers) DeleteModel(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := h.modelListSnapshot()
 	if err := h.ModelService.DeleteModel(modelID); err != nil {
 		log.Printf("Error deleting model %d: %v", modelID, err)
 		http.Error(w, "Failed to delete model", http.StatusInternalServerError)
 		return
 	}
+	h.notifyModelListChanges(before)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetModelAccess handles GET /api/admin/models/{id}/access
+func (h *AdminHandlers) GetModelAccess(w http.ResponseWriter, r *http.Request) {
+	modelID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid model ID", http.StatusBadRequest)
+		return
+	}
+
+	access, err := h.ModelService.GetModelAccess(modelID)
+	if errors.Is(err, models.ErrModelNotFound) {
+		http.Error(w, "Model not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting access of model %d: %v", modelID, err)
+		http.Error(w, "Failed to get model access", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(access)
+}
+
+// SetModelAccess handles PUT /api/admin/models/{id}/access
+// Replaces who can use the model: everyone (allow_all), or the listed roles and users.
+func (h *AdminHandlers) SetModelAccess(w http.ResponseWriter, r *http.Request) {
+	modelID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid model ID", http.StatusBadRequest)
+		return
+	}
+
+	var access models.ModelAccess
+	if err := json.NewDecoder(r.Body).Decode(&access); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	access.ModelID = modelID
+
+	before := h.modelListSnapshot()
+	err = h.ModelService.SetModelAccess(&access)
+	if errors.Is(err, models.ErrModelNotFound) {
+		http.Error(w, "Model not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, models.ErrInvalidModelGrant) {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting access of model %d: %v", modelID, err)
+		http.Error(w, "Failed to set model access", http.StatusInternalServerError)
+		return
+	}
+	h.notifyModelListChanges(before)
+
+	saved, err := h.ModelService.GetModelAccess(modelID)
+	if err != nil {
+		log.Printf("Error getting access of model %d after update: %v", modelID, err)
+		saved = &access
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
 // --- User Handlers ---
 
 // ListUsers handles GET /api/admin/users
@@ -324,6 +438,9 @@ func (h *AdminHandlers) CreateUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Missing required fields (username, email, role_id)", http.StatusBadRequest)
 		return
 	}
+	if !h.checkCanGrantRole(w, r, newUser.RoleID) {
+		return
+	}
 
 	// Call the user service method with the manually populated struct
 	if err := h.UserService.CreateUser(&newUser, password); err != nil {
@@ -394,14 +511,22 @@ func (h *AdminHandlers) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// -------------------------------------
+	if !h.checkCanManageUser(w, r, userID) || !h.checkCanGrantRole(w, r, userUpdates.RoleID) {
+		return
+	}
 
-	// Call the service to update the user
+	// Call the service to update the user (a new role may change their models)
+	before := h.modelListSnapshot()
 	if err := h.UserService.UpdateUser(&userUpdates); err != nil {
 		log.Printf("[Admin UpdateUser] Error updating user %d: %v", userID, err)
 		// Handle specific errors like "not found" if UpdateUser returns them
 		http.Error(w, "Failed to update user", http.StatusInternalServerError)
 		return
 	}
+	h.notifyModelListChanges(before)
+	if !userUpdates.IsActive {
+		h.disconnectUser(userID) // Deactivation ended their sessions
+	}
 
 	// Fetch the full updated user data to return (including role, etc.)
 	updatedUser, err := h.UserService.GetUserByID(userID)
@@ -455,6 +580,9 @@ func (h *AdminHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !h.checkCanGrantRole(w, r, user.RoleID) {
+		return
+	}
 
 	// Deactivate the user
 	user.IsActive = false
@@ -463,6 +591,7 @@ func (h *AdminHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Failed to deactivate user", http.StatusInternalServerError)
 		return
 	}
+	h.disconnectUser(userIDToDeactivate) // Deactivation ended their sessions
 
 	log.Printf("[Admin DeleteUser] User %d successfully deactivated by admin %d.", userIDToDeactivate, requestingAdminID)
 	w.WriteHeader(http.StatusNoContent)
@@ -496,6 +625,9 @@ func (h *AdminHandlers) SetUserPasswordAdmin(w http.ResponseWriter, r *http.Requ
 		http.Error(w, "Password must be at least 8 characters long", http.StatusBadRequest)
 		return
 	}
+	if !h.checkCanManageUser(w, r, userID) {
+		return
+	}
 
 	// Call the service layer function
 	if err := h.UserService.SetUserPassword(userID, request.Password); err != nil {
@@ -511,10 +643,152 @@ func (h *AdminHandlers) SetUserPasswordAdmin(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	h.disconnectUser(userID) // The new password ended their sessions
 	log.Printf("[SetUserPasswordAdmin] Password successfully set for user ID: %d", userID)
 	w.WriteHeader(http.StatusNoContent) // Success, no content needed in response
 }
 
+// ListUserSessions handles GET /api/admin/users/{id}/sessions
+// Lists where the user is logged in, most recently used first.
+func (h *AdminHandlers) ListUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCanManageUser(w, r, userID) {
+		return
+	}
+	sessions, err := h.SessionService.ListUserSessions(userID, "")
+	if err != nil {
+		log.Printf("Error listing sessions of user %d: %v", userID, err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// ForceLogoutUser handles DELETE /api/admin/users/{id}/sessions
+// Ends every session of the user and closes their WebSocket connections.
+func (h *AdminHandlers) ForceLogoutUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCanManageUser(w, r, userID) {
+		return
+	}
+	user, err := h.UserService.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	revoked, err := h.SessionService.DeleteUserSessions(userID, "")
+	if err != nil {
+		log.Printf("Error revoking sessions of user %d: %v", userID, err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+	h.disconnectUser(userID)
+	adminID := int64(middleware.GetUserIDFromContext(r.Context()))
+	details := fmt.Sprintf("%d sessions", revoked)
+	if err := h.AuditService.Record(models.AuditForcedLogout, user, adminID, middleware.ClientIP(r), details); err != nil {
+		log.Printf("Error recording audit event: %v", err)
+	}
+	log.Printf("User %d logged out of %d sessions by admin %d", userID, revoked, adminID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"revoked": revoked})
+}
+
+// disconnectUser closes the WebSocket connections of a user whose sessions
+// ended
+func (h *AdminHandlers) disconnectUser(userID int64) {
+	if h.Hub != nil {
+		h.Hub.DisconnectUser(userID)
+	}
+}
+
+// ResetUserTwoFactor handles DELETE /api/admin/users/{id}/2fa
+// Removes the user's two-factor authentication, e.g. after they lost their
+// device. If their role requires it, they enroll again at their next login.
+func (h *AdminHandlers) ResetUserTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCanManageUser(w, r, userID) {
+		return
+	}
+	user, err := h.UserService.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Error getting user %d: %v", userID, err)
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if !user.TwoFactorEnabled {
+		http.Error(w, "Conflict: The user does not have two-factor authentication", http.StatusConflict)
+		return
+	}
+
+	if err := h.UserService.DisableTwoFactor(userID); err != nil {
+		log.Printf("Error resetting two-factor authentication of user %d: %v", userID, err)
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	adminID := int64(middleware.GetUserIDFromContext(r.Context()))
+	if err := h.AuditService.Record(models.AuditTwoFactorReset, user, adminID, middleware.ClientIP(r), ""); err != nil {
+		log.Printf("Error recording audit event: %v", err)
+	}
+	log.Printf("Two-factor authentication of user %d reset by admin %d", userID, adminID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListAuditLog handles GET /api/admin/audit-log
+// Query parameters: user_id, event (an event, or a prefix like "2fa."),
+// limit (default 100, at most 500) and offset. Newest entries come first.
+func (h *AdminHandlers) ListAuditLog(w http.ResponseWriter, r *http.Request) {
+	var filter models.AuditFilter
+	query := r.URL.Query()
+	if v := query.Get("user_id"); v != "" {
+		userID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || userID <= 0 {
+			http.Error(w, "Bad Request: Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = userID
+	}
+	filter.Event = query.Get("event")
+	for _, param := range []struct {
+		name string
+		dest *int
+	}{{"limit", &filter.Limit}, {"offset", &filter.Offset}} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("Bad Request: Invalid %s", param.name), http.StatusBadRequest)
+			return
+		}
+		*param.dest = n
+	}
+
+	entries, err := h.AuditService.List(filter)
+	if err != nil {
+		log.Printf("Error listing audit log: %v", err)
+		http.Error(w, "Failed to list audit log", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
 // --- Role Handlers ---
 
 // ListRoles handles GET /api/admin/roles
@@ -532,6 +806,172 @@ func (h *AdminHandlers) ListRoles(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(roles)
 }
 
+// CreateRole handles POST /api/admin/roles
+func (h *AdminHandlers) CreateRole(w http.ResponseWriter, r *http.Request) {
+	var role models.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCanGrant(w, r, role.Permissions) {
+		return
+	}
+
+	if err := h.UserService.CreateRole(&role); err != nil {
+		h.writeRoleError(w, err, "create")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRole handles PUT /api/admin/roles/{id}
+// Replaces the role's name, description and permissions.
+func (h *AdminHandlers) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+
+	var role models.Role
+	if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	role.ID = roleID
+	// Both what the role grants now and what it will grant
+	if !h.checkCanGrantRole(w, r, roleID) || !h.checkCanGrant(w, r, role.Permissions) {
+		return
+	}
+
+	before := h.modelListSnapshot()
+	if err := h.UserService.UpdateRole(&role); err != nil {
+		h.writeRoleError(w, err, "update")
+		return
+	}
+	h.notifyModelListChanges(before) // Roles with every permission can use every model
+
+	saved, err := h.UserService.GetRole(roleID)
+	if err != nil {
+		log.Printf("Error fetching role %d after update: %v", roleID, err)
+		saved = &role
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// DeleteRole handles DELETE /api/admin/roles/{id}
+// Only roles no user has can be deleted; built-in roles cannot.
+func (h *AdminHandlers) DeleteRole(w http.ResponseWriter, r *http.Request) {
+	roleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid role ID", http.StatusBadRequest)
+		return
+	}
+	if !h.checkCanGrantRole(w, r, roleID) {
+		return
+	}
+
+	if err := h.UserService.DeleteRole(roleID); err != nil {
+		h.writeRoleError(w, err, "delete")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeRoleError writes the response for an error from a role change
+func (h *AdminHandlers) writeRoleError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, models.ErrRoleNotFound):
+		http.Error(w, "Role not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrInvalidRole):
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+	case errors.Is(err, models.ErrRoleExists), errors.Is(err, models.ErrRoleInUse), errors.Is(err, models.ErrBuiltinRole):
+		http.Error(w, fmt.Sprintf("Conflict: %v", err), http.StatusConflict)
+	default:
+		log.Printf("Error trying to %s role: %v", action, err)
+		http.Error(w, fmt.Sprintf("Failed to %s role", action), http.StatusInternalServerError)
+	}
+}
+
+// ListPermissions handles GET /api/admin/permissions
+// Lists the permissions roles can grant, with their descriptions.
+func (h *AdminHandlers) ListPermissions(w http.ResponseWriter, r *http.Request) {
+	type permission struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	names := make([]string, 0, len(models.PermissionDescriptions))
+	for name := range models.PermissionDescriptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	permissions := make([]permission, 0, len(names))
+	for _, name := range names {
+		permissions = append(permissions, permission{Name: name, Description: models.PermissionDescriptions[name]})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permissions)
+}
+
+// checkCanGrant checks that the caller has every permission in granted, so
+// that nobody gives a role or user more than they have themselves, writing
+// the error response if not.
+func (h *AdminHandlers) checkCanGrant(w http.ResponseWriter, r *http.Request, granted models.Permissions) bool {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	own, err := h.UserService.GetUserPermissions(int64(userID))
+	if err != nil {
+		log.Printf("Error getting permissions of user %d: %v", userID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	for name, ok := range granted {
+		if ok && !own.Has(name) {
+			log.Printf("Forbidden: User %d attempted to grant the %s permission", userID, name)
+			http.Error(w, fmt.Sprintf("Forbidden: You cannot grant the %s permission", name), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+// checkCanGrantRole checks that the caller has every permission of the role
+// (see checkCanGrant), writing the error response if not.
+func (h *AdminHandlers) checkCanGrantRole(w http.ResponseWriter, r *http.Request, roleID int64) bool {
+	role, err := h.UserService.GetRole(roleID)
+	if errors.Is(err, models.ErrRoleNotFound) {
+		http.Error(w, "Bad Request: Role not found", http.StatusBadRequest)
+		return false
+	}
+	if err != nil {
+		log.Printf("Error getting role %d: %v", roleID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	return h.checkCanGrant(w, r, role.Permissions)
+}
+
+// checkCanManageUser checks that the caller has every permission of the
+// user's role, so that nobody edits users with more permissions than
+// themselves, writing the error response if not.
+func (h *AdminHandlers) checkCanManageUser(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	user, err := h.UserService.GetUserByID(userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "User not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error getting user %d: %v", userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return h.checkCanGrantRole(w, r, user.RoleID)
+}
+
 // GetUsersByRole handles GET /api/admin/roles/{id}/users
 func (h *AdminHandlers) GetUsersByRole(w http.ResponseWriter, r *http.Request) {
 	// TODO: Implement authentication check - admin only
@@ -554,6 +994,105 @@ func (h *AdminHandlers) GetUsersByRole(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(users)
 }
 
+// GetUsage handles GET /api/admin/usage?user_id=...&since=...&until=...
+// Returns the recorded token usage per user and model. since and until are
+// RFC 3339 times or dates (YYYY-MM-DD); until is exclusive.
+func (h *AdminHandlers) GetUsage(w http.ResponseWriter, r *http.Request) {
+	var filter models.UsageFilter
+	query := r.URL.Query()
+	if v := query.Get("user_id"); v != "" {
+		userID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || userID <= 0 {
+			http.Error(w, "Bad Request: Invalid user_id", http.StatusBadRequest)
+			return
+		}
+		filter.UserID = userID
+	}
+	for _, param := range []struct {
+		name string
+		dest *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		v := query.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", v)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: Invalid %s (use RFC 3339 or YYYY-MM-DD)", param.name), http.StatusBadRequest)
+			return
+		}
+		*param.dest = t
+	}
+
+	usage, err := h.ChatService.GetUsageSummary(filter)
+	if err != nil {
+		log.Printf("Error getting usage: %v", err)
+		http.Error(w, "Failed to get usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(usage)
+}
+
+// ListUserModels handles GET /api/admin/users/{id}/models
+// Lists the models the user can use, as GET /api/models returns them to the user.
+func (h *AdminHandlers) ListUserModels(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if _, err := h.UserService.GetUserByID(userID); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	userModels, err := h.ModelService.GetUserFacingModelsForUser(userID)
+	if err != nil {
+		log.Printf("Error listing models of user %d: %v", userID, err)
+		http.Error(w, "Failed to list user models", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(userModels)
+}
+
+// --- Chat Handlers ---
+
+// SearchChats handles GET /api/admin/chats/search?q=...&user_id=...&limit=...
+// Searches every user's chats, or one user's if user_id is given.
+func (h *AdminHandlers) SearchChats(w http.ResponseWriter, r *http.Request) {
+	query, limit, ok := parseSearchParams(w, r)
+	if !ok {
+		return
+	}
+
+	var userID int64
+	if userIDStr := r.URL.Query().Get("user_id"); userIDStr != "" {
+		var err error
+		userID, err = strconv.ParseInt(userIDStr, 10, 64)
+		if err != nil || userID <= 0 {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := h.ChatService.SearchChats(userID, query, limit)
+	if err != nil {
+		log.Printf("Error searching chats (user %d): %v", userID, err)
+		http.Error(w, "Failed to search chats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
 // --- Provider Handlers ---
 
 // ListProviders handles GET /api/admin/providers
@@ -659,6 +1198,7 @@ func (h *AdminHandlers) UpdateProvider(w http.ResponseWriter, r *http.Request) {
 
 	provider.ID = providerID
 
+	before := h.modelListSnapshot()
 	if err := h.ProviderService.UpdateProvider(&provider); err != nil {
 		// Check for not found error from service
 		if strings.Contains(err.Error(), "not found") {
@@ -674,6 +1214,7 @@ func (h *AdminHandlers) UpdateProvider(w http.ResponseWriter, r *http.Request) {
 
 	// Success - log and return
 	log.Printf("[DEBUG] Provider %d successfully updated", providerID)
+	h.notifyModelListChanges(before)
 
 	// Return updated provider (without API key)
 	provider.APIKey = ""
@@ -690,6 +1231,7 @@ func (h *AdminHandlers) DeleteProvider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	before := h.modelListSnapshot()
 	if err := h.ProviderService.DeleteProvider(providerID); err != nil {
 		// Check for not found error from service
 		if strings.Contains(err.Error(), "not found") {
@@ -700,6 +1242,7 @@ func (h *AdminHandlers) DeleteProvider(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	h.notifyModelListChanges(before)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -739,6 +1282,7 @@ func (h *AdminHandlers) SyncProviderModels(w http.ResponseWriter, r *http.Reques
 
 	var createdModels []models.Model
 	var syncErrors []error
+	before := h.modelListSnapshot()
 
 	switch provider.Type {
 	case models.ProviderOllama:
@@ -767,6 +1311,8 @@ func (h *AdminHandlers) SyncProviderModels(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	h.notifyModelListChanges(before) // Sync may also update or deactivate models
+
 	// Return response: maybe number created, updated, deactivated?
 	// For now, mimic the old response: return newly created models.
 	response := struct {
@@ -783,5 +1329,55 @@ func (h *AdminHandlers) SyncProviderModels(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(response)
 }
 
+// modelListSnapshot returns the models each connected user can use, to be
+// passed to notifyModelListChanges once a change is made
+func (h *AdminHandlers) modelListSnapshot() map[int64][]models.UserFacingModel {
+	if h.Hub == nil {
+		return nil
+	}
+	userIDs := h.Hub.ConnectedUserIDs()
+	snapshot := make(map[int64][]models.UserFacingModel, len(userIDs))
+	for _, userID := range userIDs {
+		userModels, err := h.ModelService.GetUserFacingModelsForUser(userID)
+		if err != nil {
+			log.Printf("Error fetching models of user %d for model list update: %v", userID, err)
+			continue
+		}
+		snapshot[userID] = userModels
+	}
+	return snapshot
+}
+
+// notifyModelListChanges sends the new model list to every connected user
+// whose list differs from the one in before (all of them if it is unknown)
+func (h *AdminHandlers) notifyModelListChanges(before map[int64][]models.UserFacingModel) {
+	after := h.modelListSnapshot()
+	for userID, userModels := range after {
+		if previous, ok := before[userID]; ok && reflect.DeepEqual(previous, userModels) {
+			continue
+		}
+		payload := make([]ws.UserFacingModel, 0, len(userModels))
+		for _, m := range userModels {
+			wm := ws.UserFacingModel{
+				ID:           m.ID,
+				Name:         m.Name,
+				ModelID:      m.ModelID,
+				ProviderType: m.ProviderType,
+				MaxTokens:    m.MaxTokens,
+				Temperature:  m.Temperature,
+			}
+			if m.DefaultSystemPrompt != nil {
+				wm.DefaultSystemPrompt = *m.DefaultSystemPrompt
+			}
+			payload = append(payload, wm)
+		}
+		h.Hub.SendToUser(userID, ws.Message{
+			Type:             ws.MsgTypeModelList,
+			Timestamp:        time.Now(),
+			ModelListPayload: payload,
+		})
+	}
+}
+
 // --- Helper functions (e.g., for parsing requests, sending responses) ---
 // Could be added here or in a separate utils package if they grow complex
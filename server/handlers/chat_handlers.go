@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	// "strconv"
@@ -22,19 +23,28 @@ import (
 
 type ChatHandlers struct {
 	ChatService      *models.ChatService
+	ProjectService   *models.ProjectService
+	ModelService     *models.ModelService  // Checks which models the user may use
+	UserService      *models.UserService   // Checks the user's permissions
 	Hub              *ws.Hub               // WebSocket hub
 	ConnectorService *llm.ConnectorService // LLM connector service
 }
 
-func NewChatHandlers(cs *models.ChatService, hub *ws.Hub, connSvc *llm.ConnectorService) *ChatHandlers {
+func NewChatHandlers(cs *models.ChatService, ps *models.ProjectService, ms *models.ModelService, hub *ws.Hub, connSvc *llm.ConnectorService) *ChatHandlers {
 	return &ChatHandlers{
 		ChatService:      cs,
+		ProjectService:   ps,
+		ModelService:     ms,
+		UserService:      models.NewUserService(cs.DB),
 		Hub:              hub,
 		ConnectorService: connSvc, // Store ConnectorService
 	}
 }
 
 // ListChats handles GET /api/chats
+// Lists the chats the user owns or participates in, pinned first, optionally
+// filtered, sorted and paginated (see parseChatListOptions). The total number
+// of matching chats is returned in the X-Total-Count header.
 func (h *ChatHandlers) ListChats(w http.ResponseWriter, r *http.Request) {
 	userID := middleware.GetUserIDFromContext(r.Context())
 	if userID == 0 {
@@ -44,36 +54,214 @@ func (h *ChatHandlers) ListChats(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Printf("ListChats called by User ID: %d", userID)
 
-	// Fetch all chats (active and inactive) for the user, ordered by updated_at desc
-	chats, err := h.ChatService.GetUserChats(int64(userID), false)
+	opts, ok := parseChatListOptions(w, r)
+	if !ok {
+		return
+	}
+
+	chats, total, err := h.ChatService.ListChats(int64(userID), opts)
 	if err != nil {
 		log.Printf("Error fetching chats for user %d: %v", userID, err)
 		http.Error(w, "Failed to retrieve chats", http.StatusInternalServerError)
 		return
 	}
 
-	// If no chats found, return an empty list, not an error
-	if chats == nil {
-		chats = []models.Chat{}
-	}
-
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(chats); err != nil {
 		log.Printf("Error encoding chats response for user %d: %v", userID, err)
 	}
 }
 
+// parseChatListOptions reads the query parameters of GET /api/chats:
+// folder_id and project_id (an ID, or "none"), tag (repeatable; all must match),
+// model_id, from and to (RFC 3339 times or YYYY-MM-DD dates, on the last
+// update; a date for "to" includes that whole day), pinned (true or false),
+// sort (updated_at, created_at or title), order (asc or desc), limit and
+// offset. It writes a 400 response and returns false if any are invalid.
+func parseChatListOptions(w http.ResponseWriter, r *http.Request) (models.ChatListOptions, bool) {
+	q := r.URL.Query()
+	opts := models.ChatListOptions{Sort: q.Get("sort")}
+	fail := func(msg string) (models.ChatListOptions, bool) {
+		http.Error(w, "Bad Request: "+msg, http.StatusBadRequest)
+		return opts, false
+	}
+
+	if v := q.Get("folder_id"); v == "none" {
+		opts.FolderID = new(int64)
+	} else if v != "" {
+		folderID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || folderID <= 0 {
+			return fail("folder_id must be a folder ID or none")
+		}
+		opts.FolderID = &folderID
+	}
+	if v := q.Get("project_id"); v == "none" {
+		opts.ProjectID = new(int64)
+	} else if v != "" {
+		projectID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || projectID <= 0 {
+			return fail("project_id must be a project ID or none")
+		}
+		opts.ProjectID = &projectID
+	}
+	for _, tag := range q["tag"] {
+		tag, err := models.NormalizeTag(tag)
+		if err != nil {
+			return fail(err.Error())
+		}
+		opts.Tags = append(opts.Tags, tag)
+	}
+	if v := q.Get("model_id"); v != "" {
+		modelID, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || modelID <= 0 {
+			return fail("Invalid model_id")
+		}
+		opts.ModelID = modelID
+	}
+	for _, param := range []string{"from", "to"} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if t, err = time.Parse(time.DateOnly, v); err != nil {
+				return fail(param + " must be an RFC 3339 time or a YYYY-MM-DD date")
+			}
+			if param == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		if param == "from" {
+			opts.From = &t
+		} else {
+			opts.To = &t
+		}
+	}
+	if v := q.Get("pinned"); v != "" {
+		pinned, err := strconv.ParseBool(v)
+		if err != nil {
+			return fail("pinned must be true or false")
+		}
+		opts.Pinned = &pinned
+	}
+
+	if !models.ValidChatListSort(opts.Sort) {
+		return fail("sort must be updated_at, created_at or title")
+	}
+	switch q.Get("order") {
+	case "":
+		opts.Descending = opts.Sort != "title"
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return fail("order must be asc or desc")
+	}
+
+	opts.Limit = models.DefaultChatListLimit
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > models.MaxChatListLimit {
+			return fail(fmt.Sprintf("limit must be between 1 and %d", models.MaxChatListLimit))
+		}
+		opts.Limit = limit
+	}
+	if v := q.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return fail("offset must be 0 or more")
+		}
+		opts.Offset = offset
+	}
+	return opts, true
+}
+
+// SearchChats handles GET /api/chats/search?q=...&limit=...
+// Only the caller's own chats are searched.
+func (h *ChatHandlers) SearchChats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	query, limit, ok := parseSearchParams(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := h.ChatService.SearchChats(int64(userID), query, limit)
+	if err != nil {
+		log.Printf("Error searching chats for user %d: %v", userID, err)
+		http.Error(w, "Failed to search chats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Printf("Error encoding chat search response for user %d: %v", userID, err)
+	}
+}
+
+// parseSearchParams reads the q and limit query parameters of a chat search,
+// writing a 400 response and returning false if they are invalid.
+func parseSearchParams(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "Bad Request: q is required", http.StatusBadRequest)
+		return "", 0, false
+	}
+
+	limit := models.DefaultSearchLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > models.MaxSearchLimit {
+			http.Error(w, fmt.Sprintf("Bad Request: limit must be between 1 and %d", models.MaxSearchLimit), http.StatusBadRequest)
+			return "", 0, false
+		}
+	}
+	return query, limit, true
+}
+
 // CreateChatRequest defines the expected JSON body for POST /api/chats
 type CreateChatRequest struct {
 	Title        *string              `json:"title,omitempty"`         // Optional title
+	ProjectID    *int64               `json:"project_id,omitempty"`    // Optional project to create the chat in
 	FirstMessage *FirstMessagePayload `json:"first_message,omitempty"` // Optional first message
 }
 
 // FirstMessagePayload defines the structure for the optional first message
 type FirstMessagePayload struct {
-	Content string `json:"content"`  // Required if first_message is present
-	ModelID int64  `json:"model_id"` // Required if first_message is present
+	Content  string  `json:"content"`             // Required if first_message is present
+	ModelID  int64   `json:"model_id"`            // Required if first_message is present (unless model_ids is given or the project has a default)
+	ModelIDs []int64 `json:"model_ids,omitempty"` // Optional: several models to answer side by side (replaces model_id)
+	GenerationOptions
+}
+
+// GenerationOptions are optional per-request overrides for how the response
+// is generated. They are embedded in the message request bodies.
+type GenerationOptions struct {
+	ResponseFormat *llm.ResponseFormat `json:"response_format,omitempty"` // Optional: Request JSON output (overrides the agent's)
+	Sampling       *llm.SamplingParams `json:"sampling,omitempty"`        // Optional: Sampling parameters (override the model's and agent's)
+}
+
+// Validate checks the options are well formed.
+func (o GenerationOptions) Validate() error {
+	if o.ResponseFormat != nil {
+		if err := o.ResponseFormat.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Sampling != nil {
+		if err := o.Sampling.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // CreateChat handles POST /api/chats
@@ -95,23 +283,50 @@ func (h *ChatHandlers) CreateChat(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// The chat's project must be visible to the user; its defaults apply to
+	// the first message
+	var firstAgentID *int64
+	if req.ProjectID != nil && *req.ProjectID > 0 {
+		if !h.checkProjectVisible(w, userID, *req.ProjectID) {
+			return
+		}
+		if req.FirstMessage != nil {
+			defaultModelID, defaultAgentID, err := h.ProjectService.GetProjectDefaults(*req.ProjectID)
+			if err != nil {
+				log.Printf("Error fetching defaults of project %d: %v", *req.ProjectID, err)
+			}
+			if req.FirstMessage.ModelID <= 0 && len(req.FirstMessage.ModelIDs) == 0 {
+				req.FirstMessage.ModelID = defaultModelID
+			}
+			firstAgentID = defaultAgentID
+		}
+	}
+
 	// Validate: If first_message is present, content and model_id are required
 	if req.FirstMessage != nil {
 		if req.FirstMessage.Content == "" {
 			http.Error(w, "Bad Request: first_message requires content", http.StatusBadRequest)
 			return
 		}
-		if req.FirstMessage.ModelID <= 0 {
-			http.Error(w, "Bad Request: first_message requires a valid model_id", http.StatusBadRequest)
+		if _, err := responseModelIDs(req.FirstMessage.ModelID, req.FirstMessage.ModelIDs); err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: first_message: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := req.FirstMessage.GenerationOptions.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+			return
+		}
+		modelIDs, _ := responseModelIDs(req.FirstMessage.ModelID, req.FirstMessage.ModelIDs)
+		if !h.checkModelAccess(w, userID, modelIDs...) || !h.checkAgentAccess(w, userID, firstAgentID) {
 			return
 		}
-		// TODO: Future - Validate that the model_id exists and is accessible by the user
 	}
 
-	// Determine chat title
-	title := "New Chat" // Default
+	// Determine chat title. Default titles are replaced by a generated one
+	// after the first reply; titles given here are kept.
+	title, titleSource := "New Chat", models.TitleSourceDefault
 	if req.Title != nil && *req.Title != "" {
-		title = *req.Title // Use provided title
+		title, titleSource = *req.Title, models.TitleSourceUser // Use provided title
 	} else if req.FirstMessage != nil && req.FirstMessage.Content != "" {
 		// Use first message content as title if no explicit title provided
 		title = req.FirstMessage.Content
@@ -123,12 +338,19 @@ func (h *ChatHandlers) CreateChat(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create chat in DB
-	newChat, err := h.ChatService.CreateChat(int64(userID), title)
+	newChat, err := h.ChatService.CreateChat(int64(userID), title, titleSource)
 	if err != nil {
 		log.Printf("Error creating chat in DB for user %d: %v", userID, err)
 		http.Error(w, "Failed to create chat", http.StatusInternalServerError)
 		return
 	}
+	if req.ProjectID != nil && *req.ProjectID > 0 {
+		if err := h.ProjectService.SetChatProject(newChat.ID, req.ProjectID); err != nil {
+			log.Printf("Error setting project of new chat %d: %v", newChat.ID, err)
+		} else {
+			newChat.ProjectID = req.ProjectID
+		}
+	}
 
 	// Handle first message if provided
 	if req.FirstMessage != nil {
@@ -137,6 +359,7 @@ func (h *ChatHandlers) CreateChat(w http.ResponseWriter, r *http.Request) {
 			UserID:  int64(userID),
 			Role:    "user",
 			Content: req.FirstMessage.Content,
+			AgentID: firstAgentID, // The project's default agent, if any
 			// ModelID is null for user messages
 		}
 		if err := h.ChatService.AddMessage(&userMessage); err != nil {
@@ -148,10 +371,13 @@ func (h *ChatHandlers) CreateChat(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Added first user message (ID: %d) for new chat %d", userMessage.ID, newChat.ID)
 			// Use a background context for the goroutine
 			bgCtx := context.Background()
-			go h.processAIResponse(bgCtx, userID, userMessage, req.FirstMessage.ModelID)
+			modelIDs, _ := responseModelIDs(req.FirstMessage.ModelID, req.FirstMessage.ModelIDs)
+			h.respondToMessage(bgCtx, userID, userMessage, modelIDs, req.FirstMessage.GenerationOptions)
 		}
 	}
 
+	h.sendChatListUpdate(newChat.ID) // The user's other tabs and devices
+
 	// Return the created chat object (without messages initially)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -193,11 +419,24 @@ func (h *ChatHandlers) GetChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Authorization check: Ensure the user owns this chat
+	// Authorization check: the owner and everyone the chat is shared with
+	// can read it
 	if chat.UserID != int64(userID) {
-		log.Printf("Forbidden: User %d attempted to access chat %d owned by user %d", userID, chatID, chat.UserID)
-		http.Error(w, "Forbidden: You do not have access to this chat", http.StatusForbidden)
-		return
+		role, ok := h.checkChatAccess(w, r, userID, chatID, models.ChatRoleViewer)
+		if !ok {
+			return
+		}
+		chat.Role = role
+	}
+	if err := h.ChatService.LoadChatOrganization(int64(userID), chat); err != nil {
+		log.Printf("Error fetching folder and tags of chat %d for user %d: %v", chatID, userID, err)
+	}
+	// Name the authors of messages in shared chats
+	participants, err := h.ChatService.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("Error fetching participants of chat %d: %v", chatID, err)
+	} else if len(participants) > 1 {
+		chat.Participants = participants
 	}
 
 	// Return the chat object with messages
@@ -262,8 +501,8 @@ func (h *ChatHandlers) UpdateChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Update the title
-	if err := h.ChatService.UpdateChatTitle(chatID, req.Title); err != nil {
+	// Update the title; it is the user's from now on
+	if _, err := h.ChatService.UpdateChatTitle(chatID, req.Title, models.TitleSourceUser); err != nil {
 		log.Printf("Error updating title for chat %d: %v", chatID, err)
 		http.Error(w, "Internal Server Error: Failed to update chat title", http.StatusInternalServerError)
 		return
@@ -280,6 +519,7 @@ func (h *ChatHandlers) UpdateChat(w http.ResponseWriter, r *http.Request) {
 		existingChat.Title = req.Title // Manually update title in the fetched object
 		updatedChat = existingChat     // Use this as fallback
 	}
+	h.sendChatListUpdate(chatID)
 
 	// Return the updated chat object
 	w.Header().Set("Content-Type", "application/json")
@@ -326,6 +566,12 @@ func (h *ChatHandlers) DeleteChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Everyone who sees the chat in their list, looked up before it is gone
+	participants, err := h.ChatService.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("Error fetching participants of chat %d before delete: %v", chatID, err)
+	}
+
 	// Delete the chat and associated data
 	if err := h.ChatService.DeleteChat(chatID); err != nil {
 		// The service layer might return specific errors, but for now, assume 500
@@ -334,6 +580,12 @@ func (h *ChatHandlers) DeleteChat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	removed := make(map[int64][]int64, len(participants))
+	for _, p := range participants {
+		removed[p.UserID] = []int64{chatID}
+	}
+	h.sendChatListRemoval(removed)
+
 	// Success
 	w.WriteHeader(http.StatusNoContent)
 	log.Printf("Successfully deleted chat %d by user %d", chatID, userID)
@@ -349,6 +601,12 @@ func (h *ChatHandlers) PurgeUserChats(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("PurgeUserChats called by User ID: %d", userID)
 
+	// Everyone who sees one of the user's chats in their list
+	removed, err := h.ChatService.GetOwnedChatAudience(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching audience of user %d's chats before purge: %v", userID, err)
+	}
+
 	// Call the service layer function to delete all chats for the user.
 	// This assumes ChatService has a method like DeleteChatsByUserID.
 	// IMPORTANT: This DB method needs to be implemented and handle deleting
@@ -360,16 +618,50 @@ func (h *ChatHandlers) PurgeUserChats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.sendChatListRemoval(removed)
+
 	// Success
 	w.WriteHeader(http.StatusNoContent)
 	log.Printf("Successfully purged all chats for user %d", userID)
 }
 
+// maxCompareModels limits how many models can answer one message side by side
+const maxCompareModels = 4
+
 // CreateMessageRequest defines the structure for POST /api/chats/{id}/messages
 type CreateMessageRequest struct {
-	Content string `json:"content"`            // Required
-	ModelID int64  `json:"model_id"`           // Required: ID of model to use for response
-	AgentID *int64 `json:"agent_id,omitempty"` // Optional: Agent to use
+	Content  string  `json:"content"`             // Required
+	ModelID  int64   `json:"model_id"`            // Required unless model_ids is given or the chat's project has a default: ID of model to use for response
+	ModelIDs []int64 `json:"model_ids,omitempty"` // Optional: several models to answer side by side (replaces model_id)
+	AgentID  *int64  `json:"agent_id,omitempty"`  // Optional: Agent to use (defaults to the chat's project's)
+	GenerationOptions
+}
+
+// responseModelIDs returns the models to answer a message with: model_ids
+// without duplicates, in the order requested, or else model_id.
+func responseModelIDs(modelID int64, modelIDs []int64) ([]int64, error) {
+	if len(modelIDs) == 0 {
+		if modelID <= 0 {
+			return nil, errors.New("a valid model_id is required")
+		}
+		return []int64{modelID}, nil
+	}
+
+	var ids []int64
+	seen := make(map[int64]bool, len(modelIDs))
+	for _, id := range modelIDs {
+		if id <= 0 {
+			return nil, fmt.Errorf("invalid model ID in model_ids: %d", id)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxCompareModels {
+		return nil, fmt.Errorf("at most %d models can be compared at once", maxCompareModels)
+	}
+	return ids, nil
 }
 
 // CreateMessage handles POST /api/chats/{chat_id}/messages
@@ -401,14 +693,18 @@ func (h *ChatHandlers) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad Request: Message content cannot be empty", http.StatusBadRequest)
 		return
 	}
-	if req.ModelID <= 0 {
-		http.Error(w, "Bad Request: A valid model_id is required", http.StatusBadRequest)
+	h.applyProjectDefaults(chatID, &req.ModelID, req.ModelIDs, &req.AgentID)
+	modelIDs, err := responseModelIDs(req.ModelID, req.ModelIDs)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.GenerationOptions.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
 		return
 	}
-	// TODO: Validate ModelID exists and is active/accessible by user
-	// TODO: Validate AgentID if provided
 
-	log.Printf("CreateMessage called by User ID: %d for Chat ID: %d, Model ID: %d", userID, chatID, req.ModelID)
+	log.Printf("CreateMessage called by User ID: %d for Chat ID: %d, Model IDs: %v", userID, chatID, modelIDs)
 
 	// Authorization Check: Verify user owns the chat
 	existingChat, err := h.ChatService.GetChat(chatID, false) // Don't need messages
@@ -422,8 +718,12 @@ func (h *ChatHandlers) CreateMessage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if existingChat.UserID != int64(userID) {
-		log.Printf("Forbidden: User %d attempted to post message to chat %d owned by user %d", userID, chatID, existingChat.UserID)
-		http.Error(w, "Forbidden: You do not have access to this chat", http.StatusForbidden)
+		// Contributors of a shared chat can post too; the message is theirs
+		if _, ok := h.checkChatAccess(w, r, userID, chatID, models.ChatRoleContributor); !ok {
+			return
+		}
+	}
+	if !h.checkModelAccess(w, userID, modelIDs...) || !h.checkAgentAccess(w, userID, req.AgentID) {
 		return
 	}
 
@@ -444,6 +744,7 @@ func (h *ChatHandlers) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	log.Printf("Saved user message ID %d for chat %d", userMessage.ID, chatID)
+	h.sendUserMessage(userID, userMessage)
 
 	// Return the created user message object with 202 Accepted immediately
 	w.Header().Set("Content-Type", "application/json")
@@ -456,33 +757,34 @@ func (h *ChatHandlers) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	// --- Trigger AI response asynchronously ---
 	// Use a new context for the background task, but could link to request context if needed
 	bgCtx := context.Background() // Use background context for the goroutine
-	go h.processAIResponse(bgCtx, userID, userMessage, req.ModelID)
+	h.respondToMessage(bgCtx, userID, userMessage, modelIDs, req.GenerationOptions)
+}
+
+// respondToMessage starts generating the response to a new user message in
+// the background, side by side if several models were requested.
+func (h *ChatHandlers) respondToMessage(ctx context.Context, userID int, userMessage models.Message, modelIDs []int64, opts GenerationOptions) {
+	if len(modelIDs) > 1 {
+		go h.compareAIResponses(ctx, userID, userMessage, modelIDs, opts)
+	} else {
+		go h.processAIResponse(ctx, userID, userMessage, modelIDs[0], opts)
+	}
 }
 
 // processAIResponse handles getting the LLM response and streaming it back
 // for a *new* user message. This runs in a separate goroutine.
-func (h *ChatHandlers) processAIResponse(ctx context.Context, userID int, triggeringMsg models.Message, requestedModelID int64) {
+func (h *ChatHandlers) processAIResponse(ctx context.Context, userID int, triggeringMsg models.Message, requestedModelID int64, opts GenerationOptions) {
 	chatID := triggeringMsg.ChatID
 	log.Printf("[Chat %d] Starting AI response processing for model %d (triggered by msg %d)", chatID, requestedModelID, triggeringMsg.ID)
 
 	// Send initial status update
-	h.sendWsMessage(userID, ws.Message{
+	h.sendWsMessage(userID, chatID, ws.Message{
 		Type: "status",
 		Data: map[string]interface{}{"message": "Processing...", "chat_id": chatID},
 	})
 
-	// 1. Fetch message history (including the triggering message)
-	// TODO: Make history limit configurable?
-	historyLimit := 20
-	history, err := h.ChatService.GetMessageHistory(chatID, historyLimit)
-	if err != nil {
-		log.Printf("[Chat %d] Error getting message history: %v", chatID, err)
-		h.sendWsError(userID, chatID, fmt.Sprintf("Failed to retrieve conversation history: %v", err))
-		return
-	}
-
-	// 2. Call the shared generation logic
-	_, err = h.generateAndStreamResponse(ctx, userID, chatID, requestedModelID, history, triggeringMsg.AgentID)
+	// Call the shared generation logic. The history (including the triggering
+	// message) is loaded when building the context, sized to the model's window.
+	_, err := h.generateAndStreamResponse(ctx, userID, chatID, triggeringMsg.ID, requestedModelID, triggeringMsg.AgentID, opts, false)
 	if err != nil {
 		// Error logging and WS notification are handled within generateAndStreamResponse
 		log.Printf("[Chat %d] processAIResponse finished with error: %v", chatID, err)
@@ -491,11 +793,69 @@ func (h *ChatHandlers) processAIResponse(ctx context.Context, userID int, trigge
 	}
 }
 
+// compareAIResponses answers a new user message with several models at once.
+// Each response streams in its own lane (its chunks carry its message and
+// model IDs) and is saved as an alternative reply to the message, leaving the
+// active branch alone. Once all are done, the first complete reply becomes
+// the active one until the user picks another, and the chat's summary and
+// title are updated from it alone. This runs in a separate goroutine.
+func (h *ChatHandlers) compareAIResponses(ctx context.Context, userID int, triggeringMsg models.Message, modelIDs []int64, opts GenerationOptions) {
+	chatID := triggeringMsg.ChatID
+	log.Printf("[Chat %d] Comparing responses from models %v (triggered by msg %d)", chatID, modelIDs, triggeringMsg.ID)
+
+	h.sendWsMessage(userID, chatID, ws.Message{
+		Type: "status",
+		Data: map[string]interface{}{"message": fmt.Sprintf("Comparing %d models...", len(modelIDs)), "chat_id": chatID, "message_id": triggeringMsg.ID, "model_ids": modelIDs},
+	})
+
+	replyIDs := make([]int64, len(modelIDs))
+	complete := make([]bool, len(modelIDs))
+	var wg sync.WaitGroup
+	for i, modelID := range modelIDs {
+		wg.Add(1)
+		go func(i int, modelID int64) {
+			defer wg.Done()
+			replyID, err := h.generateAndStreamResponse(ctx, userID, chatID, triggeringMsg.ID, modelID, triggeringMsg.AgentID, opts, true)
+			if err != nil {
+				log.Printf("[Chat %d] Comparison response from model %d failed: %v", chatID, modelID, err)
+			}
+			replyIDs[i] = replyID
+			complete[i] = err == nil && replyID != 0
+		}(i, modelID)
+	}
+	wg.Wait()
+
+	// Settle on the first complete reply, else the first partial one
+	chosen := -1
+	for i, replyID := range replyIDs {
+		if replyID != 0 && (chosen == -1 || complete[i] && !complete[chosen]) {
+			chosen = i
+		}
+	}
+	log.Printf("[Chat %d] Comparison finished; replies %v", chatID, replyIDs)
+	if chosen == -1 {
+		return
+	}
+	if err := h.ChatService.SetActiveMessage(chatID, replyIDs[chosen]); err != nil {
+		log.Printf("[Chat %d] Error activating comparison reply %d: %v", chatID, replyIDs[chosen], err)
+		return
+	}
+	if complete[chosen] {
+		h.updateChatSummary(chatID, modelIDs[chosen])
+		h.generateChatTitle(chatID, modelIDs[chosen])
+	}
+}
+
 // generateAndStreamResponse is the core logic for calling the LLM and streaming results.
-// It takes the prepared message history (including system prompts) and handles connector fetching,
-// API calls, streaming via WebSocket, and saving the final assistant message.
+// It builds the context from the branch ending at replyToID (the user message being
+// answered) and handles connector fetching, API calls, streaming via WebSocket, and
+// saving the final assistant message as a reply to replyToID.
+// opts carries per-request overrides of the model's and agent's settings.
+// An alternative response (one lane of a comparison) is saved without
+// becoming the active leaf and does not update the chat's summary or title,
+// which are left to the caller once a response is chosen.
 // Returns the final assistant message ID and error.
-func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int, chatID int64, modelIDToUse int64, history []models.Message, agentID *int64) (int64, error) {
+func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int, chatID int64, replyToID int64, modelIDToUse int64, agentID *int64, opts GenerationOptions, alternative bool) (int64, error) {
 	log.Printf("[Chat %d] generateAndStreamResponse called with model %d", chatID, modelIDToUse)
 
 	// 1. Get Connector and Model details
@@ -508,30 +868,30 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 	}
 	log.Printf("[Chat %d] Using model %s (%s) via %s connector for generation", chatID, model.Name, model.ModelID, model.Provider.Type)
 
-	// 2. Build the context using the ChatContextService
-	// The history slice received already includes the latest user message.
-	// We no longer need to extract it separately.
-	/* COMMENT OUT triggeringMessageContent extraction logic
-	var triggeringMessageContent string
-	if len(history) > 0 {
-		// Get the last message (usually the user's triggering message)
-		lastMsg := history[len(history)-1]
-		if lastMsg.Role == "user" {
-			triggeringMessageContent = lastMsg.Content
-			// Remove the last message since we'll add it again in the context builder
-			history = history[:len(history)-1]
+	// The agent's prompt and tools only answer users who can use it, e.g. not
+	// a contributor regenerating a reply to the owner's private agent
+	if usable, err := h.ConnectorService.CanUseAgent(int64(userID), agentID); err != nil || !usable {
+		errMsg := fmt.Sprintf("Agent %d is not available to you", *agentID)
+		if err != nil {
+			errMsg = fmt.Sprintf("Failed to check agent access: %v", err)
 		}
+		log.Printf("[Chat %d] Not generating with agent %d for user %d: %s", chatID, *agentID, userID, errMsg)
+		h.sendWsError(userID, chatID, errMsg)
+		return 0, errors.New(errMsg)
 	}
-	*/
 
-	// Use the context service to build the LLM messages array
+	// 2. Build the context using the ChatContextService, keeping room for the
+	// response's max tokens after the agent's and request's overrides
+	sampling := h.ConnectorService.ResolveSamplingParams(model, agentID, opts.Sampling)
+	maxTokens := llm.MaxTokensFor(model, sampling)
 	chatContextSvc := h.ConnectorService.GetChatContextService()
-	llmMessages, err := chatContextSvc.BuildContextForModelRequest(
+	llmMessages, contextReport, err := chatContextSvc.BuildContextForBranch(
 		ctx,
 		chatID,
+		replyToID, // The message being answered is already in history
 		modelIDToUse,
-		"", // Pass empty string - message is already in history
 		agentID,
+		maxTokens,
 	)
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed to build context for model: %v", err)
@@ -539,19 +899,32 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 		h.sendWsError(userID, chatID, errMsg)
 		return 0, errors.New(errMsg)
 	}
+	h.sendContextReport(userID, chatID, contextReport)
+
+	// The response continues the branch the context was built from, even if
+	// the user switches branches (or other responses are added) while it streams
+	parentID := &replyToID
+	saveMessage := h.ChatService.AddMessage
+	if alternative {
+		saveMessage = h.ChatService.AddAlternativeMessage
+	}
 
 	// 3. Prepare LLM Request
 	llmReq := llm.ChatCompletionRequest{
 		Model:       model.ModelID, // Use the provider-specific model ID
 		Messages:    llmMessages,
 		Temperature: model.Temperature,
-		MaxTokens:   model.MaxTokens,
+		MaxTokens:   maxTokens,
 		Stream:      true, // Always stream
 	}
+	llmReq.ResponseFormat = h.resolveResponseFormat(chatID, agentID, opts.ResponseFormat)
+	llmReq.Sampling = sampling
+	llmReq.ThinkingBudget = llm.ThinkingBudgetFromConfig(model.Configuration)
 
 	// 4. Define WebSocket streaming callback
-	var responseContent strings.Builder
-	var assistantMsgID int64 // Store the ID once the message is created
+	var responseContent, reasoningContent strings.Builder
+	var reportedUsage llm.TokenUsage // Token counts from the provider, if it reports them
+	var assistantMsgID int64         // Store the ID once the message is created
 	firstChunk := true
 
 	callback := func(cbCtx context.Context, chunk llm.ChatCompletionChunk) error {
@@ -562,19 +935,22 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 		}
 
 		responseContent.WriteString(chunk.Content)
+		reasoningContent.WriteString(chunk.Reasoning)
+		reportedUsage.Add(chunk.Usage)
 
 		// Create the assistant message DB entry on the first non-empty chunk
-		if firstChunk && chunk.Content != "" {
+		if firstChunk && (chunk.Content != "" || chunk.Reasoning != "") {
 			assistantMessage := models.Message{
 				ChatID:     chatID,
 				UserID:     0, // Indicates assistant
 				Role:       "assistant",
 				Content:    "", // Will be updated later
+				ParentID:   parentID,
 				ModelID:    &modelIDToUse,
 				AgentID:    agentID, // Use passed agent ID
 				TokensUsed: 0,       // Will be updated later
 			}
-			if err := h.ChatService.AddMessage(&assistantMessage); err != nil {
+			if err := saveMessage(&assistantMessage); err != nil {
 				log.Printf("[Chat %d] Error creating initial assistant message entry: %v", chatID, err)
 				return fmt.Errorf("failed to save initial assistant message: %w", err) // Stop stream processing
 			}
@@ -583,13 +959,18 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 			log.Printf("[Chat %d] Created initial assistant message DB entry (ID: %d)", chatID, assistantMsgID)
 		}
 
+		if chunk.Reasoning != "" {
+			h.sendReasoningChunk(userID, chatID, assistantMsgID, replyToID, modelIDToUse, chunk.Reasoning)
+		}
+
 		// Only send non-empty chunks (and final empty chunk if needed)
 		if chunk.Content != "" || chunk.IsFinal {
 			// Correctly populate the ChunkPayload field
 			payload := ws.ChunkPayload{
-				ChatID:  chatID,
-				Content: chunk.Content,
-				IsFinal: chunk.IsFinal,
+				ChatID:   chatID,
+				ParentID: parentID,
+				Content:  chunk.Content,
+				IsFinal:  chunk.IsFinal,
 			}
 
 			// Set MessageID if available
@@ -608,20 +989,21 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 				Timestamp:    time.Now(),
 				ChunkPayload: &payload,
 			}
-			h.sendWsMessage(userID, wsMsg)
+			h.sendWsMessage(userID, chatID, wsMsg)
 		}
 
 		return nil // Indicate success
 	}
 
 	// Send status update before calling LLM
-	h.sendWsMessage(userID, ws.Message{
+	h.sendWsMessage(userID, chatID, ws.Message{
 		Type: "status",
 		Data: map[string]interface{}{"message": "Generating response...", "chat_id": chatID},
 	})
 
-	// 5. Call the Connector
-	err = connector.GenerateChatCompletion(ctx, llmReq, callback)
+	// 5. Call the Connector (running any agent tools the model asks for)
+	toolExecutor := h.attachTools(userID, chatID, agentID, &llmReq)
+	err = llm.GenerateWithTools(ctx, connector, llmReq, callback, toolExecutor)
 
 	// 6. Handle completion/error
 	if err != nil {
@@ -638,14 +1020,19 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 	}
 
 	// 7. Update the completed assistant message in DB (if created)
+	var usage llm.TokenUsage
 	if assistantMsgID != 0 {
 		finalContent := responseContent.String()
 		// Clean the response content before saving
-		cleanedContent := cleanAssistantResponse(finalContent)
-		// TODO: Calculate actual tokens used (need info from LLM response if available)
-		tokens := len(cleanedContent) // Use length of cleaned content
+		cleanedContent, reasoning := cleanAssistantResponse(finalContent, reasoningContent.String())
+		cleanedContent = h.enforceResponseFormat(ctx, userID, chatID, connector, llmReq, cleanedContent)
+		usage = responseUsage(reportedUsage, contextReport, cleanedContent+reasoning)
+		tokens := usage.CompletionTokens
 
 		updateErr := h.ChatService.UpdateMessageContentAndTokens(assistantMsgID, cleanedContent, tokens)
+		if updateErr == nil && reasoning != "" {
+			updateErr = h.ChatService.UpdateMessageReasoning(assistantMsgID, reasoning)
+		}
 		if updateErr != nil {
 			log.Printf("[Chat %d] Error updating final assistant message %d content/tokens: %v", chatID, assistantMsgID, updateErr)
 			// Don't send WS error here, primary task (streaming) was successful.
@@ -659,12 +1046,15 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 				UserID:     0, // Assistant
 				Role:       "assistant",
 				Content:    cleanedContent, // Send final cleaned content
+				Reasoning:  reasoning,      // Reasoning streamed separately
 				ModelID:    &modelIDToUse,  // Use the model ID used for generation
 				AgentID:    agentID,        // Use the agent ID used for generation
 				TokensUsed: tokens,         // Send the calculated tokens
-				CreatedAt:  time.Now(),     // Use current time as approximation for WS message
+				ParentID:   parentID,
+				SiblingIDs: h.messageSiblingIDs(assistantMsgID),
+				CreatedAt:  time.Now(), // Use current time as approximation for WS message
 			}
-			h.sendWsMessage(userID, ws.Message{
+			h.sendWsMessage(userID, chatID, ws.Message{
 				Type:           ws.MsgTypeAssistantMessage,
 				MessagePayload: &wsMsgPayload,
 			})
@@ -674,18 +1064,22 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 		// Handle case where stream finished but no DB entry was made (e.g., first chunk was empty?)
 		log.Printf("[Chat %d] Stream finished with content, but no assistant message DB entry was created. Saving now.", chatID)
 		finalContent := responseContent.String()
-		cleanedContent := cleanAssistantResponse(finalContent)
-		tokens := len(cleanedContent)
+		cleanedContent, reasoning := cleanAssistantResponse(finalContent, reasoningContent.String())
+		cleanedContent = h.enforceResponseFormat(ctx, userID, chatID, connector, llmReq, cleanedContent)
+		usage = responseUsage(reportedUsage, contextReport, cleanedContent+reasoning)
+		tokens := usage.CompletionTokens
 		assistantMessage := models.Message{
 			ChatID:     chatID,
 			UserID:     0,
 			Role:       "assistant",
 			Content:    cleanedContent,
+			Reasoning:  reasoning,
+			ParentID:   parentID,
 			ModelID:    &modelIDToUse,
 			AgentID:    agentID,
 			TokensUsed: tokens,
 		}
-		if err := h.ChatService.AddMessage(&assistantMessage); err != nil {
+		if err := saveMessage(&assistantMessage); err != nil {
 			log.Printf("[Chat %d] Error saving final assistant message after stream completion: %v", chatID, err)
 			h.sendWsError(userID, chatID, "Failed to save final assistant message after streaming.")
 			return 0, fmt.Errorf("failed to save final assistant message: %w", err)
@@ -700,12 +1094,15 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 				UserID:     0, // Assistant
 				Role:       "assistant",
 				Content:    cleanedContent, // Use the saved content
+				Reasoning:  reasoning,      // Reasoning streamed separately
 				ModelID:    &modelIDToUse,  // Use the model ID used for generation
 				AgentID:    agentID,        // Use the agent ID used for generation
 				TokensUsed: tokens,         // Use the calculated tokens
-				CreatedAt:  time.Now(),     // Use current time as approximation for WS message
+				ParentID:   parentID,
+				SiblingIDs: h.messageSiblingIDs(assistantMsgID),
+				CreatedAt:  time.Now(), // Use current time as approximation for WS message
 			}
-			h.sendWsMessage(userID, ws.Message{
+			h.sendWsMessage(userID, chatID, ws.Message{
 				Type:           ws.MsgTypeAssistantMessage,
 				MessagePayload: &wsMsgPayload,
 			})
@@ -716,38 +1113,231 @@ func (h *ChatHandlers) generateAndStreamResponse(ctx context.Context, userID int
 		// No error, but nothing to save.
 	}
 
+	if assistantMsgID != 0 {
+		if err := h.ChatService.RecordUsage(int64(userID), chatID, assistantMsgID, modelIDToUse, usage.PromptTokens, usage.CompletionTokens); err != nil {
+			log.Printf("[Chat %d] Error recording token usage: %v", chatID, err)
+		}
+		if !alternative {
+			h.updateChatSummary(chatID, modelIDToUse)
+			h.generateChatTitle(chatID, modelIDToUse)
+		}
+	}
+
 	log.Printf("[Chat %d] generateAndStreamResponse finished successfully for model %d. Final assistant msg ID: %d", chatID, modelIDToUse, assistantMsgID)
 	return assistantMsgID, nil // Return the final message ID and nil error
 }
 
-// cleanAssistantResponse removes unwanted prefixes from the raw LLM response.
-func cleanAssistantResponse(rawResponse string) string {
-	prefix := "⚙️ AI Thinking Process"
-	if strings.HasPrefix(rawResponse, prefix) {
-		// Find the end of the thinking block (assuming double newline separation)
-		endOfPrefix := strings.Index(rawResponse, "\n\n")
-		if endOfPrefix != -1 {
-			// Return the content after the prefix and the separating newlines
-			cleaned := rawResponse[endOfPrefix+2:] // +2 to skip the \n\n
-			// Trim leading/trailing whitespace just in case
-			return strings.TrimSpace(cleaned)
-		}
-		// If double newline isn't found, maybe just remove the prefix line?
-		// This is less robust. Let's try finding the first single newline after the prefix.
-		endOfPrefixLine := strings.Index(rawResponse, "\n")
-		if endOfPrefixLine != -1 && endOfPrefixLine > len(prefix) {
-			cleaned := rawResponse[endOfPrefixLine+1:]
-			return strings.TrimSpace(cleaned)
-		}
-		// Fallback: If no clear separator, just return the original response minus the exact prefix
-		// This might leave unwanted partial lines if the format is inconsistent.
-		return strings.TrimSpace(strings.TrimPrefix(rawResponse, prefix))
-	}
-	return rawResponse // Return original if prefix not found
+// attachTools adds the MCP tools enabled for the agent to the request and
+// returns an executor for them, or nil if the agent has no tools.
+// Tool failures are never fatal: the response is generated without tools.
+func (h *ChatHandlers) attachTools(userID int, chatID int64, agentID *int64, req *llm.ChatCompletionRequest) llm.ToolExecutor {
+	tools, executor, err := h.ConnectorService.PrepareTools(agentID, chatID, int64(userID))
+	if err != nil {
+		log.Printf("[Chat %d] Error preparing agent tools, continuing without tools: %v", chatID, err)
+		return nil
+	}
+	if executor == nil {
+		return nil
+	}
+
+	executor.OnInvoke = func(call llm.ToolCall) {
+		h.sendWsMessage(userID, chatID, ws.Message{
+			Type: "status",
+			Data: map[string]interface{}{"message": fmt.Sprintf("Calling tool %s...", call.Name), "chat_id": chatID, "tool": call.Name},
+		})
+	}
+	req.Tools = tools
+	return executor
+}
+
+// resolveResponseFormat returns the response format for a generation: the
+// requested one if given, otherwise the agent's configured format (if any).
+func (h *ChatHandlers) resolveResponseFormat(chatID int64, agentID *int64, requested *llm.ResponseFormat) *llm.ResponseFormat {
+	if requested != nil {
+		if !requested.IsJSON() {
+			return nil // Explicit "text" disables the agent's format
+		}
+		return requested
+	}
+	format, err := h.ConnectorService.AgentResponseFormat(agentID)
+	if err != nil {
+		log.Printf("[Chat %d] Ignoring agent response format: %v", chatID, err)
+		return nil
+	}
+	return format
+}
+
+// enforceResponseFormat validates structured output against the request's
+// response format. If it is invalid and the format allows it, the model is
+// asked once to repair it. Invalid output is still returned (and saved) so
+// nothing is lost, but the user is notified.
+func (h *ChatHandlers) enforceResponseFormat(ctx context.Context, userID int, chatID int64, connector llm.ModelConnector, req llm.ChatCompletionRequest, content string) string {
+	if !req.ResponseFormat.IsJSON() {
+		return content
+	}
+
+	validated, err := llm.ValidateStructuredOutput(content, req.ResponseFormat)
+	if err == nil {
+		return validated
+	}
+	log.Printf("[Chat %d] Structured output failed validation: %v", chatID, err)
+
+	if req.ResponseFormat.Repair {
+		h.sendWsMessage(userID, chatID, ws.Message{
+			Type: "status",
+			Data: map[string]interface{}{"message": "Repairing structured output...", "chat_id": chatID},
+		})
+		repaired, repairErr := llm.RepairStructuredOutput(ctx, connector, req, content, err)
+		if repairErr != nil {
+			log.Printf("[Chat %d] Structured output repair failed: %v", chatID, repairErr)
+		} else if validated, err = llm.ValidateStructuredOutput(repaired, req.ResponseFormat); err == nil {
+			log.Printf("[Chat %d] Structured output repaired successfully", chatID)
+			return validated
+		} else {
+			log.Printf("[Chat %d] Repaired structured output still invalid: %v", chatID, err)
+		}
+	}
+
+	h.sendWsError(userID, chatID, fmt.Sprintf("Response does not match the requested format: %v", err))
+	return content
+}
+
+// responseUsage returns the token usage of a response: the provider's counts,
+// or estimates of the context and output where it reported none.
+func responseUsage(reported llm.TokenUsage, report *llm.ContextReport, output string) llm.TokenUsage {
+	usage := reported
+	if usage.PromptTokens == 0 && report != nil {
+		usage.PromptTokens = report.EstimatedTokens
+	}
+	if usage.CompletionTokens == 0 {
+		usage.CompletionTokens = llm.HeuristicTokenEstimator{}.EstimateTokens(output)
+	}
+	return usage
+}
+
+// cleanAssistantResponse separates any reasoning still embedded in the raw
+// LLM response (e.g. <think> tags) from the answer, and combines it with the
+// reasoning streamed separately by the connector.
+func cleanAssistantResponse(rawResponse, streamedReasoning string) (content, reasoning string) {
+	content, reasoning = llm.SplitReasoning(rawResponse)
+	streamedReasoning = strings.TrimSpace(streamedReasoning)
+	if streamedReasoning != "" && reasoning != "" {
+		return content, streamedReasoning + "\n\n" + reasoning
+	}
+	if streamedReasoning != "" {
+		return content, streamedReasoning
+	}
+	return content, reasoning
+}
+
+// sendReasoningChunk streams a piece of the model's reasoning via WebSocket.
+func (h *ChatHandlers) sendReasoningChunk(userID int, chatID int64, messageID int64, parentID int64, modelID int64, reasoning string) {
+	payload := ws.ChunkPayload{
+		ChatID:   chatID,
+		ParentID: &parentID,
+		Content:  reasoning,
+		ModelID:  &modelID,
+	}
+	if messageID != 0 {
+		payload.MessageID = &messageID
+	}
+	h.sendWsMessage(userID, chatID, ws.Message{
+		Type:         ws.MsgTypeReasoningChunk,
+		ChunkPayload: &payload,
+	})
+}
+
+// updateChatSummary folds older turns into the chat's rolling summary in the
+// background once the history grows past the model's summary threshold.
+func (h *ChatHandlers) updateChatSummary(chatID, modelID int64) {
+	go func() {
+		if _, err := h.ConnectorService.UpdateChatSummary(context.Background(), chatID, modelID); err != nil {
+			log.Printf("[Chat %d] Failed to update chat summary: %v", chatID, err)
+		}
+	}()
+}
+
+// generateChatTitle names a chat that still has its default title in the
+// background, once it has a reply, and updates the chat lists of its users.
+func (h *ChatHandlers) generateChatTitle(chatID, modelID int64) {
+	go func() {
+		title, err := h.ConnectorService.GenerateChatTitle(context.Background(), chatID, modelID)
+		if err != nil {
+			log.Printf("[Chat %d] Failed to generate chat title: %v", chatID, err)
+			return
+		}
+		if title != "" {
+			h.sendChatListUpdate(chatID)
+		}
+	}()
+}
+
+// sendChatListUpdate sends the chat's current list entry in a chat_list
+// WebSocket message to its owner and every user it is shared with, so their
+// sidebars stay up to date.
+func (h *ChatHandlers) sendChatListUpdate(chatID int64) {
+	if h.Hub == nil {
+		return
+	}
+	chat, err := h.ChatService.GetChat(chatID, false)
+	if err != nil {
+		log.Printf("[Chat %d] Error fetching chat for chat list update: %v", chatID, err)
+		return
+	}
+	participants, err := h.ChatService.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("[Chat %d] Error fetching participants for chat list update: %v", chatID, err)
+		return
+	}
+
+	msg := ws.Message{
+		Type:      ws.MsgTypeChatList,
+		Timestamp: time.Now(),
+		ChatListPayload: []ws.Chat{{
+			ID:        chat.ID,
+			Title:     chat.Title,
+			UserID:    chat.UserID,
+			IsActive:  chat.IsActive,
+			CreatedAt: chat.CreatedAt,
+			UpdatedAt: chat.UpdatedAt,
+		}},
+	}
+	for _, p := range participants {
+		h.Hub.SendToUser(p.UserID, msg)
+	}
+}
+
+// sendChatListRemoval tells each user which chats left their chat list, e.g.
+// after the owner deleted them. removed maps user IDs to chat IDs.
+func (h *ChatHandlers) sendChatListRemoval(removed map[int64][]int64) {
+	if h.Hub == nil {
+		return
+	}
+	for userID, chatIDs := range removed {
+		h.Hub.SendToUser(userID, ws.Message{
+			Type:      ws.MsgTypeChatList,
+			Timestamp: time.Now(),
+			Data:      map[string]interface{}{"removed_chat_ids": chatIDs},
+		})
+	}
+}
+
+// sendContextReport tells the user when part of the conversation was left out
+// of the context to fit the model's context window.
+func (h *ChatHandlers) sendContextReport(userID int, chatID int64, report *llm.ContextReport) {
+	if report == nil || !report.Truncated() {
+		return
+	}
+	h.sendWsMessage(userID, chatID, ws.Message{
+		Type: ws.MsgTypeContextTruncated,
+		Data: map[string]interface{}{"chat_id": chatID, "context": report},
+	})
 }
 
-// sendWsMessage is a helper to send a structured message to a user via WebSocket
-func (h *ChatHandlers) sendWsMessage(userID int, msg ws.Message) {
+// sendWsMessage is a helper to send a structured message about a chat via
+// WebSocket: to every client following the chat (so all participants see
+// responses live) and to every client of the user who caused it.
+func (h *ChatHandlers) sendWsMessage(userID int, chatID int64, msg ws.Message) {
 	if h.Hub == nil {
 		log.Println("Error: WebSocket Hub is nil in ChatHandlers")
 		return
@@ -756,13 +1346,13 @@ func (h *ChatHandlers) sendWsMessage(userID int, msg ws.Message) {
 	if msg.Timestamp.IsZero() {
 		msg.Timestamp = time.Now()
 	}
-	h.Hub.SendToUser(int64(userID), msg)
+	h.Hub.SendToChat(chatID, int64(userID), msg)
 }
 
-// sendWsError is a helper to send a structured error message to a user via WebSocket
+// sendWsError is a helper to send a structured error message about a chat via WebSocket
 func (h *ChatHandlers) sendWsError(userID int, chatID int64, errorMsg string) {
 	chatIDPtr := chatID // Create a pointer for the payload
-	h.sendWsMessage(userID, ws.Message{
+	h.sendWsMessage(userID, chatID, ws.Message{
 		Type: ws.MsgTypeError, // Use constant
 		ErrorPayload: &ws.ErrorPayload{
 			Message: errorMsg,
@@ -775,7 +1365,9 @@ func (h *ChatHandlers) sendWsError(userID int, chatID int64, errorMsg string) {
 
 // RegenerateMessageRequest defines the optional body for POST /api/chats/{id}/messages/regenerate
 type RegenerateMessageRequest struct {
-	ModelID *int64 `json:"model_id,omitempty"` // Optional: New model ID to use
+	ModelID   *int64 `json:"model_id,omitempty"`   // Optional: New model ID to use
+	MessageID *int64 `json:"message_id,omitempty"` // Optional: Assistant message to regenerate (defaults to the last one)
+	GenerationOptions
 }
 
 // RegenerateMessage handles POST /api/chats/{chat_id}/messages/regenerate
@@ -809,7 +1401,10 @@ func (h *ChatHandlers) RegenerateMessage(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "Bad Request: Invalid model_id provided for regeneration", http.StatusBadRequest)
 		return
 	}
-	// TODO: Validate ModelID exists and is active/accessible by user
+	if err := req.GenerationOptions.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
 
 	log.Printf("RegenerateMessage called by User ID: %d for Chat ID: %d (New Model ID: %v)", userID, chatID, req.ModelID)
 
@@ -825,298 +1420,590 @@ func (h *ChatHandlers) RegenerateMessage(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if existingChat.UserID != int64(userID) {
-		log.Printf("Forbidden: User %d attempted to regenerate message in chat %d owned by user %d", userID, chatID, existingChat.UserID)
-		http.Error(w, "Forbidden: You do not have access to this chat", http.StatusForbidden)
-		return
+		if _, ok := h.checkChatAccess(w, r, userID, chatID, models.ChatRoleContributor); !ok {
+			return
+		}
 	}
 
-	// Return 202 Accepted immediately
-	w.WriteHeader(http.StatusAccepted)
-
-	// --- Trigger Regeneration Asynchronously ---
-	bgCtx := context.Background()
-	go func(ctx context.Context, userID int, chatID int64, requestedNewModelID *int64) {
-		log.Printf("[Regen Chat %d] Starting regeneration process...", chatID)
-
-		// Send initial status update
-		h.sendWsMessage(userID, ws.Message{
-			Type: "status",
-			Data: map[string]interface{}{"message": "Regenerating response...", "chat_id": chatID},
-		})
-
-		// 1. Get last N messages
-		history, err := h.ChatService.GetMessageHistory(chatID, 20) // Get reasonable history
+	// Find the assistant message to regenerate: the requested one, or the
+	// last one on the active branch
+	var target *models.Message
+	if req.MessageID != nil {
+		target, err = h.ChatService.GetMessage(*req.MessageID)
+		if err != nil || target.ChatID != chatID {
+			http.Error(w, "Not Found: Message not found in this chat", http.StatusNotFound)
+			return
+		}
+		if target.Role != "assistant" {
+			http.Error(w, "Bad Request: Only assistant messages can be regenerated", http.StatusBadRequest)
+			return
+		}
+	} else {
+		history, err := h.ChatService.GetMessageHistory(chatID, 20)
 		if err != nil {
-			log.Printf("[Regen Chat %d] Error getting message history: %v", chatID, err)
-			h.sendWsError(userID, chatID, "Failed to retrieve conversation history for regeneration.")
+			log.Printf("Error getting message history for chat %d (regenerate): %v", chatID, err)
+			http.Error(w, "Internal Server Error: Failed to retrieve conversation history", http.StatusInternalServerError)
 			return
 		}
-
-		// 2. Find the last assistant message
-		lastAssistantMsgIndex := -1
 		for i := len(history) - 1; i >= 0; i-- {
 			if history[i].Role == "assistant" {
-				lastAssistantMsgIndex = i
+				target = &history[i]
 				break
 			}
 		}
-
-		if lastAssistantMsgIndex == -1 {
-			log.Printf("[Regen Chat %d] No previous assistant message found to regenerate.", chatID)
-			h.sendWsError(userID, chatID, "Cannot regenerate: No previous assistant message found.")
+		if target == nil {
+			http.Error(w, "Bad Request: No previous assistant message found to regenerate", http.StatusBadRequest)
 			return
 		}
+	}
+	if target.ParentID == nil {
+		http.Error(w, "Bad Request: Cannot regenerate: the message does not follow a user message", http.StatusBadRequest)
+		return
+	}
 
-		lastAssistantMsg := history[lastAssistantMsgIndex]
-
-		// IMPROVED: Find the most recent user message that appears before the lastAssistantMsg
-		lastUserMsgIndex := -1
-		for i := lastAssistantMsgIndex - 1; i >= 0; i-- {
-			if history[i].Role == "user" {
-				lastUserMsgIndex = i
-				break
-			}
-		}
+	modelIDToUse := target.ModelID // Default to original model
+	if req.ModelID != nil {
+		modelIDToUse = req.ModelID // Override with user request
+	}
+	if modelIDToUse == nil || *modelIDToUse == 0 {
+		http.Error(w, "Bad Request: Cannot determine model for regeneration; provide model_id", http.StatusBadRequest)
+		return
+	}
+	if !h.checkModelAccess(w, userID, *modelIDToUse) {
+		return
+	}
 
-		var historyToResubmit []models.Message
+	// Return 202 Accepted immediately
+	w.WriteHeader(http.StatusAccepted)
 
-		// Logic for determining what history to include
-		if lastUserMsgIndex >= 0 {
-			// We found a user message immediately before the assistant message
-			// Include all context up to and including that user message
-			historyToResubmit = history[:lastUserMsgIndex+1]
-			log.Printf("[Regen Chat %d] Found last user message at position %d out of %d total messages",
-				chatID, lastUserMsgIndex, len(history))
-		} else {
-			// No user message found before the assistant message
-			// This shouldn't happen in normal operation but we'll handle it gracefully
-			// by including all history before the assistant message
-			historyToResubmit = history[:lastAssistantMsgIndex]
-			log.Printf("[Regen Chat %d] No user message found before the last assistant message - unusual state", chatID)
-		}
+	// --- Trigger Regeneration Asynchronously ---
+	// The new response is a sibling of the target: the branch is moved back to
+	// the preceding user message and generation continues from there, leaving
+	// the previous response as an alternative.
+	bgCtx := context.Background()
+	go func(ctx context.Context, target models.Message, modelID int64, opts GenerationOptions) {
+		log.Printf("[Regen Chat %d] Regenerating message %d with model %d", chatID, target.ID, modelID)
 
-		if len(historyToResubmit) == 0 {
-			log.Printf("[Regen Chat %d] No message history found to use for regeneration.", chatID)
-			h.sendWsError(userID, chatID, "Cannot regenerate: No suitable history found to regenerate from.")
+		// Send initial status update
+		h.sendWsMessage(userID, chatID, ws.Message{
+			Type: "status",
+			Data: map[string]interface{}{"message": "Regenerating response...", "chat_id": chatID},
+		})
+
+		if err := h.switchToBranch(userID, chatID, *target.ParentID, false); err != nil {
+			log.Printf("[Regen Chat %d] Error moving active branch to message %d: %v", chatID, *target.ParentID, err)
+			h.sendWsError(userID, chatID, "Failed to prepare regeneration.")
 			return
 		}
 
-		// Check if the last message in our history is a user message, which is required for regeneration
-		lastMsgInHistory := historyToResubmit[len(historyToResubmit)-1]
-		if lastMsgInHistory.Role != "user" {
-			log.Printf("[Regen Chat %d] Last message in history is not a user message (%s). Cannot regenerate.",
-				chatID, lastMsgInHistory.Role)
-			h.sendWsError(userID, chatID, "Cannot regenerate: The last message must be from a user.")
+		assistantMsgID, err := h.generateAndStreamResponse(ctx, userID, chatID, *target.ParentID, modelID, target.AgentID, opts, false)
+		if err != nil {
+			log.Printf("[Regen Chat %d] Regeneration finished with error: %v", chatID, err)
 			return
 		}
+		log.Printf("[Regen Chat %d] Regeneration finished successfully using model %d. Final assistant msg ID: %d", chatID, modelID, assistantMsgID)
+	}(bgCtx, *target, *modelIDToUse, req.GenerationOptions)
+	// --- End Regeneration Trigger ---
+}
 
-		// Extract the triggering user message content to pass explicitly to context builder
-		triggeringMessageContent := lastMsgInHistory.Content
+// switchToBranch activates the branch through messageID (continuing to the
+// end of that branch if descend is set) and tells the user's client to
+// remove the messages of the previous branch that are no longer shown.
+func (h *ChatHandlers) switchToBranch(userID int, chatID, messageID int64, descend bool) error {
+	previous, err := h.ChatService.GetChatMessages(chatID)
+	if err != nil {
+		return err
+	}
 
-		// 3. Determine model ID to use
-		modelIDToUse := lastAssistantMsg.ModelID // Default to original model
-		if requestedNewModelID != nil {
-			modelIDToUse = requestedNewModelID // Override with user request
-			log.Printf("[Regen Chat %d] User requested override to model ID %d", chatID, *modelIDToUse)
-		}
-		if modelIDToUse == nil || *modelIDToUse == 0 {
-			errMsg := fmt.Sprintf("Cannot determine model ID for regeneration (Original: %v, Requested: %v)", lastAssistantMsg.ModelID, requestedNewModelID)
-			log.Printf("[Regen Chat %d] %s", chatID, errMsg)
-			h.sendWsError(userID, chatID, errMsg)
-			return
+	if descend {
+		_, err = h.ChatService.SwitchBranch(chatID, messageID)
+	} else {
+		err = h.ChatService.SetActiveMessage(chatID, messageID)
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := h.ChatService.GetChatMessages(chatID)
+	if err != nil {
+		return err
+	}
+	shown := make(map[int64]bool, len(current))
+	for _, msg := range current {
+		shown[msg.ID] = true
+	}
+	for _, msg := range previous {
+		if !shown[msg.ID] {
+			h.sendWsMessage(userID, chatID, ws.Message{
+				Type:          ws.MsgTypeRemoveMessage,
+				RemovePayload: &ws.RemovePayload{ChatID: chatID, MessageID: msg.ID},
+			})
 		}
-		finalModelID := *modelIDToUse
+	}
+	return nil
+}
 
-		// For regeneration, we'll handle context differently than regular messages
-		log.Printf("[Regen Chat %d] Regenerating with triggering message: %s", chatID, triggeringMessageContent)
+// messageSiblingIDs returns the IDs of the alternatives to a message, or nil
+// if it has none.
+func (h *ChatHandlers) messageSiblingIDs(messageID int64) []int64 {
+	message, err := h.ChatService.GetMessage(messageID)
+	if err != nil {
+		return nil
+	}
+	siblings, err := h.ChatService.GetMessageSiblings(message)
+	if err != nil || len(siblings) < 2 {
+		return nil
+	}
+	ids := make([]int64, len(siblings))
+	for i, sibling := range siblings {
+		ids[i] = sibling.ID
+	}
+	return ids
+}
 
-		// Remove the last user message from history as we'll pass it explicitly
-		// historyForContext := historyToResubmit[:len(historyToResubmit)-1]
+// ListToolInvocations handles GET /api/chats/{chat_id}/tool-invocations
+func (h *ChatHandlers) ListToolInvocations(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
 
-		// Use a background context for the goroutine
-		// bgCtx := context.Background()
+	chatIDStr := r.PathValue("chat_id")
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid chat ID format '%s': %v", chatIDStr, err)
+		http.Error(w, "Bad Request: Invalid chat ID format", http.StatusBadRequest)
+		return
+	}
 
-		// Call the shared generation logic with modified flow for regeneration
-		log.Printf("[Regen Chat %d] Using explicit context building for regeneration", chatID)
+	// Authorization Check: Verify user can read the chat
+	if _, ok := h.checkChatAccess(w, r, userID, chatID, models.ChatRoleViewer); !ok {
+		return
+	}
 
-		// Here we need to get the connector and model details first
-		connector, model, err := h.ConnectorService.GetConnectorForModel(ctx, finalModelID)
+	invocations := []models.ToolInvocation{}
+	if toolManager := h.ConnectorService.GetToolManager(); toolManager != nil {
+		invocations, err = toolManager.Service().GetChatToolInvocations(chatID)
 		if err != nil {
-			errMsg := fmt.Sprintf("Failed to get model configuration: %v", err)
-			log.Printf("[Regen Chat %d] Error getting connector for model %d: %v", chatID, finalModelID, err)
-			h.sendWsError(userID, chatID, errMsg)
+			log.Printf("Error fetching tool invocations for chat %d: %v", chatID, err)
+			http.Error(w, "Internal Server Error: Failed to retrieve tool invocations", http.StatusInternalServerError)
 			return
 		}
+		if invocations == nil {
+			invocations = []models.ToolInvocation{}
+		}
+	}
 
-		// Use the context service to build the LLM messages array
-		chatContextSvc := h.ConnectorService.GetChatContextService()
-		llmMessages, err := chatContextSvc.BuildContextForModelRequest(
-			ctx,
-			chatID,
-			finalModelID,
-			triggeringMessageContent, // Pass explicit triggering message content
-			lastAssistantMsg.AgentID,
-		)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(invocations); err != nil {
+		log.Printf("Error encoding tool invocations response for chat %d: %v", chatID, err)
+	}
+}
+
+// checkModelAccess checks that the user can use each of the models (see
+// ModelAccess), writing the error response if not.
+func (h *ChatHandlers) checkModelAccess(w http.ResponseWriter, userID int, modelIDs ...int64) bool {
+	for _, modelID := range modelIDs {
+		usable, err := h.ModelService.CanUseModel(int64(userID), modelID)
 		if err != nil {
-			errMsg := fmt.Sprintf("Failed to build context for regeneration: %v", err)
-			log.Printf("[Regen Chat %d] Error building context: %v", chatID, err)
-			h.sendWsError(userID, chatID, errMsg)
-			return
+			log.Printf("Error checking access of user %d to model %d: %v", userID, modelID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return false
+		}
+		if !usable {
+			log.Printf("Forbidden: User %d attempted to use model %d", userID, modelID)
+			http.Error(w, fmt.Sprintf("Forbidden: Model %d is not available to you", modelID), http.StatusForbidden)
+			return false
 		}
+	}
+	return true
+}
 
-		// Log the context being sent for regeneration
-		log.Printf("[Regen Chat %d] Built context with %d messages for regeneration", chatID, len(llmMessages))
+// checkAgentAccess checks that the user can use the agent (nil for none),
+// writing the error response if not
+func (h *ChatHandlers) checkAgentAccess(w http.ResponseWriter, userID int, agentID *int64) bool {
+	usable, err := h.ConnectorService.CanUseAgent(int64(userID), agentID)
+	if err != nil {
+		log.Printf("Error checking access of user %d to agent %d: %v", userID, *agentID, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if !usable {
+		log.Printf("Forbidden: User %d attempted to use agent %d", userID, *agentID)
+		http.Error(w, fmt.Sprintf("Forbidden: Agent %d is not available to you", *agentID), http.StatusForbidden)
+		return false
+	}
+	return true
+}
 
-		// Set up streaming and message handling like in generateAndStreamResponse
-		var responseContent strings.Builder
-		var assistantMsgID int64
-		firstChunk := true
+// getOwnedChatID parses the {chat_id} path value and checks that the chat
+// belongs to the user, writing the error response if not.
+func (h *ChatHandlers) getOwnedChatID(w http.ResponseWriter, r *http.Request, userID int) (int64, bool) {
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleOwner)
+	return chatID, ok
+}
 
-		callback := func(cbCtx context.Context, chunk llm.ChatCompletionChunk) error {
-			if cbCtx.Err() != nil {
-				log.Printf("[Regen Chat %d] Context cancelled during streaming callback.", chatID)
-				return cbCtx.Err()
-			}
+// getChatAccess parses the {chat_id} path value and checks that the user has
+// at least the required role in the chat (owner, or a participant it is
+// shared with). It returns the user's role, writing the error response if
+// access is denied.
+func (h *ChatHandlers) getChatAccess(w http.ResponseWriter, r *http.Request, userID int, required string) (int64, string, bool) {
+	chatIDStr := r.PathValue("chat_id")
+	chatID, err := strconv.ParseInt(chatIDStr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid chat ID format '%s': %v", chatIDStr, err)
+		http.Error(w, "Bad Request: Invalid chat ID format", http.StatusBadRequest)
+		return 0, "", false
+	}
+	role, ok := h.checkChatAccess(w, r, userID, chatID, required)
+	return chatID, role, ok
+}
 
-			responseContent.WriteString(chunk.Content)
-
-			if firstChunk && chunk.Content != "" {
-				assistantMessage := models.Message{
-					ChatID:     chatID,
-					UserID:     0,
-					Role:       "assistant",
-					Content:    "",
-					ModelID:    &finalModelID,
-					AgentID:    lastAssistantMsg.AgentID,
-					TokensUsed: 0,
-				}
-				if err := h.ChatService.AddMessage(&assistantMessage); err != nil {
-					log.Printf("[Regen Chat %d] Error creating initial assistant message entry: %v", chatID, err)
-					return fmt.Errorf("failed to save initial assistant message: %w", err)
-				}
-				assistantMsgID = assistantMessage.ID
-				firstChunk = false
-				log.Printf("[Regen Chat %d] Created regenerated assistant message DB entry (ID: %d)", chatID, assistantMsgID)
-			}
+// checkChatAccess checks that the user has at least the required role in the
+// chat, writing the error response if not.
+func (h *ChatHandlers) checkChatAccess(w http.ResponseWriter, r *http.Request, userID int, chatID int64, required string) (string, bool) {
+	role, err := h.ChatService.GetChatRole(chatID, int64(userID))
+	if err != nil {
+		if err.Error() == fmt.Sprintf("chat not found: %d", chatID) {
+			http.Error(w, "Not Found: Chat not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching chat %d for auth check: %v", chatID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return "", false
+	}
+	if role == "" {
+		log.Printf("Forbidden: User %d attempted to access chat %d (%s %s)", userID, chatID, r.Method, r.URL.Path)
+		http.Error(w, "Forbidden: You do not have access to this chat", http.StatusForbidden)
+		return "", false
+	}
+	if !models.ChatRoleAllows(role, required) {
+		log.Printf("Forbidden: User %d (%s) attempted an action requiring %s on chat %d (%s %s)", userID, role, required, chatID, r.Method, r.URL.Path)
+		http.Error(w, fmt.Sprintf("Forbidden: This requires the %s role in this chat", required), http.StatusForbidden)
+		return "", false
+	}
+	return role, true
+}
 
-			if chunk.Content != "" || chunk.IsFinal {
-				// Correctly populate the ChunkPayload field
-				payload := ws.ChunkPayload{
-					ChatID:  chatID,
-					Content: chunk.Content,
-					IsFinal: chunk.IsFinal,
-				}
-
-				// Set MessageID if available
-				if assistantMsgID != 0 {
-					payload.MessageID = &assistantMsgID
-				}
-
-				// Add the model ID - finalModelID is the correct variable in this context
-				// Create a local copy we can safely take the address of
-				modelIDCopy := finalModelID
-				payload.ModelID = &modelIDCopy
-
-				// Create and send the WebSocket message
-				wsMsg := ws.Message{
-					Type:         ws.MsgTypeAssistantChunk,
-					Timestamp:    time.Now(),
-					ChunkPayload: &payload,
-				}
-				h.sendWsMessage(userID, wsMsg)
-			}
+// getChatMessage parses the {message_id} path value and loads the message,
+// checking it belongs to the chat. It writes the error response if not.
+func (h *ChatHandlers) getChatMessage(w http.ResponseWriter, r *http.Request, chatID int64) (*models.Message, bool) {
+	messageIDStr := r.PathValue("message_id")
+	messageID, err := strconv.ParseInt(messageIDStr, 10, 64)
+	if err != nil {
+		log.Printf("Invalid message ID format '%s': %v", messageIDStr, err)
+		http.Error(w, "Bad Request: Invalid message ID format", http.StatusBadRequest)
+		return nil, false
+	}
 
-			return nil
+	message, err := h.ChatService.GetMessage(messageID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "Not Found: Message not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching message %d: %v", messageID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		}
+		return nil, false
+	}
+	if message.ChatID != chatID {
+		http.Error(w, "Not Found: Message not found in this chat", http.StatusNotFound)
+		return nil, false
+	}
+	return message, true
+}
 
-		// Send status update before calling LLM
-		h.sendWsMessage(userID, ws.Message{
-			Type: "status",
-			Data: map[string]interface{}{"message": "Regenerating response...", "chat_id": chatID},
-		})
+// EditMessageRequest defines the body for POST /api/chats/{chat_id}/messages/{message_id}/edit
+type EditMessageRequest struct {
+	Content string `json:"content"`
+	ModelID int64  `json:"model_id"`
+	AgentID *int64 `json:"agent_id,omitempty"` // Optional: defaults to the edited message's agent
+	GenerationOptions
+}
 
-		// Prepare LLM Request
-		llmReq := llm.ChatCompletionRequest{
-			Model:       model.ModelID,
-			Messages:    llmMessages,
-			Temperature: model.Temperature,
-			MaxTokens:   model.MaxTokens,
-			Stream:      true,
-		}
+// EditMessage handles POST /api/chats/{chat_id}/messages/{message_id}/edit.
+// The edited text is saved as a new user message alongside the original
+// (a new branch from the same point) and a response is generated for it.
+func (h *ChatHandlers) EditMessage(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleContributor)
+	if !ok {
+		return
+	}
+	original, ok := h.getChatMessage(w, r, chatID)
+	if !ok {
+		return
+	}
+	if original.Role != "user" {
+		http.Error(w, "Bad Request: Only user messages can be edited", http.StatusBadRequest)
+		return
+	}
 
-		// Call the Connector
-		err = connector.GenerateChatCompletion(ctx, llmReq, callback)
+	var req EditMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Content == "" {
+		http.Error(w, "Bad Request: Message content cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if req.ModelID <= 0 {
+		http.Error(w, "Bad Request: A valid model_id is required", http.StatusBadRequest)
+		return
+	}
+	if err := req.GenerationOptions.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if !h.checkModelAccess(w, userID, req.ModelID) {
+		return
+	}
+	agentID := req.AgentID
+	if agentID == nil {
+		agentID = original.AgentID
+	}
+	if !h.checkAgentAccess(w, userID, agentID) {
+		return
+	}
 
-		// Handle completion/error
-		if err != nil {
-			errMsg := fmt.Sprintf("Error generating regenerated response: %v", err)
-			log.Printf("[Regen Chat %d] Error generating chat completion: %v", chatID, err)
-			h.sendWsError(userID, chatID, errMsg)
-			if assistantMsgID != 0 {
-				log.Printf("[Regen Chat %d] Potentially incomplete assistant message (ID: %d) due to error.", chatID, assistantMsgID)
-			}
-			return
-		}
+	// Show the original's branch up to the edit point, then add the edit
+	if err := h.switchToBranch(userID, chatID, original.ID, false); err != nil {
+		log.Printf("Error moving active branch of chat %d to message %d: %v", chatID, original.ID, err)
+		http.Error(w, "Internal Server Error: Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+	h.sendWsMessage(userID, chatID, ws.Message{
+		Type:          ws.MsgTypeRemoveMessage,
+		RemovePayload: &ws.RemovePayload{ChatID: chatID, MessageID: original.ID},
+	})
 
-		// Update the completed assistant message in DB
-		if assistantMsgID != 0 {
-			finalContent := responseContent.String()
-			cleanedContent := cleanAssistantResponse(finalContent)
-			tokens := len(cleanedContent)
-
-			updateErr := h.ChatService.UpdateMessageContentAndTokens(assistantMsgID, cleanedContent, tokens)
-			if updateErr != nil {
-				log.Printf("[Regen Chat %d] Error updating final assistant message %d content/tokens: %v", chatID, assistantMsgID, updateErr)
-			} else {
-				log.Printf("[Regen Chat %d] Successfully updated final regenerated assistant message %d", chatID, assistantMsgID)
-				// Send final message confirmation via WebSocket for regeneration too
-				wsMsgPayload := ws.MessagePayload{
-					ID:         assistantMsgID,
-					ChatID:     chatID,
-					UserID:     0, // Assistant
-					Role:       "assistant",
-					Content:    cleanedContent,
-					ModelID:    &finalModelID,
-					AgentID:    lastAssistantMsg.AgentID,
-					TokensUsed: tokens,
-					CreatedAt:  time.Now(), // Approximation
-				}
-				h.sendWsMessage(userID, ws.Message{
-					Type:           ws.MsgTypeAssistantMessage,
-					MessagePayload: &wsMsgPayload,
-				})
-				log.Printf("[Regen Chat %d] Sent final assistant_message WS update for message %d", chatID, assistantMsgID)
-			}
-		} else if responseContent.Len() > 0 {
-			log.Printf("[Regen Chat %d] Stream finished with content, but no assistant message DB entry was created. Saving now.", chatID)
-			finalContent := responseContent.String()
-			cleanedContent := cleanAssistantResponse(finalContent)
-			tokens := len(cleanedContent)
-			assistantMessage := models.Message{
-				ChatID:     chatID,
-				UserID:     0,
-				Role:       "assistant",
-				Content:    cleanedContent,
-				ModelID:    &finalModelID,
-				AgentID:    lastAssistantMsg.AgentID,
-				TokensUsed: tokens,
-			}
-			if err := h.ChatService.AddMessage(&assistantMessage); err != nil {
-				log.Printf("[Regen Chat %d] Error saving final regenerated assistant message: %v", chatID, err)
-				h.sendWsError(userID, chatID, "Failed to save final regenerated assistant message.")
-				return
-			} else {
-				assistantMsgID = assistantMessage.ID
-				log.Printf("[Regen Chat %d] Successfully saved final regenerated assistant message %d.", chatID, assistantMsgID)
-			}
-		} else {
-			log.Printf("[Regen Chat %d] Regenerated AI response stream finished with no content.", chatID)
-			h.sendWsError(userID, chatID, "Regeneration produced no content. Please try again.")
+	userMessage := models.Message{
+		ChatID:  chatID,
+		UserID:  int64(userID),
+		Role:    "user",
+		Content: req.Content,
+		AgentID: agentID,
+	}
+	if err := h.ChatService.AddBranchMessage(&userMessage, original.ParentID); err != nil {
+		log.Printf("Error saving edited message for chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to save message", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Saved edit of message %d as message %d in chat %d", original.ID, userMessage.ID, chatID)
+
+	userMessage.SiblingIDs = h.messageSiblingIDs(userMessage.ID)
+	h.sendUserMessage(userID, userMessage)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(userMessage); err != nil {
+		log.Printf("Error encoding edited message response for chat %d: %v", chatID, err)
+	}
+
+	go h.processAIResponse(context.Background(), userID, userMessage, req.ModelID, req.GenerationOptions)
+}
+
+// MessageSiblingsResponse is returned by GET /api/chats/{chat_id}/messages/{message_id}/siblings
+type MessageSiblingsResponse struct {
+	Siblings        []models.Message `json:"siblings"`          // Alternatives, including the message itself, oldest first
+	ActiveMessageID *int64           `json:"active_message_id"` // The sibling on the active branch, if any
+}
+
+// ListMessageSiblings handles GET /api/chats/{chat_id}/messages/{message_id}/siblings
+func (h *ChatHandlers) ListMessageSiblings(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleViewer)
+	if !ok {
+		return
+	}
+	message, ok := h.getChatMessage(w, r, chatID)
+	if !ok {
+		return
+	}
+
+	siblings, err := h.ChatService.GetMessageSiblings(message)
+	if err != nil {
+		log.Printf("Error fetching siblings of message %d: %v", message.ID, err)
+		http.Error(w, "Internal Server Error: Failed to retrieve alternatives", http.StatusInternalServerError)
+		return
+	}
+	path, err := h.ChatService.GetChatMessages(chatID)
+	if err != nil {
+		log.Printf("Error fetching active branch of chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to retrieve alternatives", http.StatusInternalServerError)
+		return
+	}
+
+	resp := MessageSiblingsResponse{Siblings: siblings}
+	onPath := make(map[int64]bool, len(path))
+	for _, msg := range path {
+		onPath[msg.ID] = true
+	}
+	for i := range siblings {
+		if onPath[siblings[i].ID] {
+			resp.ActiveMessageID = &siblings[i].ID
+			break
 		}
+	}
 
-		log.Printf("[Regen Chat %d] Regeneration finished successfully using model %d. Final assistant msg ID: %d", chatID, finalModelID, assistantMsgID)
-	}(bgCtx, userID, chatID, req.ModelID)
-	// --- End Regeneration Trigger ---
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding siblings response for message %d: %v", message.ID, err)
+	}
+}
+
+// ActivateMessage handles POST /api/chats/{chat_id}/messages/{message_id}/activate.
+// It switches to the branch through the message, continuing along its most
+// recent replies, and returns the chat with the messages of that branch.
+func (h *ChatHandlers) ActivateMessage(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleContributor)
+	if !ok {
+		return
+	}
+	message, ok := h.getChatMessage(w, r, chatID)
+	if !ok {
+		return
+	}
+
+	leafID, err := h.ChatService.SwitchBranch(chatID, message.ID)
+	if err != nil {
+		log.Printf("Error switching chat %d to branch of message %d: %v", chatID, message.ID, err)
+		http.Error(w, "Internal Server Error: Failed to switch branch", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d switched chat %d to the branch through message %d (leaf %d)", userID, chatID, message.ID, leafID)
+
+	chat, err := h.ChatService.GetChat(chatID, true)
+	if err != nil {
+		log.Printf("Error fetching chat %d after switching branch: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to retrieve chat details", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(chat); err != nil {
+		log.Printf("Error encoding chat response for chat %d: %v", chatID, err)
+	}
+}
+
+// GetChatSummary handles GET /api/chats/{chat_id}/summary
+func (h *ChatHandlers) GetChatSummary(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleViewer)
+	if !ok {
+		return
+	}
+
+	summary, err := h.ChatService.GetBranchSummary(chatID, 0)
+	if err != nil {
+		log.Printf("Error fetching summary for chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to retrieve chat summary", http.StatusInternalServerError)
+		return
+	}
+	if summary == nil {
+		http.Error(w, "Not Found: Chat has no summary yet", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("Error encoding summary response for chat %d: %v", chatID, err)
+	}
+}
+
+// UpdateChatSummaryRequest defines the body for PUT /api/chats/{chat_id}/summary
+type UpdateChatSummaryRequest struct {
+	Content string `json:"content"`
+}
+
+// UpdateChatSummary handles PUT /api/chats/{chat_id}/summary. The owner's
+// text replaces the summary; later automatic updates extend it.
+func (h *ChatHandlers) UpdateChatSummary(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req UpdateChatSummaryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Content = strings.TrimSpace(req.Content)
+	if req.Content == "" {
+		http.Error(w, "Bad Request: Summary content cannot be empty (use DELETE to remove it)", http.StatusBadRequest)
+		return
+	}
+
+	summary, err := h.ChatService.GetBranchSummary(chatID, 0)
+	if err != nil {
+		log.Printf("Error fetching summary for chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to retrieve chat summary", http.StatusInternalServerError)
+		return
+	}
+	if summary == nil {
+		// A summary written before any was generated covers no messages
+		summary = &models.ChatSummary{ChatID: chatID}
+	}
+	summary.Content = req.Content
+	summary.IsEdited = true
+	if err := h.ChatService.SaveChatSummary(summary); err != nil {
+		log.Printf("Error saving summary for chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to save chat summary", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d edited the summary of chat %d", userID, chatID)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summary); err != nil {
+		log.Printf("Error encoding summary response for chat %d: %v", chatID, err)
+	}
+}
+
+// DeleteChatSummary handles DELETE /api/chats/{chat_id}/summary. The full
+// history is used again until the next automatic summary.
+func (h *ChatHandlers) DeleteChatSummary(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+
+	if err := h.ChatService.DeleteChatSummary(chatID); err != nil {
+		log.Printf("Error deleting summary for chat %d: %v", chatID, err)
+		http.Error(w, "Internal Server Error: Failed to delete chat summary", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // RegisterUserRoutes connects the handler functions to the router
@@ -1124,6 +2011,13 @@ func (h *ChatHandlers) RegisterUserRoutes(mux *http.ServeMux, mw func(http.Handl
 	// Apply middleware (mw) to all chat/message routes
 	mux.Handle("GET /api/chats", mw(http.HandlerFunc(h.ListChats)))
 	mux.Handle("POST /api/chats", mw(http.HandlerFunc(h.CreateChat)))
+	mux.Handle("GET /api/chats/search", mw(http.HandlerFunc(h.SearchChats)))
+	log.Println("Registered user chat route: GET /api/chats/search")
+	requireExport := middleware.RequirePermission(h.UserService, models.PermExportChats)
+	mux.Handle("GET /api/chats/export", mw(requireExport(http.HandlerFunc(h.ExportChats))))
+	mux.Handle("GET /api/chats/{chat_id}/export", mw(requireExport(http.HandlerFunc(h.ExportChat))))
+	mux.Handle("POST /api/chats/import", mw(http.HandlerFunc(h.ImportChats)))
+	log.Println("Registered user chat routes: GET /api/chats/export, GET /api/chats/{id}/export, POST /api/chats/import")
 
 	// Note: Using Go 1.22+ path value matching
 	mux.Handle("GET /api/chats/{chat_id}", mw(http.HandlerFunc(h.GetChat)))
@@ -1132,6 +2026,41 @@ func (h *ChatHandlers) RegisterUserRoutes(mux *http.ServeMux, mw func(http.Handl
 	mux.Handle("POST /api/chats/{chat_id}/messages", mw(http.HandlerFunc(h.CreateMessage)))
 	mux.Handle("POST /api/chats/{chat_id}/messages/regenerate", mw(http.HandlerFunc(h.RegenerateMessage)))
 	log.Println("Registered user chat routes: GET /api/chats, POST /api/chats, GET/PUT/DELETE /api/chats/{id}, POST /api/chats/{id}/messages, POST /api/chats/{id}/messages/regenerate")
+	mux.Handle("POST /api/chats/{chat_id}/messages/{message_id}/edit", mw(http.HandlerFunc(h.EditMessage)))
+	mux.Handle("GET /api/chats/{chat_id}/messages/{message_id}/siblings", mw(http.HandlerFunc(h.ListMessageSiblings)))
+	mux.Handle("POST /api/chats/{chat_id}/messages/{message_id}/activate", mw(http.HandlerFunc(h.ActivateMessage)))
+	log.Println("Registered user chat routes: POST /api/chats/{id}/messages/{message_id}/edit, GET .../siblings, POST .../activate")
+	mux.Handle("GET /api/chats/{chat_id}/tool-invocations", mw(http.HandlerFunc(h.ListToolInvocations)))
+	log.Println("Registered user chat route: GET /api/chats/{id}/tool-invocations")
+	mux.Handle("GET /api/chats/{chat_id}/summary", mw(http.HandlerFunc(h.GetChatSummary)))
+	mux.Handle("PUT /api/chats/{chat_id}/summary", mw(http.HandlerFunc(h.UpdateChatSummary)))
+	mux.Handle("DELETE /api/chats/{chat_id}/summary", mw(http.HandlerFunc(h.DeleteChatSummary)))
+	log.Println("Registered user chat routes: GET/PUT/DELETE /api/chats/{id}/summary")
+	mux.Handle("POST /api/chats/{chat_id}/shares", mw(http.HandlerFunc(h.CreateChatShare)))
+	mux.Handle("GET /api/chats/{chat_id}/shares", mw(http.HandlerFunc(h.ListChatShares)))
+	mux.Handle("DELETE /api/chats/{chat_id}/shares/{share_id}", mw(http.HandlerFunc(h.RevokeChatShare)))
+	log.Println("Registered user chat routes: POST/GET /api/chats/{id}/shares, DELETE /api/chats/{id}/shares/{share_id}")
+	mux.Handle("GET /api/chats/{chat_id}/participants", mw(http.HandlerFunc(h.ListChatParticipants)))
+	mux.Handle("POST /api/chats/{chat_id}/participants", mw(http.HandlerFunc(h.AddChatParticipant)))
+	mux.Handle("DELETE /api/chats/{chat_id}/participants/{user_id}", mw(http.HandlerFunc(h.RemoveChatParticipant)))
+	log.Println("Registered user chat routes: GET/POST /api/chats/{id}/participants, DELETE /api/chats/{id}/participants/{user_id}")
+	mux.Handle("POST /api/chats/organize", mw(http.HandlerFunc(h.OrganizeChats)))
+	mux.Handle("GET /api/folders", mw(http.HandlerFunc(h.ListChatFolders)))
+	mux.Handle("POST /api/folders", mw(http.HandlerFunc(h.CreateChatFolder)))
+	mux.Handle("PUT /api/folders/{folder_id}", mw(http.HandlerFunc(h.UpdateChatFolder)))
+	mux.Handle("DELETE /api/folders/{folder_id}", mw(http.HandlerFunc(h.DeleteChatFolder)))
+	mux.Handle("GET /api/tags", mw(http.HandlerFunc(h.ListChatTags)))
+	log.Println("Registered user chat routes: POST /api/chats/organize, GET/POST /api/folders, PUT/DELETE /api/folders/{folder_id}, GET /api/tags")
+	mux.Handle("GET /api/projects", mw(http.HandlerFunc(h.ListProjects)))
+	mux.Handle("POST /api/projects", mw(http.HandlerFunc(h.CreateProject)))
+	mux.Handle("GET /api/projects/{project_id}", mw(http.HandlerFunc(h.GetProject)))
+	mux.Handle("PUT /api/projects/{project_id}", mw(http.HandlerFunc(h.UpdateProject)))
+	mux.Handle("DELETE /api/projects/{project_id}", mw(http.HandlerFunc(h.DeleteProject)))
+	mux.Handle("POST /api/projects/{project_id}/files", mw(http.HandlerFunc(h.AddProjectFile)))
+	mux.Handle("GET /api/projects/{project_id}/files/{file_id}", mw(http.HandlerFunc(h.GetProjectFile)))
+	mux.Handle("DELETE /api/projects/{project_id}/files/{file_id}", mw(http.HandlerFunc(h.DeleteProjectFile)))
+	mux.Handle("PUT /api/chats/{chat_id}/project", mw(http.HandlerFunc(h.SetChatProject)))
+	log.Println("Registered user project routes: GET/POST /api/projects, GET/PUT/DELETE /api/projects/{id}, POST /api/projects/{id}/files, GET/DELETE /api/projects/{id}/files/{file_id}, PUT /api/chats/{id}/project")
 	// Register the new purge route
 	mux.Handle("DELETE /api/chats/purge", mw(http.HandlerFunc(h.PurgeUserChats)))
 	log.Println("Registered user chat route: DELETE /api/chats/purge")
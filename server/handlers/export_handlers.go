@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// exportFormat describes one of the formats chats can be exported in
+type exportFormat struct {
+	ContentType string
+	Extension   string
+	Write       func(e *models.ChatExport, w io.Writer) error
+}
+
+// exportFormats are the formats accepted by the export endpoints' format parameter
+var exportFormats = map[string]exportFormat{
+	"md":   {"text/markdown; charset=utf-8", "md", (*models.ChatExport).WriteMarkdown},
+	"json": {"application/json", "json", (*models.ChatExport).WriteJSON},
+	"html": {"text/html; charset=utf-8", "html", (*models.ChatExport).WriteHTML},
+}
+
+// parseExportFormat reads the format query parameter (Markdown by default),
+// writing a 400 response and returning false if it is unknown.
+func parseExportFormat(w http.ResponseWriter, r *http.Request) (exportFormat, bool) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		name = "md"
+	}
+	format, ok := exportFormats[name]
+	if !ok {
+		http.Error(w, "Bad Request: format must be md, json or html", http.StatusBadRequest)
+	}
+	return format, ok
+}
+
+// ExportChat handles GET /api/chats/{chat_id}/export?format=md|json|html
+func (h *ChatHandlers) ExportChat(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleViewer)
+	if !ok {
+		return
+	}
+	format, ok := parseExportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	export, err := h.ChatService.ExportChat(chatID)
+	if err != nil {
+		log.Printf("Error exporting chat %d: %v", chatID, err)
+		http.Error(w, "Failed to export chat", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, exportFileName(export, format)))
+	if err := format.Write(export, w); err != nil {
+		log.Printf("Error writing export of chat %d: %v", chatID, err)
+	}
+}
+
+// ExportChats handles GET /api/chats/export?format=md|json|html
+// Streams a zip archive with one file per chat of the user.
+func (h *ChatHandlers) ExportChats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	format, ok := parseExportFormat(w, r)
+	if !ok {
+		return
+	}
+
+	chats, err := h.ChatService.GetUserChats(int64(userID), false)
+	if err != nil {
+		log.Printf("Error fetching chats of user %d for export: %v", userID, err)
+		http.Error(w, "Failed to export chats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="cyberai-chats-%s.zip"`, time.Now().UTC().Format("2006-01-02")))
+
+	// Errors past this point can no longer change the response status, so
+	// they end the archive early and are logged
+	archive := zip.NewWriter(w)
+	for _, chat := range chats {
+		export, err := h.ChatService.ExportChat(chat.ID)
+		if err != nil {
+			log.Printf("Error exporting chat %d of user %d: %v", chat.ID, userID, err)
+			return
+		}
+		file, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     exportFileName(export, format),
+			Method:   zip.Deflate,
+			Modified: export.Chat.UpdatedAt,
+		})
+		if err != nil {
+			log.Printf("Error adding chat %d to export archive: %v", chat.ID, err)
+			return
+		}
+		if err := format.Write(export, file); err != nil {
+			log.Printf("Error writing chat %d to export archive: %v", chat.ID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Error finishing export archive of user %d: %v", userID, err)
+	}
+}
+
+// exportFileName names an exported chat after its ID and title, keeping only
+// characters that are safe in file names
+func exportFileName(export *models.ChatExport, format exportFormat) string {
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(export.Chat.Title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if r > unicode.MaxASCII {
+				continue
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else if !dash && slug.Len() > 0 {
+			slug.WriteByte('-')
+			dash = true
+		}
+		if slug.Len() >= 40 {
+			break
+		}
+	}
+	name := strings.TrimSuffix(slug.String(), "-")
+	if name == "" {
+		return fmt.Sprintf("chat-%d.%s", export.Chat.ID, format.Extension)
+	}
+	return fmt.Sprintf("chat-%d-%s.%s", export.Chat.ID, name, format.Extension)
+}
+
+// maxImportUploadSize limits uploads to the import endpoint. ChatGPT archives
+// include every image of every conversation, so they can be large; multipart
+// uploads are spooled to disk.
+const maxImportUploadSize = 2 << 30
+
+// ImportChats handles POST /api/chats/import
+// Accepts a ChatGPT or Claude export (conversations.json or its zip archive)
+// or a CyberAI JSON export (or bulk export archive), either as the "file"
+// field of a multipart form or as the raw request body. Creates a chat for
+// each conversation and returns a report of what was imported and skipped.
+func (h *ChatHandlers) ImportChats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var file io.ReaderAt
+	var size int64
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportUploadSize)
+		upload, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Bad Request: Missing or unreadable file field", http.StatusBadRequest)
+			return
+		}
+		defer upload.Close()
+		defer r.MultipartForm.RemoveAll()
+		file, size = upload, header.Size
+	} else {
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, models.MaxImportFileSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Bad Request: Body must be at most %d MB (upload larger archives as multipart form data)", models.MaxImportFileSize>>20), http.StatusBadRequest)
+			return
+		}
+		file, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	chats, report, err := models.ParseChatImport(file, size)
+	if err != nil {
+		if errors.Is(err, models.ErrUnknownImportFormat) {
+			http.Error(w, "Bad Request: File is not a ChatGPT, Claude or CyberAI export", http.StatusBadRequest)
+		} else {
+			http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		}
+		return
+	}
+
+	for i := range chats {
+		chat, err := h.ChatService.ImportChat(int64(userID), &chats[i])
+		if err != nil {
+			log.Printf("Error importing chat %q for user %d: %v", chats[i].Title, userID, err)
+			report.Skip(chats[i].Title, "", "failed to save the conversation")
+			continue
+		}
+		report.Imported = append(report.Imported, models.ImportedChatInfo{
+			ChatID:   chat.ID,
+			Title:    chat.Title,
+			Messages: len(chats[i].Messages),
+		})
+	}
+	log.Printf("User %d imported %d chats from a %s export (%d items skipped)", userID, len(report.Imported), report.Format, len(report.Skipped))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error encoding import report for user %d: %v", userID, err)
+	}
+}
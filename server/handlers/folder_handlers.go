@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// MaxFolderNameLength is the longest folder name, in bytes
+const MaxFolderNameLength = 100
+
+// ChatFolderRequest is the body of POST /api/folders and PUT /api/folders/{folder_id}
+type ChatFolderRequest struct {
+	Name string `json:"name"`
+}
+
+// OrganizeChatsRequest is the body of POST /api/chats/organize. Omitted
+// fields are left unchanged.
+type OrganizeChatsRequest struct {
+	ChatIDs    []int64  `json:"chat_ids"`
+	FolderID   *int64   `json:"folder_id,omitempty"` // Move into this folder; 0 to take out of any folder
+	Pinned     *bool    `json:"pinned,omitempty"`
+	AddTags    []string `json:"add_tags,omitempty"`
+	RemoveTags []string `json:"remove_tags,omitempty"`
+}
+
+// OrganizeChats handles POST /api/chats/organize
+// Moves chats between folders, pins or unpins them and adds or removes tags,
+// for the caller only. Either every chat is changed or none is.
+func (h *ChatHandlers) OrganizeChats(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req OrganizeChatsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.ChatIDs) == 0 {
+		http.Error(w, "Bad Request: chat_ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.ChatIDs) > models.MaxChatListLimit {
+		http.Error(w, fmt.Sprintf("Bad Request: At most %d chats can be organized at once", models.MaxChatListLimit), http.StatusBadRequest)
+		return
+	}
+	if req.FolderID == nil && req.Pinned == nil && len(req.AddTags) == 0 && len(req.RemoveTags) == 0 {
+		http.Error(w, "Bad Request: Nothing to change", http.StatusBadRequest)
+		return
+	}
+
+	org := models.ChatOrganization{FolderID: req.FolderID, Pinned: req.Pinned}
+	var err error
+	if org.AddTags, err = normalizeTags(req.AddTags); err == nil {
+		org.RemoveTags, err = normalizeTags(req.RemoveTags)
+	}
+	if err != nil {
+		http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Participants organise shared chats in their own list too
+	for _, chatID := range req.ChatIDs {
+		if _, ok := h.checkChatAccess(w, r, userID, chatID, models.ChatRoleViewer); !ok {
+			return
+		}
+	}
+
+	if err := h.ChatService.OrganizeChats(int64(userID), req.ChatIDs, org); err != nil {
+		if errors.Is(err, models.ErrFolderNotFound) {
+			http.Error(w, "Not Found: Folder not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error organizing chats %v for user %d: %v", req.ChatIDs, userID, err)
+			http.Error(w, "Failed to organize chats", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d organized %d chats", userID, len(req.ChatIDs))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// normalizeTags normalizes each tag with models.NormalizeTag
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag, err := models.NormalizeTag(tag)
+		if err != nil {
+			return nil, err
+		}
+		normalized = append(normalized, tag)
+	}
+	return normalized, nil
+}
+
+// ListChatTags handles GET /api/tags
+// Lists the tags the user has used, with the number of chats carrying each.
+func (h *ChatHandlers) ListChatTags(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	tags, err := h.ChatService.GetUserTags(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching tags for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch tags", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tags)
+}
+
+// ListChatFolders handles GET /api/folders
+func (h *ChatHandlers) ListChatFolders(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	folders, err := h.ChatService.GetChatFolders(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching folders for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch folders", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folders)
+}
+
+// decodeFolderName reads and validates the body of a folder request, writing
+// a 400 response and returning false if it is invalid.
+func decodeFolderName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req ChatFolderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return "", false
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		http.Error(w, "Bad Request: name is required", http.StatusBadRequest)
+		return "", false
+	}
+	if len(name) > MaxFolderNameLength {
+		http.Error(w, fmt.Sprintf("Bad Request: name cannot be longer than %d characters", MaxFolderNameLength), http.StatusBadRequest)
+		return "", false
+	}
+	return name, true
+}
+
+// CreateChatFolder handles POST /api/folders
+func (h *ChatHandlers) CreateChatFolder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	name, ok := decodeFolderName(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := h.ChatService.CreateChatFolder(int64(userID), name)
+	if err != nil {
+		if errors.Is(err, models.ErrFolderExists) {
+			http.Error(w, "Conflict: You already have a folder with that name", http.StatusConflict)
+		} else {
+			log.Printf("Error creating folder %q for user %d: %v", name, userID, err)
+			http.Error(w, "Failed to create folder", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d created folder %d", userID, folder.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(folder)
+}
+
+// UpdateChatFolder handles PUT /api/folders/{folder_id}
+// Renames the folder.
+func (h *ChatHandlers) UpdateChatFolder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	folderID, err := strconv.ParseInt(r.PathValue("folder_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid folder ID format", http.StatusBadRequest)
+		return
+	}
+	name, ok := decodeFolderName(w, r)
+	if !ok {
+		return
+	}
+
+	folder, err := h.ChatService.RenameChatFolder(folderID, int64(userID), name)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrFolderNotFound):
+			http.Error(w, "Not Found: Folder not found", http.StatusNotFound)
+		case errors.Is(err, models.ErrFolderExists):
+			http.Error(w, "Conflict: You already have a folder with that name", http.StatusConflict)
+		default:
+			log.Printf("Error renaming folder %d for user %d: %v", folderID, userID, err)
+			http.Error(w, "Failed to rename folder", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(folder)
+}
+
+// DeleteChatFolder handles DELETE /api/folders/{folder_id}
+// The chats in the folder are kept and are no longer in any folder.
+func (h *ChatHandlers) DeleteChatFolder(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	folderID, err := strconv.ParseInt(r.PathValue("folder_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid folder ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ChatService.DeleteChatFolder(folderID, int64(userID)); err != nil {
+		if errors.Is(err, models.ErrFolderNotFound) {
+			http.Error(w, "Not Found: Folder not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error deleting folder %d for user %d: %v", folderID, userID, err)
+			http.Error(w, "Failed to delete folder", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d deleted folder %d", userID, folderID)
+	w.WriteHeader(http.StatusNoContent)
+}
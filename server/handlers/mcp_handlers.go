@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ramborogers/cyberai/server/llm"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// MCPHandlers provides admin handlers for managing MCP servers
+type MCPHandlers struct {
+	MCPService  *models.MCPService
+	ToolManager *llm.MCPToolManager
+}
+
+// NewMCPHandlers creates a new instance of MCPHandlers
+func NewMCPHandlers(toolManager *llm.MCPToolManager) *MCPHandlers {
+	return &MCPHandlers{
+		MCPService:  toolManager.Service(),
+		ToolManager: toolManager,
+	}
+}
+
+// RegisterAdminRoutes registers the MCP server routes (relative to /api/admin/),
+// which require the manage_providers permission
+func (h *MCPHandlers) RegisterAdminRoutes(mux *http.ServeMux, requirePermission func(permissions ...string) func(http.Handler) http.Handler) {
+	manageProviders := requirePermission(models.PermManageProviders)
+	mux.Handle("GET /mcp-servers", manageProviders(http.HandlerFunc(h.ListServers)))
+	mux.Handle("POST /mcp-servers", manageProviders(http.HandlerFunc(h.CreateServer)))
+	mux.Handle("GET /mcp-servers/{id}", manageProviders(http.HandlerFunc(h.GetServer)))
+	mux.Handle("PUT /mcp-servers/{id}", manageProviders(http.HandlerFunc(h.UpdateServer)))
+	mux.Handle("DELETE /mcp-servers/{id}", manageProviders(http.HandlerFunc(h.DeleteServer)))
+	mux.Handle("POST /mcp-servers/{id}/sync", manageProviders(http.HandlerFunc(h.SyncServerTools)))
+	mux.Handle("GET /mcp-servers/{id}/tools", manageProviders(http.HandlerFunc(h.ListServerTools)))
+}
+
+// stripMCPSecrets removes env and header values (which may hold credentials)
+// from a server before it is returned, keeping only the keys.
+func stripMCPSecrets(server *models.MCPServer) {
+	for k := range server.Env {
+		server.Env[k] = ""
+	}
+	for k := range server.Headers {
+		server.Headers[k] = ""
+	}
+}
+
+// keepMCPSecrets fills blank values in updated with the stored values.
+func keepMCPSecrets(updated, stored map[string]string) {
+	for k, v := range updated {
+		if old, ok := stored[k]; ok && v == "" {
+			updated[k] = old
+		}
+	}
+}
+
+// ListServers handles GET /api/admin/mcp-servers
+func (h *MCPHandlers) ListServers(w http.ResponseWriter, r *http.Request) {
+	servers, err := h.MCPService.ListServers(false)
+	if err != nil {
+		log.Printf("Error listing MCP servers: %v", err)
+		http.Error(w, "Failed to list MCP servers", http.StatusInternalServerError)
+		return
+	}
+	if servers == nil {
+		servers = []models.MCPServer{}
+	}
+	for i := range servers {
+		stripMCPSecrets(&servers[i])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(servers)
+}
+
+// CreateServer handles POST /api/admin/mcp-servers
+func (h *MCPHandlers) CreateServer(w http.ResponseWriter, r *http.Request) {
+	var server models.MCPServer
+	server.IsActive = true // Default when omitted
+	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := server.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.MCPService.CreateServer(&server); err != nil {
+		log.Printf("Error creating MCP server: %v", err)
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: mcp_servers.name") {
+			http.Error(w, fmt.Sprintf("MCP server name '%s' already exists", server.Name), http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create MCP server", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Discover tools right away so the server is immediately usable; a failure
+	// here is not fatal, the admin can retry with the sync endpoint.
+	if server.IsActive {
+		if tools, err := h.ToolManager.SyncServerTools(r.Context(), server.ID); err != nil {
+			log.Printf("Initial tool sync failed for MCP server %d (%s): %v", server.ID, server.Name, err)
+		} else {
+			server.Tools = tools
+		}
+	}
+
+	stripMCPSecrets(&server)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(server)
+}
+
+// getServerForRequest parses the {id} path value and loads the server,
+// writing an error response on failure.
+func (h *MCPHandlers) getServerForRequest(w http.ResponseWriter, r *http.Request) (*models.MCPServer, bool) {
+	serverID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid MCP server ID", http.StatusBadRequest)
+		return nil, false
+	}
+	server, err := h.MCPService.GetServer(serverID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "MCP server not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error getting MCP server %d: %v", serverID, err)
+			http.Error(w, "Failed to get MCP server", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return server, true
+}
+
+// GetServer handles GET /api/admin/mcp-servers/{id}
+func (h *MCPHandlers) GetServer(w http.ResponseWriter, r *http.Request) {
+	server, ok := h.getServerForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tools, err := h.MCPService.ListServerTools(server.ID)
+	if err != nil {
+		log.Printf("Error listing tools for MCP server %d: %v", server.ID, err)
+	}
+	server.Tools = tools
+
+	stripMCPSecrets(server)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(server)
+}
+
+// UpdateServer handles PUT /api/admin/mcp-servers/{id}
+// Env and headers are only replaced when present in the request body.
+func (h *MCPHandlers) UpdateServer(w http.ResponseWriter, r *http.Request) {
+	existing, ok := h.getServerForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	server := *existing
+	server.Env = nil
+	server.Headers = nil
+	if err := json.NewDecoder(r.Body).Decode(&server); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	server.ID = existing.ID
+	// Blank values are what stripMCPSecrets returned; keep the stored secret
+	keepMCPSecrets(server.Env, existing.Env)
+	keepMCPSecrets(server.Headers, existing.Headers)
+	if err := server.Validate(); err != nil {
+		http.Error(w, fmt.Sprintf("Bad Request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.MCPService.UpdateServer(&server); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "MCP server not found", http.StatusNotFound)
+		} else if strings.Contains(err.Error(), "UNIQUE constraint failed: mcp_servers.name") {
+			http.Error(w, fmt.Sprintf("MCP server name '%s' already exists", server.Name), http.StatusConflict)
+		} else {
+			log.Printf("Error updating MCP server %d: %v", server.ID, err)
+			http.Error(w, "Failed to update MCP server", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Drop the live connection so the next call uses the new settings
+	h.ToolManager.Invalidate(server.ID)
+
+	updated, err := h.MCPService.GetServer(server.ID)
+	if err != nil {
+		log.Printf("Error fetching updated MCP server %d: %v", server.ID, err)
+		updated = &server
+	}
+	stripMCPSecrets(updated)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteServer handles DELETE /api/admin/mcp-servers/{id}
+func (h *MCPHandlers) DeleteServer(w http.ResponseWriter, r *http.Request) {
+	serverID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid MCP server ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.MCPService.DeleteServer(serverID); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			http.Error(w, "MCP server not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error deleting MCP server %d: %v", serverID, err)
+			http.Error(w, "Failed to delete MCP server", http.StatusInternalServerError)
+		}
+		return
+	}
+	h.ToolManager.Invalidate(serverID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SyncServerTools handles POST /api/admin/mcp-servers/{id}/sync
+// It reconnects to the server and refreshes the stored tool list.
+func (h *MCPHandlers) SyncServerTools(w http.ResponseWriter, r *http.Request) {
+	server, ok := h.getServerForRequest(w, r)
+	if !ok {
+		return
+	}
+	if !server.IsActive {
+		http.Error(w, "Bad Request: MCP server is disabled", http.StatusBadRequest)
+		return
+	}
+
+	tools, err := h.ToolManager.SyncServerTools(r.Context(), server.ID)
+	if err != nil {
+		log.Printf("Error syncing tools for MCP server %d (%s): %v", server.ID, server.Name, err)
+		http.Error(w, fmt.Sprintf("Failed to sync MCP server: %v", err), http.StatusBadGateway)
+		return
+	}
+	if tools == nil {
+		tools = []models.MCPTool{}
+	}
+
+	response := struct {
+		ToolsDiscovered int              `json:"tools_discovered"`
+		Tools           []models.MCPTool `json:"tools"`
+	}{
+		ToolsDiscovered: len(tools),
+		Tools:           tools,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListServerTools handles GET /api/admin/mcp-servers/{id}/tools
+func (h *MCPHandlers) ListServerTools(w http.ResponseWriter, r *http.Request) {
+	server, ok := h.getServerForRequest(w, r)
+	if !ok {
+		return
+	}
+
+	tools, err := h.MCPService.ListServerTools(server.ID)
+	if err != nil {
+		log.Printf("Error listing tools for MCP server %d: %v", server.ID, err)
+		http.Error(w, "Failed to list MCP tools", http.StatusInternalServerError)
+		return
+	}
+	if tools == nil {
+		tools = []models.MCPTool{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tools)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/ramborogers/cyberai/server/middleware"
 	"github.com/ramborogers/cyberai/server/models"
 )
 
@@ -19,16 +20,18 @@ func NewModelHandlers(ms *models.ModelService) *ModelHandlers {
 
 // ListModels handles GET /api/models
 func (h *ModelHandlers) ListModels(w http.ResponseWriter, r *http.Request) {
-	// No need to check auth here, middleware already handled it.
-	// UserID could be retrieved from context if needed for filtering later:
-	// userID := middleware.GetUserIDFromContext(r.Context())
-	// log.Printf("ListModels called by User ID: %d", userID)
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
 
 	log.Println("API Call: GET /api/models")
 
-	userFacingModels, err := h.ModelService.GetActiveUserFacingModels()
+	// Only the models the user was granted (see ModelAccess)
+	userFacingModels, err := h.ModelService.GetUserFacingModelsForUser(int64(userID))
 	if err != nil {
-		log.Printf("Error fetching active user-facing models: %v", err)
+		log.Printf("Error fetching user-facing models for user %d: %v", userID, err)
 		http.Error(w, "Failed to fetch models", http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+	"github.com/ramborogers/cyberai/server/ws"
+)
+
+// AddChatParticipantRequest is the body of POST /api/chats/{chat_id}/participants
+type AddChatParticipantRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"` // "viewer" or "contributor"
+}
+
+// ListChatParticipants handles GET /api/chats/{chat_id}/participants
+// Lists the owner and everyone the chat is shared with. Any participant can
+// see who else is in the chat.
+func (h *ChatHandlers) ListChatParticipants(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, _, ok := h.getChatAccess(w, r, userID, models.ChatRoleViewer)
+	if !ok {
+		return
+	}
+
+	participants, err := h.ChatService.GetChatParticipants(chatID)
+	if err != nil {
+		log.Printf("Error fetching participants of chat %d: %v", chatID, err)
+		http.Error(w, "Failed to fetch participants", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(participants)
+}
+
+// AddChatParticipant handles POST /api/chats/{chat_id}/participants
+// Invites a user to the chat, or changes the role of one already invited.
+func (h *ChatHandlers) AddChatParticipant(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req AddChatParticipantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Username == "" {
+		http.Error(w, "Bad Request: username is required", http.StatusBadRequest)
+		return
+	}
+	if !models.ValidParticipantRole(req.Role) {
+		http.Error(w, "Bad Request: role must be viewer or contributor", http.StatusBadRequest)
+		return
+	}
+
+	participant, err := h.ChatService.AddChatParticipant(chatID, req.Username, req.Role, int64(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrUserNotFound):
+			http.Error(w, "Not Found: No active user with that username", http.StatusNotFound)
+		case errors.Is(err, models.ErrInviteOwner):
+			http.Error(w, "Bad Request: You already own this chat", http.StatusBadRequest)
+		default:
+			log.Printf("Error adding participant %q to chat %d: %v", req.Username, chatID, err)
+			http.Error(w, "Failed to add participant", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d added user %d to chat %d as %s", userID, participant.UserID, chatID, participant.Role)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(participant)
+}
+
+// RemoveChatParticipant handles DELETE /api/chats/{chat_id}/participants/{user_id}
+// The owner can remove anyone; participants can remove themselves to leave
+// the chat.
+func (h *ChatHandlers) RemoveChatParticipant(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	participantID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid user ID format", http.StatusBadRequest)
+		return
+	}
+	required := models.ChatRoleOwner
+	if participantID == int64(userID) {
+		required = models.ChatRoleViewer
+	}
+	chatID, _, ok := h.getChatAccess(w, r, userID, required)
+	if !ok {
+		return
+	}
+
+	if err := h.ChatService.RemoveChatParticipant(chatID, participantID); err != nil {
+		if errors.Is(err, models.ErrParticipantNotFound) {
+			http.Error(w, "Not Found: User is not a participant of this chat", http.StatusNotFound)
+		} else {
+			log.Printf("Error removing user %d from chat %d: %v", participantID, chatID, err)
+			http.Error(w, "Failed to remove participant", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d removed user %d from chat %d", userID, participantID, chatID)
+
+	// Stop live updates to the removed user's clients
+	if h.Hub != nil {
+		h.Hub.UnsubscribeUser(chatID, participantID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendUserMessage tells the chat's other participants about a message posted
+// by userID, so they see it without reloading
+func (h *ChatHandlers) sendUserMessage(userID int, message models.Message) {
+	if h.Hub == nil {
+		return
+	}
+	h.Hub.SendToChat(message.ChatID, 0, ws.Message{
+		Type: ws.MsgTypeUserMessage,
+		MessagePayload: &ws.MessagePayload{
+			ID:         message.ID,
+			ChatID:     message.ChatID,
+			UserID:     int64(userID),
+			Role:       message.Role,
+			Content:    message.Content,
+			AgentID:    message.AgentID,
+			ParentID:   message.ParentID,
+			SiblingIDs: message.SiblingIDs,
+			CreatedAt:  message.CreatedAt,
+		},
+	})
+}
+
+// CanAccessChat reports whether the user may follow the chat live; it is the
+// WebSocket hub's subscription check
+func (h *ChatHandlers) CanAccessChat(userID, chatID int64) bool {
+	role, err := h.ChatService.GetChatRole(chatID, userID)
+	if err != nil {
+		if err.Error() != fmt.Sprintf("chat not found: %d", chatID) {
+			log.Printf("Error checking access of user %d to chat %d: %v", userID, chatID, err)
+		}
+		return false
+	}
+	return role != ""
+}
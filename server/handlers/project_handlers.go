@@ -0,0 +1,451 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// Limits on the text fields of a project, in bytes
+const (
+	MaxProjectNameLength         = 100
+	MaxProjectDescriptionLength  = 1000
+	MaxProjectInstructionsLength = 20000
+)
+
+// ProjectRequest is the body of POST /api/projects and PUT /api/projects/{project_id}
+type ProjectRequest struct {
+	Name           string `json:"name"`
+	Description    string `json:"description"`
+	Instructions   string `json:"instructions"`
+	DefaultModelID *int64 `json:"default_model_id,omitempty"` // Model answering messages that name none
+	DefaultAgentID *int64 `json:"default_agent_id,omitempty"` // Agent used for messages that name none
+	IsShared       bool   `json:"is_shared"`                  // Share with the users in the owner's role
+}
+
+// ProjectFileRequest is the JSON body of POST /api/projects/{project_id}/files
+type ProjectFileRequest struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// SetChatProjectRequest is the body of PUT /api/chats/{chat_id}/project
+type SetChatProjectRequest struct {
+	ProjectID *int64 `json:"project_id"` // null or 0 to take the chat out of its project
+}
+
+// ListProjects handles GET /api/projects
+// Lists the caller's projects and the projects shared with their role.
+func (h *ChatHandlers) ListProjects(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	projects, err := h.ProjectService.ListProjects(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching projects for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch projects", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(projects)
+}
+
+// decodeProject reads and validates the body of a project request into p,
+// writing a 400 response and returning false if it is invalid.
+func decodeProject(w http.ResponseWriter, r *http.Request, p *models.Project) bool {
+	var req ProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return false
+	}
+	p.Name = strings.TrimSpace(req.Name)
+	switch {
+	case p.Name == "":
+		http.Error(w, "Bad Request: name is required", http.StatusBadRequest)
+		return false
+	case len(p.Name) > MaxProjectNameLength:
+		http.Error(w, fmt.Sprintf("Bad Request: name cannot be longer than %d characters", MaxProjectNameLength), http.StatusBadRequest)
+		return false
+	case len(req.Description) > MaxProjectDescriptionLength:
+		http.Error(w, fmt.Sprintf("Bad Request: description cannot be longer than %d characters", MaxProjectDescriptionLength), http.StatusBadRequest)
+		return false
+	case len(req.Instructions) > MaxProjectInstructionsLength:
+		http.Error(w, fmt.Sprintf("Bad Request: instructions cannot be longer than %d characters", MaxProjectInstructionsLength), http.StatusBadRequest)
+		return false
+	}
+	p.Description = strings.TrimSpace(req.Description)
+	p.Instructions = strings.TrimSpace(req.Instructions)
+	p.DefaultModelID, p.DefaultAgentID = req.DefaultModelID, req.DefaultAgentID
+	if p.DefaultModelID != nil && *p.DefaultModelID <= 0 {
+		p.DefaultModelID = nil
+	}
+	if p.DefaultAgentID != nil && *p.DefaultAgentID <= 0 {
+		p.DefaultAgentID = nil
+	}
+	p.IsShared = req.IsShared
+	return true
+}
+
+// CreateProject handles POST /api/projects
+func (h *ChatHandlers) CreateProject(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project := models.Project{UserID: int64(userID)}
+	if !decodeProject(w, r, &project) {
+		return
+	}
+
+	if err := h.ProjectService.CreateProject(&project); err != nil {
+		if errors.Is(err, models.ErrInvalidProjectDefault) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Error creating project %q for user %d: %v", project.Name, userID, err)
+			http.Error(w, "Failed to create project", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d created project %d", userID, project.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(project)
+}
+
+// getProject loads the project named in the path if the user can see it and,
+// when owner is set, owns it, writing the error response otherwise.
+func (h *ChatHandlers) getProject(w http.ResponseWriter, r *http.Request, userID int, owner bool) (*models.Project, bool) {
+	projectID, err := strconv.ParseInt(r.PathValue("project_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid project ID format", http.StatusBadRequest)
+		return nil, false
+	}
+
+	project, err := h.ProjectService.GetProject(projectID, int64(userID))
+	if err != nil {
+		if errors.Is(err, models.ErrProjectNotFound) {
+			http.Error(w, "Not Found: Project not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching project %d for user %d: %v", projectID, userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	if owner && project.UserID != int64(userID) {
+		http.Error(w, "Forbidden: Only the project's owner can change it", http.StatusForbidden)
+		return nil, false
+	}
+	return project, true
+}
+
+// GetProject handles GET /api/projects/{project_id}
+// Returns the project with its files (without their content).
+func (h *ChatHandlers) GetProject(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, false)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// UpdateProject handles PUT /api/projects/{project_id}
+// Replaces the project's name, description, instructions, defaults and
+// sharing. Owner only.
+func (h *ChatHandlers) UpdateProject(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, true)
+	if !ok {
+		return
+	}
+	if !decodeProject(w, r, project) {
+		return
+	}
+
+	if err := h.ProjectService.UpdateProject(project); err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidProjectDefault):
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		case errors.Is(err, models.ErrProjectNotFound):
+			http.Error(w, "Not Found: Project not found", http.StatusNotFound)
+		default:
+			log.Printf("Error updating project %d for user %d: %v", project.ID, userID, err)
+			http.Error(w, "Failed to update project", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(project)
+}
+
+// DeleteProject handles DELETE /api/projects/{project_id}
+// The project's chats are kept and are no longer in any project. Owner only.
+func (h *ChatHandlers) DeleteProject(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, true)
+	if !ok {
+		return
+	}
+
+	if err := h.ProjectService.DeleteProject(project.ID, int64(userID)); err != nil {
+		if errors.Is(err, models.ErrProjectNotFound) {
+			http.Error(w, "Not Found: Project not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error deleting project %d for user %d: %v", project.ID, userID, err)
+			http.Error(w, "Failed to delete project", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d deleted project %d", userID, project.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readProjectFile reads the file of an upload, either the "file" field of a
+// multipart form or a JSON ProjectFileRequest, writing a 400 response and
+// returning false if it is missing, too large or not text.
+func readProjectFile(w http.ResponseWriter, r *http.Request) (name string, content []byte, ok bool) {
+	r.Body = http.MaxBytesReader(w, r.Body, 2*models.MaxProjectFileSize)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "Bad Request: A file is required in the \"file\" field", http.StatusBadRequest)
+			return "", nil, false
+		}
+		defer file.Close()
+		if content, err = io.ReadAll(io.LimitReader(file, models.MaxProjectFileSize+1)); err != nil {
+			http.Error(w, "Bad Request: Could not read the file", http.StatusBadRequest)
+			return "", nil, false
+		}
+		name = header.Filename
+	} else {
+		var req ProjectFileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+			return "", nil, false
+		}
+		name, content = req.Name, []byte(req.Content)
+	}
+
+	name = strings.TrimSpace(filepath.Base(name))
+	switch {
+	case name == "" || name == "." || name == string(filepath.Separator):
+		http.Error(w, "Bad Request: The file needs a name", http.StatusBadRequest)
+		return "", nil, false
+	case len(content) == 0:
+		http.Error(w, "Bad Request: The file is empty", http.StatusBadRequest)
+		return "", nil, false
+	case len(content) > models.MaxProjectFileSize:
+		http.Error(w, fmt.Sprintf("Bad Request: Files cannot be larger than %d KB", models.MaxProjectFileSize>>10), http.StatusBadRequest)
+		return "", nil, false
+	case !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0:
+		http.Error(w, "Bad Request: Only text files can be attached", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return name, content, true
+}
+
+// AddProjectFile handles POST /api/projects/{project_id}/files
+// Attaches a reference text file to the project. Owner only.
+func (h *ChatHandlers) AddProjectFile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, true)
+	if !ok {
+		return
+	}
+	name, content, ok := readProjectFile(w, r)
+	if !ok {
+		return
+	}
+
+	file := models.ProjectFile{ProjectID: project.ID, Name: name, Content: string(content)}
+	if err := h.ProjectService.AddProjectFile(&file); err != nil {
+		if errors.Is(err, models.ErrTooManyProjectFiles) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Error adding file to project %d: %v", project.ID, err)
+			http.Error(w, "Failed to add file", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d added file %d (%d bytes) to project %d", userID, file.ID, file.Size, project.ID)
+
+	file.Content = ""
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(file)
+}
+
+// GetProjectFile handles GET /api/projects/{project_id}/files/{file_id}
+// Returns the file with its content.
+func (h *ChatHandlers) GetProjectFile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, false)
+	if !ok {
+		return
+	}
+	fileID, err := strconv.ParseInt(r.PathValue("file_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid file ID format", http.StatusBadRequest)
+		return
+	}
+
+	file, err := h.ProjectService.GetProjectFile(project.ID, fileID)
+	if err != nil {
+		if errors.Is(err, models.ErrProjectFileNotFound) {
+			http.Error(w, "Not Found: File not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching file %d of project %d: %v", fileID, project.ID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// DeleteProjectFile handles DELETE /api/projects/{project_id}/files/{file_id}
+// Owner only.
+func (h *ChatHandlers) DeleteProjectFile(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	project, ok := h.getProject(w, r, userID, true)
+	if !ok {
+		return
+	}
+	fileID, err := strconv.ParseInt(r.PathValue("file_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid file ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ProjectService.DeleteProjectFile(project.ID, fileID); err != nil {
+		if errors.Is(err, models.ErrProjectFileNotFound) {
+			http.Error(w, "Not Found: File not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error deleting file %d of project %d: %v", fileID, project.ID, err)
+			http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d deleted file %d of project %d", userID, fileID, project.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetChatProject handles PUT /api/chats/{chat_id}/project
+// Moves a chat owned by the caller into one of the projects they can see, or
+// out of its project.
+func (h *ChatHandlers) SetChatProject(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+	var req SetChatProjectRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	projectID := req.ProjectID
+	if projectID != nil && *projectID <= 0 {
+		projectID = nil
+	}
+	if projectID != nil && !h.checkProjectVisible(w, userID, *projectID) {
+		return
+	}
+
+	if err := h.ProjectService.SetChatProject(chatID, projectID); err != nil {
+		log.Printf("Error setting project of chat %d: %v", chatID, err)
+		http.Error(w, "Failed to set chat project", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d moved chat %d to project %v", userID, chatID, projectID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkProjectVisible checks the user can see the project, writing a 404
+// response and returning false if not
+func (h *ChatHandlers) checkProjectVisible(w http.ResponseWriter, userID int, projectID int64) bool {
+	if _, err := h.ProjectService.GetProject(projectID, int64(userID)); err != nil {
+		if errors.Is(err, models.ErrProjectNotFound) {
+			http.Error(w, "Not Found: Project not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error fetching project %d for user %d: %v", projectID, userID, err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
+// applyProjectDefaults fills in the model and agent of a message that names
+// none with the defaults of the chat's project
+func (h *ChatHandlers) applyProjectDefaults(chatID int64, modelID *int64, modelIDs []int64, agentID **int64) {
+	if (*modelID > 0 || len(modelIDs) > 0) && *agentID != nil {
+		return
+	}
+	defaultModelID, defaultAgentID, err := h.ProjectService.GetChatDefaults(chatID)
+	if err != nil {
+		log.Printf("Error fetching project defaults for chat %d: %v", chatID, err)
+		return
+	}
+	if *modelID <= 0 && len(modelIDs) == 0 {
+		*modelID = defaultModelID
+	}
+	if *agentID == nil {
+		*agentID = defaultAgentID
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/middleware"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// shareURL is the path of the read-only page of a share link
+func shareURL(token string) string {
+	return "/share/" + url.PathEscape(token)
+}
+
+// shareResponse is a share link as returned to its owner
+type shareResponse struct {
+	models.ChatShare
+	URL string `json:"url"`
+}
+
+// CreateChatShare handles POST /api/chats/{chat_id}/shares
+// Body: {"require_login": bool, "expires_at": RFC 3339 time (optional)}
+// Snapshots the chat as it is now and returns the new share link.
+func (h *ChatHandlers) CreateChatShare(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		RequireLogin bool       `json:"require_login"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "Bad Request: expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	share, err := h.ChatService.CreateChatShare(chatID, int64(userID), req.RequireLogin, req.ExpiresAt)
+	if err != nil {
+		log.Printf("Error sharing chat %d for user %d: %v", chatID, userID, err)
+		http.Error(w, "Failed to share chat", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d shared chat %d (share %d, %d messages)", userID, chatID, share.ID, share.Messages)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(shareResponse{*share, shareURL(share.Token)})
+}
+
+// ListChatShares handles GET /api/chats/{chat_id}/shares
+// Lists every share link of the chat, including revoked and expired ones.
+func (h *ChatHandlers) ListChatShares(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+
+	shares, err := h.ChatService.GetChatShares(chatID)
+	if err != nil {
+		log.Printf("Error fetching shares of chat %d: %v", chatID, err)
+		http.Error(w, "Failed to fetch share links", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]shareResponse, len(shares))
+	for i, share := range shares {
+		response[i] = shareResponse{share, shareURL(share.Token)}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeChatShare handles DELETE /api/chats/{chat_id}/shares/{share_id}
+func (h *ChatHandlers) RevokeChatShare(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	chatID, ok := h.getOwnedChatID(w, r, userID)
+	if !ok {
+		return
+	}
+	shareID, err := strconv.ParseInt(r.PathValue("share_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid share ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ChatService.RevokeChatShare(chatID, shareID); err != nil {
+		if errors.Is(err, models.ErrShareNotFound) {
+			http.Error(w, "Not Found: Share link not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error revoking share %d of chat %d: %v", shareID, chatID, err)
+			http.Error(w, "Failed to revoke share link", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d revoked share %d of chat %d", userID, shareID, chatID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getViewableShare loads the share link named by the {token} path value and
+// checks that the current visitor may view it. This is deliberately separate
+// from chat ownership: anyone allowed by the link sees its snapshot, never
+// the live chat. It writes the error response if the share cannot be viewed.
+func (h *ChatHandlers) getViewableShare(w http.ResponseWriter, r *http.Request) (*models.ChatShare, error) {
+	token := r.PathValue("token")
+	share, err := h.ChatService.GetChatShareByToken(token)
+	if err == nil {
+		err = share.CheckAccess(int64(middleware.GetUserIDFromContext(r.Context())), time.Now())
+	}
+	switch {
+	case err == nil:
+		return share, nil
+	case errors.Is(err, models.ErrShareNotFound):
+		http.Error(w, "Not Found: Share link not found", http.StatusNotFound)
+	case errors.Is(err, models.ErrShareRevoked):
+		http.Error(w, "Gone: This share link has been revoked", http.StatusGone)
+	case errors.Is(err, models.ErrShareExpired):
+		http.Error(w, "Gone: This share link has expired", http.StatusGone)
+	case errors.Is(err, models.ErrShareLoginRequired):
+		// Let the caller decide between a 401 and a redirect to the login page
+	default:
+		log.Printf("Error loading share link: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+	return nil, err
+}
+
+// ViewChatShare handles GET /share/{token}
+// Renders the shared snapshot as a read-only page. Visitors who are not
+// logged in are sent to the login page if the link requires it.
+func (h *ChatHandlers) ViewChatShare(w http.ResponseWriter, r *http.Request) {
+	share, err := h.getViewableShare(w, r)
+	if errors.Is(err, models.ErrShareLoginRequired) {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	if err := share.Snapshot.WriteHTML(w); err != nil {
+		log.Printf("Error rendering share %d: %v", share.ID, err)
+	}
+}
+
+// GetChatShareSnapshot handles GET /api/shares/{token}
+// Returns the shared snapshot in the JSON export format, so it can also be
+// imported with POST /api/chats/import.
+func (h *ChatHandlers) GetChatShareSnapshot(w http.ResponseWriter, r *http.Request) {
+	share, err := h.getViewableShare(w, r)
+	if errors.Is(err, models.ErrShareLoginRequired) {
+		http.Error(w, "Unauthorized: This share link requires login", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := share.Snapshot.WriteJSON(w); err != nil {
+		log.Printf("Error writing share %d: %v", share.ID, err)
+	}
+}
+
+// RegisterShareRoutes connects the public share link routes to the router.
+// They must not be behind SessionAuthMiddleware; mw should only identify the
+// visitor if they are logged in.
+func (h *ChatHandlers) RegisterShareRoutes(mux *http.ServeMux, mw func(http.Handler) http.Handler) {
+	mux.Handle("GET /share/{token}", mw(http.HandlerFunc(h.ViewChatShare)))
+	mux.Handle("GET /api/shares/{token}", mw(http.HandlerFunc(h.GetChatShareSnapshot)))
+	log.Println("Registered public share routes: GET /share/{token}, GET /api/shares/{token}")
+}
@@ -3,8 +3,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/ramborogers/cyberai/server/middleware"
 	"github.com/ramborogers/cyberai/server/models"
@@ -12,12 +16,23 @@ import (
 
 // UserHandlers struct holds dependencies for user-related handlers
 type UserHandlers struct {
-	UserService *models.UserService
+	UserService    *models.UserService
+	ProfileService *models.ProfileService
 }
 
 // NewUserHandlers creates a new instance of UserHandlers
-func NewUserHandlers(us *models.UserService) *UserHandlers {
-	return &UserHandlers{UserService: us}
+func NewUserHandlers(us *models.UserService, ps *models.ProfileService) *UserHandlers {
+	return &UserHandlers{UserService: us, ProfileService: ps}
+}
+
+// CustomInstructionsRequest is the body of PUT /api/user/me/instructions
+type CustomInstructionsRequest struct {
+	CustomInstructions string `json:"custom_instructions"`
+}
+
+// MemoryRequest is the body of POST /api/user/me/memories and PUT /api/user/me/memories/{memory_id}
+type MemoryRequest struct {
+	Content string `json:"content"`
 }
 
 // GetCurrentUser handles GET /api/user/me
@@ -65,5 +80,209 @@ func (h *UserHandlers) RegisterUserSelfRoutes(mux *http.ServeMux, mw func(http.H
 	// Apply middleware (mw) to user self-management routes
 	mux.Handle("GET /api/user/me", mw(http.HandlerFunc(h.GetCurrentUser)))
 	log.Println("Registered user self route: GET /api/user/me")
+	mux.Handle("GET /api/user/me/instructions", mw(http.HandlerFunc(h.GetCustomInstructions)))
+	mux.Handle("PUT /api/user/me/instructions", mw(http.HandlerFunc(h.UpdateCustomInstructions)))
+	mux.Handle("GET /api/user/me/memories", mw(http.HandlerFunc(h.ListMemories)))
+	mux.Handle("POST /api/user/me/memories", mw(http.HandlerFunc(h.AddMemory)))
+	mux.Handle("DELETE /api/user/me/memories", mw(http.HandlerFunc(h.ClearMemories)))
+	mux.Handle("PUT /api/user/me/memories/{memory_id}", mw(http.HandlerFunc(h.UpdateMemory)))
+	mux.Handle("DELETE /api/user/me/memories/{memory_id}", mw(http.HandlerFunc(h.DeleteMemory)))
+	log.Println("Registered user self routes: GET/PUT /api/user/me/instructions, GET/POST/DELETE /api/user/me/memories, PUT/DELETE /api/user/me/memories/{id}")
 	// Add other routes like PUT /api/user/me for profile updates, POST /api/user/me/password for password changes later
 }
+
+// GetCustomInstructions handles GET /api/user/me/instructions
+func (h *UserHandlers) GetCustomInstructions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	ci, err := h.ProfileService.GetCustomInstructions(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching custom instructions for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch custom instructions", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ci)
+}
+
+// UpdateCustomInstructions handles PUT /api/user/me/instructions
+// Replaces the user's custom instructions; empty instructions clear them.
+func (h *UserHandlers) UpdateCustomInstructions(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	var req CustomInstructionsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	instructions := strings.TrimSpace(req.CustomInstructions)
+	if len(instructions) > models.MaxCustomInstructionsLength {
+		http.Error(w, fmt.Sprintf("Bad Request: custom_instructions cannot be longer than %d characters", models.MaxCustomInstructionsLength), http.StatusBadRequest)
+		return
+	}
+
+	ci, err := h.ProfileService.SetCustomInstructions(int64(userID), instructions)
+	if err != nil {
+		log.Printf("Error saving custom instructions for user %d: %v", userID, err)
+		http.Error(w, "Failed to save custom instructions", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d updated their custom instructions (%d bytes)", userID, len(instructions))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ci)
+}
+
+// ListMemories handles GET /api/user/me/memories
+func (h *UserHandlers) ListMemories(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	memories, err := h.ProfileService.ListMemories(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching memories for user %d: %v", userID, err)
+		http.Error(w, "Failed to fetch memories", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memories)
+}
+
+// decodeMemory reads and validates the body of a memory request, writing a
+// 400 response and returning false if it is invalid.
+func decodeMemory(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req MemoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Bad Request: Invalid JSON body", http.StatusBadRequest)
+		return "", false
+	}
+	content := strings.TrimSpace(req.Content)
+	if content == "" {
+		http.Error(w, "Bad Request: content is required", http.StatusBadRequest)
+		return "", false
+	}
+	if len(content) > models.MaxMemoryLength {
+		http.Error(w, fmt.Sprintf("Bad Request: content cannot be longer than %d characters", models.MaxMemoryLength), http.StatusBadRequest)
+		return "", false
+	}
+	return content, true
+}
+
+// AddMemory handles POST /api/user/me/memories
+func (h *UserHandlers) AddMemory(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	content, ok := decodeMemory(w, r)
+	if !ok {
+		return
+	}
+
+	memory := models.UserMemory{UserID: int64(userID), Content: content}
+	if err := h.ProfileService.AddMemory(&memory); err != nil {
+		if errors.Is(err, models.ErrTooManyMemories) {
+			http.Error(w, "Bad Request: "+err.Error(), http.StatusBadRequest)
+		} else {
+			log.Printf("Error adding memory for user %d: %v", userID, err)
+			http.Error(w, "Failed to add memory", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d added memory %d", userID, memory.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(memory)
+}
+
+// UpdateMemory handles PUT /api/user/me/memories/{memory_id}
+func (h *UserHandlers) UpdateMemory(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	memoryID, err := strconv.ParseInt(r.PathValue("memory_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid memory ID format", http.StatusBadRequest)
+		return
+	}
+	content, ok := decodeMemory(w, r)
+	if !ok {
+		return
+	}
+
+	memory := models.UserMemory{ID: memoryID, UserID: int64(userID), Content: content}
+	if err := h.ProfileService.UpdateMemory(&memory); err != nil {
+		if errors.Is(err, models.ErrMemoryNotFound) {
+			http.Error(w, "Not Found: Memory not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error updating memory %d for user %d: %v", memoryID, userID, err)
+			http.Error(w, "Failed to update memory", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(memory)
+}
+
+// DeleteMemory handles DELETE /api/user/me/memories/{memory_id}
+func (h *UserHandlers) DeleteMemory(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	memoryID, err := strconv.ParseInt(r.PathValue("memory_id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad Request: Invalid memory ID format", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.ProfileService.DeleteMemory(memoryID, int64(userID)); err != nil {
+		if errors.Is(err, models.ErrMemoryNotFound) {
+			http.Error(w, "Not Found: Memory not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error deleting memory %d for user %d: %v", memoryID, userID, err)
+			http.Error(w, "Failed to delete memory", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("User %d deleted memory %d", userID, memoryID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClearMemories handles DELETE /api/user/me/memories
+// Forgets everything the user asked the models to remember.
+func (h *UserHandlers) ClearMemories(w http.ResponseWriter, r *http.Request) {
+	userID := middleware.GetUserIDFromContext(r.Context())
+	if userID == 0 {
+		http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.ProfileService.ClearMemories(int64(userID)); err != nil {
+		log.Printf("Error clearing memories for user %d: %v", userID, err)
+		http.Error(w, "Failed to clear memories", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("User %d cleared their memories", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
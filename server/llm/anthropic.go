@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -13,6 +14,9 @@ import (
 	"github.com/ramborogers/cyberai/server/models"
 )
 
+// anthropicMinThinkingBudget is the smallest thinking budget the API accepts.
+const anthropicMinThinkingBudget = 1024
+
 // AnthropicConnector interacts with an Anthropic API endpoint.
 type AnthropicConnector struct {
 	client  anthropic.Client
@@ -106,9 +110,28 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 			systemPrompt = msg.Content
 		case "user", "assistant":
 			// Convert message to Anthropic's format
-			content := []anthropic.ContentBlockParamUnion{{
-				OfRequestTextBlock: &anthropic.TextBlockParam{Text: msg.Content},
-			}}
+			var content []anthropic.ContentBlockParamUnion
+			// With extended thinking, the thinking block must be replayed
+			// before the tool calls of the turn it belongs to
+			if msg.Role == "assistant" && msg.ReasoningSignature != "" && req.ThinkingBudget > 0 {
+				content = append(content, anthropic.ContentBlockParamUnion{
+					OfRequestThinkingBlock: &anthropic.ThinkingBlockParam{Thinking: msg.Reasoning, Signature: msg.ReasoningSignature},
+				})
+			}
+			if msg.Content != "" || len(msg.ToolCalls) == 0 {
+				content = append(content, anthropic.ContentBlockParamUnion{
+					OfRequestTextBlock: &anthropic.TextBlockParam{Text: msg.Content},
+				})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Arguments)
+				if len(input) == 0 {
+					input = json.RawMessage("{}")
+				}
+				content = append(content, anthropic.ContentBlockParamUnion{
+					OfRequestToolUseBlock: &anthropic.ToolUseBlockParam{ID: call.ID, Name: call.Name, Input: input},
+				})
+			}
 
 			anthropicRole := anthropic.MessageParamRoleUser
 			if msg.Role == "assistant" {
@@ -119,6 +142,20 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 				Role:    anthropicRole,
 				Content: content,
 			})
+		case "tool":
+			// Tool results are sent as tool_result blocks in a user message;
+			// consecutive results share a single message.
+			block := anthropic.NewToolResultBlock(msg.ToolCallID, msg.Content, false)
+			last := len(anthropicMessages) - 1
+			if last >= 0 && anthropicMessages[last].Role == anthropic.MessageParamRoleUser &&
+				len(anthropicMessages[last].Content) > 0 && anthropicMessages[last].Content[0].OfRequestToolResultBlock != nil {
+				anthropicMessages[last].Content = append(anthropicMessages[last].Content, block)
+			} else {
+				anthropicMessages = append(anthropicMessages, anthropic.MessageParam{
+					Role:    anthropic.MessageParamRoleUser,
+					Content: []anthropic.ContentBlockParamUnion{block},
+				})
+			}
 		default:
 			return fmt.Errorf("invalid message role for Anthropic: %s", msg.Role)
 		}
@@ -140,12 +177,72 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 		params.System = []anthropic.TextBlockParam{textBlock}
 	}
 
-	// Set temperature if provided
-	if req.Temperature > 0 {
+	// Extended thinking: the budget counts towards max_tokens, so add it on
+	// top of the configured answer length
+	thinking := req.ThinkingBudget > 0
+	if thinking {
+		budget := req.ThinkingBudget
+		if budget < anthropicMinThinkingBudget {
+			budget = anthropicMinThinkingBudget
+		}
+		params.Thinking = anthropic.ThinkingConfigParamOfThinkingConfigEnabled(int64(budget))
+		params.MaxTokens += int64(budget)
+	}
+
+	// Set temperature if provided (not allowed together with thinking)
+	if req.Temperature > 0 && !thinking {
 		// Use the Float helper function to create an Opt[float64]
 		params.Temperature = anthropic.Float(req.Temperature)
 	}
 
+	// Sampling parameters; with thinking enabled the API rejects top_k and
+	// restricts top_p, so they are dropped
+	sampling := req.Sampling
+	if thinking {
+		if sampling.TopP != nil || sampling.TopK != nil {
+			log.Printf("Warning: ignoring top_p/top_k for Anthropic model %s because thinking is enabled", req.Model)
+		}
+		sampling.TopP, sampling.TopK = nil, nil
+	}
+	warnUnsupportedSampling("Anthropic", req.Model, sampling, "top_p", "top_k", "stop")
+	if sampling.TopP != nil {
+		params.TopP = anthropic.Float(*sampling.TopP)
+	}
+	if sampling.TopK != nil {
+		params.TopK = anthropic.Int(int64(*sampling.TopK))
+	}
+	if len(sampling.Stop) > 0 {
+		params.StopSequences = sampling.Stop
+	}
+
+	// Add tool definitions if provided
+	for _, tool := range req.Tools {
+		params.Tools = append(params.Tools, anthropicTool(tool))
+	}
+
+	// Structured output: Anthropic has no response_format, so offer a tool
+	// whose input schema is the requested schema and force the model to call
+	// it. The tool input then becomes the response content.
+	structuredToolName := ""
+	if req.ResponseFormat.IsJSON() {
+		structuredToolName = toolNameSanitizer.ReplaceAllString(req.ResponseFormat.SchemaName(), "_")
+		params.Tools = append(params.Tools, anthropicTool(ToolDefinition{
+			Name:        structuredToolName,
+			Description: "Respond to the user. Always call this tool to give your final answer as structured data.",
+			InputSchema: req.ResponseFormat.EffectiveSchema(),
+		}))
+		if thinking {
+			// Forced tool use is not allowed with thinking; the output is
+			// validated afterwards instead
+			log.Printf("Anthropic thinking enabled for model %s, structured output tool is offered but not forced", req.Model)
+		} else if len(req.Tools) == 0 {
+			params.ToolChoice = anthropic.ToolChoiceParamOfToolChoiceTool(structuredToolName)
+		} else {
+			// Other tools must stay callable; "any" still forbids a plain-text answer
+			params.ToolChoice = anthropic.ToolChoiceUnionParam{OfToolChoiceAny: &anthropic.ToolChoiceAnyParam{}}
+		}
+	}
+
 	// Handle streaming vs non-streaming
 	if req.Stream {
 		stream := c.client.Messages.NewStreaming(ctx, params)
@@ -155,8 +252,34 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 
 		defer stream.Close()
 
+		// Accumulate the full message so tool_use blocks can be recovered at the end
+		var accumulated anthropic.Message
+
 		for stream.Next() {
 			delta := stream.Current()
+			if err := accumulated.Accumulate(delta); err != nil {
+				log.Printf("Error accumulating Anthropic stream event: %v", err)
+			}
+
+			if len(delta.Delta.Thinking) > 0 {
+				if err := callback(ctx, ChatCompletionChunk{Reasoning: delta.Delta.Thinking}); err != nil {
+					return fmt.Errorf("callback error processing stream chunk: %w", err)
+				}
+				continue
+			}
+
+			// Stream the structured output tool's input as the response content
+			if structuredToolName != "" {
+				if n := len(accumulated.Content); n > 0 && delta.Delta.PartialJSON != "" &&
+					accumulated.Content[n-1].Type == "tool_use" && accumulated.Content[n-1].Name == structuredToolName {
+					if err := callback(ctx, ChatCompletionChunk{Content: delta.Delta.PartialJSON}); err != nil {
+						return fmt.Errorf("callback error processing stream chunk: %w", err)
+					}
+				}
+				if !thinking {
+					continue // Any plain text is preamble, not part of the JSON answer
+				}
+			}
 
 			if len(delta.Delta.Text) > 0 {
 				chunk := ChatCompletionChunk{
@@ -180,9 +303,13 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 
 		// Signal end of stream
 		if callback != nil {
+			_, signature := anthropicThinking(accumulated.Content)
 			finalChunk := ChatCompletionChunk{
-				Content: "",
-				IsFinal: true,
+				Content:            "",
+				IsFinal:            true,
+				ToolCalls:          anthropicToolCalls(accumulated.Content, structuredToolName),
+				ReasoningSignature: signature,
+				Usage:              anthropicUsage(accumulated.Usage),
 			}
 			if err := callback(ctx, finalChunk); err != nil {
 				return fmt.Errorf("callback error processing final chunk: %w", err)
@@ -203,17 +330,29 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 		if len(resp.Content) > 0 {
 			// Get text from the first text block
 			for _, block := range resp.Content {
-				if block.Type == "text" {
-					content = block.Text
+				if structuredToolName != "" && block.Type == "tool_use" && block.Name == structuredToolName {
+					content = string(block.Input)
 					break
 				}
+				// Plain text is only the answer when the structured tool is not forced
+				if block.Type == "text" && (structuredToolName == "" || thinking) && content == "" {
+					content = block.Text
+					if structuredToolName == "" {
+						break
+					}
+				}
 			}
 		}
 
 		if callback != nil {
+			reasoning, signature := anthropicThinking(resp.Content)
 			chunk := ChatCompletionChunk{
-				Content: content,
-				IsFinal: true,
+				Content:            content,
+				IsFinal:            true,
+				ToolCalls:          anthropicToolCalls(resp.Content, structuredToolName),
+				Reasoning:          reasoning,
+				ReasoningSignature: signature,
+				Usage:              anthropicUsage(resp.Usage),
 			}
 			if err := callback(ctx, chunk); err != nil {
 				return fmt.Errorf("callback error processing non-streamed response: %w", err)
@@ -223,3 +362,63 @@ func (c *AnthropicConnector) GenerateChatCompletion(ctx context.Context, req Cha
 		return nil
 	}
 }
+
+// anthropicThinking joins the thinking blocks of a response and returns the
+// signature of the last one.
+func anthropicThinking(blocks []anthropic.ContentBlockUnion) (reasoning, signature string) {
+	for _, block := range blocks {
+		if block.Type != "thinking" {
+			continue
+		}
+		reasoning = joinReasoning(reasoning, block.Thinking)
+		signature = block.Signature
+	}
+	return reasoning, signature
+}
+
+// anthropicTool converts a tool definition to Anthropic's format.
+func anthropicTool(tool ToolDefinition) anthropic.ToolUnionParam {
+	schema := anthropic.ToolInputSchemaParam{ExtraFields: map[string]interface{}{}}
+	for k, v := range tool.InputSchema {
+		switch k {
+		case "type":
+			// Always "object"; set by the SDK
+		case "properties":
+			schema.Properties = v
+		default:
+			schema.ExtraFields[k] = v
+		}
+	}
+	toolParam := anthropic.ToolUnionParamOfTool(schema, tool.Name)
+	if tool.Description != "" {
+		toolParam.OfTool.Description = anthropic.String(tool.Description)
+	}
+	return toolParam
+}
+
+// anthropicToolCalls extracts tool_use blocks from a response, skipping the
+// structured output tool (if any), whose input is the answer itself.
+func anthropicToolCalls(blocks []anthropic.ContentBlockUnion, structuredToolName string) []ToolCall {
+	var calls []ToolCall
+	for _, block := range blocks {
+		if block.Type != "tool_use" || (structuredToolName != "" && block.Name == structuredToolName) {
+			continue
+		}
+		args := string(block.Input)
+		if strings.TrimSpace(args) == "" {
+			args = "{}"
+		}
+		calls = append(calls, ToolCall{ID: block.ID, Name: block.Name, Arguments: args})
+	}
+	return calls
+}
+
+// anthropicUsage converts Anthropic token counts, returning nil if none were
+// reported. Cached input tokens count as prompt tokens.
+func anthropicUsage(usage anthropic.Usage) *TokenUsage {
+	prompt := usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+	if prompt == 0 && usage.OutputTokens == 0 {
+		return nil
+	}
+	return &TokenUsage{PromptTokens: int(prompt), CompletionTokens: int(usage.OutputTokens)}
+}
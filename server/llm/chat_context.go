@@ -4,16 +4,41 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/ramborogers/cyberai/server/models"
 )
 
+// maxHistoryMessages caps how many stored messages are considered when
+// filling the context, however large the model's window is.
+const maxHistoryMessages = 1000
+
 // ChatContextService handles the building of context for LLM requests
 type ChatContextService struct {
-	chatService  *models.ChatService
-	modelService *models.ModelService
-	agentService *models.AgentService
-	defaultLimit int // Maximum number of messages to include in context
+	chatService    *models.ChatService
+	modelService   *models.ModelService
+	agentService   *models.AgentService
+	projectService *models.ProjectService
+	profileService *models.ProfileService
+	estimator      TokenEstimator // Estimates message sizes against the model's context window
+}
+
+// ContextReport describes how the context for a request was assembled and
+// what, if anything, had to be left out to fit the model's context window.
+type ContextReport struct {
+	ContextWindow      int  `json:"context_window"`      // Model context size (tokens)
+	ReservedOutput     int  `json:"reserved_output"`     // Tokens kept free for the response
+	Budget             int  `json:"budget"`              // Tokens available for the prompt
+	EstimatedTokens    int  `json:"estimated_tokens"`    // Estimated size of the assembled prompt
+	IncludedMessages   int  `json:"included_messages"`   // Conversation messages sent (excluding the system prompt)
+	SummarizedMessages int  `json:"summarized_messages"` // Older messages replaced by the chat's rolling summary
+	DroppedMessages    int  `json:"dropped_messages"`    // Older history messages left out
+	OverBudget         bool `json:"over_budget"`         // System prompt and latest user turn alone exceed the budget
+}
+
+// Truncated reports whether the context is missing part of the conversation.
+func (r *ContextReport) Truncated() bool {
+	return r.DroppedMessages > 0 || r.OverBudget
 }
 
 // NewChatContextService creates a new ChatContextService
@@ -21,105 +46,283 @@ func NewChatContextService(
 	chatService *models.ChatService,
 	modelService *models.ModelService,
 	agentService *models.AgentService,
+	projectService *models.ProjectService,
+	profileService *models.ProfileService,
 ) *ChatContextService {
 	return &ChatContextService{
-		chatService:  chatService,
-		modelService: modelService,
-		agentService: agentService,
-		defaultLimit: 20, // Default context window size
+		chatService:    chatService,
+		modelService:   modelService,
+		agentService:   agentService,
+		projectService: projectService,
+		profileService: profileService,
+		estimator:      HeuristicTokenEstimator{},
 	}
 }
 
 // BuildContextForModelRequest retrieves chat history and formats it for LLM API request
 // It creates a properly structured message array with:
-// 1. System prompts (from model or agent)
+// 1. One system message composing, in order: the model's default system
+// prompt, the agent's system prompt, the custom instructions and memory of
+// the user being answered (see models.ProfileService.GetChatPersonalization),
+// the instructions and files of the chat's project, and the chat's rolling
+// summary
 // 2. Previous conversation messages in chronological order
 // 3. The newest user message
+//
+// The system prompt and the newest user turn are always included. Older
+// messages are added newest first for as long as they fit in the model's
+// context window minus the tokens reserved for the response; the returned
+// report says what was left out.
 func (s *ChatContextService) BuildContextForModelRequest(
 	ctx context.Context,
 	chatID int64,
 	modelID int64,
 	newMessageContent string,
 	agentID *int64,
-) ([]Message, error) {
+) ([]Message, *ContextReport, error) {
+	return s.buildContext(ctx, chatID, 0, modelID, newMessageContent, agentID, 0)
+}
+
+// BuildContextForBranch builds the context like BuildContextForModelRequest,
+// from the branch ending at leafID instead of the active one, keeping room
+// for a response of up to maxTokens (the model's max tokens if 0). Responses
+// generated side by side use it so that each is built from the same point
+// while the others are being added to the chat.
+func (s *ChatContextService) BuildContextForBranch(
+	ctx context.Context,
+	chatID int64,
+	leafID int64,
+	modelID int64,
+	agentID *int64,
+	maxTokens int,
+) ([]Message, *ContextReport, error) {
+	return s.buildContext(ctx, chatID, leafID, modelID, "", agentID, maxTokens)
+}
+
+// buildContext builds the context from the branch ending at leafID (the
+// active branch if 0), keeping room for a response of up to maxTokens (the
+// model's max tokens if 0).
+func (s *ChatContextService) buildContext(
+	ctx context.Context,
+	chatID int64,
+	leafID int64,
+	modelID int64,
+	newMessageContent string,
+	agentID *int64,
+	maxTokens int,
+) ([]Message, *ContextReport, error) {
 	// 1. First get the model details to fetch system prompt and other settings
 	model, err := s.modelService.GetModelByID(modelID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get model details: %w", err)
+		return nil, nil, fmt.Errorf("failed to get model details: %w", err)
 	}
 	if model == nil {
-		return nil, fmt.Errorf("model with ID %d not found", modelID)
+		return nil, nil, fmt.Errorf("model with ID %d not found", modelID)
+	}
+
+	if maxTokens <= 0 {
+		maxTokens = model.MaxTokens
 	}
+	report := &ContextReport{ContextWindow: ContextWindowForModel(model)}
+	report.ReservedOutput = ReservedOutputTokens(model, report.ContextWindow, maxTokens)
+	report.Budget = report.ContextWindow - report.ReservedOutput
 
-	// 2. Get message history with the specified limit
-	log.Printf("[BuildContext] Attempting to fetch history for ChatID: %d (Limit: %d)", chatID, s.defaultLimit)
-	messages, err := s.chatService.GetMessageHistory(chatID, s.defaultLimit)
+	// 2. Get message history; the token budget decides how much of it is used
+	log.Printf("[BuildContext] Attempting to fetch history for ChatID: %d (Budget: %d tokens)", chatID, report.Budget)
+	messages, err := s.chatService.GetBranchHistory(chatID, leafID, maxHistoryMessages)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve chat history: %w", err)
+		return nil, nil, fmt.Errorf("failed to retrieve chat history: %w", err)
 	}
 
 	log.Printf("[Chat %d] Retrieved %d messages for context", chatID, len(messages))
 
-	// 3. Create the messages array for the LLM
-	llmMessages := make([]Message, 0, len(messages)+2) // +2 for system message and new user message
+	summary, err := s.chatService.GetBranchSummary(chatID, leafID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve chat summary: %w", err)
+	}
 
-	// 4. Add system prompt if available from model
+	// 3. Compose the system prompt from its parts, in this order:
+	//   a. the model's default system prompt
+	//   b. the agent's system prompt
+	//   c. the custom instructions of the user being answered
+	//   d. their memory, unless the chat has participants
+	//   e. the instructions and files of the chat's project
+	//   f. the chat's rolling summary
+	// Each part is added after the ones before it; none replaces another.
+	var systemParts []string
 	if model.DefaultSystemPrompt != "" {
-		llmMessages = append(llmMessages, Message{
-			Role:    "system",
-			Content: model.DefaultSystemPrompt,
-		})
+		systemParts = append(systemParts, model.DefaultSystemPrompt)
 		log.Printf("[Chat %d] Added model system prompt to context", chatID)
 	}
 
-	// 5. Add agent system prompt if agent ID is provided
 	if agentID != nil && *agentID > 0 {
 		agent, err := s.agentService.GetAgent(*agentID)
 		if err == nil && agent != nil && agent.SystemPrompt != "" {
-			// If both model and agent prompts exist, agent takes precedence
-			if len(llmMessages) > 0 && llmMessages[0].Role == "system" {
-				llmMessages[0].Content = agent.SystemPrompt
-				log.Printf("[Chat %d] Replaced with agent system prompt", chatID)
-			} else {
-				llmMessages = append(llmMessages, Message{
-					Role:    "system",
-					Content: agent.SystemPrompt,
-				})
-				log.Printf("[Chat %d] Added agent system prompt to context", chatID)
+			systemParts = append(systemParts, agent.SystemPrompt)
+			log.Printf("[Chat %d] Added agent system prompt to context", chatID)
+		}
+	}
+
+	// 4. The personalisation of the author of the message being answered: the
+	// last user message of the branch (the chat's owner for a new message)
+	var authorID int64
+	if newMessageContent == "" {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if messages[i].Role == "user" {
+				authorID = messages[i].UserID
+				break
 			}
 		}
 	}
+	instructions, memories, err := s.profileService.GetChatPersonalization(chatID, authorID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve user personalization: %w", err)
+	}
+	if instructions != "" {
+		systemParts = append(systemParts, customInstructionsPrefix+instructions)
+		log.Printf("[Chat %d] Added custom instructions to context", chatID)
+	}
+	if len(memories) > 0 {
+		systemParts = append(systemParts, memoryContext(memories))
+		log.Printf("[Chat %d] Added %d memories to context", chatID, len(memories))
+	}
 
-	// 6. Add previous messages from history
-	for _, msg := range messages {
+	// 5. Chats in a project also follow its instructions and see its files
+	project, err := s.projectService.GetChatProject(chatID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to retrieve chat project: %w", err)
+	}
+	if project != nil {
+		systemParts = append(systemParts, projectContext(project))
+		log.Printf("[Chat %d] Added project %d (%d files) to context", chatID, project.ID, len(project.Files))
+	}
+
+	// 6. The rolling summary stands in for the messages it covers
+	if summary != nil && summary.Content != "" {
+		systemParts = append(systemParts, summaryContextPrefix+summary.Content)
+		log.Printf("[Chat %d] Added chat summary (through message %d) to context", chatID, summary.ThroughMessageID)
+	}
+
+	var systemMessages []Message
+	if len(systemParts) > 0 {
+		systemMessages = []Message{{Role: "system", Content: strings.Join(systemParts, "\n\n")}}
+	}
+
+	// 7. Convert the history. Only the content is sent; stored reasoning is
+	// deliberately left out of the context.
+	history := make([]Message, 0, len(messages)+1)
+	summarized := summarizedCount(messages, summary)
+	for i, msg := range messages {
+		if i < summarized {
+			report.SummarizedMessages++
+			continue
+		}
 		// Skip system messages in history if we already added a system message
-		if msg.Role == "system" && len(llmMessages) > 0 && llmMessages[0].Role == "system" {
+		if msg.Role == "system" && len(systemMessages) > 0 {
 			continue
 		}
 
-		llmMessages = append(llmMessages, Message{
+		history = append(history, Message{
 			Role:    msg.Role,
 			Content: msg.Content,
 		})
 	}
 
-	// 7. Add the new user message
+	// 8. Split off the latest user turn, which is always kept: the new
+	// message, or else the last user message in history and anything after it
+	var latestTurn []Message
 	if newMessageContent != "" {
-		llmMessages = append(llmMessages, Message{
-			Role:    "user",
-			Content: newMessageContent,
-		})
+		latestTurn = []Message{{Role: "user", Content: newMessageContent}}
+	} else {
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Role == "user" {
+				latestTurn = history[i:]
+				history = history[:i]
+				break
+			}
+		}
+	}
+
+	// 9. Fill the remaining budget with history, newest first
+	used := 0
+	for _, msg := range systemMessages {
+		used += estimateMessageTokens(s.estimator, msg)
+	}
+	for _, msg := range latestTurn {
+		used += estimateMessageTokens(s.estimator, msg)
 	}
+	report.OverBudget = used > report.Budget
 
-	log.Printf("[Chat %d] Built context with %d messages for LLM request", chatID, len(llmMessages))
+	start := len(history)
+	for start > 0 {
+		tokens := estimateMessageTokens(s.estimator, history[start-1])
+		if used+tokens > report.Budget {
+			break
+		}
+		used += tokens
+		start--
+	}
+	// Providers expect the conversation to open with a user turn
+	for start > 0 && start < len(history) && history[start].Role != "user" {
+		used -= estimateMessageTokens(s.estimator, history[start])
+		start++
+	}
+	report.DroppedMessages = start
+	report.IncludedMessages = len(history) - start + len(latestTurn)
+	report.EstimatedTokens = used
+
+	llmMessages := make([]Message, 0, len(systemMessages)+len(history)-start+len(latestTurn))
+	llmMessages = append(llmMessages, systemMessages...)
+	llmMessages = append(llmMessages, history[start:]...)
+	llmMessages = append(llmMessages, latestTurn...)
+
+	if report.Truncated() {
+		log.Printf("[Chat %d] Context truncated to fit %d of %d tokens: dropped %d older messages (over budget: %v)",
+			chatID, report.EstimatedTokens, report.Budget, report.DroppedMessages, report.OverBudget)
+	}
+	log.Printf("[Chat %d] Built context with %d messages (~%d tokens) for LLM request", chatID, len(llmMessages), report.EstimatedTokens)
+
+	return llmMessages, report, nil
+}
 
-	return llmMessages, nil
+// customInstructionsPrefix introduces the custom instructions of the user
+// being answered
+const customInstructionsPrefix = "Custom instructions from the user:\n"
+
+// memoryContext lists what the user being answered asked the models to
+// remember
+func memoryContext(memories []models.UserMemory) string {
+	var b strings.Builder
+	b.WriteString("What the user asked you to remember about them:")
+	for _, m := range memories {
+		b.WriteString("\n- ")
+		b.WriteString(m.Content)
+	}
+	return b.String()
+}
+
+// projectContext is the text added to the system prompt of chats in the
+// project: its instructions, then each of its reference files
+func projectContext(project *models.Project) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This conversation is part of the project %q.", project.Name)
+	if project.Instructions != "" {
+		b.WriteString(" Project instructions:\n\n")
+		b.WriteString(project.Instructions)
+	}
+	if len(project.Files) > 0 {
+		b.WriteString("\n\nReference files of the project:")
+		for _, f := range project.Files {
+			fmt.Fprintf(&b, "\n\n<file name=%q>\n%s\n</file>", f.Name, f.Content)
+		}
+	}
+	return b.String()
 }
 
-// SetContextWindowSize changes the maximum number of messages included in context
-func (s *ChatContextService) SetContextWindowSize(limit int) {
-	if limit > 0 {
-		s.defaultLimit = limit
-		log.Printf("Chat context window size set to %d messages", limit)
+// SetTokenEstimator replaces the estimator used to fit messages into the
+// model's context window (e.g. with a real tokenizer).
+func (s *ChatContextService) SetTokenEstimator(estimator TokenEstimator) {
+	if estimator != nil {
+		s.estimator = estimator
 	}
 }
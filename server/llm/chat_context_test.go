@@ -0,0 +1,284 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ramborogers/cyberai/server/db"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// contextTest builds contexts from chats in a new database
+type contextTest struct {
+	svc      *ChatContextService
+	chats    *models.ChatService
+	profiles *models.ProfileService
+	users    *models.UserService
+	models   *models.ModelService
+	provider *models.Provider
+	created  int // Models created so far, to name the next one
+}
+
+func newContextTest(t *testing.T) *contextTest {
+	t.Helper()
+	database, err := db.New(filepath.Join(t.TempDir(), "cyberai.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+
+	ct := &contextTest{
+		chats:    models.NewChatService(database, nil),
+		profiles: models.NewProfileService(database),
+		users:    models.NewUserService(database),
+		models:   models.NewModelService(database),
+		provider: &models.Provider{Name: "OpenAI", Type: models.ProviderOpenAI},
+	}
+	if err := models.NewProviderService(database).CreateProvider(ct.provider); err != nil {
+		t.Fatalf("CreateProvider: %v", err)
+	}
+	ct.svc = NewChatContextService(ct.chats, ct.models, models.NewAgentService(database), models.NewProjectService(database), ct.profiles)
+	return ct
+}
+
+// createModel creates an active model with the configuration
+func (ct *contextTest) createModel(t *testing.T, maxTokens int, config models.Configuration) *models.Model {
+	t.Helper()
+	ct.created++
+	model := &models.Model{ProviderID: ct.provider.ID, Name: fmt.Sprintf("GPT %d", ct.created), ModelID: fmt.Sprintf("gpt-%d", ct.created),
+		MaxTokens: maxTokens, IsActive: true, Configuration: config}
+	if err := ct.models.CreateModel(model); err != nil {
+		t.Fatalf("CreateModel: %v", err)
+	}
+	return model
+}
+
+// createUser creates an active user
+func (ct *contextTest) createUser(t *testing.T, username string) *models.User {
+	t.Helper()
+	user := &models.User{Username: username, Email: username + "@example.com", RoleID: models.UserRoleID, IsActive: true}
+	if err := ct.users.CreateUser(user, "password1"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return user
+}
+
+// personalize sets the user's custom instructions and one memory
+func (ct *contextTest) personalize(t *testing.T, user *models.User) {
+	t.Helper()
+	if _, err := ct.profiles.SetCustomInstructions(user.ID, "Instructions of "+user.Username); err != nil {
+		t.Fatalf("SetCustomInstructions: %v", err)
+	}
+	if err := ct.profiles.AddMemory(&models.UserMemory{UserID: user.ID, Content: "Memory of " + user.Username}); err != nil {
+		t.Fatalf("AddMemory: %v", err)
+	}
+}
+
+// addMessage continues the active branch of the chat, returning the message ID
+func (ct *contextTest) addMessage(t *testing.T, chatID, userID int64, role, content string) int64 {
+	t.Helper()
+	msg := &models.Message{ChatID: chatID, UserID: userID, Role: role, Content: content}
+	if err := ct.chats.AddMessage(msg); err != nil {
+		t.Fatalf("AddMessage: %v", err)
+	}
+	return msg.ID
+}
+
+// systemPrompt returns the content of the context's system message, if any
+func systemPrompt(messages []Message) string {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		return messages[0].Content
+	}
+	return ""
+}
+
+func TestBuildContextPersonalization(t *testing.T) {
+	ct := newContextTest(t)
+	model := ct.createModel(t, 1000, nil)
+	owner := ct.createUser(t, "olivia")
+	guest := ct.createUser(t, "gabriel")
+	ct.personalize(t, owner)
+	ct.personalize(t, guest)
+
+	private, err := ct.chats.CreateChat(owner.ID, "Private", "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	privateLeaf := ct.addMessage(t, private.ID, owner.ID, "user", "Hello")
+
+	shared, err := ct.chats.CreateChat(owner.ID, "Shared", "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	if _, err := ct.chats.AddChatParticipant(shared.ID, guest.Username, models.ChatRoleContributor, owner.ID); err != nil {
+		t.Fatalf("AddChatParticipant: %v", err)
+	}
+	ownerLeaf := ct.addMessage(t, shared.ID, owner.ID, "user", "Hello")
+	ct.addMessage(t, shared.ID, 0, "assistant", "Hi")
+	guestLeaf := ct.addMessage(t, shared.ID, guest.ID, "user", "Hello from the guest")
+
+	tests := []struct {
+		name   string
+		chatID int64
+		leafID int64
+		want   []string // Parts of the system prompt
+		absent []string
+	}{
+		{
+			name:   "owner of a private chat",
+			chatID: private.ID,
+			leafID: privateLeaf,
+			want:   []string{"Instructions of olivia", "Memory of olivia"},
+			absent: []string{"gabriel"},
+		},
+		{
+			name:   "owner of a shared chat",
+			chatID: shared.ID,
+			leafID: ownerLeaf,
+			want:   []string{"Instructions of olivia"},
+			absent: []string{"Memory of", "gabriel"},
+		},
+		{
+			name:   "participant of a shared chat",
+			chatID: shared.ID,
+			leafID: guestLeaf,
+			want:   []string{"Instructions of gabriel"},
+			absent: []string{"Memory of", "olivia"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages, _, err := ct.svc.BuildContextForBranch(context.Background(), tt.chatID, tt.leafID, model.ID, nil, 0)
+			if err != nil {
+				t.Fatalf("BuildContextForBranch: %v", err)
+			}
+			prompt := systemPrompt(messages)
+			for _, part := range tt.want {
+				if !strings.Contains(prompt, part) {
+					t.Errorf("system prompt %q is missing %q", prompt, part)
+				}
+			}
+			for _, part := range tt.absent {
+				if strings.Contains(prompt, part) {
+					t.Errorf("system prompt %q contains %q", prompt, part)
+				}
+			}
+		})
+	}
+}
+
+// byteEstimator counts every byte as a token, so each message of the tests
+// costs its length plus messageTokenOverhead
+type byteEstimator struct{}
+
+func (byteEstimator) EstimateTokens(text string) int { return len(text) }
+
+func TestBuildContextBudget(t *testing.T) {
+	ct := newContextTest(t)
+	ct.svc.SetTokenEstimator(byteEstimator{})
+	user := ct.createUser(t, "uma")
+	chat, err := ct.chats.CreateChat(user.ID, "Budget", "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	// Five messages of 6 tokens each
+	ct.addMessage(t, chat.ID, user.ID, "user", "u1")
+	ct.addMessage(t, chat.ID, 0, "assistant", "a1")
+	ct.addMessage(t, chat.ID, user.ID, "user", "u2")
+	ct.addMessage(t, chat.ID, 0, "assistant", "a2")
+	leaf := ct.addMessage(t, chat.ID, user.ID, "user", "u3")
+
+	tests := []struct {
+		name        string
+		modelMax    int // The model's max tokens
+		config      models.Configuration
+		maxTokens   int    // Max tokens of the request; the model's if 0
+		newMessage  string // Answered instead of the branch's last message, if set
+		wantContent []string
+		wantReport  ContextReport
+	}{
+		{
+			name:        "everything fits",
+			modelMax:    100,
+			config:      models.Configuration{"context_window": 1000},
+			wantContent: []string{"u1", "a1", "u2", "a2", "u3"},
+			wantReport:  ContextReport{ContextWindow: 1000, ReservedOutput: 100, Budget: 900, EstimatedTokens: 30, IncludedMessages: 5},
+		},
+		{
+			name:        "max tokens capped at half the window",
+			modelMax:    1000,
+			config:      models.Configuration{"context_window": 60},
+			wantContent: []string{"u1", "a1", "u2", "a2", "u3"},
+			wantReport:  ContextReport{ContextWindow: 60, ReservedOutput: 30, Budget: 30, EstimatedTokens: 30, IncludedMessages: 5},
+		},
+		{
+			name:        "max tokens of the request",
+			modelMax:    1000,
+			config:      models.Configuration{"context_window": 60},
+			maxTokens:   12,
+			wantContent: []string{"u1", "a1", "u2", "a2", "u3"},
+			wantReport:  ContextReport{ContextWindow: 60, ReservedOutput: 12, Budget: 48, EstimatedTokens: 30, IncludedMessages: 5},
+		},
+		{
+			name:        "newest history first",
+			modelMax:    20,
+			config:      models.Configuration{"context_window": 40},
+			wantContent: []string{"u2", "a2", "u3"},
+			wantReport:  ContextReport{ContextWindow: 40, ReservedOutput: 20, Budget: 20, EstimatedTokens: 18, IncludedMessages: 3, DroppedMessages: 2},
+		},
+		{
+			name:        "leading assistant reply trimmed",
+			modelMax:    14,
+			config:      models.Configuration{"context_window": 28},
+			wantContent: []string{"u3"},
+			wantReport:  ContextReport{ContextWindow: 28, ReservedOutput: 14, Budget: 14, EstimatedTokens: 6, IncludedMessages: 1, DroppedMessages: 4},
+		},
+		{
+			name:        "latest turn over budget",
+			modelMax:    100,
+			config:      models.Configuration{"context_window": 8, "reserved_output_tokens": 4},
+			wantContent: []string{"u3"},
+			wantReport:  ContextReport{ContextWindow: 8, ReservedOutput: 4, Budget: 4, EstimatedTokens: 6, IncludedMessages: 1, DroppedMessages: 4, OverBudget: true},
+		},
+		{
+			name:        "new message",
+			modelMax:    20,
+			config:      models.Configuration{"context_window": 47},
+			newMessage:  "new message",
+			wantContent: []string{"u3", "new message"},
+			wantReport:  ContextReport{ContextWindow: 47, ReservedOutput: 20, Budget: 27, EstimatedTokens: 21, IncludedMessages: 2, DroppedMessages: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := ct.createModel(t, tt.modelMax, tt.config)
+			var messages []Message
+			var report *ContextReport
+			var err error
+			if tt.newMessage != "" {
+				messages, report, err = ct.svc.BuildContextForModelRequest(context.Background(), chat.ID, model.ID, tt.newMessage, nil)
+			} else {
+				messages, report, err = ct.svc.BuildContextForBranch(context.Background(), chat.ID, leaf, model.ID, nil, tt.maxTokens)
+			}
+			if err != nil {
+				t.Fatalf("building the context: %v", err)
+			}
+			var content []string
+			for _, msg := range messages {
+				content = append(content, msg.Content)
+			}
+			if strings.Join(content, ",") != strings.Join(tt.wantContent, ",") {
+				t.Errorf("messages = %q, want %q", content, tt.wantContent)
+			}
+			if *report != tt.wantReport {
+				t.Errorf("report = %+v\nwant %+v", *report, tt.wantReport)
+			}
+		})
+	}
+}
@@ -15,27 +15,121 @@ type ConnectorService struct {
 	modelService       *models.ModelService
 	providerService    *models.ProviderService
 	chatContextService *ChatContextService
+	agentService       *models.AgentService
+	toolManager        *MCPToolManager // Optional: nil disables MCP tools
+	summarizing        sync.Map        // Chat IDs with a summary update in progress
+	titling            sync.Map        // Chat IDs with a title being generated
 	// TODO: Potentially add caching for connectors if instantiation is expensive
 	mu sync.Mutex // To protect concurrent access if caching is added
 }
 
 // NewConnectorService creates a new ConnectorService.
-func NewConnectorService(ms *models.ModelService, ps *models.ProviderService, chatSvc *models.ChatService, agentSvc *models.AgentService) *ConnectorService {
+func NewConnectorService(ms *models.ModelService, ps *models.ProviderService, chatSvc *models.ChatService, agentSvc *models.AgentService, projectSvc *models.ProjectService, profileSvc *models.ProfileService, toolManager *MCPToolManager) *ConnectorService {
 	if ms == nil || ps == nil {
 		// This should not happen if initialization is done correctly in main.go
 		log.Fatal("ConnectorService requires non-nil ModelService and ProviderService")
 	}
 
 	// Create the embedded ChatContextService
-	chatContextSvc := NewChatContextService(chatSvc, ms, agentSvc)
+	chatContextSvc := NewChatContextService(chatSvc, ms, agentSvc, projectSvc, profileSvc)
 
 	return &ConnectorService{
 		modelService:       ms,
 		providerService:    ps,
 		chatContextService: chatContextSvc,
+		agentService:       agentSvc,
+		toolManager:        toolManager,
 	}
 }
 
+// GetToolManager returns the MCP tool manager (may be nil)
+func (s *ConnectorService) GetToolManager() *MCPToolManager {
+	return s.toolManager
+}
+
+// CanUseAgent reports whether the user can use the agent (nil for none)
+func (s *ConnectorService) CanUseAgent(userID int64, agentID *int64) (bool, error) {
+	if agentID == nil || *agentID <= 0 {
+		return true, nil
+	}
+	return s.agentService.CanUseAgent(userID, *agentID)
+}
+
+// PrepareTools resolves the MCP tools available to an agent and returns their
+// definitions together with an executor that logs invocations to the chat.
+// It returns nil values when no agent is given or the agent has no tools.
+func (s *ConnectorService) PrepareTools(agentID *int64, chatID, userID int64) ([]ToolDefinition, *ChatToolExecutor, error) {
+	if s.toolManager == nil || agentID == nil || *agentID <= 0 {
+		return nil, nil, nil
+	}
+
+	agent, err := s.agentService.GetAgent(*agentID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load agent %d for tools: %w", *agentID, err)
+	}
+
+	defs, bindings, err := s.toolManager.ToolsForAgent(agent)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve tools for agent %d: %w", *agentID, err)
+	}
+	if len(defs) == 0 {
+		return nil, nil, nil
+	}
+
+	log.Printf("[Chat %d] Agent %d has %d MCP tools available", chatID, *agentID, len(defs))
+	return defs, s.toolManager.NewChatToolExecutor(bindings, chatID, userID, agentID), nil
+}
+
+// AgentResponseFormat returns the response format configured on the agent
+// ("response_format" in its configuration), or nil if it has none.
+func (s *ConnectorService) AgentResponseFormat(agentID *int64) (*ResponseFormat, error) {
+	if agentID == nil || *agentID <= 0 {
+		return nil, nil
+	}
+
+	agent, err := s.agentService.GetAgent(*agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load agent %d for response format: %w", *agentID, err)
+	}
+
+	format, err := ResponseFormatFromConfig(agent.Configuration)
+	if err != nil {
+		return nil, fmt.Errorf("agent %d has an invalid response_format: %w", *agentID, err)
+	}
+	if !format.IsJSON() {
+		return nil, nil
+	}
+	return format, nil
+}
+
+// ResolveSamplingParams combines the sampling parameters configured on the
+// model, those on the agent (if any) and the request's override, later ones
+// taking precedence. Invalid configurations are logged and skipped.
+func (s *ConnectorService) ResolveSamplingParams(model *models.Model, agentID *int64, override *SamplingParams) SamplingParams {
+	params, err := SamplingParamsFromConfig(model.Configuration)
+	if err != nil {
+		log.Printf("Ignoring sampling parameters of model %d: %v", model.ID, err)
+		params = SamplingParams{}
+	}
+	params.MaxTokens = nil // The model's own max tokens applies
+
+	if agentID != nil && *agentID > 0 {
+		agent, err := s.agentService.GetAgent(*agentID)
+		if err != nil {
+			log.Printf("Failed to load agent %d for sampling parameters: %v", *agentID, err)
+		} else if agentParams, err := SamplingParamsFromConfig(agent.Configuration); err != nil {
+			log.Printf("Ignoring sampling parameters of agent %d: %v", *agentID, err)
+		} else {
+			params = params.Merge(agentParams)
+		}
+	}
+
+	if override != nil {
+		params = params.Merge(*override)
+	}
+	return params
+}
+
 // GetChatContextService returns the embedded ChatContextService
 func (s *ConnectorService) GetChatContextService() *ChatContextService {
 	return s.chatContextService
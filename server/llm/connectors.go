@@ -9,19 +9,52 @@ import (
 // Message represents a single message in a conversation, suitable for API requests.
 // We might use models.Message directly or adapt it if provider APIs differ significantly.
 type Message struct {
-	Role    string `json:"role"` // e.g., "system", "user", "assistant"
+	Role    string `json:"role"` // e.g., "system", "user", "assistant", "tool"
 	Content string `json:"content"`
-	// Add fields for images, tools later if needed
+
+	// Tool calling: assistant messages may carry ToolCalls; "tool" messages
+	// carry the result of the call identified by ToolCallID.
+	ToolCalls  []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+	Name       string     `json:"name,omitempty"` // Tool name for "tool" messages
+
+	// Reasoning produced alongside an assistant message. It is only sent back
+	// to providers that require it within a tool-calling turn (Anthropic,
+	// identified by ReasoningSignature); stored history never includes it.
+	Reasoning          string `json:"reasoning,omitempty"`
+	ReasoningSignature string `json:"reasoning_signature,omitempty"`
+}
+
+// ToolDefinition describes a tool the model may call.
+type ToolDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema"` // JSON Schema for the arguments object
+}
+
+// ToolCall is a request from the model to invoke a tool.
+type ToolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"` // JSON-encoded arguments object
 }
 
 // ChatCompletionRequest encapsulates the data needed for a chat completion.
 type ChatCompletionRequest struct {
-	Model       string    `json:"model"`    // The provider-specific model ID (e.g., "llama3", "gpt-4o")
-	Messages    []Message `json:"messages"` // Conversation history
-	Temperature float64   `json:"temperature,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"` // Provider might have different ways to limit
-	Stream      bool      `json:"stream"`               // Whether to stream the response
-	// Add other common parameters like top_p, presence_penalty etc. if needed
+	Model       string           `json:"model"`    // The provider-specific model ID (e.g., "llama3", "gpt-4o")
+	Messages    []Message        `json:"messages"` // Conversation history
+	Temperature float64          `json:"temperature,omitempty"`
+	MaxTokens   int              `json:"max_tokens,omitempty"` // Provider might have different ways to limit
+	Stream      bool             `json:"stream"`               // Whether to stream the response
+	Tools       []ToolDefinition `json:"tools,omitempty"`      // Tools the model may call
+	// ResponseFormat optionally requests JSON output, optionally matching a schema
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	// ThinkingBudget enables extended thinking with this many tokens where the
+	// provider requires it to be requested (Anthropic budget, Ollama "think").
+	ThinkingBudget int `json:"thinking_budget,omitempty"`
+	// Sampling holds optional parameters such as top_p or stop sequences;
+	// connectors map what their provider supports and warn about the rest
+	Sampling SamplingParams `json:"sampling,omitempty"`
 
 	// Provider-specific options can be added here or handled internally by connectors
 	// Options map[string]interface{} `json:"options,omitempty"`
@@ -31,7 +64,31 @@ type ChatCompletionRequest struct {
 type ChatCompletionChunk struct {
 	Content string `json:"content"`
 	IsFinal bool   `json:"is_final,omitempty"` // Indicates the last chunk of the response
-	// Include other stream info if provided by API (e.g., token counts, finish reason)
+	// Reasoning carries the model's thinking, separate from the answer in Content
+	Reasoning string `json:"reasoning,omitempty"`
+	// ReasoningSignature is an opaque provider token that must accompany the
+	// reasoning if it is sent back (Anthropic); set on the final chunk
+	ReasoningSignature string `json:"reasoning_signature,omitempty"`
+	// ToolCalls is set (typically on the last chunk) when the model asks to call tools
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Usage is the provider's token count for the request, set on the final
+	// chunk when the provider reports one
+	Usage *TokenUsage `json:"usage,omitempty"`
+}
+
+// TokenUsage is the number of tokens a request used, as counted by the provider.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Add adds the counts of other (which may be nil) to u.
+func (u *TokenUsage) Add(other *TokenUsage) {
+	if other == nil {
+		return
+	}
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
 }
 
 // ChunkCallback is a function type that processes incoming stream chunks.
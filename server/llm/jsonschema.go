@@ -0,0 +1,290 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ValidateJSONSchema checks a decoded JSON value against a JSON Schema and
+// returns a list of human-readable violations (empty when valid).
+//
+// It supports the subset of JSON Schema that providers accept for structured
+// output: type, properties, required, additionalProperties, items, enum,
+// const, anyOf/oneOf/allOf, numeric and length bounds, pattern and local
+// "$ref"s into "$defs"/"definitions".
+func ValidateJSONSchema(value interface{}, schema map[string]interface{}) []string {
+	v := schemaValidator{root: schema}
+	v.validate(value, schema, "$")
+	return v.errors
+}
+
+type schemaValidator struct {
+	root   map[string]interface{}
+	errors []string
+	depth  int
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+}
+
+// resolve follows a local $ref such as "#/$defs/Item".
+func (v *schemaValidator) resolve(ref string) (map[string]interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	var node interface{} = v.root
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if part == "" {
+			continue
+		}
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		node = m[part]
+	}
+	m, ok := node.(map[string]interface{})
+	return m, ok
+}
+
+func (v *schemaValidator) validate(value interface{}, schema map[string]interface{}, path string) {
+	if schema == nil {
+		return
+	}
+	v.depth++
+	defer func() { v.depth-- }()
+	if v.depth > 64 {
+		v.fail(path, "schema nesting too deep")
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found {
+			v.fail(path, "unresolvable $ref %q", ref)
+			return
+		}
+		v.validate(value, target, path)
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(value, t) {
+		v.fail(path, "expected type %v, got %s", t, jsonTypeName(value))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(value, e) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value must be one of %v", enum)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(value, c) {
+		v.fail(path, "value must be %v", c)
+	}
+
+	if subs, ok := schema["allOf"].([]interface{}); ok {
+		for _, s := range subs {
+			if sm, ok := s.(map[string]interface{}); ok {
+				v.validate(value, sm, path)
+			}
+		}
+	}
+	if subs, ok := schema["anyOf"].([]interface{}); ok && v.countMatches(value, subs, path) == 0 {
+		v.fail(path, "value does not match any of the allowed schemas")
+	}
+	if subs, ok := schema["oneOf"].([]interface{}); ok {
+		if n := v.countMatches(value, subs, path); n != 1 {
+			v.fail(path, "value must match exactly one schema (matched %d)", n)
+		}
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(val, schema, path)
+	case []interface{}:
+		v.validateArray(val, schema, path)
+	case string:
+		n := float64(len([]rune(val)))
+		if min, ok := number(schema["minLength"]); ok && n < min {
+			v.fail(path, "string shorter than %v", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && n > max {
+			v.fail(path, "string longer than %v", max)
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			if re, err := regexp.Compile(p); err == nil && !re.MatchString(val) {
+				v.fail(path, "string does not match pattern %q", p)
+			}
+		}
+	case float64:
+		if min, ok := number(schema["minimum"]); ok && val < min {
+			v.fail(path, "value %v is less than minimum %v", val, min)
+		}
+		if max, ok := number(schema["maximum"]); ok && val > max {
+			v.fail(path, "value %v is greater than maximum %v", val, max)
+		}
+		if min, ok := number(schema["exclusiveMinimum"]); ok && val <= min {
+			v.fail(path, "value %v must be greater than %v", val, min)
+		}
+		if max, ok := number(schema["exclusiveMaximum"]); ok && val >= max {
+			v.fail(path, "value %v must be less than %v", val, max)
+		}
+	}
+}
+
+func (v *schemaValidator) countMatches(value interface{}, subs []interface{}, path string) int {
+	matches := 0
+	for _, s := range subs {
+		sm, ok := s.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		sub := schemaValidator{root: v.root, depth: v.depth}
+		sub.validate(value, sm, path)
+		if len(sub.errors) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+func (v *schemaValidator) validateObject(obj map[string]interface{}, schema map[string]interface{}, path string) {
+	props, _ := schema["properties"].(map[string]interface{})
+
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, present := obj[name]; !present {
+					v.fail(path, "missing required property %q", name)
+				}
+			}
+		}
+	}
+
+	// Iterate in a stable order so error messages are deterministic
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		childPath := path + "." + k
+		if ps, ok := props[k].(map[string]interface{}); ok {
+			v.validate(obj[k], ps, childPath)
+			continue
+		}
+		switch ap := schema["additionalProperties"].(type) {
+		case bool:
+			if !ap {
+				v.fail(path, "unexpected property %q", k)
+			}
+		case map[string]interface{}:
+			v.validate(obj[k], ap, childPath)
+		}
+	}
+}
+
+func (v *schemaValidator) validateArray(arr []interface{}, schema map[string]interface{}, path string) {
+	n := float64(len(arr))
+	if min, ok := number(schema["minItems"]); ok && n < min {
+		v.fail(path, "array has fewer than %v items", min)
+	}
+	if max, ok := number(schema["maxItems"]); ok && n > max {
+		v.fail(path, "array has more than %v items", max)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range arr {
+			v.validate(item, items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+func matchesType(value interface{}, t interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return matchesSingleType(value, tt)
+	case []interface{}:
+		for _, single := range tt {
+			if s, ok := single.(string); ok && matchesSingleType(value, s) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(value interface{}, t string) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
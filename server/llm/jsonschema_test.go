@@ -0,0 +1,178 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// decode parses a JSON test value or schema
+func decode(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid test JSON %s: %v", s, err)
+	}
+	return v
+}
+
+func TestValidateJSONSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		value  string
+		errors []string // Substrings of the violations, in order; none if valid
+	}{
+		// Types
+		{"string", `{"type": "string"}`, `"a"`, nil},
+		{"number for string", `{"type": "string"}`, `1`, []string{"$: expected type string, got number"}},
+		{"integer", `{"type": "integer"}`, `3`, nil},
+		{"fraction for integer", `{"type": "integer"}`, `3.5`, []string{"expected type integer, got number"}},
+		{"boolean", `{"type": "boolean"}`, `false`, nil},
+		{"null for boolean", `{"type": "boolean"}`, `null`, []string{"expected type boolean, got null"}},
+		{"array for object", `{"type": "object"}`, `[]`, []string{"expected type object, got array"}},
+		{"one of several types", `{"type": ["string", "null"]}`, `null`, nil},
+		{"none of several types", `{"type": ["string", "null"]}`, `{}`, []string{"expected type [string null], got object"}},
+		{"no type", `{}`, `{"anything": [1]}`, nil},
+
+		// Objects
+		{
+			name:   "required present",
+			schema: `{"type": "object", "required": ["id", "name"]}`,
+			value:  `{"id": 1, "name": "a"}`,
+		},
+		{
+			name:   "required missing",
+			schema: `{"type": "object", "required": ["id", "name"]}`,
+			value:  `{"id": 1}`,
+			errors: []string{`$: missing required property "name"`},
+		},
+		{
+			name:   "additional properties forbidden",
+			schema: `{"type": "object", "properties": {"id": {"type": "integer"}}, "additionalProperties": false}`,
+			value:  `{"id": 1, "extra": true, "more": 2}`,
+			errors: []string{`unexpected property "extra"`, `unexpected property "more"`},
+		},
+		{
+			name:   "additional properties allowed by default",
+			schema: `{"type": "object", "properties": {"id": {"type": "integer"}}}`,
+			value:  `{"id": 1, "extra": true}`,
+		},
+		{
+			name:   "additional properties with a schema",
+			schema: `{"type": "object", "additionalProperties": {"type": "number"}}`,
+			value:  `{"a": 1, "b": "two"}`,
+			errors: []string{"$.b: expected type number, got string"},
+		},
+		{
+			name: "nested object",
+			schema: `{"type": "object", "properties": {"user": {
+				"type": "object", "required": ["email"],
+				"properties": {"email": {"type": "string"}, "age": {"type": "integer", "minimum": 0}}}}}`,
+			value:  `{"user": {"age": -1}}`,
+			errors: []string{`$.user: missing required property "email"`, "$.user.age: value -1 is less than minimum 0"},
+		},
+
+		// Arrays
+		{
+			name:   "items",
+			schema: `{"type": "array", "items": {"type": "string"}}`,
+			value:  `["a", 2, "c", false]`,
+			errors: []string{"$[1]: expected type string", "$[3]: expected type string"},
+		},
+		{
+			name:   "item count",
+			schema: `{"type": "array", "minItems": 2, "maxItems": 3}`,
+			value:  `[1]`,
+			errors: []string{"array has fewer than 2 items"},
+		},
+		{
+			name:   "array of objects",
+			schema: `{"type": "array", "items": {"type": "object", "required": ["id"], "additionalProperties": false, "properties": {"id": {"type": "integer"}}}}`,
+			value:  `[{"id": 1}, {"name": "x"}]`,
+			errors: []string{`$[1]: missing required property "id"`, `$[1]: unexpected property "name"`},
+		},
+
+		// Values
+		{"enum", `{"enum": ["low", "high", 3]}`, `"high"`, nil},
+		{"enum number", `{"enum": ["low", "high", 3]}`, `3`, nil},
+		{"not in enum", `{"type": "string", "enum": ["low", "high"]}`, `"medium"`, []string{"value must be one of [low high]"}},
+		{"const", `{"const": {"a": 1}}`, `{"a": 1}`, nil},
+		{"not const", `{"const": "v1"}`, `"v2"`, []string{"value must be v1"}},
+		{"string length", `{"type": "string", "minLength": 2, "maxLength": 3}`, `"héllo"`, []string{"string longer than 3"}},
+		{"pattern", `{"type": "string", "pattern": "^[a-z]+$"}`, `"abc1"`, []string{`does not match pattern "^[a-z]+$"`}},
+		{"exclusive bounds", `{"type": "number", "exclusiveMinimum": 0, "exclusiveMaximum": 1}`, `1`, []string{"value 1 must be less than 1"}},
+
+		// References and combinations
+		{
+			name: "$ref into $defs",
+			schema: `{"type": "object", "properties": {"items": {"type": "array", "items": {"$ref": "#/$defs/Item"}}},
+				"$defs": {"Item": {"type": "object", "required": ["sku"], "properties": {"sku": {"type": "string"}}}}}`,
+			value:  `{"items": [{"sku": "A1"}, {"sku": 7}, {}]}`,
+			errors: []string{"$.items[1].sku: expected type string", `$.items[2]: missing required property "sku"`},
+		},
+		{
+			name:   "$ref into definitions",
+			schema: `{"$ref": "#/definitions/Name", "definitions": {"Name": {"type": "string"}}}`,
+			value:  `"a"`,
+		},
+		{
+			name:   "unresolvable $ref",
+			schema: `{"$ref": "#/$defs/Missing"}`,
+			value:  `"a"`,
+			errors: []string{`unresolvable $ref "#/$defs/Missing"`},
+		},
+		{
+			name:   "anyOf matching the second",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "object", "required": ["id"]}]}`,
+			value:  `{"id": 1}`,
+		},
+		{
+			name:   "anyOf matching none",
+			schema: `{"anyOf": [{"type": "string"}, {"type": "object", "required": ["id"]}]}`,
+			value:  `{"name": "x"}`,
+			errors: []string{"value does not match any of the allowed schemas"},
+		},
+		{
+			name:   "anyOf with $ref",
+			schema: `{"anyOf": [{"$ref": "#/$defs/Num"}, {"type": "null"}], "$defs": {"Num": {"type": "number", "minimum": 10}}}`,
+			value:  `5`,
+			errors: []string{"value does not match any of the allowed schemas"},
+		},
+		{
+			name:   "oneOf matching both",
+			schema: `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`,
+			value:  `2`,
+			errors: []string{"value must match exactly one schema (matched 2)"},
+		},
+		{
+			name:   "allOf",
+			schema: `{"allOf": [{"type": "object", "required": ["a"]}, {"required": ["b"]}]}`,
+			value:  `{"a": 1}`,
+			errors: []string{`missing required property "b"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, _ := decode(t, tt.schema).(map[string]interface{})
+			errs := ValidateJSONSchema(decode(t, tt.value), schema)
+			if len(errs) != len(tt.errors) {
+				t.Fatalf("errors = %q, want %d matching %q", errs, len(tt.errors), tt.errors)
+			}
+			for i, want := range tt.errors {
+				if !strings.Contains(errs[i], want) {
+					t.Errorf("error %d = %q, want it to contain %q", i, errs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateJSONSchemaRecursiveRef(t *testing.T) {
+	// A schema referring to itself stops at a depth limit instead of recursing forever
+	schema, _ := decode(t, `{"$ref": "#"}`).(map[string]interface{})
+	errs := ValidateJSONSchema("a", schema)
+	if len(errs) == 0 || !strings.Contains(errs[0], "schema nesting too deep") {
+		t.Errorf("errors = %q, want the nesting limit", errs)
+	}
+}
@@ -0,0 +1,364 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/mcp"
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// mcpCallTimeout bounds a single MCP request (connect, list or call).
+const mcpCallTimeout = 60 * time.Second
+
+// MCPToolManager keeps live connections to registered MCP servers and exposes
+// their tools to agents.
+//
+// Agents opt in to tools through their configuration:
+//
+//	"mcp_tools": ["jira/*", "wiki/search"]
+//
+// where each entry is "<server name>/<tool name>" and "*" selects every tool
+// discovered on that server.
+type MCPToolManager struct {
+	service *models.MCPService
+
+	mu      sync.Mutex
+	clients map[int64]*mcp.Client
+}
+
+// NewMCPToolManager creates a manager backed by the given service.
+func NewMCPToolManager(service *models.MCPService) *MCPToolManager {
+	return &MCPToolManager{
+		service: service,
+		clients: make(map[int64]*mcp.Client),
+	}
+}
+
+// Service returns the underlying MCPService.
+func (m *MCPToolManager) Service() *models.MCPService {
+	return m.service
+}
+
+// ConnectMCPServer opens a transport to the server and performs the MCP
+// handshake.
+func ConnectMCPServer(ctx context.Context, server *models.MCPServer) (*mcp.Client, error) {
+	var transport mcp.Transport
+	var err error
+	switch server.Transport {
+	case models.MCPTransportStdio:
+		transport, err = mcp.NewStdioTransport(server.Command, server.Args, server.Env)
+	case models.MCPTransportHTTP:
+		transport, err = mcp.NewHTTPTransport(server.URL, server.Headers, mcpCallTimeout)
+	default:
+		err = fmt.Errorf("unsupported MCP transport '%s'", server.Transport)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client := mcp.NewClient(transport)
+	if _, err := client.Initialize(ctx); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to initialize MCP server '%s': %w", server.Name, err)
+	}
+	log.Printf("Connected to MCP server '%s' (%s %s)", server.Name, client.ServerInfo.Name, client.ServerInfo.Version)
+	return client, nil
+}
+
+// client returns a live client for the server, connecting on first use.
+func (m *MCPToolManager) client(ctx context.Context, serverID int64) (*mcp.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if c, ok := m.clients[serverID]; ok {
+		return c, nil
+	}
+
+	server, err := m.service.GetServer(serverID)
+	if err != nil {
+		return nil, err
+	}
+	if !server.IsActive {
+		return nil, fmt.Errorf("MCP server '%s' is disabled", server.Name)
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, mcpCallTimeout)
+	defer cancel()
+	c, err := ConnectMCPServer(connectCtx, server)
+	if err != nil {
+		return nil, err
+	}
+	m.clients[serverID] = c
+	return c, nil
+}
+
+// Invalidate closes the cached connection for a server so the next use
+// reconnects with fresh settings. Call after updating or deleting a server.
+func (m *MCPToolManager) Invalidate(serverID int64) {
+	m.mu.Lock()
+	c, ok := m.clients[serverID]
+	delete(m.clients, serverID)
+	m.mu.Unlock()
+
+	if ok {
+		if err := c.Close(); err != nil {
+			log.Printf("Error closing MCP client for server %d: %v", serverID, err)
+		}
+	}
+}
+
+// Close shuts down every open MCP connection.
+func (m *MCPToolManager) Close() {
+	m.mu.Lock()
+	ids := make([]int64, 0, len(m.clients))
+	for id := range m.clients {
+		ids = append(ids, id)
+	}
+	m.mu.Unlock()
+
+	for _, id := range ids {
+		m.Invalidate(id)
+	}
+}
+
+// SyncServerTools lists the server's tools and stores them, replacing the
+// previously discovered set.
+func (m *MCPToolManager) SyncServerTools(ctx context.Context, serverID int64) ([]models.MCPTool, error) {
+	// Always reconnect so that a sync picks up configuration changes.
+	m.Invalidate(serverID)
+
+	c, err := m.client(ctx, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	listCtx, cancel := context.WithTimeout(ctx, mcpCallTimeout)
+	defer cancel()
+	discovered, err := c.ListTools(listCtx)
+	if err != nil {
+		m.Invalidate(serverID)
+		return nil, fmt.Errorf("failed to list tools: %w", err)
+	}
+
+	tools := make([]models.MCPTool, 0, len(discovered))
+	for _, t := range discovered {
+		tools = append(tools, models.MCPTool{
+			ServerID:    serverID,
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+		})
+	}
+	if err := m.service.ReplaceServerTools(serverID, tools); err != nil {
+		return nil, err
+	}
+	log.Printf("Synced %d tools from MCP server %d", len(tools), serverID)
+	return m.service.ListServerTools(serverID)
+}
+
+// boundTool maps a model-facing tool name back to its MCP server and tool.
+type boundTool struct {
+	serverID int64
+	toolName string
+}
+
+var toolNameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// exposedToolName builds a provider-safe, unique tool name for a server tool.
+func exposedToolName(serverName, toolName string) string {
+	name := toolNameSanitizer.ReplaceAllString(serverName, "_") + "__" + toolNameSanitizer.ReplaceAllString(toolName, "_")
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// agentToolPatterns reads the "mcp_tools" entry of an agent configuration.
+func agentToolPatterns(agent *models.Agent) []string {
+	raw, ok := agent.Configuration["mcp_tools"]
+	if !ok {
+		return nil
+	}
+	var patterns []string
+	switch v := raw.(type) {
+	case []interface{}:
+		for _, p := range v {
+			if s, ok := p.(string); ok && s != "" {
+				patterns = append(patterns, s)
+			}
+		}
+	case string:
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				patterns = append(patterns, s)
+			}
+		}
+	}
+	return patterns
+}
+
+// ToolsForAgent resolves the agent's "mcp_tools" configuration against the
+// discovered tools of active servers.
+func (m *MCPToolManager) ToolsForAgent(agent *models.Agent) ([]ToolDefinition, map[string]boundTool, error) {
+	patterns := agentToolPatterns(agent)
+	if len(patterns) == 0 {
+		return nil, nil, nil
+	}
+
+	servers, err := m.service.ListServers(true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var defs []ToolDefinition
+	bindings := make(map[string]boundTool)
+	for _, server := range servers {
+		var wanted []string
+		for _, p := range patterns {
+			serverName, toolName, found := strings.Cut(p, "/")
+			if !found {
+				toolName = "*"
+			}
+			if serverName == server.Name {
+				wanted = append(wanted, toolName)
+			}
+		}
+		if len(wanted) == 0 {
+			continue
+		}
+
+		tools, err := m.service.ListServerTools(server.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, t := range tools {
+			if !matchesAny(t.Name, wanted) {
+				continue
+			}
+			name := exposedToolName(server.Name, t.Name)
+			if _, dup := bindings[name]; dup {
+				continue
+			}
+			schema := t.InputSchema
+			if schema == nil {
+				schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+			}
+			defs = append(defs, ToolDefinition{Name: name, Description: t.Description, InputSchema: schema})
+			bindings[name] = boundTool{serverID: server.ID, toolName: t.Name}
+		}
+	}
+	return defs, bindings, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ChatToolExecutor runs MCP tool calls on behalf of a chat and logs every
+// invocation.
+type ChatToolExecutor struct {
+	manager  *MCPToolManager
+	bindings map[string]boundTool
+	chatID   int64
+	userID   int64
+	agentID  *int64
+
+	// OnInvoke, if set, is called before each tool runs (e.g. to notify the UI).
+	OnInvoke func(call ToolCall)
+}
+
+// NewChatToolExecutor creates an executor for the resolved tool bindings.
+func (m *MCPToolManager) NewChatToolExecutor(bindings map[string]boundTool, chatID, userID int64, agentID *int64) *ChatToolExecutor {
+	return &ChatToolExecutor{
+		manager:  m,
+		bindings: bindings,
+		chatID:   chatID,
+		userID:   userID,
+		agentID:  agentID,
+	}
+}
+
+// ExecuteTool implements ToolExecutor.
+func (e *ChatToolExecutor) ExecuteTool(ctx context.Context, call ToolCall) (string, error) {
+	binding, ok := e.bindings[call.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown tool '%s'", call.Name)
+	}
+	if e.OnInvoke != nil {
+		e.OnInvoke(call)
+	}
+
+	var args map[string]interface{}
+	if strings.TrimSpace(call.Arguments) != "" {
+		if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+			return "", fmt.Errorf("invalid JSON arguments for tool '%s': %w", call.Name, err)
+		}
+	}
+
+	start := time.Now()
+	result, callErr := e.call(ctx, binding, args)
+
+	serverID := binding.serverID
+	inv := models.ToolInvocation{
+		ChatID:     e.chatID,
+		UserID:     e.userID,
+		AgentID:    e.agentID,
+		ServerID:   &serverID,
+		ToolName:   binding.toolName,
+		Arguments:  call.Arguments,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	var text string
+	if callErr != nil {
+		inv.IsError = true
+		inv.Result = callErr.Error()
+	} else {
+		text = result.Text()
+		inv.IsError = result.IsError
+		inv.Result = text
+	}
+	if err := e.manager.service.LogToolInvocation(&inv); err != nil {
+		log.Printf("[Chat %d] Failed to log invocation of tool %s: %v", e.chatID, call.Name, err)
+	}
+	log.Printf("[Chat %d] Tool %s (server %d) finished in %dms (error: %v)", e.chatID, binding.toolName, binding.serverID, inv.DurationMs, inv.IsError)
+
+	if callErr != nil {
+		return "", callErr
+	}
+	if result.IsError {
+		return "Error: " + text, nil
+	}
+	return text, nil
+}
+
+func (e *ChatToolExecutor) call(ctx context.Context, binding boundTool, args map[string]interface{}) (*mcp.CallToolResult, error) {
+	c, err := e.manager.client(ctx, binding.serverID)
+	if err != nil {
+		return nil, err
+	}
+	callCtx, cancel := context.WithTimeout(ctx, mcpCallTimeout)
+	defer cancel()
+	result, err := c.CallTool(callCtx, binding.toolName, args)
+	if err != nil {
+		// Drop the connection so a broken server process is restarted next time.
+		var rpcErr *mcp.RPCError
+		if !errors.As(err, &rpcErr) {
+			e.manager.Invalidate(binding.serverID)
+		}
+		return nil, err
+	}
+	return result, nil
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/ramborogers/cyberai/server/models"
@@ -94,6 +95,20 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 			Role:    msg.Role,
 			Content: msg.Content,
 		}
+		if msg.Role == "tool" {
+			ollamaMessage.ToolName = msg.Name
+		}
+		for _, call := range msg.ToolCalls {
+			var args map[string]interface{}
+			if call.Arguments != "" {
+				if err := json.Unmarshal([]byte(call.Arguments), &args); err != nil {
+					return fmt.Errorf("invalid arguments for tool call %s: %w", call.Name, err)
+				}
+			}
+			ollamaMessage.ToolCalls = append(ollamaMessage.ToolCalls, OllamaToolCall{
+				Function: OllamaToolCallFunction{Name: call.Name, Arguments: args},
+			})
+		}
 		ollamaMessages = append(ollamaMessages, ollamaMessage)
 	}
 
@@ -112,6 +127,69 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 		chatReq.Options["num_predict"] = req.MaxTokens
 	}
 
+	// Sampling parameters (Ollama supports all of them)
+	sampling := req.Sampling
+	if sampling.TopP != nil {
+		chatReq.Options["top_p"] = *sampling.TopP
+	}
+	if sampling.TopK != nil {
+		chatReq.Options["top_k"] = *sampling.TopK
+	}
+	if len(sampling.Stop) > 0 {
+		chatReq.Options["stop"] = sampling.Stop
+	}
+	if sampling.Seed != nil {
+		chatReq.Options["seed"] = *sampling.Seed
+	}
+	if sampling.PresencePenalty != nil {
+		chatReq.Options["presence_penalty"] = *sampling.PresencePenalty
+	}
+	if sampling.FrequencyPenalty != nil {
+		chatReq.Options["frequency_penalty"] = *sampling.FrequencyPenalty
+	}
+	if sampling.RepeatPenalty != nil {
+		chatReq.Options["repeat_penalty"] = *sampling.RepeatPenalty
+	}
+	if sampling.NumCtx != nil {
+		chatReq.Options["num_ctx"] = *sampling.NumCtx
+	}
+	if sampling.KeepAlive != "" {
+		// Ollama reads a bare number as seconds but parses strings as durations
+		if seconds, err := strconv.ParseFloat(sampling.KeepAlive, 64); err == nil {
+			chatReq.KeepAlive = seconds
+		} else {
+			chatReq.KeepAlive = sampling.KeepAlive
+		}
+	}
+
+	// Ollama has no thinking budget; any budget just turns thinking on, which
+	// returns the reasoning in a separate "thinking" field
+	if req.ThinkingBudget > 0 {
+		think := true
+		chatReq.Think = &think
+	}
+
+	// Structured output: "json" for any JSON, or the schema itself
+	if req.ResponseFormat.IsJSON() {
+		if req.ResponseFormat.Type == ResponseFormatJSONSchema {
+			chatReq.Format = req.ResponseFormat.Schema
+		} else {
+			chatReq.Format = "json"
+		}
+	}
+
+	// Add tool definitions if provided
+	for _, tool := range req.Tools {
+		chatReq.Tools = append(chatReq.Tools, OllamaTool{
+			Type: "function",
+			Function: OllamaToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
 	// Convert request to JSON
 	reqBody, err := json.Marshal(chatReq)
 	if err != nil {
@@ -148,6 +226,10 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 		scanner := bufio.NewScanner(resp.Body)
 		scanner.Split(bufio.ScanLines)
 
+		// Models that are not asked to think separately inline their
+		// reasoning in <think> tags
+		var thinkParser ThinkTagParser
+
 		isFinal := false
 		for !isFinal && scanner.Scan() {
 			line := scanner.Text()
@@ -163,9 +245,20 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 			}
 
 			// Send chunk via callback
+			content, reasoning := thinkParser.Feed(streamResp.Message.Content)
+			if streamResp.Done {
+				restContent, restReasoning := thinkParser.Flush()
+				content += restContent
+				reasoning += restReasoning
+			}
 			chunk := ChatCompletionChunk{
-				Content: streamResp.Message.Content,
-				IsFinal: streamResp.Done,
+				Content:   content,
+				Reasoning: streamResp.Message.Thinking + reasoning,
+				IsFinal:   streamResp.Done,
+				ToolCalls: ollamaToolCalls(streamResp.Message.ToolCalls),
+			}
+			if streamResp.Done {
+				chunk.Usage = streamResp.usage()
 			}
 
 			if err := callback(ctx, chunk); err != nil {
@@ -195,9 +288,13 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 		}
 
 		if callback != nil {
+			content, reasoning := SplitReasoning(chatResp.Message.Content)
 			chunk := ChatCompletionChunk{
-				Content: chatResp.Message.Content,
-				IsFinal: true,
+				Content:   content,
+				Reasoning: joinReasoning(chatResp.Message.Thinking, reasoning),
+				IsFinal:   true,
+				ToolCalls: ollamaToolCalls(chatResp.Message.ToolCalls),
+				Usage:     chatResp.usage(),
 			}
 			if err := callback(ctx, chunk); err != nil {
 				return fmt.Errorf("callback error processing non-streamed response: %w", err)
@@ -209,22 +306,68 @@ func (c *OllamaConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 	}
 }
 
+// ollamaToolCalls converts Ollama tool calls to the generic form. Ollama does
+// not assign call IDs, so synthetic ones are generated.
+func ollamaToolCalls(calls []OllamaToolCall) []ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	result := make([]ToolCall, 0, len(calls))
+	for i, call := range calls {
+		args, err := json.Marshal(call.Function.Arguments)
+		if err != nil || call.Function.Arguments == nil {
+			args = []byte("{}")
+		}
+		result = append(result, ToolCall{
+			ID:        fmt.Sprintf("call_%d_%d", time.Now().UnixNano(), i),
+			Name:      call.Function.Name,
+			Arguments: string(args),
+		})
+	}
+	return result
+}
+
 // --- Ollama Specific API Structures ---
 // (Based on https://github.com/ollama/ollama/blob/main/docs/api.md)
 
 type OllamaMessage struct {
-	Role    string   `json:"role"`
-	Content string   `json:"content"`
-	Images  []string `json:"images,omitempty"` // Base64 encoded images
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"` // Reasoning, when the request sets "think"
+	Images    []string         `json:"images,omitempty"`   // Base64 encoded images
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // For role "tool"
+}
+
+type OllamaTool struct {
+	Type     string             `json:"type"` // Always "function"
+	Function OllamaToolFunction `json:"function"`
+}
+
+type OllamaToolFunction struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters"`
+}
+
+type OllamaToolCall struct {
+	Function OllamaToolCallFunction `json:"function"`
+}
+
+type OllamaToolCallFunction struct {
+	Name      string                 `json:"name"`
+	Arguments map[string]interface{} `json:"arguments"`
 }
 
 type OllamaChatRequest struct {
 	Model     string                 `json:"model"`
 	Messages  []OllamaMessage        `json:"messages"`
-	Format    string                 `json:"format,omitempty"`  // e.g., "json"
+	Format    interface{}            `json:"format,omitempty"`  // "json" or a JSON schema object
 	Options   map[string]interface{} `json:"options,omitempty"` // Passthrough parameters (temperature, max_tokens etc.)
+	Tools     []OllamaTool           `json:"tools,omitempty"`
 	Stream    bool                   `json:"stream"`
-	KeepAlive string                 `json:"keep_alive,omitempty"`
+	Think     *bool                  `json:"think,omitempty"`      // Return reasoning separately in message.thinking
+	KeepAlive interface{}            `json:"keep_alive,omitempty"` // Duration string ("10m") or seconds
 }
 
 // OllamaStreamResponse represents a single line in the streaming response
@@ -245,3 +388,11 @@ type OllamaStreamResponse struct {
 
 // OllamaChatResponse represents the non-streaming response (rarely used if streaming preferred)
 type OllamaChatResponse = OllamaStreamResponse // Same structure, just Done=true
+
+// usage returns the token counts of a final response, or nil if it has none.
+func (r *OllamaStreamResponse) usage() *TokenUsage {
+	if r.PromptEvalCount == 0 && r.EvalCount == 0 {
+		return nil
+	}
+	return &TokenUsage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
@@ -2,6 +2,7 @@ package llm
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -11,6 +12,8 @@ import (
 
 	openai "github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/resp"
+	"github.com/openai/openai-go/shared"
 
 	// Assuming internal/apierr might be needed for error checking, or maybe just check status code
 	// "github.com/openai/openai-go/internal/apierr"
@@ -106,6 +109,17 @@ func (c *OpenAIConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 			openaiMessages[i] = openai.UserMessage(msg.Content)
 		case "assistant":
 			openaiMessages[i] = openai.AssistantMessage(msg.Content)
+			for _, call := range msg.ToolCalls {
+				openaiMessages[i].OfAssistant.ToolCalls = append(openaiMessages[i].OfAssistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+					ID: call.ID,
+					Function: openai.ChatCompletionMessageToolCallFunctionParam{
+						Name:      call.Name,
+						Arguments: call.Arguments,
+					},
+				})
+			}
+		case "tool":
+			openaiMessages[i] = openai.ToolMessage(msg.Content, msg.ToolCallID)
 		case "system":
 			openaiMessages[i] = openai.SystemMessage(msg.Content)
 		default:
@@ -125,10 +139,60 @@ func (c *OpenAIConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 		openaiReq.Temperature = openai.Float(float64(req.Temperature))
 	}
 
+	// Sampling parameters
+	sampling := req.Sampling
+	warnUnsupportedSampling("OpenAI", req.Model, sampling, "top_p", "stop", "seed", "presence_penalty", "frequency_penalty")
+	if sampling.TopP != nil {
+		openaiReq.TopP = openai.Float(*sampling.TopP)
+	}
+	if len(sampling.Stop) > 0 {
+		openaiReq.Stop.OfChatCompletionNewsStopArray = sampling.Stop
+	}
+	if sampling.Seed != nil {
+		openaiReq.Seed = openai.Int(*sampling.Seed)
+	}
+	if sampling.PresencePenalty != nil {
+		openaiReq.PresencePenalty = openai.Float(*sampling.PresencePenalty)
+	}
+	if sampling.FrequencyPenalty != nil {
+		openaiReq.FrequencyPenalty = openai.Float(*sampling.FrequencyPenalty)
+	}
+
+	// Structured output
+	if req.ResponseFormat.IsJSON() {
+		if req.ResponseFormat.Type == ResponseFormatJSONSchema {
+			jsonSchema := shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   req.ResponseFormat.SchemaName(),
+				Schema: req.ResponseFormat.Schema,
+			}
+			if req.ResponseFormat.Strict {
+				jsonSchema.Strict = openai.Bool(true)
+			}
+			openaiReq.ResponseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{JSONSchema: jsonSchema}
+		} else {
+			openaiReq.ResponseFormat.OfJSONObject = &shared.ResponseFormatJSONObjectParam{}
+		}
+	}
+
+	// Add tool definitions if provided
+	for _, tool := range req.Tools {
+		fn := shared.FunctionDefinitionParam{
+			Name:       tool.Name,
+			Parameters: shared.FunctionParameters(tool.InputSchema),
+		}
+		if tool.Description != "" {
+			fn.Description = openai.String(tool.Description)
+		}
+		openaiReq.Tools = append(openaiReq.Tools, openai.ChatCompletionToolParam{Function: fn})
+	}
+
 	log.Printf("OpenAI GenerateChatCompletion called for model %s (Streaming: %v)", req.Model, req.Stream)
 
 	// 3. Make API call
 	if req.Stream {
+		// Ask for the token counts, which arrive in a last chunk without choices
+		openaiReq.StreamOptions.IncludeUsage = openai.Bool(true)
+
 		// Use NewStreaming method for streaming
 		stream := c.client.Chat.Completions.NewStreaming(ctx, openaiReq)
 		if stream.Err() != nil {
@@ -138,15 +202,41 @@ func (c *OpenAIConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 
 		log.Printf("OpenAI stream created for model %s", req.Model)
 
+		// Tool calls arrive as fragments keyed by index; accumulate them and
+		// emit the complete calls once the stream ends.
+		var toolCalls []ToolCall
+		// Some compatible servers inline reasoning in <think> tags
+		var thinkParser ThinkTagParser
+		var usage *TokenUsage
+
 		// Using Next() and Current() methods from ssestream.Stream
 		for stream.Next() {
 			response := stream.Current()
+			if response.Usage.TotalTokens > 0 {
+				usage = openAIUsage(response.Usage)
+			}
 
 			if len(response.Choices) > 0 {
-				chunkContent := response.Choices[0].Delta.Content
-				if chunkContent != "" {
+				for _, delta := range response.Choices[0].Delta.ToolCalls {
+					for int(delta.Index) >= len(toolCalls) {
+						toolCalls = append(toolCalls, ToolCall{})
+					}
+					tc := &toolCalls[delta.Index]
+					if delta.ID != "" {
+						tc.ID = delta.ID
+					}
+					if delta.Function.Name != "" {
+						tc.Name = delta.Function.Name
+					}
+					tc.Arguments += delta.Function.Arguments
+				}
+
+				chunkContent, chunkReasoning := thinkParser.Feed(response.Choices[0].Delta.Content)
+				chunkReasoning = openAIReasoning(response.Choices[0].Delta.JSON.ExtraFields) + chunkReasoning
+				if chunkContent != "" || chunkReasoning != "" {
 					chunk := ChatCompletionChunk{
-						Content: chunkContent,
+						Content:   chunkContent,
+						Reasoning: chunkReasoning,
 						// IsFinal can be determined by response.Choices[0].FinishReason
 					}
 					if err := callback(ctx, chunk); err != nil {
@@ -164,22 +254,38 @@ func (c *OpenAIConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 			return fmt.Errorf("error in OpenAI stream: %w", err)
 		}
 
+		restContent, restReasoning := thinkParser.Flush()
+		if len(toolCalls) > 0 || restContent != "" || restReasoning != "" || usage != nil {
+			if err := callback(ctx, ChatCompletionChunk{Content: restContent, Reasoning: restReasoning, ToolCalls: toolCalls, IsFinal: true, Usage: usage}); err != nil {
+				return fmt.Errorf("callback error processing tool calls: %w", err)
+			}
+		}
+
 		log.Printf("OpenAI stream finished for model %s", req.Model)
 		return nil // Stream finished successfully
 	} else {
 		// Non-streaming request
 		// Use New method for non-streaming
-		resp, err := c.client.Chat.Completions.New(ctx, openaiReq)
+		response, err := c.client.Chat.Completions.New(ctx, openaiReq)
 		if err != nil {
 			return fmt.Errorf("failed to create OpenAI chat completion: %w", err)
 		}
 
-		if len(resp.Choices) > 0 {
-			fullContent := resp.Choices[0].Message.Content
+		if len(response.Choices) > 0 {
+			fullContent, reasoning := SplitReasoning(response.Choices[0].Message.Content)
 			if callback != nil {
 				chunk := ChatCompletionChunk{
-					Content: fullContent,
-					IsFinal: true,
+					Content:   fullContent,
+					Reasoning: joinReasoning(openAIReasoning(response.Choices[0].Message.JSON.ExtraFields), reasoning),
+					IsFinal:   true,
+					Usage:     openAIUsage(response.Usage),
+				}
+				for _, call := range response.Choices[0].Message.ToolCalls {
+					chunk.ToolCalls = append(chunk.ToolCalls, ToolCall{
+						ID:        call.ID,
+						Name:      call.Function.Name,
+						Arguments: call.Function.Arguments,
+					})
 				}
 				if err := callback(ctx, chunk); err != nil {
 					return fmt.Errorf("callback error processing non-streamed response: %w", err)
@@ -191,3 +297,28 @@ func (c *OpenAIConnector) GenerateChatCompletion(ctx context.Context, req ChatCo
 		}
 	}
 }
+
+// openAIReasoning extracts reasoning that OpenAI-compatible servers return in
+// non-standard message fields: "reasoning_content" (DeepSeek, vLLM),
+// "reasoning" (OpenRouter, Ollama) or a "reasoning_summary".
+func openAIReasoning(extra map[string]resp.Field) string {
+	for _, key := range []string{"reasoning_content", "reasoning", "reasoning_summary"} {
+		field, ok := extra[key]
+		if !ok || field.Raw() == "" || field.Raw() == "null" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal([]byte(field.Raw()), &text); err == nil && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// openAIUsage converts OpenAI token counts, returning nil if none were reported.
+func openAIUsage(usage openai.CompletionUsage) *TokenUsage {
+	if usage.TotalTokens == 0 {
+		return nil
+	}
+	return &TokenUsage{PromptTokens: int(usage.PromptTokens), CompletionTokens: int(usage.CompletionTokens)}
+}
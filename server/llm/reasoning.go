@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"strings"
+)
+
+// Tags used by reasoning models (DeepSeek-R1, Qwen3, ...) to wrap their
+// chain of thought inline in the response content.
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// reasoningPrefixes are labels that some clients prepend to a reasoning
+// block followed by a blank line; the block up to the blank line is treated
+// as reasoning when found at the start of a response.
+var reasoningPrefixes = []string{"⚙️ AI Thinking Process"}
+
+// ThinkTagParser separates <think>...</think> reasoning from streamed
+// content. Tags may be split across chunks; a partial tag at the end of a
+// chunk is held back until the next call.
+type ThinkTagParser struct {
+	inThink bool
+	pending string
+}
+
+// Feed consumes the next chunk of content and returns the parts that belong
+// to the answer and to the reasoning.
+func (p *ThinkTagParser) Feed(s string) (content, reasoning string) {
+	buf := p.pending + s
+	p.pending = ""
+
+	var contentOut, reasoningOut strings.Builder
+	for buf != "" {
+		tag := thinkOpenTag
+		out := &contentOut
+		if p.inThink {
+			tag = thinkCloseTag
+			out = &reasoningOut
+		}
+
+		if idx := strings.Index(buf, tag); idx != -1 {
+			out.WriteString(buf[:idx])
+			buf = buf[idx+len(tag):]
+			p.inThink = !p.inThink
+			continue
+		}
+
+		// Hold back a trailing partial tag
+		keep := partialSuffix(buf, tag)
+		out.WriteString(buf[:len(buf)-keep])
+		p.pending = buf[len(buf)-keep:]
+		break
+	}
+	return contentOut.String(), reasoningOut.String()
+}
+
+// Flush returns any held-back text at the end of the stream.
+func (p *ThinkTagParser) Flush() (content, reasoning string) {
+	rest := p.pending
+	p.pending = ""
+	if p.inThink {
+		return "", rest
+	}
+	return rest, ""
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag.
+func partialSuffix(s, tag string) int {
+	for n := len(tag) - 1; n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// SplitReasoning separates reasoning embedded in a complete response (think
+// tags or a known reasoning label) from the answer.
+func SplitReasoning(text string) (content, reasoning string) {
+	var p ThinkTagParser
+	content, reasoning = p.Feed(text)
+	restContent, restReasoning := p.Flush()
+	content += restContent
+	reasoning += restReasoning
+
+	for _, prefix := range reasoningPrefixes {
+		if !strings.HasPrefix(content, prefix) {
+			continue
+		}
+		block, answer, found := strings.Cut(strings.TrimPrefix(content, prefix), "\n\n")
+		if !found {
+			break // No clear end of the block; leave the content alone
+		}
+		reasoning = joinReasoning(reasoning, strings.TrimSpace(block))
+		content = answer
+		break
+	}
+
+	return strings.TrimSpace(content), strings.TrimSpace(reasoning)
+}
+
+// joinReasoning concatenates two pieces of reasoning with a blank line.
+func joinReasoning(a, b string) string {
+	if a == "" {
+		return b
+	}
+	if b == "" {
+		return a
+	}
+	return a + "\n\n" + b
+}
+
+// ThinkingBudgetFromConfig reads the "thinking_budget" entry (in tokens) of
+// a model configuration; 0 if absent or invalid.
+func ThinkingBudgetFromConfig(config map[string]interface{}) int {
+	return configInt(config, "thinking_budget")
+}
@@ -0,0 +1,171 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Response format types.
+const (
+	ResponseFormatText       = "text"        // Free-form text (default)
+	ResponseFormatJSONObject = "json_object" // Any valid JSON object
+	ResponseFormatJSONSchema = "json_schema" // JSON matching Schema
+)
+
+// ResponseFormat requests structured (JSON) output from the model.
+type ResponseFormat struct {
+	Type   string                 `json:"type"`             // "text", "json_object" or "json_schema"
+	Name   string                 `json:"name,omitempty"`   // Schema name (OpenAI requires one; defaults to "response")
+	Schema map[string]interface{} `json:"schema,omitempty"` // Required for "json_schema"
+	Strict bool                   `json:"strict,omitempty"` // Ask the provider to enforce the schema strictly (OpenAI)
+	Repair bool                   `json:"repair,omitempty"` // Retry once with the validation errors if the output is invalid
+}
+
+// IsJSON reports whether the format asks for JSON output.
+func (f *ResponseFormat) IsJSON() bool {
+	return f != nil && (f.Type == ResponseFormatJSONObject || f.Type == ResponseFormatJSONSchema)
+}
+
+// SchemaName returns the schema name, defaulting to "response".
+func (f *ResponseFormat) SchemaName() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return "response"
+}
+
+// EffectiveSchema returns the schema to enforce: the given schema for
+// "json_schema", or a bare object schema for "json_object".
+func (f *ResponseFormat) EffectiveSchema() map[string]interface{} {
+	if f.Type == ResponseFormatJSONSchema && f.Schema != nil {
+		return f.Schema
+	}
+	return map[string]interface{}{"type": "object"}
+}
+
+// Validate checks that the format is well formed.
+func (f *ResponseFormat) Validate() error {
+	switch f.Type {
+	case "", ResponseFormatText, ResponseFormatJSONObject:
+		return nil
+	case ResponseFormatJSONSchema:
+		if len(f.Schema) == 0 {
+			return fmt.Errorf("response_format of type json_schema requires a schema")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid response_format type '%s' (must be text, json_object or json_schema)", f.Type)
+	}
+}
+
+// ResponseFormatFromConfig reads the "response_format" entry of an agent
+// configuration. It returns nil if the entry is absent.
+func ResponseFormatFromConfig(config map[string]interface{}) (*ResponseFormat, error) {
+	raw, ok := config["response_format"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+
+	var format ResponseFormat
+	switch v := raw.(type) {
+	case string:
+		// Shorthand: "json" / "json_object"
+		format.Type = v
+		if v == "json" {
+			format.Type = ResponseFormatJSONObject
+		}
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response_format: %w", err)
+		}
+		if err := json.Unmarshal(data, &format); err != nil {
+			return nil, fmt.Errorf("invalid response_format: %w", err)
+		}
+	}
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	return &format, nil
+}
+
+// ExtractJSON returns the JSON in a model's reply, which models sometimes
+// wrap in a Markdown code fence or in prose ("Here is the JSON: {...}"): the
+// reply itself if it is JSON, else the first fenced block, else the span from
+// the first opening bracket to the last matching closing one if that is JSON.
+// Otherwise it returns the trimmed reply, for validation to reject.
+func ExtractJSON(content string) string {
+	s := strings.TrimSpace(content)
+	if json.Valid([]byte(s)) {
+		return s
+	}
+
+	if start := strings.Index(s, "```"); start != -1 {
+		fenced := s[start+3:]
+		if nl := strings.Index(fenced, "\n"); nl != -1 {
+			fenced = fenced[nl+1:] // Drop the language tag line (e.g. ```json)
+			if end := strings.Index(fenced, "```"); end != -1 {
+				return strings.TrimSpace(fenced[:end])
+			}
+		}
+	}
+
+	if start := strings.IndexAny(s, "{["); start != -1 {
+		closing := "}"
+		if s[start] == '[' {
+			closing = "]"
+		}
+		if end := strings.LastIndex(s, closing); end > start && json.Valid([]byte(s[start:end+1])) {
+			return s[start : end+1]
+		}
+	}
+	return s
+}
+
+// ValidateStructuredOutput checks that content is JSON matching the format.
+// It returns the extracted JSON text on success.
+func ValidateStructuredOutput(content string, format *ResponseFormat) (string, error) {
+	if !format.IsJSON() {
+		return content, nil
+	}
+
+	extracted := ExtractJSON(content)
+	var value interface{}
+	if err := json.Unmarshal([]byte(extracted), &value); err != nil {
+		return content, fmt.Errorf("output is not valid JSON: %v", err)
+	}
+	if errs := ValidateJSONSchema(value, format.EffectiveSchema()); len(errs) > 0 {
+		return extracted, fmt.Errorf("output does not match schema: %s", strings.Join(errs, "; "))
+	}
+	return extracted, nil
+}
+
+// RepairStructuredOutput asks the model once more for output that satisfies
+// the format, quoting the invalid output and the validation error. The
+// returned content is not validated; callers should validate it again.
+func RepairStructuredOutput(ctx context.Context, connector ModelConnector, req ChatCompletionRequest, invalidOutput string, validationErr error) (string, error) {
+	schemaJSON, _ := json.Marshal(req.ResponseFormat.EffectiveSchema())
+
+	req.Messages = append(append([]Message{}, req.Messages...),
+		Message{Role: "assistant", Content: invalidOutput},
+		Message{Role: "user", Content: fmt.Sprintf(
+			"Your previous response was rejected: %v\n\nReply again with only a JSON value that matches this JSON Schema, with no explanation or code fences:\n%s",
+			validationErr, string(schemaJSON))},
+	)
+	req.Stream = false
+	req.Tools = nil
+
+	var repaired strings.Builder
+	err := connector.GenerateChatCompletion(ctx, req, func(cbCtx context.Context, chunk ChatCompletionChunk) error {
+		repaired.WriteString(chunk.Content)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("repair request failed: %w", err)
+	}
+	log.Printf("Structured output repair attempt returned %d bytes for model %s", repaired.Len(), req.Model)
+	return repaired.String(), nil
+}
@@ -0,0 +1,202 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"object", `{"a": 1}`, `{"a": 1}`},
+		{"surrounding whitespace", "\n  [1, 2]\n", `[1, 2]`},
+		{"fenced with a language", "```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"fenced without a language", "```\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"fenced in prose", "Here you go:\n```json\n{\"a\": 1}\n```\nAnything else?", `{"a": 1}`},
+		{"object in prose", `Here is the JSON: {"a": {"b": [1]}}. Let me know!`, `{"a": {"b": [1]}}`},
+		{"array in prose", `The list is [1, 2, 3] as requested.`, `[1, 2, 3]`},
+		{"invalid span in prose", `Use {braces} like {this}`, `Use {braces} like {this}`},
+		{"no JSON", "I cannot help with that.", "I cannot help with that."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractJSON(tt.content); got != tt.want {
+				t.Errorf("ExtractJSON(%q) = %q, want %q", tt.content, got, tt.want)
+			}
+		})
+	}
+}
+
+// personSchema requires a name and allows an age
+var personSchema = map[string]interface{}{
+	"type":                 "object",
+	"required":             []interface{}{"name"},
+	"additionalProperties": false,
+	"properties": map[string]interface{}{
+		"name": map[string]interface{}{"type": "string"},
+		"age":  map[string]interface{}{"type": "integer"},
+	},
+}
+
+func TestValidateStructuredOutput(t *testing.T) {
+	schemaFormat := &ResponseFormat{Type: ResponseFormatJSONSchema, Schema: personSchema}
+	tests := []struct {
+		name    string
+		content string
+		format  *ResponseFormat
+		want    string
+		wantErr string
+	}{
+		{"no format", "Hello", nil, "Hello", ""},
+		{"text", "Hello", &ResponseFormat{Type: ResponseFormatText}, "Hello", ""},
+		{"json_object", `{"x": true}`, &ResponseFormat{Type: ResponseFormatJSONObject}, `{"x": true}`, ""},
+		{"json_object given an array", `[1]`, &ResponseFormat{Type: ResponseFormatJSONObject}, `[1]`, "expected type object, got array"},
+		{"not JSON", "Sure! Ada is 36.", schemaFormat, "", "output is not valid JSON"},
+		{"matching", `{"name": "Ada", "age": 36}`, schemaFormat, `{"name": "Ada", "age": 36}`, ""},
+		{"matching in a fence", "```json\n{\"name\": \"Ada\"}\n```", schemaFormat, `{"name": "Ada"}`, ""},
+		{"matching in prose", `Here it is: {"name": "Ada"}`, schemaFormat, `{"name": "Ada"}`, ""},
+		{"missing property", `{"age": 36}`, schemaFormat, `{"age": 36}`, `missing required property "name"`},
+		{"wrong type", `{"name": "Ada", "age": "36"}`, schemaFormat, `{"name": "Ada", "age": "36"}`, "$.age: expected type integer, got string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateStructuredOutput(tt.content, tt.format)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+			if tt.want != "" && got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseFormatFromConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		wantType string // Empty for no format
+		wantErr  bool
+	}{
+		{"absent", map[string]interface{}{}, "", false},
+		{"shorthand", map[string]interface{}{"response_format": "json"}, ResponseFormatJSONObject, false},
+		{"object", map[string]interface{}{"response_format": map[string]interface{}{"type": "json_schema", "schema": personSchema}}, ResponseFormatJSONSchema, false},
+		{"schema missing", map[string]interface{}{"response_format": map[string]interface{}{"type": "json_schema"}}, "", true},
+		{"unknown type", map[string]interface{}{"response_format": "yaml"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := ResponseFormatFromConfig(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			gotType := ""
+			if format != nil {
+				gotType = format.Type
+			}
+			if !tt.wantErr && gotType != tt.wantType {
+				t.Errorf("type = %q, want %q", gotType, tt.wantType)
+			}
+		})
+	}
+}
+
+// stubConnector replies with its reply, in two chunks, recording the requests
+type stubConnector struct {
+	reply    string
+	err      error
+	requests []ChatCompletionRequest
+}
+
+func (c *stubConnector) GenerateChatCompletion(ctx context.Context, req ChatCompletionRequest, callback ChunkCallback) error {
+	c.requests = append(c.requests, req)
+	if c.err != nil {
+		return c.err
+	}
+	half := len(c.reply) / 2
+	if err := callback(ctx, ChatCompletionChunk{Content: c.reply[:half]}); err != nil {
+		return err
+	}
+	return callback(ctx, ChatCompletionChunk{Content: c.reply[half:], IsFinal: true})
+}
+
+func (c *stubConnector) HealthCheck(ctx context.Context) error { return nil }
+
+func (c *stubConnector) GetType() models.ProviderType { return models.ProviderOpenAI }
+
+func TestRepairStructuredOutput(t *testing.T) {
+	format := &ResponseFormat{Type: ResponseFormatJSONSchema, Schema: personSchema, Repair: true}
+	tests := []struct {
+		name        string
+		reply       string
+		err         error
+		wantErr     string // Error of the repair request
+		wantInvalid string // Error validating the repaired output
+	}{
+		{"repaired", `{"name": "Ada", "age": 36}`, nil, "", ""},
+		{"repaired in a fence", "```json\n{\"name\": \"Ada\"}\n```", nil, "", ""},
+		{"still invalid", `{"name": "Ada", "nickname": "A"}`, nil, "", `unexpected property "nickname"`},
+		{"request failed", "", errors.New("rate limited"), "repair request failed: rate limited", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ChatCompletionRequest{
+				Model:          "gpt",
+				Messages:       []Message{{Role: "user", Content: "Describe Ada as JSON"}},
+				Stream:         true,
+				Tools:          []ToolDefinition{{Name: "search"}},
+				ResponseFormat: format,
+			}
+			invalid := `{"name": 7}`
+			_, validationErr := ValidateStructuredOutput(invalid, format)
+			if validationErr == nil {
+				t.Fatal("the first output should be invalid")
+			}
+
+			connector := &stubConnector{reply: tt.reply, err: tt.err}
+			repaired, err := RepairStructuredOutput(context.Background(), connector, req, invalid, validationErr)
+			if len(connector.requests) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(connector.requests))
+			}
+			sent := connector.requests[0]
+			if sent.Stream || sent.Tools != nil {
+				t.Errorf("repair request has Stream %v and tools %v, want neither", sent.Stream, sent.Tools)
+			}
+			if len(sent.Messages) != 3 || sent.Messages[1].Role != "assistant" || sent.Messages[1].Content != invalid ||
+				sent.Messages[2].Role != "user" || !strings.Contains(sent.Messages[2].Content, "$.name: expected type string") {
+				t.Errorf("repair messages = %+v, want the invalid output and the validation error", sent.Messages)
+			}
+			if len(req.Messages) != 1 {
+				t.Errorf("the original request now has %d messages", len(req.Messages))
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RepairStructuredOutput: %v", err)
+			}
+			_, err = ValidateStructuredOutput(repaired, format)
+			if tt.wantInvalid == "" && err != nil {
+				t.Errorf("repaired output %q is invalid: %v", repaired, err)
+			}
+			if tt.wantInvalid != "" && (err == nil || !strings.Contains(err.Error(), tt.wantInvalid)) {
+				t.Errorf("validating %q: error = %v, want it to contain %q", repaired, err, tt.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SamplingParams holds optional generation parameters beyond temperature.
+// Nil / empty fields are left to the provider's defaults, or for MaxTokens to
+// the model's max tokens.
+//
+// They can be set on a model's configuration, overridden by an agent's
+// configuration and again by an individual request; see ResolveSamplingParams.
+type SamplingParams struct {
+	MaxTokens        *int     `json:"max_tokens,omitempty"` // Agents and requests only: replaces the model's max tokens
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	RepeatPenalty    *float64 `json:"repeat_penalty,omitempty"` // Ollama only
+	NumCtx           *int     `json:"num_ctx,omitempty"`        // Ollama only: context window size
+	KeepAlive        string   `json:"keep_alive,omitempty"`     // Ollama only: e.g. "10m", "-1" or "0"
+}
+
+// SamplingParamsFromConfig reads sampling parameters from the top-level keys
+// of a model or agent configuration (e.g. {"top_p": 0.9, "stop": ["###"]}).
+// Unrelated keys are ignored.
+func SamplingParamsFromConfig(config map[string]interface{}) (SamplingParams, error) {
+	var params SamplingParams
+	if len(config) == 0 {
+		return params, nil
+	}
+
+	// "stop" may be given as a single string
+	if stop, ok := config["stop"].(string); ok {
+		config = copyConfig(config)
+		config["stop"] = []string{stop}
+	}
+	// keep_alive may be given as a number of seconds
+	if keepAlive, ok := config["keep_alive"].(float64); ok {
+		config = copyConfig(config)
+		config["keep_alive"] = strconv.FormatFloat(keepAlive, 'f', -1, 64)
+	}
+
+	data, err := json.Marshal(config)
+	if err != nil {
+		return params, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return SamplingParams{}, fmt.Errorf("invalid sampling parameters: %w", err)
+	}
+	return params, params.Validate()
+}
+
+func copyConfig(config map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Validate checks the parameters are within the ranges providers accept.
+func (p SamplingParams) Validate() error {
+	if p.MaxTokens != nil && *p.MaxTokens <= 0 {
+		return fmt.Errorf("max_tokens must be positive")
+	}
+	if p.TopP != nil && (*p.TopP < 0 || *p.TopP > 1) {
+		return fmt.Errorf("top_p must be between 0 and 1")
+	}
+	if p.TopK != nil && *p.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative")
+	}
+	if p.PresencePenalty != nil && (*p.PresencePenalty < -2 || *p.PresencePenalty > 2) {
+		return fmt.Errorf("presence_penalty must be between -2 and 2")
+	}
+	if p.FrequencyPenalty != nil && (*p.FrequencyPenalty < -2 || *p.FrequencyPenalty > 2) {
+		return fmt.Errorf("frequency_penalty must be between -2 and 2")
+	}
+	if p.RepeatPenalty != nil && *p.RepeatPenalty < 0 {
+		return fmt.Errorf("repeat_penalty must not be negative")
+	}
+	if p.NumCtx != nil && *p.NumCtx <= 0 {
+		return fmt.Errorf("num_ctx must be positive")
+	}
+	if p.KeepAlive != "" {
+		if _, err := strconv.ParseFloat(p.KeepAlive, 64); err != nil {
+			if _, err := time.ParseDuration(p.KeepAlive); err != nil {
+				return fmt.Errorf("keep_alive must be a duration (e.g. \"10m\") or a number of seconds")
+			}
+		}
+	}
+	return nil
+}
+
+// Merge returns p with every parameter set in override replacing p's value.
+func (p SamplingParams) Merge(override SamplingParams) SamplingParams {
+	if override.MaxTokens != nil {
+		p.MaxTokens = override.MaxTokens
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		p.TopK = override.TopK
+	}
+	if override.Stop != nil {
+		p.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	if override.PresencePenalty != nil {
+		p.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		p.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.RepeatPenalty != nil {
+		p.RepeatPenalty = override.RepeatPenalty
+	}
+	if override.NumCtx != nil {
+		p.NumCtx = override.NumCtx
+	}
+	if override.KeepAlive != "" {
+		p.KeepAlive = override.KeepAlive
+	}
+	return p
+}
+
+// setParams returns the JSON names of the parameters that are set, other than
+// max_tokens, which every provider supports.
+func (p SamplingParams) setParams() []string {
+	var names []string
+	if p.TopP != nil {
+		names = append(names, "top_p")
+	}
+	if p.TopK != nil {
+		names = append(names, "top_k")
+	}
+	if len(p.Stop) > 0 {
+		names = append(names, "stop")
+	}
+	if p.Seed != nil {
+		names = append(names, "seed")
+	}
+	if p.PresencePenalty != nil {
+		names = append(names, "presence_penalty")
+	}
+	if p.FrequencyPenalty != nil {
+		names = append(names, "frequency_penalty")
+	}
+	if p.RepeatPenalty != nil {
+		names = append(names, "repeat_penalty")
+	}
+	if p.NumCtx != nil {
+		names = append(names, "num_ctx")
+	}
+	if p.KeepAlive != "" {
+		names = append(names, "keep_alive")
+	}
+	return names
+}
+
+// warnUnsupportedSampling logs the set parameters a provider cannot map.
+func warnUnsupportedSampling(provider, model string, p SamplingParams, supported ...string) {
+	var ignored []string
+	for _, name := range p.setParams() {
+		if !matchesAny(name, supported) {
+			ignored = append(ignored, name)
+		}
+	}
+	if len(ignored) > 0 {
+		log.Printf("Warning: %s does not support sampling parameters %s; ignoring them for model %s", provider, strings.Join(ignored, ", "), model)
+	}
+}
@@ -0,0 +1,243 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// Defaults for rolling chat summaries.
+const (
+	defaultSummaryThreshold = 0.75 // Fraction of the context budget unsummarised history may use
+	summaryKeepFraction     = 0.5  // Fraction of the budget kept as verbatim recent turns after summarising
+	summaryMaxTokens        = 1024 // Output limit for a summary (at most a quarter of the summariser's window)
+	maxSummaryRounds        = 5    // Chunks summarised per update when the backlog exceeds the summariser's window
+)
+
+const summarySystemPrompt = `You maintain a running summary of a conversation between a user and an AI assistant.
+Update the summary with the new messages. Keep facts, decisions, names, numbers, code identifiers, open questions and the user's preferences; drop pleasantries and repetition.
+Write the summary in the third person as concise prose or bullet points, with no preamble. Reply with the updated summary only.`
+
+// SummaryConfig controls rolling summaries for chats using a model. It is read
+// from the model's configuration.
+type SummaryConfig struct {
+	Enabled   bool    // "summarize": false turns summaries off
+	ModelID   int64   // "summary_model_id": model that writes the summary (defaults to the chat's model)
+	Threshold float64 // "summary_threshold": fraction of the context budget that triggers a summary
+}
+
+// SummaryConfigFromModel reads the summary settings of a model.
+func SummaryConfigFromModel(model *models.Model) SummaryConfig {
+	cfg := SummaryConfig{Enabled: true, ModelID: model.ID, Threshold: defaultSummaryThreshold}
+	if enabled, ok := model.Configuration["summarize"].(bool); ok {
+		cfg.Enabled = enabled
+	}
+	if id := configInt(model.Configuration, "summary_model_id"); id > 0 {
+		cfg.ModelID = int64(id)
+	}
+	if threshold, ok := model.Configuration["summary_threshold"].(float64); ok && threshold > 0 && threshold <= 1 {
+		cfg.Threshold = threshold
+	}
+	return cfg
+}
+
+// summaryContextPrefix introduces the summary in the system prompt.
+const summaryContextPrefix = "Summary of the earlier conversation:\n"
+
+// UpdateChatSummary folds older turns of a chat into its rolling summary once
+// the unsummarised history uses more than the threshold of the chat model's
+// context budget. The most recent turns (about half the budget) are left
+// verbatim. The existing summary, including any edits by the chat owner, is
+// extended rather than rewritten from scratch.
+//
+// It returns the updated summary, or nil if no update was needed. Concurrent
+// updates of the same chat are skipped.
+func (s *ConnectorService) UpdateChatSummary(ctx context.Context, chatID, modelID int64) (*models.ChatSummary, error) {
+	if _, running := s.summarizing.LoadOrStore(chatID, true); running {
+		return nil, nil
+	}
+	defer s.summarizing.Delete(chatID)
+
+	chatModel, err := s.modelService.GetModelByID(modelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model details: %w", err)
+	}
+	cfg := SummaryConfigFromModel(chatModel)
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	contextSvc := s.chatContextService
+	chatService := contextSvc.chatService
+	estimator := contextSvc.estimator
+
+	window := ContextWindowForModel(chatModel)
+	budget := window - ReservedOutputTokens(chatModel, window, chatModel.MaxTokens)
+
+	// A summary of another branch is replaced by one of the active branch
+	summary, err := chatService.GetBranchSummary(chatID, 0)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := chatService.GetMessageHistory(chatID, maxHistoryMessages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve chat history: %w", err)
+	}
+	pending := unsummarizedMessages(messages, summary)
+
+	used := 0
+	if summary != nil {
+		used += estimator.EstimateTokens(summary.Content)
+	}
+	for _, msg := range pending {
+		used += estimator.EstimateTokens(msg.Content) + messageTokenOverhead
+	}
+	if float64(used) <= cfg.Threshold*float64(budget) {
+		return nil, nil
+	}
+
+	// Keep the newest turns verbatim, starting at a user message
+	keep, kept := len(pending), 0
+	for keep > 0 {
+		tokens := estimator.EstimateTokens(pending[keep-1].Content) + messageTokenOverhead
+		if float64(kept+tokens) > summaryKeepFraction*float64(budget) {
+			break
+		}
+		kept += tokens
+		keep--
+	}
+	for keep < len(pending) && pending[keep].Role != "user" {
+		keep++
+	}
+	toSummarize := pending[:keep]
+	if len(toSummarize) == 0 {
+		return nil, nil
+	}
+
+	connector, summaryModel, err := s.GetConnectorForModel(ctx, cfg.ModelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get summariser model: %w", err)
+	}
+	summaryWindow := ContextWindowForModel(summaryModel)
+	maxTokens := min(summaryMaxTokens, summaryWindow/4)
+	summaryBudget := summaryWindow - maxTokens - estimator.EstimateTokens(summarySystemPrompt)
+
+	current := ""
+	if summary != nil {
+		current = summary.Content
+	}
+
+	log.Printf("[Chat %d] Summarising %d older messages (~%d tokens of history, budget %d) with model %s", chatID, len(toSummarize), used, budget, summaryModel.ModelID)
+	for round := 0; round < maxSummaryRounds && len(toSummarize) > 0; round++ {
+		// Take as many of the oldest messages as fit in the summariser's window
+		available := summaryBudget - estimator.EstimateTokens(current)
+		n := 0
+		for n < len(toSummarize) {
+			tokens := estimator.EstimateTokens(toSummarize[n].Content) + messageTokenOverhead
+			if n > 0 && tokens > available {
+				break
+			}
+			available -= tokens
+			n++
+		}
+		// End the chunk before a user message so the turns left unsummarised
+		// open with the user
+		for n > 1 && n < len(toSummarize) && toSummarize[n].Role != "user" {
+			n--
+		}
+		chunk := toSummarize[:n]
+		toSummarize = toSummarize[n:]
+
+		updated, err := generateSummary(ctx, connector, summaryModel, maxTokens, current, chunk)
+		if err != nil {
+			return nil, err
+		}
+		current = updated
+
+		if summary == nil {
+			summary = &models.ChatSummary{ChatID: chatID}
+		}
+		summary.Content = current
+		summary.ThroughMessageID = chunk[len(chunk)-1].ID
+		summary.ModelID = &summaryModel.ID
+		summary.IsEdited = false
+		if err := chatService.SaveChatSummary(summary); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("[Chat %d] Chat summary updated through message %d (%d characters)", chatID, summary.ThroughMessageID, len(summary.Content))
+	return summary, nil
+}
+
+// generateSummary asks the summariser model to extend the summary with the
+// given messages.
+func generateSummary(ctx context.Context, connector ModelConnector, model *models.Model, maxTokens int, current string, messages []models.Message) (string, error) {
+	var prompt strings.Builder
+	if current != "" {
+		prompt.WriteString("Current summary:\n")
+		prompt.WriteString(current)
+		prompt.WriteString("\n\n")
+	}
+	prompt.WriteString("New messages:\n")
+	for _, msg := range messages {
+		fmt.Fprintf(&prompt, "\n[%s]: %s\n", msg.Role, msg.Content)
+	}
+
+	req := ChatCompletionRequest{
+		Model: model.ModelID,
+		Messages: []Message{
+			{Role: "system", Content: summarySystemPrompt},
+			{Role: "user", Content: prompt.String()},
+		},
+		Temperature: 0.2,
+		MaxTokens:   maxTokens,
+		Stream:      false,
+	}
+
+	var output strings.Builder
+	err := connector.GenerateChatCompletion(ctx, req, func(cbCtx context.Context, chunk ChatCompletionChunk) error {
+		output.WriteString(chunk.Content)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("summary request failed: %w", err)
+	}
+
+	content, _ := SplitReasoning(output.String())
+	if content == "" {
+		return "", fmt.Errorf("summariser model %s returned an empty summary", model.ModelID)
+	}
+	return content, nil
+}
+
+// summarizedCount returns how many of a branch's messages (oldest first) the
+// summary covers: those up to its last message, if they include it. The
+// summary must apply to the branch (see ChatService.GetBranchSummary).
+func summarizedCount(messages []models.Message, summary *models.ChatSummary) int {
+	if summary == nil || summary.ThroughMessageID == 0 {
+		return 0
+	}
+	for i, msg := range messages {
+		if msg.ID == summary.ThroughMessageID {
+			return i + 1
+		}
+	}
+	return 0 // The summary ends before the oldest message
+}
+
+// unsummarizedMessages returns the non-system messages of a branch after
+// those the summary covers.
+func unsummarizedMessages(messages []models.Message, summary *models.ChatSummary) []models.Message {
+	messages = messages[summarizedCount(messages, summary):]
+	pending := make([]models.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == "system" {
+			continue
+		}
+		pending = append(pending, msg)
+	}
+	return pending
+}
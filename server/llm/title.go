@@ -0,0 +1,157 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// Limits for generated chat titles.
+const (
+	titleMaxTokens     = 32   // Output limit for a title (leaves room for stray reasoning)
+	titleMaxLength     = 80   // Longest title kept, in bytes
+	titlePromptMaxText = 2000 // Bytes of the first message and reply shown to the title model
+)
+
+const titleSystemPrompt = `You write titles for conversations between a user and an AI assistant.
+Reply with a title of at most six words that says what the conversation is about. Use the language of the conversation.
+Reply with the title only: no quotes, no "Title:" prefix and no final punctuation.`
+
+// TitleConfig controls automatic titles for chats using a model. It is read
+// from the model's configuration.
+type TitleConfig struct {
+	Enabled bool  // "generate_title": false keeps the default titles
+	ModelID int64 // "title_model_id": model that writes the title (defaults to the chat's model)
+}
+
+// TitleConfigFromModel reads the title settings of a model.
+func TitleConfigFromModel(model *models.Model) TitleConfig {
+	cfg := TitleConfig{Enabled: true, ModelID: model.ID}
+	if enabled, ok := model.Configuration["generate_title"].(bool); ok {
+		cfg.Enabled = enabled
+	}
+	if id := configInt(model.Configuration, "title_model_id"); id > 0 {
+		cfg.ModelID = int64(id)
+	}
+	return cfg
+}
+
+// GenerateChatTitle asks the title model to name a chat from its first
+// message and reply, and saves the title with UpdateChatTitle. Only chats
+// still carrying a default title are named, so titles the user set are
+// never overwritten and a chat is named once.
+//
+// It returns the new title, or "" if the chat was not renamed. Concurrent
+// requests for the same chat are skipped.
+func (s *ConnectorService) GenerateChatTitle(ctx context.Context, chatID, modelID int64) (string, error) {
+	if _, running := s.titling.LoadOrStore(chatID, true); running {
+		return "", nil
+	}
+	defer s.titling.Delete(chatID)
+
+	chatService := s.chatContextService.chatService
+	chat, err := chatService.GetChat(chatID, false)
+	if err != nil {
+		return "", err
+	}
+	if chat.TitleSource != models.TitleSourceDefault {
+		return "", nil
+	}
+
+	chatModel, err := s.modelService.GetModelByID(modelID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get model details: %w", err)
+	}
+	if chatModel == nil {
+		return "", fmt.Errorf("model with ID %d not found", modelID)
+	}
+	cfg := TitleConfigFromModel(chatModel)
+	if !cfg.Enabled {
+		return "", nil
+	}
+
+	// The first user message and the first reply to it
+	messages, err := chatService.GetMessageHistory(chatID, maxHistoryMessages)
+	if err != nil {
+		return "", fmt.Errorf("failed to retrieve chat history: %w", err)
+	}
+	var question, answer string
+	for _, msg := range messages {
+		if msg.Role == "user" && question == "" {
+			question = msg.Content
+		} else if msg.Role == "assistant" && question != "" {
+			answer = msg.Content
+			break
+		}
+	}
+	if question == "" || answer == "" {
+		return "", nil
+	}
+
+	connector, titleModel, err := s.GetConnectorForModel(ctx, cfg.ModelID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get title model: %w", err)
+	}
+
+	req := ChatCompletionRequest{
+		Model: titleModel.ModelID,
+		Messages: []Message{
+			{Role: "system", Content: titleSystemPrompt},
+			{Role: "user", Content: fmt.Sprintf("[user]: %s\n\n[assistant]: %s", truncateText(question, titlePromptMaxText), truncateText(answer, titlePromptMaxText))},
+		},
+		Temperature: 0.2,
+		MaxTokens:   titleMaxTokens,
+		Stream:      false,
+	}
+	var output strings.Builder
+	err = connector.GenerateChatCompletion(ctx, req, func(cbCtx context.Context, chunk ChatCompletionChunk) error {
+		output.WriteString(chunk.Content)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("title request failed: %w", err)
+	}
+
+	content, _ := SplitReasoning(output.String())
+	title := cleanTitle(content)
+	if title == "" {
+		return "", fmt.Errorf("title model %s returned an empty title", titleModel.ModelID)
+	}
+
+	changed, err := chatService.UpdateChatTitle(chatID, title, models.TitleSourceGenerated)
+	if err != nil || !changed {
+		return "", err
+	}
+	log.Printf("[Chat %d] Generated title %q with model %s", chatID, title, titleModel.ModelID)
+	return title, nil
+}
+
+// cleanTitle keeps the first line of the model's answer, without the quotes,
+// label and final punctuation models tend to add anyway.
+func cleanTitle(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(s, "Title:"), "title:"))
+	s = strings.TrimLeft(s, "\"'`*#“‘ ")
+	s = strings.TrimRight(s, "\"'`*”’.!。 ")
+	return truncateText(s, titleMaxLength)
+}
+
+// truncateText shortens s to at most max bytes without splitting a character,
+// marking the cut with an ellipsis.
+func truncateText(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := max - len("…")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
@@ -0,0 +1,109 @@
+package llm
+
+import (
+	"unicode/utf8"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+// TokenEstimator estimates how many tokens a piece of text uses. Exact
+// counts depend on each model's tokenizer; an estimator only needs to be
+// close enough (and preferably on the high side) to budget the context.
+type TokenEstimator interface {
+	EstimateTokens(text string) int
+}
+
+// HeuristicTokenEstimator approximates BPE tokenizers without a vocabulary:
+// about four ASCII characters per token, and one token per non-ASCII rune
+// (CJK text and emoji rarely merge into longer tokens).
+type HeuristicTokenEstimator struct{}
+
+// EstimateTokens implements TokenEstimator.
+func (HeuristicTokenEstimator) EstimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// messageTokenOverhead covers the role and separator tokens that chat
+// templates add around every message.
+const messageTokenOverhead = 4
+
+// estimateMessageTokens estimates the tokens a message adds to a request.
+func estimateMessageTokens(estimator TokenEstimator, msg Message) int {
+	return estimator.EstimateTokens(msg.Content) + messageTokenOverhead
+}
+
+// Default context window sizes (in tokens) when a model does not configure
+// one. Ollama's is the server default num_ctx, beyond which it silently
+// drops the start of the prompt.
+var defaultContextWindows = map[models.ProviderType]int{
+	models.ProviderOllama:    4096,
+	models.ProviderOpenAI:    128000,
+	models.ProviderAnthropic: 200000,
+}
+
+const fallbackContextWindow = 8192
+
+// ContextWindowForModel returns the model's context window in tokens: the
+// "context_window" entry of its configuration, else (for Ollama) its
+// "num_ctx" option, else a default for the provider.
+func ContextWindowForModel(model *models.Model) int {
+	if n := configInt(model.Configuration, "context_window"); n > 0 {
+		return n
+	}
+	if model.Provider != nil && model.Provider.Type == models.ProviderOllama {
+		if n := configInt(model.Configuration, "num_ctx"); n > 0 {
+			return n
+		}
+	}
+	if model.Provider != nil {
+		if n, ok := defaultContextWindows[model.Provider.Type]; ok {
+			return n
+		}
+	}
+	return fallbackContextWindow
+}
+
+// MaxTokensFor returns the output limit of a request to the model with the
+// given sampling parameters: their max tokens if set, else the model's.
+func MaxTokensFor(model *models.Model, params SamplingParams) int {
+	if params.MaxTokens != nil {
+		return *params.MaxTokens
+	}
+	return model.MaxTokens
+}
+
+// ReservedOutputTokens returns how much of the context window to keep free
+// for a response of up to maxTokens: the "reserved_output_tokens" entry of
+// the model's configuration, else maxTokens plus any thinking budget. Since
+// max tokens is often set to the full window size, the default is capped at
+// half the window.
+func ReservedOutputTokens(model *models.Model, contextWindow, maxTokens int) int {
+	if n := configInt(model.Configuration, "reserved_output_tokens"); n > 0 {
+		return n
+	}
+	reserved := maxTokens + ThinkingBudgetFromConfig(model.Configuration)
+	if reserved > contextWindow/2 {
+		reserved = contextWindow / 2
+	}
+	return reserved
+}
+
+// configInt reads an integer entry of a JSON configuration map; 0 if absent
+// or not a number.
+func configInt(config map[string]interface{}, key string) int {
+	switch v := config[key].(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
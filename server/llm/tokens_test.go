@@ -0,0 +1,87 @@
+package llm
+
+import (
+	"testing"
+
+	"github.com/ramborogers/cyberai/server/models"
+)
+
+func TestHeuristicTokenEstimator(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"a", 1},
+		{"abcd", 1},
+		{"abcde", 2},
+		{"日本語", 3},
+		{"Go 🚀", 2},
+	}
+	for _, tt := range tests {
+		if got := (HeuristicTokenEstimator{}).EstimateTokens(tt.text); got != tt.want {
+			t.Errorf("EstimateTokens(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContextWindowForModel(t *testing.T) {
+	ollama := &models.Provider{Type: models.ProviderOllama}
+	openAI := &models.Provider{Type: models.ProviderOpenAI}
+	tests := []struct {
+		name  string
+		model models.Model
+		want  int
+	}{
+		{"configured", models.Model{Provider: openAI, Configuration: models.Configuration{"context_window": 32000.0}}, 32000},
+		{"configured over num_ctx", models.Model{Provider: ollama, Configuration: models.Configuration{"context_window": 16384.0, "num_ctx": 8192.0}}, 16384},
+		{"Ollama num_ctx", models.Model{Provider: ollama, Configuration: models.Configuration{"num_ctx": 8192.0}}, 8192},
+		{"num_ctx of another provider", models.Model{Provider: openAI, Configuration: models.Configuration{"num_ctx": 8192.0}}, 128000},
+		{"Ollama default", models.Model{Provider: ollama}, 4096},
+		{"Anthropic default", models.Model{Provider: &models.Provider{Type: models.ProviderAnthropic}}, 200000},
+		{"not a number", models.Model{Provider: openAI, Configuration: models.Configuration{"context_window": "large"}}, 128000},
+		{"unknown provider", models.Model{}, fallbackContextWindow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContextWindowForModel(&tt.model); got != tt.want {
+				t.Errorf("ContextWindowForModel = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxTokensFor(t *testing.T) {
+	model := &models.Model{MaxTokens: 2048}
+	if got := MaxTokensFor(model, SamplingParams{}); got != 2048 {
+		t.Errorf("MaxTokensFor without a limit = %d, want the model's 2048", got)
+	}
+	limit := 512
+	if got := MaxTokensFor(model, SamplingParams{MaxTokens: &limit}); got != 512 {
+		t.Errorf("MaxTokensFor with a limit = %d, want 512", got)
+	}
+}
+
+func TestReservedOutputTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    models.Configuration
+		window    int
+		maxTokens int
+		want      int
+	}{
+		{"max tokens", nil, 8000, 1000, 1000},
+		{"max tokens and thinking budget", models.Configuration{"thinking_budget": 2000.0}, 8000, 1000, 3000},
+		{"capped at half the window", nil, 8000, 8000, 4000},
+		{"thinking budget capped", models.Configuration{"thinking_budget": 4000.0}, 8000, 1000, 4000},
+		{"configured", models.Configuration{"reserved_output_tokens": 6000.0}, 8000, 1000, 6000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := &models.Model{Configuration: tt.config}
+			if got := ReservedOutputTokens(model, tt.window, tt.maxTokens); got != tt.want {
+				t.Errorf("ReservedOutputTokens = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
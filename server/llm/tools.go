@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// maxToolRounds limits how many request/tool-result round trips a single
+// response may take before the model is asked to answer without tools.
+const maxToolRounds = 8
+
+// ToolExecutor runs tool calls requested by a model.
+type ToolExecutor interface {
+	// ExecuteTool runs the call and returns the text result to feed back to the model.
+	// A returned error is reported to the model as the tool result.
+	ExecuteTool(ctx context.Context, call ToolCall) (string, error)
+}
+
+// GenerateWithTools drives a chat completion that may call tools. Each time
+// the model requests tool calls they are run via the executor, the results
+// are appended to the conversation and the model is called again. Text and
+// reasoning chunks from every round are passed to callback; only the last
+// round's final chunk is delivered with IsFinal set.
+//
+// Without tools or an executor this is equivalent to calling
+// connector.GenerateChatCompletion directly.
+func GenerateWithTools(ctx context.Context, connector ModelConnector, req ChatCompletionRequest, callback ChunkCallback, executor ToolExecutor) error {
+	if executor == nil || len(req.Tools) == 0 {
+		return connector.GenerateChatCompletion(ctx, req, callback)
+	}
+
+	// Every round is billed; the total is reported on the last final chunk
+	var usage TokenUsage
+
+	for round := 0; ; round++ {
+		if round == maxToolRounds {
+			log.Printf("Tool round limit (%d) reached for model %s, requesting final answer without tools", maxToolRounds, req.Model)
+			req.Tools = nil
+		}
+
+		var calls []ToolCall
+		var text, reasoning strings.Builder
+		var signature string
+		var finalChunk *ChatCompletionChunk
+
+		roundCallback := func(cbCtx context.Context, chunk ChatCompletionChunk) error {
+			calls = append(calls, chunk.ToolCalls...)
+			chunk.ToolCalls = nil
+			text.WriteString(chunk.Content)
+			reasoning.WriteString(chunk.Reasoning)
+			if chunk.ReasoningSignature != "" {
+				signature = chunk.ReasoningSignature
+			}
+
+			// Hold back the final chunk until we know whether more rounds follow.
+			usage.Add(chunk.Usage)
+			chunk.Usage = nil
+			if chunk.IsFinal {
+				c := chunk
+				finalChunk = &c
+				return nil
+			}
+			if chunk.Content == "" && chunk.Reasoning == "" {
+				return nil
+			}
+			return callback(cbCtx, chunk)
+		}
+
+		if err := connector.GenerateChatCompletion(ctx, req, roundCallback); err != nil {
+			return err
+		}
+
+		if len(calls) == 0 {
+			if finalChunk == nil && usage != (TokenUsage{}) {
+				finalChunk = &ChatCompletionChunk{IsFinal: true}
+			}
+			if finalChunk != nil {
+				if usage != (TokenUsage{}) {
+					finalChunk.Usage = &usage
+				}
+				return callback(ctx, *finalChunk)
+			}
+			return nil
+		}
+
+		// Flush any text carried on the held-back chunk without ending the stream.
+		if finalChunk != nil && (finalChunk.Content != "" || finalChunk.Reasoning != "") {
+			finalChunk.IsFinal = false
+			if err := callback(ctx, *finalChunk); err != nil {
+				return err
+			}
+		}
+
+		req.Messages = append(req.Messages, Message{
+			Role:               "assistant",
+			Content:            text.String(),
+			ToolCalls:          calls,
+			Reasoning:          reasoning.String(),
+			ReasoningSignature: signature,
+		})
+		for _, call := range calls {
+			result, err := executor.ExecuteTool(ctx, call)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				result = fmt.Sprintf("Error: %v", err)
+			}
+			req.Messages = append(req.Messages, Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallID: call.ID,
+				Name:       call.Name,
+			})
+		}
+	}
+}
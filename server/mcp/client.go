@@ -0,0 +1,204 @@
+// Package mcp implements a minimal Model Context Protocol client used to
+// discover and invoke tools exposed by external MCP servers.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// ProtocolVersion is the MCP protocol revision this client speaks.
+const ProtocolVersion = "2025-03-26"
+
+// Transport moves JSON-RPC messages between the client and an MCP server.
+type Transport interface {
+	// Call sends a request and waits for the matching response.
+	Call(ctx context.Context, req *Request) (*Response, error)
+	// Notify sends a notification (no response expected).
+	Notify(ctx context.Context, req *Request) error
+	// Close releases any resources held by the transport.
+	Close() error
+}
+
+// Request is a JSON-RPC 2.0 request or notification.
+type Request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      *int64      `json:"id,omitempty"` // nil for notifications
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response.
+type Response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      *int64          `json:"id,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is a JSON-RPC 2.0 error object.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("mcp error %d: %s", e.Code, e.Message)
+}
+
+// Implementation identifies a client or server.
+type Implementation struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// InitializeResult is the server's answer to the initialize request.
+type InitializeResult struct {
+	ProtocolVersion string                 `json:"protocolVersion"`
+	Capabilities    map[string]interface{} `json:"capabilities"`
+	ServerInfo      Implementation         `json:"serverInfo"`
+	Instructions    string                 `json:"instructions,omitempty"`
+}
+
+// Tool is a tool advertised by an MCP server.
+type Tool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+// ListToolsResult is one page of the tools/list response.
+type ListToolsResult struct {
+	Tools      []Tool `json:"tools"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// Content is a single content item returned by a tool call.
+type Content struct {
+	Type     string `json:"type"` // "text", "image", "resource", ...
+	Text     string `json:"text,omitempty"`
+	MimeType string `json:"mimeType,omitempty"`
+}
+
+// CallToolResult is the response to tools/call.
+type CallToolResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Text concatenates the text items of the result. Non-text items are
+// summarised so the model knows something was returned.
+func (r *CallToolResult) Text() string {
+	var out string
+	for i, c := range r.Content {
+		if i > 0 {
+			out += "\n"
+		}
+		if c.Type == "text" {
+			out += c.Text
+		} else {
+			out += fmt.Sprintf("[%s content (%s) omitted]", c.Type, c.MimeType)
+		}
+	}
+	return out
+}
+
+// Client is an MCP client bound to a single server over a Transport.
+type Client struct {
+	transport Transport
+	nextID    atomic.Int64
+
+	// ServerInfo is populated by Initialize.
+	ServerInfo Implementation
+}
+
+// NewClient creates a client using the given transport. Initialize must be
+// called before any other method.
+func NewClient(t Transport) *Client {
+	return &Client{transport: t}
+}
+
+// call performs a request and decodes the result into out (if non-nil).
+func (c *Client) call(ctx context.Context, method string, params interface{}, out interface{}) error {
+	id := c.nextID.Add(1)
+	resp, err := c.transport.Call(ctx, &Request{JSONRPC: "2.0", ID: &id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", method, err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out != nil && len(resp.Result) > 0 {
+		if err := json.Unmarshal(resp.Result, out); err != nil {
+			return fmt.Errorf("failed to decode %s result: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// Initialize performs the MCP handshake.
+func (c *Client) Initialize(ctx context.Context) (*InitializeResult, error) {
+	params := map[string]interface{}{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      Implementation{Name: "cyberai", Version: "1.0"},
+	}
+
+	var result InitializeResult
+	if err := c.call(ctx, "initialize", params, &result); err != nil {
+		return nil, err
+	}
+	c.ServerInfo = result.ServerInfo
+	if result.ProtocolVersion != ProtocolVersion {
+		log.Printf("MCP server %s negotiated protocol version %s (requested %s)", result.ServerInfo.Name, result.ProtocolVersion, ProtocolVersion)
+	}
+
+	if err := c.transport.Notify(ctx, &Request{JSONRPC: "2.0", Method: "notifications/initialized"}); err != nil {
+		return nil, fmt.Errorf("failed to send initialized notification: %w", err)
+	}
+	return &result, nil
+}
+
+// ListTools returns every tool advertised by the server, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		var params map[string]interface{}
+		if cursor != "" {
+			params = map[string]interface{}{"cursor": cursor}
+		}
+		var page ListToolsResult
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, err
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool invokes a tool with the given arguments.
+func (c *Client) CallTool(ctx context.Context, name string, arguments map[string]interface{}) (*CallToolResult, error) {
+	if arguments == nil {
+		arguments = map[string]interface{}{}
+	}
+	params := map[string]interface{}{"name": name, "arguments": arguments}
+
+	var result CallToolResult
+	if err := c.call(ctx, "tools/call", params, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Close shuts down the underlying transport.
+func (c *Client) Close() error {
+	return c.transport.Close()
+}
@@ -0,0 +1,179 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPTransport implements the MCP "streamable HTTP" transport: every message
+// is POSTed to a single endpoint, and responses come back either as a JSON
+// body or as a server-sent event stream.
+type HTTPTransport struct {
+	url        string
+	headers    map[string]string
+	httpClient *http.Client
+
+	mu        sync.Mutex
+	sessionID string
+}
+
+// NewHTTPTransport creates a transport for the given endpoint URL. headers are
+// sent with every request (e.g. Authorization).
+func NewHTTPTransport(url string, headers map[string]string, timeout time.Duration) (*HTTPTransport, error) {
+	if url == "" {
+		return nil, fmt.Errorf("http transport requires a URL")
+	}
+	if timeout == 0 {
+		timeout = 60 * time.Second
+	}
+	return &HTTPTransport{
+		url:        url,
+		headers:    headers,
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (t *HTTPTransport) newRequest(ctx context.Context, method string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	req.Header.Set("MCP-Protocol-Version", ProtocolVersion)
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	t.mu.Lock()
+	if t.sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", t.sessionID)
+	}
+	t.mu.Unlock()
+	return req, nil
+}
+
+func (t *HTTPTransport) post(ctx context.Context, msg *Request) (*http.Response, error) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal MCP request: %w", err)
+	}
+	req, err := t.newRequest(ctx, http.MethodPost, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MCP HTTP request: %w", err)
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach MCP server at %s: %w", t.url, err)
+	}
+	if sid := resp.Header.Get("Mcp-Session-Id"); sid != "" {
+		t.mu.Lock()
+		t.sessionID = sid
+		t.mu.Unlock()
+	}
+	if resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		return nil, fmt.Errorf("MCP server returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp, nil
+}
+
+// Call POSTs a request and waits for the matching response.
+func (t *HTTPTransport) Call(ctx context.Context, req *Request) (*Response, error) {
+	if req.ID == nil {
+		return nil, fmt.Errorf("request has no ID")
+	}
+	httpResp, err := t.post(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if strings.HasPrefix(httpResp.Header.Get("Content-Type"), "text/event-stream") {
+		return readSSEResponse(httpResp.Body, *req.ID)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode MCP response: %w", err)
+	}
+	return &resp, nil
+}
+
+// readSSEResponse scans an event stream until the response with the given ID
+// arrives. Other events (notifications, server requests) are skipped.
+func readSSEResponse(r io.Reader, id int64) (*Response, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "data:") {
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue
+		}
+
+		// Blank line terminates an event.
+		var resp Response
+		if err := json.Unmarshal([]byte(data.String()), &resp); err == nil && resp.ID != nil && *resp.ID == id {
+			return &resp, nil
+		}
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading MCP event stream: %w", err)
+	}
+	return nil, fmt.Errorf("MCP event stream ended without a response to request %d", id)
+}
+
+// Notify POSTs a notification; the server answers with 202 Accepted.
+func (t *HTTPTransport) Notify(ctx context.Context, req *Request) error {
+	resp, err := t.post(ctx, req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
+// Close terminates the session on the server if one was established.
+func (t *HTTPTransport) Close() error {
+	t.mu.Lock()
+	sid := t.sessionID
+	t.mu.Unlock()
+	if sid == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := t.newRequest(ctx, http.MethodDelete, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,188 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// StdioTransport talks to an MCP server launched as a subprocess, exchanging
+// newline-delimited JSON-RPC messages over its stdin/stdout.
+type StdioTransport struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	pending map[int64]chan *Response
+	done    chan struct{}
+	err     error // set when the read loop exits
+}
+
+// NewStdioTransport starts the command and begins reading its output.
+// env entries are added on top of the current process environment.
+func NewStdioTransport(command string, args []string, env map[string]string) (*StdioTransport, error) {
+	if command == "" {
+		return nil, fmt.Errorf("stdio transport requires a command")
+	}
+
+	cmd := exec.Command(command, args...)
+	cmd.Env = os.Environ()
+	for k, v := range env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	cmd.Stderr = &stderrLogger{prefix: fmt.Sprintf("[MCP %s] ", command)}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin for MCP server: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout for MCP server: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start MCP server %q: %w", command, err)
+	}
+
+	t := &StdioTransport{
+		cmd:     cmd,
+		stdin:   stdin,
+		pending: make(map[int64]chan *Response),
+		done:    make(chan struct{}),
+	}
+	go t.readLoop(stdout)
+	return t, nil
+}
+
+// readLoop dispatches responses to waiting callers and answers server pings.
+func (t *StdioTransport) readLoop(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		// Peek at the message to tell responses from server-initiated requests.
+		var msg struct {
+			ID     *int64 `json:"id"`
+			Method string `json:"method"`
+		}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			log.Printf("MCP stdio: ignoring malformed message: %v", err)
+			continue
+		}
+
+		if msg.Method != "" {
+			if msg.Method == "ping" && msg.ID != nil {
+				_ = t.write(&Response{JSONRPC: "2.0", ID: msg.ID, Result: json.RawMessage(`{}`)})
+			}
+			// Other notifications/requests from the server are not supported.
+			continue
+		}
+
+		var resp Response
+		if err := json.Unmarshal(line, &resp); err != nil || resp.ID == nil {
+			continue
+		}
+		t.mu.Lock()
+		ch, ok := t.pending[*resp.ID]
+		delete(t.pending, *resp.ID)
+		t.mu.Unlock()
+		if ok {
+			ch <- &resp
+		}
+	}
+
+	t.mu.Lock()
+	t.err = scanner.Err()
+	if t.err == nil {
+		t.err = io.EOF
+	}
+	t.mu.Unlock()
+	close(t.done)
+}
+
+func (t *StdioTransport) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+	_, err = t.stdin.Write(append(data, '\n'))
+	return err
+}
+
+// Call sends a request and waits for its response.
+func (t *StdioTransport) Call(ctx context.Context, req *Request) (*Response, error) {
+	if req.ID == nil {
+		return nil, fmt.Errorf("request has no ID")
+	}
+	ch := make(chan *Response, 1)
+	t.mu.Lock()
+	t.pending[*req.ID] = ch
+	t.mu.Unlock()
+
+	cleanup := func() {
+		t.mu.Lock()
+		delete(t.pending, *req.ID)
+		t.mu.Unlock()
+	}
+
+	if err := t.write(req); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to write to MCP server: %w", err)
+	}
+
+	select {
+	case resp := <-ch:
+		return resp, nil
+	case <-ctx.Done():
+		cleanup()
+		return nil, ctx.Err()
+	case <-t.done:
+		cleanup()
+		return nil, fmt.Errorf("MCP server exited: %w", t.err)
+	}
+}
+
+// Notify sends a notification.
+func (t *StdioTransport) Notify(ctx context.Context, req *Request) error {
+	return t.write(req)
+}
+
+// Close closes stdin and waits for the process to exit, killing it if it
+// does not do so promptly.
+func (t *StdioTransport) Close() error {
+	_ = t.stdin.Close()
+	select {
+	case <-t.done:
+	case <-time.After(2 * time.Second):
+		if t.cmd.Process != nil {
+			_ = t.cmd.Process.Kill()
+		}
+	}
+	_ = t.cmd.Wait()
+	return nil
+}
+
+// stderrLogger forwards a subprocess's stderr to the server log.
+type stderrLogger struct {
+	prefix string
+}
+
+func (l *stderrLogger) Write(p []byte) (int, error) {
+	log.Printf("%s%s", l.prefix, string(p))
+	return len(p), nil
+}
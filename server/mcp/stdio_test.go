@@ -0,0 +1,190 @@
+package mcp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// exampleServer is the path of the built cmd/mcp-example server
+var exampleServer string
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "mcp-example")
+	if err != nil {
+		panic(err)
+	}
+	exampleServer = filepath.Join(dir, "mcp-example")
+	build := exec.Command("go", "build", "-o", exampleServer, "github.com/ramborogers/cyberai/cmd/mcp-example")
+	build.Stderr = os.Stderr
+	if err := build.Run(); err != nil {
+		os.RemoveAll(dir)
+		panic("failed to build mcp-example: " + err.Error())
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newExampleClient starts the example server and completes the handshake
+func newExampleClient(t *testing.T) *Client {
+	t.Helper()
+	transport, err := NewStdioTransport(exampleServer, nil, nil)
+	if err != nil {
+		t.Fatalf("NewStdioTransport: %v", err)
+	}
+	client := NewClient(transport)
+	t.Cleanup(func() { client.Close() })
+
+	if _, err := client.Initialize(testContext(t)); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return client
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestInitialize(t *testing.T) {
+	transport, err := NewStdioTransport(exampleServer, nil, nil)
+	if err != nil {
+		t.Fatalf("NewStdioTransport: %v", err)
+	}
+	client := NewClient(transport)
+	defer client.Close()
+
+	result, err := client.Initialize(testContext(t))
+	if err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	if result.ProtocolVersion != ProtocolVersion {
+		t.Errorf("protocol version = %q, want %q", result.ProtocolVersion, ProtocolVersion)
+	}
+	if _, ok := result.Capabilities["tools"]; !ok {
+		t.Errorf("capabilities = %v, want tools", result.Capabilities)
+	}
+	if client.ServerInfo.Name != "mcp-example" {
+		t.Errorf("server name = %q, want mcp-example", client.ServerInfo.Name)
+	}
+}
+
+func TestListTools(t *testing.T) {
+	client := newExampleClient(t)
+
+	tools, err := client.ListTools(testContext(t))
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	want := []string{"echo", "add", "current_time"}
+	if len(tools) != len(want) {
+		t.Fatalf("got %d tools, want %d: %+v", len(tools), len(want), tools)
+	}
+	for i, tool := range tools {
+		if tool.Name != want[i] {
+			t.Errorf("tool %d = %q, want %q", i, tool.Name, want[i])
+		}
+		if tool.InputSchema["type"] != "object" {
+			t.Errorf("tool %q input schema type = %v, want object", tool.Name, tool.InputSchema["type"])
+		}
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	client := newExampleClient(t)
+
+	tests := []struct {
+		name      string
+		tool      string
+		arguments map[string]interface{}
+		want      string
+		isError   bool
+	}{
+		{"echo", "echo", map[string]interface{}{"text": "hello"}, "hello", false},
+		{"add", "add", map[string]interface{}{"a": 2, "b": 3.5}, "5.5", false},
+		{"invalid arguments", "add", map[string]interface{}{"a": "two"}, "both 'a' and 'b' must be numbers", true},
+		{"no arguments", "add", nil, "both 'a' and 'b' must be numbers", true},
+		{"unknown tool", "missing", nil, "unknown tool: missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := client.CallTool(testContext(t), tt.tool, tt.arguments)
+			if err != nil {
+				t.Fatalf("CallTool: %v", err)
+			}
+			if result.IsError != tt.isError {
+				t.Errorf("isError = %v, want %v", result.IsError, tt.isError)
+			}
+			if got := result.Text(); got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnknownMethod(t *testing.T) {
+	client := newExampleClient(t)
+
+	err := client.call(testContext(t), "resources/list", nil, nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) {
+		t.Fatalf("error = %v, want an RPCError", err)
+	}
+	if rpcErr.Code != -32601 {
+		t.Errorf("code = %d, want -32601", rpcErr.Code)
+	}
+}
+
+func TestCallAfterClose(t *testing.T) {
+	client := newExampleClient(t)
+	client.Close()
+
+	if _, err := client.ListTools(testContext(t)); err == nil {
+		t.Fatal("ListTools after Close succeeded")
+	}
+}
+
+func TestServerExits(t *testing.T) {
+	transport, err := NewStdioTransport("sh", []string{"-c", "exit 0"}, nil)
+	if err != nil {
+		t.Fatalf("NewStdioTransport: %v", err)
+	}
+	client := NewClient(transport)
+	defer client.Close()
+
+	if _, err := client.Initialize(testContext(t)); err == nil {
+		t.Fatal("Initialize with an exited server succeeded")
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	// A server that reads requests but never answers
+	transport, err := NewStdioTransport("sh", []string{"-c", "cat >/dev/null"}, nil)
+	if err != nil {
+		t.Fatalf("NewStdioTransport: %v", err)
+	}
+	client := NewClient(transport)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := client.Initialize(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestMissingCommand(t *testing.T) {
+	if _, err := NewStdioTransport("", nil, nil); err == nil {
+		t.Error("NewStdioTransport without a command succeeded")
+	}
+	if _, err := NewStdioTransport(filepath.Join(t.TempDir(), "missing"), nil, nil); err == nil {
+		t.Error("NewStdioTransport with a missing command succeeded")
+	}
+}
@@ -3,8 +3,10 @@ package middleware
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/sessions"
 	"github.com/ramborogers/cyberai/server/models" // Will need UserService here
@@ -18,7 +20,7 @@ const (
 )
 
 // SessionAuthMiddleware redirects unauthenticated users to the login page.
-// It requires a session store and a UserService to check user validity (optional, can just check session).
+// It requires a session store and a UserService.
 func SessionAuthMiddleware(store sessions.Store, userService *models.UserService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -37,19 +39,8 @@ func SessionAuthMiddleware(store sessions.Store, userService *models.UserService
 				return
 			}
 
-			// Optional: Verify user still exists and is active in the database
-			// user, err := userService.GetUserByID(userID) // Assumes GetUserByID exists
-			// if err != nil || user == nil || !user.IsActive {
-			//     log.Printf("User %d not found or inactive during auth check", userID)
-			//     // Clear potentially invalid session
-			//     session.Values[string(UserIDContextKey)] = 0
-			//     session.Options.MaxAge = -1 // Expire cookie immediately
-			//     if saveErr := session.Save(r, w); saveErr != nil {
-			//         log.Printf("Error saving expired session: %v", saveErr)
-			//     }
-			//     http.Redirect(w, r, "/login", http.StatusFound)
-			//     return
-			// }
+			// The session store refuses sessions of deactivated users, and
+			// deactivation deletes them, so the user is active
 
 			// User is authenticated, add userID to context
 			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
@@ -59,52 +50,140 @@ func SessionAuthMiddleware(store sessions.Store, userService *models.UserService
 	}
 }
 
-// AdminRequiredMiddleware checks if the authenticated user has the 'admin' role.
-// It relies on SessionAuthMiddleware having run first (or performs its own session check).
-// Requires a UserService to fetch the user's role.
-func AdminRequiredMiddleware(store sessions.Store, userService *models.UserService) func(http.Handler) http.Handler {
+// APITokenAuthMiddleware authenticates requests carrying an API token
+// ("Authorization: Bearer cai_...") as the token's user, whose role must
+// grant models.PermUseAPITokens, and passes other requests to sessionAuth.
+// Requests with an invalid token are refused rather than redirected, since
+// API clients do not log in.
+func APITokenAuthMiddleware(tokens *models.APITokenService, userService *models.UserService, sessionAuth func(http.Handler) http.Handler) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		// First, ensure the user is authenticated via session
-		// sessionAuthHandler := SessionAuthMiddleware(store, userService)(next) // REMOVED - unused
+		withSession := sessionAuth(next)
 
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// ---- AdminRequiredMiddleware Logic ----
-			// Check session directly
-			sessionCheck, err := store.Get(r, SessionName)
+			token, ok := bearerToken(r)
+			if !ok {
+				withSession.ServeHTTP(w, r)
+				return
+			}
+
+			userID, err := tokens.GetAPITokenUserID(token)
+			if err != nil {
+				log.Printf("Error authenticating API token: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if userID == 0 {
+				http.Error(w, "Unauthorized: Invalid or expired API token", http.StatusUnauthorized)
+				return
+			}
+			granted, err := userService.HasPermission(userID, models.PermUseAPITokens)
+			if err != nil {
+				log.Printf("Error getting permissions of user %d: %v", userID, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !granted {
+				log.Printf("APITokenAuth: User ID %d may not use API tokens (%s %s)", userID, r.Method, r.URL.Path)
+				http.Error(w, fmt.Sprintf("Forbidden: Requires the %s permission", models.PermUseAPITokens), http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, int(userID))
+			log.Printf("APITokenAuth: Authenticated User ID: %d for request: %s %s\n", userID, r.Method, r.URL.Path)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// bearerToken returns the token in the request's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return token, true
+}
+
+// OptionalSessionMiddleware adds the user ID to the request context if the
+// request has a valid session, and lets anonymous requests through otherwise.
+// Used by public pages that show more to logged-in users.
+func OptionalSessionMiddleware(store sessions.Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := store.Get(r, SessionName)
+			if err != nil {
+				log.Printf("Session store error in optional session middleware: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, ok := session.Values[string(UserIDContextKey)].(int)
+			if !ok || userID <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// PermissionRequiredMiddleware checks that the authenticated user's role
+// grants at least one of the permissions (see models.Permissions), e.g.
+// models.AdminPermissions for the admin area. It performs its own session
+// check, so it does not rely on SessionAuthMiddleware having run first.
+func PermissionRequiredMiddleware(store sessions.Store, userService *models.UserService, permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		checked := RequirePermission(userService, permissions...)(next)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session, err := store.Get(r, SessionName)
 			if err != nil {
-				log.Printf("Session store error in admin middleware: %v", err)
+				log.Printf("Session store error in permission middleware: %v", err)
 				http.Redirect(w, r, "/login", http.StatusFound)
 				return
 			}
 
-			userIDCheck, okCheck := sessionCheck.Values[string(UserIDContextKey)].(int)
-			if !okCheck || userIDCheck <= 0 {
+			userID, ok := session.Values[string(UserIDContextKey)].(int)
+			if !ok || userID <= 0 {
 				// If the user isn't authenticated via session, redirect to login.
-				log.Printf("AdminRequired: No valid user ID found in session for %s %s. Redirecting to login.", r.Method, r.URL.Path)
+				log.Printf("PermissionRequired: No valid user ID found in session for %s %s. Redirecting to login.", r.Method, r.URL.Path)
 				http.Redirect(w, r, "/login", http.StatusFound)
 				return
 			}
 
-			// User is authenticated via session, check role
-			roleCheck, errCheck := userService.GetUserRole(int64(userIDCheck))
-			if errCheck != nil {
-				log.Printf("Error getting role for user %d in admin check: %v", userIDCheck, errCheck)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
+			checked.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequirePermission checks that the user in the request context (added by
+// SessionAuthMiddleware or PermissionRequiredMiddleware) has a role granting
+// at least one of the permissions.
+func RequirePermission(userService *models.UserService, permissions ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID := GetUserIDFromContext(r.Context())
+			if userID == 0 {
+				http.Error(w, "Unauthorized: User ID not found in context", http.StatusUnauthorized)
 				return
 			}
 
-			if roleCheck != "admin" {
-				log.Printf("AdminRequired: Access denied for User ID %d (role: %s) to %s %s\n", userIDCheck, roleCheck, r.Method, r.URL.Path)
-				http.Error(w, "Forbidden: Administrator access required", http.StatusForbidden)
+			granted, err := userService.HasPermission(int64(userID), permissions...)
+			if err != nil {
+				log.Printf("Error getting permissions of user %d: %v", userID, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !granted {
+				log.Printf("PermissionRequired: Access denied for User ID %d to %s %s (needs one of %v)", userID, r.Method, r.URL.Path, permissions)
+				http.Error(w, fmt.Sprintf("Forbidden: Requires the %s permission", strings.Join(permissions, " or ")), http.StatusForbidden)
 				return
 			}
 
-			// User is admin, add ID to context and proceed
-			ctx := context.WithValue(r.Context(), UserIDContextKey, userIDCheck)
-			log.Printf("AdminRequired: Granted access for User ID %d (role: admin) to %s %s\n", userIDCheck, r.Method, r.URL.Path)
-			next.ServeHTTP(w, r.WithContext(ctx))
-			// ---- End REVISED ----
-
+			next.ServeHTTP(w, r)
 		})
 	}
 }
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the proxies whose X-Forwarded-For headers are believed.
+// Configure this based on your environment.
+// Example: var TrustedProxies = []string{"192.168.1.1", "10.0.0.1"}
+var TrustedProxies = []string{"127.0.0.1", "::1"} // Trust localhost/loopback by default
+
+// isTrustedProxy checks if a given remote address belongs to the trusted list
+func isTrustedProxy(remoteAddr string) bool {
+	// Attempt to split host and port, ignore port
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		// If splitting fails, assume it might be just an IP
+		host = remoteAddr
+	}
+
+	for _, trusted := range TrustedProxies {
+		if host == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP extracts the client IP, considering X-Forwarded-For from trusted proxies
+func ClientIP(r *http.Request) string {
+	remoteAddr := r.RemoteAddr
+
+	if isTrustedProxy(remoteAddr) {
+		xff := r.Header.Get("X-Forwarded-For")
+		if xff != "" {
+			// X-Forwarded-For can be a comma-separated list (client, proxy1, proxy2)
+			ips := strings.Split(xff, ",")
+			if len(ips) > 0 {
+				clientIP := strings.TrimSpace(ips[0])
+				if clientIP != "" {
+					return clientIP // Return the first IP in the list
+				}
+			}
+		}
+
+		// Fallback: Check X-Real-IP if X-Forwarded-For is not useful
+		xRealIP := r.Header.Get("X-Real-IP")
+		if xRealIP != "" {
+			return strings.TrimSpace(xRealIP)
+		}
+	}
+
+	// Default to RemoteAddr if not proxied or header is missing/invalid
+	// Attempt to split host and port, return only host if successful
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err == nil {
+		return host
+	}
+	return remoteAddr // Return the raw RemoteAddr if splitting fails
+}
@@ -72,6 +72,19 @@ func (s *AgentService) GetAgent(agentID int64) (*Agent, error) {
 	return &agent, nil
 }
 
+// CanUseAgent reports whether the user can use the agent's prompt and tools:
+// it must be active and either theirs or public
+func (s *AgentService) CanUseAgent(userID, agentID int64) (bool, error) {
+	var usable bool
+	err := s.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM agents WHERE id = ? AND is_active = 1 AND (user_id = ? OR is_public = 1))
+	`, agentID, userID).Scan(&usable)
+	if err != nil {
+		return false, fmt.Errorf("failed to check access to agent %d: %w", agentID, err)
+	}
+	return usable, nil
+}
+
 // ListAgents retrieves all agents, with optional filtering
 func (s *AgentService) ListAgents(userID int64, includePublic bool, activeOnly bool) ([]Agent, error) {
 	query := `
@@ -136,8 +149,27 @@ func (s *AgentService) ListAgents(userID int64, includePublic bool, activeOnly b
 	return agents, nil
 }
 
+// checkCanPublish returns ErrPermissionDenied unless the user's role allows
+// making agents public
+func (s *AgentService) checkCanPublish(userID int64) error {
+	allowed, err := NewUserService(s.DB).HasPermission(userID, PermCreatePublicAgents)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrPermissionDenied, PermCreatePublicAgents)
+	}
+	return nil
+}
+
 // CreateAgent creates a new agent
 func (s *AgentService) CreateAgent(agent *Agent) error {
+	if agent.IsPublic {
+		if err := s.checkCanPublish(agent.UserID); err != nil {
+			return err
+		}
+	}
+
 	// Serialize configuration to JSON
 	configJSON, err := json.Marshal(agent.Configuration)
 	if err != nil {
@@ -181,6 +213,12 @@ func (s *AgentService) CreateAgent(agent *Agent) error {
 
 // UpdateAgent updates an existing agent
 func (s *AgentService) UpdateAgent(agent *Agent) error {
+	if agent.IsPublic {
+		if err := s.checkCanPublish(agent.UserID); err != nil {
+			return err
+		}
+	}
+
 	// Serialize configuration to JSON
 	configJSON, err := json.Marshal(agent.Configuration)
 	if err != nil {
@@ -205,27 +243,33 @@ func (s *AgentService) UpdateAgent(agent *Agent) error {
 	return nil
 }
 
-// DeleteAgent deletes an agent
+// DeleteAgent deletes an agent, clearing it as the default of the projects
+// that used it
 func (s *AgentService) DeleteAgent(agentID int64, userID int64) error {
-	result, err := s.DB.Exec(
-		"DELETE FROM agents WHERE id = ? AND user_id = ?",
-		agentID, userID,
-	)
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(
+			"DELETE FROM agents WHERE id = ? AND user_id = ?",
+			agentID, userID,
+		)
 
-	if err != nil {
-		return fmt.Errorf("failed to delete agent: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("failed to delete agent: %w", err)
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error checking rows affected: %w", err)
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error checking rows affected: %w", err)
+		}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("no agent found with ID %d for user %d", agentID, userID)
-	}
+		if rowsAffected == 0 {
+			return fmt.Errorf("no agent found with ID %d for user %d", agentID, userID)
+		}
 
-	return nil
+		if _, err := tx.Exec(`UPDATE projects SET default_agent_id = NULL WHERE default_agent_id = ?`, agentID); err != nil {
+			return fmt.Errorf("failed to clear project default agent: %w", err)
+		}
+		return nil
+	})
 }
 
 // ToggleAgentStatus activates or deactivates an agent
@@ -254,6 +298,12 @@ func (s *AgentService) ToggleAgentStatus(agentID int64, userID int64, isActive b
 
 // ToggleAgentPublic changes the public visibility of an agent
 func (s *AgentService) ToggleAgentPublic(agentID int64, userID int64, isPublic bool) error {
+	if isPublic {
+		if err := s.checkCanPublish(userID); err != nil {
+			return err
+		}
+	}
+
 	result, err := s.DB.Exec(`
 		UPDATE agents
 		SET is_public = ?, updated_at = ?
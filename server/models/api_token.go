@@ -0,0 +1,158 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// APITokenPrefix starts every API token, so that leaked tokens are easy to
+// recognize
+const APITokenPrefix = "cai_"
+
+// MaxAPITokens is how many API tokens a user can have
+const MaxAPITokens = 20
+
+// ErrAPITokenNotFound is returned when revoking a token that does not exist
+// or belongs to someone else
+var ErrAPITokenNotFound = errors.New("API token not found")
+
+// ErrTooManyAPITokens is returned when the user already has MaxAPITokens
+var ErrTooManyAPITokens = fmt.Errorf("at most %d API tokens are allowed", MaxAPITokens)
+
+// APIToken is a personal token authenticating API requests as its user, for
+// scripts and other clients without a browser session. Only its hash is
+// stored; the token itself is returned once, when it is created.
+type APIToken struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Hint       string     `json:"hint"` // Start of the token, to tell tokens apart
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Never expires if nil
+}
+
+// APITokenService stores API tokens, looked up by the hash of the token
+type APITokenService struct {
+	DB *db.DB
+}
+
+// NewAPITokenService creates a new API token service
+func NewAPITokenService(database *db.DB) *APITokenService {
+	return &APITokenService{DB: database}
+}
+
+// CreateAPIToken creates a token for the user, expiring at expiresAt if not
+// nil, and returns it along with the token itself
+func (s *APITokenService) CreateAPIToken(userID int64, name string, expiresAt *time.Time) (*APIToken, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", fmt.Errorf("failed to generate API token: %w", err)
+	}
+	token := APITokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	apiToken := &APIToken{
+		UserID:    userID,
+		Name:      name,
+		Hint:      token[:len(APITokenPrefix)+4],
+		CreatedAt: time.Now(),
+		ExpiresAt: expiresAt,
+	}
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_id = ?`, userID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count API tokens: %w", err)
+		}
+		if count >= MaxAPITokens {
+			return ErrTooManyAPITokens
+		}
+		result, err := tx.Exec(`
+			INSERT INTO api_tokens (user_id, name, token_hash, hint, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, userID, name, HashSessionToken(token), apiToken.Hint, apiToken.CreatedAt, expiresAt)
+		if err != nil {
+			return fmt.Errorf("failed to create API token: %w", err)
+		}
+		apiToken.ID, err = result.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return apiToken, token, nil
+}
+
+// GetAPITokenUserID returns the user a token authenticates, or 0 if there is
+// no such token, it expired, or its user was deactivated. The token's last
+// use is recorded, at most once per sessionTouchInterval.
+func (s *APITokenService) GetAPITokenUserID(token string) (int64, error) {
+	var id, userID int64
+	var lastUsedAt sql.NullTime
+	var expiresAt sql.NullTime
+	err := s.DB.QueryRow(`
+		SELECT t.id, t.user_id, t.last_used_at, t.expires_at
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND u.is_active
+	`, HashSessionToken(token)).Scan(&id, &userID, &lastUsedAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get API token: %w", err)
+	}
+	now := time.Now()
+	if expiresAt.Valid && now.After(expiresAt.Time) {
+		return 0, nil
+	}
+	if !lastUsedAt.Valid || now.Sub(lastUsedAt.Time) >= sessionTouchInterval {
+		if _, err := s.DB.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, id); err != nil {
+			return 0, fmt.Errorf("failed to update API token: %w", err)
+		}
+	}
+	return userID, nil
+}
+
+// ListAPITokens returns the user's tokens, newest first, including expired ones
+func (s *APITokenService) ListAPITokens(userID int64) ([]APIToken, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, name, hint, created_at, last_used_at, expires_at
+		FROM api_tokens WHERE user_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &token.Hint,
+			&token.CreatedAt, &token.LastUsedAt, &token.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API token: %w", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating API tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// DeleteAPIToken revokes one of the user's tokens
+func (s *APITokenService) DeleteAPIToken(userID, tokenID int64) error {
+	result, err := s.DB.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete API token: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
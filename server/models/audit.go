@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// Audit log events
+const (
+	AuditTwoFactorEnrollmentStarted = "2fa.enrollment_started"
+	AuditTwoFactorEnabled           = "2fa.enabled"
+	AuditTwoFactorDisabled          = "2fa.disabled"
+	AuditTwoFactorReset             = "2fa.reset" // By an admin, e.g. for a lost device
+	AuditTwoFactorVerified          = "2fa.verified"
+	AuditTwoFactorFailed            = "2fa.failed"
+	AuditTwoFactorLockedOut         = "2fa.locked_out" // Too many failed codes in a row
+	AuditRecoveryCodeUsed           = "2fa.recovery_code_used"
+	AuditRecoveryCodesRegenerated   = "2fa.recovery_codes_regenerated"
+	AuditSessionsRevoked            = "session.revoked"      // By the user, from another session
+	AuditForcedLogout               = "session.force_logout" // By an admin
+	AuditAPITokenCreated            = "api_token.created"
+	AuditAPITokenRevoked            = "api_token.revoked"
+)
+
+// AuditEntry is a security event in the audit log
+type AuditEntry struct {
+	ID        int64     `json:"id"`
+	UserID    *int64    `json:"user_id,omitempty"` // The user the event is about; nil once deleted
+	Username  string    `json:"username"`
+	ActorID   *int64    `json:"actor_id,omitempty"` // Who caused the event, if not the user
+	Event     string    `json:"event"`
+	Details   string    `json:"details,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit log entries; zero values select everything
+type AuditFilter struct {
+	UserID int64
+	Event  string // An event, or a prefix ending in "." such as "2fa."
+	Limit  int    // At most 500; defaults to 100
+	Offset int
+}
+
+// AuditService records and lists security events
+type AuditService struct {
+	DB *db.DB
+}
+
+// NewAuditService creates a new audit service
+func NewAuditService(database *db.DB) *AuditService {
+	return &AuditService{DB: database}
+}
+
+// Record adds an event about a user to the audit log. actorID is the admin
+// who caused it, or 0 if the user did.
+func (s *AuditService) Record(event string, user *User, actorID int64, ipAddress, details string) error {
+	var userID, actor sql.NullInt64
+	username := ""
+	if user != nil {
+		userID = sql.NullInt64{Int64: user.ID, Valid: true}
+		username = user.Username
+	}
+	if actorID != 0 {
+		actor = sql.NullInt64{Int64: actorID, Valid: true}
+	}
+	if _, err := s.DB.Exec(`
+		INSERT INTO audit_log (user_id, username, actor_id, event, details, ip_address, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, username, actor, event, details, ipAddress, time.Now()); err != nil {
+		return fmt.Errorf("failed to record audit event %s: %w", event, err)
+	}
+	return nil
+}
+
+// List returns the entries selected by the filter, newest first
+func (s *AuditService) List(filter AuditFilter) ([]AuditEntry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	if filter.Limit > 500 {
+		filter.Limit = 500
+	}
+
+	query := `
+		SELECT id, user_id, username, actor_id, event, details, ip_address, created_at
+		FROM audit_log
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if filter.UserID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, filter.UserID)
+	}
+	if filter.Event != "" {
+		if filter.Event[len(filter.Event)-1] == '.' {
+			query += " AND substr(event, 1, ?) = ?"
+			args = append(args, len(filter.Event), filter.Event)
+		} else {
+			query += " AND event = ?"
+			args = append(args, filter.Event)
+		}
+	}
+	query += " ORDER BY id DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := s.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var userID, actorID sql.NullInt64
+		if err := rows.Scan(&entry.ID, &userID, &entry.Username, &actorID, &entry.Event,
+			&entry.Details, &entry.IPAddress, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if userID.Valid {
+			entry.UserID = &userID.Int64
+		}
+		if actorID.Valid {
+			entry.ActorID = &actorID.Int64
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit log: %w", err)
+	}
+	return entries, nil
+}
@@ -24,15 +24,31 @@ type WSMessage struct {
 	// We can't import it directly, so we define what we need here
 }
 
+// Where a chat's title comes from. Only default titles are replaced by
+// generated ones, so titles the user set are never overwritten.
+const (
+	TitleSourceDefault   = "default"   // "New Chat" or the start of the first message
+	TitleSourceGenerated = "generated" // Written by the title model after the first reply
+	TitleSourceUser      = "user"      // Set by the user
+)
+
 // Chat represents a conversation between a user and AI models
 type Chat struct {
-	ID        int64     `json:"id"`
-	Title     string    `json:"title"`
-	UserID    int64     `json:"user_id"`
-	IsActive  bool      `json:"is_active"`
-	Messages  []Message `json:"messages,omitempty"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID              int64             `json:"id"`
+	Title           string            `json:"title"`
+	TitleSource     string            `json:"title_source,omitempty"` // See TitleSourceDefault (set by GetChat)
+	UserID          int64             `json:"user_id"`
+	IsActive        bool              `json:"is_active"`
+	ActiveMessageID *int64            `json:"active_message_id,omitempty"` // Leaf of the active branch (set by GetChat)
+	ProjectID       *int64            `json:"project_id,omitempty"`        // Project the chat belongs to, if any
+	Messages        []Message         `json:"messages,omitempty"`          // Active branch, oldest first
+	Role            string            `json:"role,omitempty"`              // Caller's role, if not the owner (set for shared chats)
+	Participants    []ChatParticipant `json:"participants,omitempty"`      // Owner and participants, if shared (set by the GetChat handler)
+	FolderID        *int64            `json:"folder_id,omitempty"`         // Caller's folder for the chat, if any
+	IsPinned        bool              `json:"is_pinned"`                   // Pinned by the caller
+	Tags            []string          `json:"tags,omitempty"`              // Caller's tags on the chat
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
 }
 
 // Message represents a single message in a chat
@@ -42,16 +58,46 @@ type Message struct {
 	UserID     int64     `json:"user_id"`
 	Role       string    `json:"role"` // "user", "assistant", "system"
 	Content    string    `json:"content"`
+	Reasoning  string    `json:"reasoning,omitempty"` // Model's thinking, kept out of future context
+	ParentID   *int64    `json:"parent_id,omitempty"` // Previous message in the branch (nil for the first)
 	ModelID    *int64    `json:"model_id,omitempty"`
 	AgentID    *int64    `json:"agent_id,omitempty"`
 	TokensUsed int       `json:"tokens_used,omitempty"`
 	CreatedAt  time.Time `json:"created_at"`
 
+	// Alternatives at this point of the conversation (messages with the same
+	// parent, including this one, oldest first); only set by GetChatMessages
+	// when there is more than one
+	SiblingIDs []int64 `json:"sibling_ids,omitempty"`
+
+	// Tokens used to generate an assistant message; only set by
+	// GetMessageSiblings when usage was recorded
+	Usage *MessageUsage `json:"usage,omitempty"`
+
 	// Optional relationships for API responses
 	Model *LLMModel `json:"model,omitempty"`
 	Agent *Agent    `json:"agent,omitempty"`
 }
 
+// MessageUsage is the token usage recorded for generating a message
+type MessageUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatSummary is a rolling summary of the older part of a chat. It stands in
+// for the messages up to ThroughMessageID when building the model's context.
+type ChatSummary struct {
+	ChatID           int64     `json:"chat_id"`
+	Content          string    `json:"content"`
+	ThroughMessageID int64     `json:"through_message_id"` // Last message the summary covers
+	ModelID          *int64    `json:"model_id,omitempty"` // Summariser model that last updated it
+	IsEdited         bool      `json:"is_edited"`          // Edited by the chat owner
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
 // ChatService handles chat-related operations
 type ChatService struct {
 	DB  *db.DB
@@ -67,15 +113,15 @@ func NewChatService(database *db.DB, hub WSHub) *ChatService {
 }
 
 // CreateChat creates a new chat for a user
-func (s *ChatService) CreateChat(userID int64, title string) (*Chat, error) {
+func (s *ChatService) CreateChat(userID int64, title, titleSource string) (*Chat, error) {
 	var chat Chat
 
 	err := s.DB.Transaction(func(tx *sql.Tx) error {
 		// Insert the chat
 		result, err := tx.Exec(`
-			INSERT INTO chats (user_id, title, is_active)
-			VALUES (?, ?, 1)
-		`, userID, title)
+			INSERT INTO chats (user_id, title, title_source, is_active)
+			VALUES (?, ?, ?, 1)
+		`, userID, title, titleSource)
 
 		if err != nil {
 			return fmt.Errorf("failed to create chat: %w", err)
@@ -89,11 +135,11 @@ func (s *ChatService) CreateChat(userID int64, title string) (*Chat, error) {
 
 		// Retrieve the created chat
 		err = tx.QueryRow(`
-			SELECT c.id, c.title, c.user_id, c.is_active, c.created_at, c.updated_at
+			SELECT c.id, c.title, c.title_source, c.user_id, c.is_active, c.created_at, c.updated_at
 			FROM chats c
 			WHERE c.id = ?
 		`, chatID).Scan(
-			&chat.ID, &chat.Title, &chat.UserID,
+			&chat.ID, &chat.Title, &chat.TitleSource, &chat.UserID,
 			&chat.IsActive, &chat.CreatedAt, &chat.UpdatedAt,
 		)
 
@@ -116,13 +162,14 @@ func (s *ChatService) GetChat(chatID int64, includeMessages bool) (*Chat, error)
 	// Get the chat details
 	var chat Chat
 
+	var activeMessageID, projectID sql.NullInt64
 	err := s.DB.QueryRow(`
-		SELECT c.id, c.title, c.user_id, c.is_active, c.created_at, c.updated_at
+		SELECT c.id, c.title, c.title_source, c.user_id, c.is_active, c.active_message_id, c.project_id, c.created_at, c.updated_at
 		FROM chats c
 		WHERE c.id = ?
 	`, chatID).Scan(
-		&chat.ID, &chat.Title, &chat.UserID,
-		&chat.IsActive, &chat.CreatedAt, &chat.UpdatedAt,
+		&chat.ID, &chat.Title, &chat.TitleSource, &chat.UserID,
+		&chat.IsActive, &activeMessageID, &projectID, &chat.CreatedAt, &chat.UpdatedAt,
 	)
 
 	if err != nil {
@@ -131,6 +178,12 @@ func (s *ChatService) GetChat(chatID int64, includeMessages bool) (*Chat, error)
 		}
 		return nil, fmt.Errorf("database error: %w", err)
 	}
+	if activeMessageID.Valid {
+		chat.ActiveMessageID = &activeMessageID.Int64
+	}
+	if projectID.Valid {
+		chat.ProjectID = &projectID.Int64
+	}
 
 	// Optionally get the messages
 	if includeMessages {
@@ -144,35 +197,58 @@ func (s *ChatService) GetChat(chatID int64, includeMessages bool) (*Chat, error)
 	return &chat, nil
 }
 
-// GetChatMessages retrieves all messages for a chat
+// messageColumns lists the message columns read by scanMessage.
+const messageColumns = `m.id, m.chat_id, m.user_id, m.role, m.content, m.reasoning,
+		       m.parent_id, m.model_id, m.agent_id, m.tokens_used, m.created_at`
+
+// scanMessage scans a row selected with messageColumns.
+func scanMessage(row interface{ Scan(...interface{}) error }, msg *Message) error {
+	return row.Scan(
+		&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content, &msg.Reasoning,
+		&msg.ParentID, &msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
+	)
+}
+
+// GetChatMessages retrieves the messages on the chat's active branch, with the
+// IDs of their alternatives where the conversation branches
 func (s *ChatService) GetChatMessages(chatID int64) ([]Message, error) {
+	messages, err := s.getBranchPath(chatID, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group every message of the chat by parent to find the alternatives
 	rows, err := s.DB.Query(`
-		SELECT m.id, m.chat_id, m.user_id, m.role, m.content,
-		       m.model_id, m.agent_id, m.tokens_used, m.created_at
-		FROM messages m
-		WHERE m.chat_id = ?
-		ORDER BY m.created_at ASC
+		SELECT id, parent_id FROM messages
+		WHERE chat_id = ?
+		ORDER BY created_at ASC, id ASC
 	`, chatID)
-
 	if err != nil {
-		return nil, fmt.Errorf("failed to query messages: %w", err)
+		return nil, fmt.Errorf("failed to query message tree: %w", err)
 	}
 	defer rows.Close()
 
-	var messages []Message
+	children := make(map[int64][]int64) // Parent ID (0 for the first message) -> child IDs
 	for rows.Next() {
-		var msg Message
-		if err := rows.Scan(
-			&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content,
-			&msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
-		); err != nil {
-			return nil, fmt.Errorf("failed to scan message: %w", err)
+		var id int64
+		var parentID sql.NullInt64
+		if err := rows.Scan(&id, &parentID); err != nil {
+			return nil, fmt.Errorf("failed to scan message tree: %w", err)
 		}
-		messages = append(messages, msg)
+		children[parentID.Int64] = append(children[parentID.Int64], id)
 	}
-
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating messages: %w", err)
+		return nil, fmt.Errorf("error iterating message tree: %w", err)
+	}
+
+	for i := range messages {
+		var parentID int64
+		if messages[i].ParentID != nil {
+			parentID = *messages[i].ParentID
+		}
+		if siblings := children[parentID]; len(siblings) > 1 {
+			messages[i].SiblingIDs = siblings
+		}
 	}
 
 	return messages, nil
@@ -221,19 +297,22 @@ func (s *ChatService) GetUserChats(userID int64, activeOnly bool) ([]Chat, error
 	return chats, nil
 }
 
-// UpdateChatTitle updates a chat's title
-func (s *ChatService) UpdateChatTitle(chatID int64, title string) error {
-	_, err := s.DB.Exec(`
+// UpdateChatTitle updates a chat's title, recording where it came from (see
+// TitleSourceDefault). Titles other than the user's only replace a default
+// title. It reports whether the title was changed.
+func (s *ChatService) UpdateChatTitle(chatID int64, title, source string) (bool, error) {
+	result, err := s.DB.Exec(`
 		UPDATE chats
-		SET title = ?, updated_at = ?
-		WHERE id = ?
-	`, title, time.Now(), chatID)
+		SET title = ?, title_source = ?, updated_at = ?
+		WHERE id = ? AND (? = ? OR title_source = ?)
+	`, title, source, time.Now(), chatID, source, TitleSourceUser, TitleSourceDefault)
 
 	if err != nil {
-		return fmt.Errorf("failed to update chat title: %w", err)
+		return false, fmt.Errorf("failed to update chat title: %w", err)
 	}
 
-	return nil
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
 }
 
 // ArchiveChat marks a chat as inactive
@@ -266,6 +345,40 @@ func (s *ChatService) DeleteChat(chatID int64) error {
 			return fmt.Errorf("failed to delete chat usage statistics: %w", err)
 		}
 
+		// Delete associated tool invocation log entries
+		_, err = tx.Exec("DELETE FROM tool_invocations WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat tool invocations: %w", err)
+		}
+
+		// Delete the chat's rolling summary
+		_, err = tx.Exec("DELETE FROM chat_summaries WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat summary: %w", err)
+		}
+
+		// Delete the chat's share links
+		_, err = tx.Exec("DELETE FROM chat_shares WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat shares: %w", err)
+		}
+
+		// Remove the chat's participants
+		_, err = tx.Exec("DELETE FROM chat_participants WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat participants: %w", err)
+		}
+
+		// Remove how its owner and participants had organised it
+		_, err = tx.Exec("DELETE FROM chat_user_settings WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat settings: %w", err)
+		}
+		_, err = tx.Exec("DELETE FROM chat_tags WHERE chat_id = ?", chatID)
+		if err != nil {
+			return fmt.Errorf("failed to delete chat tags: %w", err)
+		}
+
 		// Delete the chat
 		_, err = tx.Exec("DELETE FROM chats WHERE id = ?", chatID)
 		if err != nil {
@@ -293,15 +406,48 @@ func (s *ChatService) DeleteChatsByUserID(userID int64) error {
 	return nil
 }
 
-// AddMessage adds a new message to a chat and updates the chat's updated_at time
+// AddMessage adds a new message to a chat and updates the chat's updated_at time.
+// Unless message.ParentID is set, the message continues the active branch;
+// either way it becomes the end of the active branch.
 func (s *ChatService) AddMessage(message *Message) error {
+	return s.addMessage(message, message.ParentID == nil, true)
+}
+
+// AddBranchMessage adds a message after parentID (at the start of the chat
+// if nil), creating a new branch when the parent already has replies, and
+// makes it the end of the active branch.
+func (s *ChatService) AddBranchMessage(message *Message, parentID *int64) error {
+	message.ParentID = parentID
+	return s.addMessage(message, false, true)
+}
+
+// AddAlternativeMessage adds a reply after message.ParentID without changing
+// the active branch, such as one of several responses compared side by side
+// before one of them is chosen.
+func (s *ChatService) AddAlternativeMessage(message *Message) error {
+	return s.addMessage(message, false, false)
+}
+
+func (s *ChatService) addMessage(message *Message, continueActiveBranch, activate bool) error {
 	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		if continueActiveBranch {
+			var activeMessageID sql.NullInt64
+			err := tx.QueryRow(`SELECT active_message_id FROM chats WHERE id = ?`, message.ChatID).Scan(&activeMessageID)
+			if err != nil {
+				return fmt.Errorf("failed to get active branch: %w", err)
+			}
+			message.ParentID = nil
+			if activeMessageID.Valid {
+				message.ParentID = &activeMessageID.Int64
+			}
+		}
+
 		// Insert the message
 		result, err := tx.Exec(`
-			INSERT INTO messages (chat_id, user_id, role, content, model_id, agent_id, tokens_used)
-			VALUES (?, ?, ?, ?, ?, ?, ?)
-		`, message.ChatID, message.UserID, message.Role, message.Content,
-			message.ModelID, message.AgentID, message.TokensUsed)
+			INSERT INTO messages (chat_id, user_id, role, content, reasoning, parent_id, model_id, agent_id, tokens_used)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, message.ChatID, message.UserID, message.Role, message.Content, message.Reasoning,
+			message.ParentID, message.ModelID, message.AgentID, message.TokensUsed)
 
 		if err != nil {
 			return fmt.Errorf("failed to add message: %w", err)
@@ -315,10 +461,14 @@ func (s *ChatService) AddMessage(message *Message) error {
 
 		message.ID = messageID
 
-		// Update the chat's updated_at timestamp
-		_, err = tx.Exec(`
-			UPDATE chats SET updated_at = ? WHERE id = ?
-		`, time.Now(), message.ChatID)
+		// Make it the end of the active branch and update the chat's updated_at timestamp
+		if activate {
+			_, err = tx.Exec(`
+				UPDATE chats SET active_message_id = ?, updated_at = ? WHERE id = ?
+			`, messageID, time.Now(), message.ChatID)
+		} else {
+			_, err = tx.Exec(`UPDATE chats SET updated_at = ? WHERE id = ?`, time.Now(), message.ChatID)
+		}
 
 		if err != nil {
 			return fmt.Errorf("failed to update chat timestamp: %w", err)
@@ -338,17 +488,13 @@ func (s *ChatService) AddMessage(message *Message) error {
 func (s *ChatService) GetLatestMessage(chatID int64) (*Message, error) {
 	var msg Message
 
-	err := s.DB.QueryRow(`
-		SELECT m.id, m.chat_id, m.user_id, m.role, m.content,
-		       m.model_id, m.agent_id, m.tokens_used, m.created_at
+	err := scanMessage(s.DB.QueryRow(`
+		SELECT `+messageColumns+`
 		FROM messages m
 		WHERE m.chat_id = ?
 		ORDER BY m.created_at DESC
 		LIMIT 1
-	`, chatID).Scan(
-		&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content,
-		&msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
-	)
+	`, chatID), &msg)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -360,20 +506,47 @@ func (s *ChatService) GetLatestMessage(chatID int64) (*Message, error) {
 	return &msg, nil
 }
 
-// GetMessageHistory retrieves a number of messages from a chat
+// GetMessageHistory retrieves up to limit of the latest messages on the chat's
+// active branch, oldest first
 func (s *ChatService) GetMessageHistory(chatID int64, limit int) ([]Message, error) {
 	if limit <= 0 {
 		limit = 50 // Default limit
 	}
+	return s.getBranchPath(chatID, 0, limit)
+}
+
+// GetBranchHistory retrieves up to limit of the latest messages on the branch
+// ending at leafID, oldest first, whether or not it is the active branch
+func (s *ChatService) GetBranchHistory(chatID, leafID int64, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = 50 // Default limit
+	}
+	return s.getBranchPath(chatID, leafID, limit)
+}
+
+// getBranchPath walks a branch back from its leaf (the active leaf if leafID
+// is 0) and returns up to limit messages (all if limit is 0) in chronological
+// order.
+func (s *ChatService) getBranchPath(chatID, leafID int64, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = -1 // SQLite: no limit
+	}
 
 	rows, err := s.DB.Query(`
-		SELECT m.id, m.chat_id, m.user_id, m.role, m.content,
-		       m.model_id, m.agent_id, m.tokens_used, m.created_at
-		FROM messages m
+		WITH RECURSIVE path(id, depth) AS (
+			SELECT COALESCE(NULLIF(?, 0), active_message_id), 0 FROM chats
+			WHERE id = ? AND COALESCE(NULLIF(?, 0), active_message_id) IS NOT NULL
+			UNION ALL
+			SELECT p.parent_id, path.depth + 1
+			FROM messages p JOIN path ON p.id = path.id
+			WHERE p.parent_id IS NOT NULL
+		)
+		SELECT `+messageColumns+`
+		FROM path JOIN messages m ON m.id = path.id
 		WHERE m.chat_id = ?
-		ORDER BY m.created_at DESC
+		ORDER BY path.depth ASC
 		LIMIT ?
-	`, chatID, limit)
+	`, leafID, chatID, leafID, chatID, limit)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query message history: %w", err)
@@ -383,10 +556,7 @@ func (s *ChatService) GetMessageHistory(chatID int64, limit int) ([]Message, err
 	var messages []Message
 	for rows.Next() {
 		var msg Message
-		if err := rows.Scan(
-			&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content,
-			&msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
-		); err != nil {
+		if err := scanMessage(rows, &msg); err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
 		// Add in reverse order to get chronological order
@@ -400,6 +570,112 @@ func (s *ChatService) GetMessageHistory(chatID int64, limit int) ([]Message, err
 	return messages, nil
 }
 
+// GetMessage retrieves a single message by ID
+func (s *ChatService) GetMessage(messageID int64) (*Message, error) {
+	var msg Message
+	err := scanMessage(s.DB.QueryRow(`
+		SELECT `+messageColumns+`
+		FROM messages m
+		WHERE m.id = ?
+	`, messageID), &msg)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message not found: %d", messageID)
+		}
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return &msg, nil
+}
+
+// GetMessageSiblings returns the alternatives to a message: the messages of
+// the chat with the same parent, including the message itself, oldest first
+func (s *ChatService) GetMessageSiblings(message *Message) ([]Message, error) {
+	rows, err := s.DB.Query(`
+		SELECT `+messageColumns+`, u.prompt_tokens, u.completion_tokens, u.total_tokens
+		FROM messages m
+		LEFT JOIN (
+			SELECT message_id, SUM(prompt_tokens) AS prompt_tokens,
+			       SUM(completion_tokens) AS completion_tokens, SUM(total_tokens) AS total_tokens
+			FROM usage_statistics
+			GROUP BY message_id
+		) u ON u.message_id = m.id
+		WHERE m.chat_id = ? AND m.parent_id IS ?
+		ORDER BY m.created_at ASC, m.id ASC
+	`, message.ChatID, message.ParentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sibling messages: %w", err)
+	}
+	defer rows.Close()
+
+	var siblings []Message
+	for rows.Next() {
+		var msg Message
+		var promptTokens, completionTokens, totalTokens sql.NullInt64
+		if err := rows.Scan(
+			&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content, &msg.Reasoning,
+			&msg.ParentID, &msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
+			&promptTokens, &completionTokens, &totalTokens,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		if totalTokens.Valid {
+			msg.Usage = &MessageUsage{
+				PromptTokens:     int(promptTokens.Int64),
+				CompletionTokens: int(completionTokens.Int64),
+				TotalTokens:      int(totalTokens.Int64),
+			}
+		}
+		siblings = append(siblings, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages: %w", err)
+	}
+	return siblings, nil
+}
+
+// SetActiveMessage makes the branch ending at messageID the active one, so
+// the next message is added after it.
+func (s *ChatService) SetActiveMessage(chatID, messageID int64) error {
+	result, err := s.DB.Exec(`
+		UPDATE chats SET active_message_id = ?
+		WHERE id = ? AND EXISTS (SELECT 1 FROM messages WHERE id = ? AND chat_id = ?)
+	`, messageID, chatID, messageID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to set active message: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("message not found: %d", messageID)
+	}
+	return nil
+}
+
+// SwitchBranch activates the branch through messageID, continuing from it
+// along the most recent replies to the end of that branch. It returns the
+// new active leaf.
+func (s *ChatService) SwitchBranch(chatID, messageID int64) (int64, error) {
+	leafID := messageID
+	for {
+		var childID int64
+		err := s.DB.QueryRow(`
+			SELECT id FROM messages
+			WHERE chat_id = ? AND parent_id = ?
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1
+		`, chatID, leafID).Scan(&childID)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to follow branch: %w", err)
+		}
+		leafID = childID
+	}
+	if err := s.SetActiveMessage(chatID, leafID); err != nil {
+		return 0, err
+	}
+	return leafID, nil
+}
+
 // GetChatStatistics gets usage statistics for a chat
 func (s *ChatService) GetChatStatistics(chatID int64) (map[string]interface{}, error) {
 	var stats = make(map[string]interface{})
@@ -465,12 +741,45 @@ func (s *ChatService) GetChatStatistics(chatID int64) (map[string]interface{}, e
 	return stats, nil
 }
 
-// DeleteMessage removes a single message by ID
+// RecordUsage stores the tokens used to generate a message in the usage statistics
+func (s *ChatService) RecordUsage(userID, chatID, messageID, modelID int64, promptTokens, completionTokens int) error {
+	_, err := s.DB.Exec(`
+		INSERT INTO usage_statistics (user_id, chat_id, message_id, model_id, prompt_tokens, completion_tokens, total_tokens)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, userID, chatID, messageID, modelID, promptTokens, completionTokens, promptTokens+completionTokens)
+	if err != nil {
+		return fmt.Errorf("failed to record usage for message %d: %w", messageID, err)
+	}
+	return nil
+}
+
+// DeleteMessage removes a single message by ID. Its replies are attached to
+// its parent, and the active branch moves to the parent if it ended here.
 func (s *ChatService) DeleteMessage(messageID int64) error {
-	query := "DELETE FROM messages WHERE id = ?"
-	result, err := s.DB.Exec(query, messageID)
+	var result sql.Result
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			UPDATE messages SET parent_id = (SELECT parent_id FROM messages WHERE id = ?)
+			WHERE parent_id = ?
+		`, messageID, messageID); err != nil {
+			return fmt.Errorf("failed to re-parent replies of message %d: %w", messageID, err)
+		}
+		if _, err := tx.Exec(`
+			UPDATE chats SET active_message_id = (SELECT parent_id FROM messages WHERE id = ?)
+			WHERE active_message_id = ?
+		`, messageID, messageID); err != nil {
+			return fmt.Errorf("failed to move active branch off message %d: %w", messageID, err)
+		}
+
+		var err error
+		result, err = tx.Exec("DELETE FROM messages WHERE id = ?", messageID)
+		if err != nil {
+			return fmt.Errorf("failed to delete message %d: %w", messageID, err)
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete message %d: %w", messageID, err)
+		return err
 	}
 
 	rowsAffected, err := result.RowsAffected()
@@ -535,3 +844,99 @@ func (s *ChatService) UpdateMessageContentAndTokens(messageID int64, content str
 		return nil // Commit transaction
 	})
 }
+
+// UpdateMessageReasoning stores the reasoning produced for an assistant message.
+func (s *ChatService) UpdateMessageReasoning(messageID int64, reasoning string) error {
+	result, err := s.DB.Exec(`UPDATE messages SET reasoning = ? WHERE id = ?`, reasoning, messageID)
+	if err != nil {
+		return fmt.Errorf("failed to update message reasoning: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("message with ID %d not found for update", messageID)
+	}
+	return nil
+}
+
+// GetChatSummary returns the chat's rolling summary, or nil if it has none.
+func (s *ChatService) GetChatSummary(chatID int64) (*ChatSummary, error) {
+	var summary ChatSummary
+	var modelID sql.NullInt64
+	err := s.DB.QueryRow(`
+		SELECT chat_id, content, through_message_id, model_id, is_edited, created_at, updated_at
+		FROM chat_summaries
+		WHERE chat_id = ?
+	`, chatID).Scan(
+		&summary.ChatID, &summary.Content, &summary.ThroughMessageID, &modelID,
+		&summary.IsEdited, &summary.CreatedAt, &summary.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get chat summary: %w", err)
+	}
+	if modelID.Valid {
+		summary.ModelID = &modelID.Int64
+	}
+	return &summary, nil
+}
+
+// GetBranchSummary returns the chat's rolling summary if it applies to the
+// branch ending at leafID (the active branch if 0), or nil. A summary made on
+// another branch, e.g. before an earlier message was edited, does not apply.
+func (s *ChatService) GetBranchSummary(chatID, leafID int64) (*ChatSummary, error) {
+	summary, err := s.GetChatSummary(chatID)
+	if err != nil || summary == nil || summary.ThroughMessageID == 0 {
+		return summary, err // A summary covering no messages applies everywhere
+	}
+
+	var onBranch bool
+	err = s.DB.QueryRow(`
+		WITH RECURSIVE path(id) AS (
+			SELECT COALESCE(NULLIF(?, 0), active_message_id) FROM chats WHERE id = ?
+			UNION ALL
+			SELECT m.parent_id FROM messages m JOIN path ON m.id = path.id
+			WHERE m.parent_id IS NOT NULL
+		)
+		SELECT EXISTS (SELECT 1 FROM path WHERE id = ?)
+	`, leafID, chatID, summary.ThroughMessageID).Scan(&onBranch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check chat summary branch: %w", err)
+	}
+	if !onBranch {
+		return nil, nil
+	}
+	return summary, nil
+}
+
+// SaveChatSummary creates or replaces the chat's rolling summary.
+func (s *ChatService) SaveChatSummary(summary *ChatSummary) error {
+	now := time.Now()
+	_, err := s.DB.Exec(`
+		INSERT INTO chat_summaries (chat_id, content, through_message_id, model_id, is_edited, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			content = excluded.content,
+			through_message_id = excluded.through_message_id,
+			model_id = excluded.model_id,
+			is_edited = excluded.is_edited,
+			updated_at = excluded.updated_at
+	`, summary.ChatID, summary.Content, summary.ThroughMessageID, summary.ModelID, summary.IsEdited, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to save chat summary: %w", err)
+	}
+	if summary.CreatedAt.IsZero() {
+		summary.CreatedAt = now
+	}
+	summary.UpdatedAt = now
+	return nil
+}
+
+// DeleteChatSummary removes the chat's rolling summary, so the next update
+// summarises the conversation from the start.
+func (s *ChatService) DeleteChatSummary(chatID int64) error {
+	if _, err := s.DB.Exec(`DELETE FROM chat_summaries WHERE chat_id = ?`, chatID); err != nil {
+		return fmt.Errorf("failed to delete chat summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"time"
+)
+
+// Identifies the JSON chat export format, so imports can recognise it
+const (
+	ChatExportFormat  = "cyberai-chat"
+	ChatExportVersion = 1
+)
+
+// ChatExport is a complete copy of a chat: every message of every branch,
+// which branch is active and the rolling summary. Its JSON form can be
+// imported again.
+type ChatExport struct {
+	Format     string            `json:"format"`
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Chat       ExportedChat      `json:"chat"`
+	Summary    *ChatSummary      `json:"summary,omitempty"`
+	Messages   []ExportedMessage `json:"messages"` // Every message of the chat, oldest first
+}
+
+// ExportedChat is the chat itself in an export
+type ExportedChat struct {
+	ID              int64     `json:"id"`
+	Title           string    `json:"title"`
+	ActiveMessageID *int64    `json:"active_message_id,omitempty"` // Leaf of the active branch
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// ExportedMessage is a message in an export, with the names of the model and
+// agent that wrote it (which may no longer exist where it is imported) and
+// its recorded token usage.
+type ExportedMessage struct {
+	Message
+	ModelName string `json:"model_name,omitempty"`
+	AgentName string `json:"agent_name,omitempty"`
+}
+
+// ExportChat collects everything in a chat for export
+func (s *ChatService) ExportChat(chatID int64) (*ChatExport, error) {
+	chat, err := s.GetChat(chatID, false)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := s.GetChatSummary(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT `+messageColumns+`, COALESCE(md.name, ''), COALESCE(a.name, ''),
+		       u.prompt_tokens, u.completion_tokens, u.total_tokens
+		FROM messages m
+		LEFT JOIN models md ON md.id = m.model_id
+		LEFT JOIN agents a ON a.id = m.agent_id
+		LEFT JOIN (
+			SELECT message_id, SUM(prompt_tokens) AS prompt_tokens,
+			       SUM(completion_tokens) AS completion_tokens, SUM(total_tokens) AS total_tokens
+			FROM usage_statistics
+			GROUP BY message_id
+		) u ON u.message_id = m.id
+		WHERE m.chat_id = ?
+		ORDER BY m.created_at ASC, m.id ASC
+	`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages for export: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []ExportedMessage{}
+	for rows.Next() {
+		var msg ExportedMessage
+		var promptTokens, completionTokens, totalTokens sql.NullInt64
+		if err := rows.Scan(
+			&msg.ID, &msg.ChatID, &msg.UserID, &msg.Role, &msg.Content, &msg.Reasoning,
+			&msg.ParentID, &msg.ModelID, &msg.AgentID, &msg.TokensUsed, &msg.CreatedAt,
+			&msg.ModelName, &msg.AgentName, &promptTokens, &completionTokens, &totalTokens,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan message for export: %w", err)
+		}
+		if totalTokens.Valid {
+			msg.Usage = &MessageUsage{
+				PromptTokens:     int(promptTokens.Int64),
+				CompletionTokens: int(completionTokens.Int64),
+				TotalTokens:      int(totalTokens.Int64),
+			}
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating messages for export: %w", err)
+	}
+
+	return &ChatExport{
+		Format:     ChatExportFormat,
+		Version:    ChatExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Chat: ExportedChat{
+			ID:              chat.ID,
+			Title:           chat.Title,
+			ActiveMessageID: chat.ActiveMessageID,
+			CreatedAt:       chat.CreatedAt,
+			UpdatedAt:       chat.UpdatedAt,
+		},
+		Summary:  summary,
+		Messages: messages,
+	}, nil
+}
+
+// ActiveBranch returns the messages of the active branch, oldest first: the
+// conversation as it is shown in the chat. Each message's SiblingIDs lists its
+// alternatives where the conversation branches.
+func (e *ChatExport) ActiveBranch() []ExportedMessage {
+	byID := make(map[int64]int, len(e.Messages))
+	children := make(map[int64][]int64) // Parent ID (0 for the first message) -> child IDs
+	for i, msg := range e.Messages {
+		byID[msg.ID] = i
+		var parentID int64
+		if msg.ParentID != nil {
+			parentID = *msg.ParentID
+		}
+		children[parentID] = append(children[parentID], msg.ID)
+	}
+
+	var branch []ExportedMessage
+	if e.Chat.ActiveMessageID == nil {
+		return branch
+	}
+	for id := *e.Chat.ActiveMessageID; ; {
+		i, found := byID[id]
+		if !found {
+			break
+		}
+		msg := e.Messages[i]
+		var parentID int64
+		if msg.ParentID != nil {
+			parentID = *msg.ParentID
+		}
+		if siblings := children[parentID]; len(siblings) > 1 {
+			msg.SiblingIDs = siblings
+		}
+		branch = append([]ExportedMessage{msg}, branch...)
+		if msg.ParentID == nil {
+			break
+		}
+		id = parentID
+	}
+	return branch
+}
+
+// WriteJSON writes the complete export as indented JSON
+func (e *ChatExport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(e)
+}
+
+// WriteMarkdown writes the active branch of the chat as Markdown
+func (e *ChatExport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", exportTitle(e.Chat.Title))
+	fmt.Fprintf(&b, "- Chat ID: %d\n", e.Chat.ID)
+	fmt.Fprintf(&b, "- Created: %s\n", exportTime(e.Chat.CreatedAt))
+	fmt.Fprintf(&b, "- Exported: %s\n", exportTime(e.ExportedAt))
+
+	for _, msg := range e.ActiveBranch() {
+		fmt.Fprintf(&b, "\n---\n\n### %s — %s\n\n", msg.Heading(), exportTime(msg.CreatedAt))
+		if details := msg.Details(); details != "" {
+			fmt.Fprintf(&b, "_%s_\n\n", details)
+		}
+		if msg.Reasoning != "" {
+			fmt.Fprintf(&b, "<details>\n<summary>Reasoning</summary>\n\n%s\n\n</details>\n\n", strings.TrimSpace(msg.Reasoning))
+		}
+		fmt.Fprintf(&b, "%s\n", strings.TrimSpace(msg.Content))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteHTML writes the active branch of the chat as a standalone HTML page
+func (e *ChatExport) WriteHTML(w io.Writer) error {
+	return chatExportHTML.Execute(w, struct {
+		Title      string
+		Chat       ExportedChat
+		ExportedAt time.Time
+		Messages   []ExportedMessage
+	}{exportTitle(e.Chat.Title), e.Chat, e.ExportedAt, e.ActiveBranch()})
+}
+
+// Heading names who wrote the message: the role, and for replies the model
+// and agent.
+func (m ExportedMessage) Heading() string {
+	switch m.Role {
+	case "user":
+		return "User"
+	case "assistant":
+		heading := "Assistant"
+		if m.ModelName != "" {
+			heading += " (" + m.ModelName + ")"
+		}
+		if m.AgentName != "" {
+			heading += " · Agent: " + m.AgentName
+		}
+		return heading
+	case "system":
+		return "System"
+	default:
+		return m.Role
+	}
+}
+
+// Details describes the message's token count and alternative versions
+func (m ExportedMessage) Details() string {
+	var details []string
+	switch {
+	case m.Usage != nil:
+		details = append(details, fmt.Sprintf("%d tokens (%d prompt, %d completion)",
+			m.Usage.TotalTokens, m.Usage.PromptTokens, m.Usage.CompletionTokens))
+	case m.TokensUsed > 0:
+		details = append(details, fmt.Sprintf("%d tokens", m.TokensUsed))
+	}
+	if len(m.SiblingIDs) > 1 {
+		details = append(details, fmt.Sprintf("%d versions, other versions only in the JSON export", len(m.SiblingIDs)))
+	}
+	return strings.Join(details, " · ")
+}
+
+func exportTitle(title string) string {
+	if title == "" {
+		return "Untitled Chat"
+	}
+	return title
+}
+
+func exportTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04 UTC")
+}
+
+var chatExportHTML = template.Must(template.New("chat").Funcs(template.FuncMap{
+	"time": exportTime,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 2em auto; padding: 0 1em; color: #222; }
+.meta { color: #666; font-size: 0.9em; }
+.message { border-top: 1px solid #ddd; padding: 1em 0; }
+.message h3 { margin: 0 0 0.3em; font-size: 1em; }
+.assistant h3 { color: #06823a; }
+.content { white-space: pre-wrap; }
+details { color: #555; margin: 0.5em 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="meta">Chat ID: {{.Chat.ID}} · Created: {{time .Chat.CreatedAt}} · Exported: {{time .ExportedAt}}</p>
+{{range .Messages}}<div class="message {{.Role}}" id="message-{{.ID}}">
+<h3>{{.Heading}}</h3>
+<p class="meta">{{time .CreatedAt}}{{with .Details}} · {{.}}{{end}}</p>
+{{if .Reasoning}}<details><summary>Reasoning</summary><div class="content">{{.Reasoning}}</div></details>
+{{end}}<div class="content">{{.Content}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,446 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Folders, tags and pins are each user's own way of organising their chat
+// list, so they are kept per user and also work for chats shared with them.
+// Nobody else sees them, not even the other participants of a shared chat.
+
+// MaxTagLength is the longest tag, in characters
+const MaxTagLength = 50
+
+// DefaultChatListLimit and MaxChatListLimit bound a page of the chat list
+const (
+	DefaultChatListLimit = 100
+	MaxChatListLimit     = 500
+)
+
+// ErrFolderNotFound is returned when the folder does not exist or belongs to another user
+var ErrFolderNotFound = errors.New("folder not found")
+
+// ErrFolderExists is returned when the user already has a folder with that name
+var ErrFolderExists = errors.New("a folder with that name already exists")
+
+// ChatFolder is a user-defined folder of chats
+type ChatFolder struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Name      string    `json:"name"`
+	Chats     int       `json:"chats"` // Number of chats in the folder
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TagCount is a tag and the number of the user's chats carrying it
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Chats int    `json:"chats"`
+}
+
+// NormalizeTag trims and lower-cases a tag so that "Go " and "go" are the same tag
+func NormalizeTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" {
+		return "", fmt.Errorf("tags cannot be empty")
+	}
+	if utf8.RuneCountInString(tag) > MaxTagLength {
+		return "", fmt.Errorf("tags cannot be longer than %d characters", MaxTagLength)
+	}
+	return tag, nil
+}
+
+// ChatListOptions filter, sort and paginate the chat list. Zero values mean
+// no filter.
+type ChatListOptions struct {
+	FolderID   *int64     // Only chats in this folder; 0 for chats in no folder
+	ProjectID  *int64     // Only chats in this project; 0 for chats in no project
+	Tags       []string   // Only chats with all of these tags
+	ModelID    int64      // Only chats with a message from this model
+	From       *time.Time // Only chats updated at or after this time
+	To         *time.Time // Only chats updated before this time
+	Pinned     *bool      // Only pinned (or only unpinned) chats
+	Sort       string     // "updated_at" (default), "created_at" or "title"
+	Descending bool
+	Limit      int // Defaults to DefaultChatListLimit
+	Offset     int
+}
+
+// chatListSortColumns maps the sort options to the columns they order by
+var chatListSortColumns = map[string]string{
+	"":           "c.updated_at",
+	"updated_at": "c.updated_at",
+	"created_at": "c.created_at",
+	"title":      "c.title COLLATE NOCASE",
+}
+
+// ValidChatListSort reports whether sort is a supported sort order
+func ValidChatListSort(sort string) bool {
+	_, ok := chatListSortColumns[sort]
+	return ok
+}
+
+// sqlTime formats t the way timestamps are stored, so they compare as text
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// ListChats returns a page of the chats the user owns or participates in,
+// pinned chats first, with their folder, pin and tags filled in, and the
+// number of chats matching the filters across all pages
+func (s *ChatService) ListChats(userID int64, opts ChatListOptions) ([]Chat, int, error) {
+	where := []string{"(c.user_id = ? OR p.user_id IS NOT NULL)"}
+	args := []interface{}{userID, userID, userID}
+
+	if opts.FolderID != nil {
+		if *opts.FolderID == 0 {
+			where = append(where, "cs.folder_id IS NULL")
+		} else {
+			where = append(where, "cs.folder_id = ?")
+			args = append(args, *opts.FolderID)
+		}
+	}
+	if opts.ProjectID != nil {
+		if *opts.ProjectID == 0 {
+			where = append(where, "c.project_id IS NULL")
+		} else {
+			where = append(where, "c.project_id = ?")
+			args = append(args, *opts.ProjectID)
+		}
+	}
+	for _, tag := range opts.Tags {
+		where = append(where, "EXISTS (SELECT 1 FROM chat_tags t WHERE t.chat_id = c.id AND t.user_id = ? AND t.tag = ?)")
+		args = append(args, userID, tag)
+	}
+	if opts.ModelID != 0 {
+		where = append(where, "EXISTS (SELECT 1 FROM messages m WHERE m.chat_id = c.id AND m.model_id = ?)")
+		args = append(args, opts.ModelID)
+	}
+	if opts.From != nil {
+		where = append(where, "c.updated_at >= ?")
+		args = append(args, sqlTime(*opts.From))
+	}
+	if opts.To != nil {
+		where = append(where, "c.updated_at < ?")
+		args = append(args, sqlTime(*opts.To))
+	}
+	if opts.Pinned != nil {
+		where = append(where, "COALESCE(cs.is_pinned, 0) = ?")
+		args = append(args, *opts.Pinned)
+	}
+
+	from := `
+		FROM chats c
+		LEFT JOIN chat_participants p ON p.chat_id = c.id AND p.user_id = ?
+		LEFT JOIN chat_user_settings cs ON cs.chat_id = c.id AND cs.user_id = ?
+		WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	if err := s.DB.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count chats: %w", err)
+	}
+
+	column, ok := chatListSortColumns[opts.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("invalid chat list sort: %q", opts.Sort)
+	}
+	direction := "ASC"
+	if opts.Descending {
+		direction = "DESC"
+	}
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultChatListLimit
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT c.id, c.title, c.user_id, c.is_active, c.project_id, c.created_at, c.updated_at,
+		       COALESCE(p.role, ''), cs.folder_id, COALESCE(cs.is_pinned, 0)
+		`+from+`
+		ORDER BY COALESCE(cs.is_pinned, 0) DESC, `+column+` `+direction+`, c.id `+direction+`
+		LIMIT ? OFFSET ?
+	`, append(args, limit, opts.Offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query chats: %w", err)
+	}
+	defer rows.Close()
+
+	chats := []Chat{}
+	for rows.Next() {
+		var chat Chat
+		var projectID, folderID sql.NullInt64
+		if err := rows.Scan(
+			&chat.ID, &chat.Title, &chat.UserID, &chat.IsActive, &projectID, &chat.CreatedAt, &chat.UpdatedAt,
+			&chat.Role, &folderID, &chat.IsPinned,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan chat: %w", err)
+		}
+		if projectID.Valid {
+			chat.ProjectID = &projectID.Int64
+		}
+		if folderID.Valid {
+			chat.FolderID = &folderID.Int64
+		}
+		chats = append(chats, chat)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating chats: %w", err)
+	}
+
+	if err := s.loadChatTags(userID, chats); err != nil {
+		return nil, 0, err
+	}
+	return chats, total, nil
+}
+
+// loadChatTags sets the Tags of each chat to the user's tags on it
+func (s *ChatService) loadChatTags(userID int64, chats []Chat) error {
+	if len(chats) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Chat, len(chats))
+	placeholders := make([]string, len(chats))
+	args := []interface{}{userID}
+	for i := range chats {
+		byID[chats[i].ID] = &chats[i]
+		placeholders[i] = "?"
+		args = append(args, chats[i].ID)
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT chat_id, tag FROM chat_tags
+		WHERE user_id = ? AND chat_id IN (`+strings.Join(placeholders, ",")+`)
+		ORDER BY tag
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query chat tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID int64
+		var tag string
+		if err := rows.Scan(&chatID, &tag); err != nil {
+			return fmt.Errorf("failed to scan chat tag: %w", err)
+		}
+		chat := byID[chatID]
+		chat.Tags = append(chat.Tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating chat tags: %w", err)
+	}
+	return nil
+}
+
+// LoadChatOrganization fills in the user's folder, pin and tags on the chat
+func (s *ChatService) LoadChatOrganization(userID int64, chat *Chat) error {
+	var folderID sql.NullInt64
+	err := s.DB.QueryRow(`
+		SELECT folder_id, is_pinned FROM chat_user_settings WHERE chat_id = ? AND user_id = ?
+	`, chat.ID, userID).Scan(&folderID, &chat.IsPinned)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get chat settings: %w", err)
+	}
+	chat.FolderID = nil
+	if folderID.Valid {
+		chat.FolderID = &folderID.Int64
+	}
+
+	chats := []Chat{{ID: chat.ID}}
+	if err := s.loadChatTags(userID, chats); err != nil {
+		return err
+	}
+	chat.Tags = chats[0].Tags
+	return nil
+}
+
+// ChatOrganization is a change to how the user has organised some chats.
+// Nil fields are left as they are.
+type ChatOrganization struct {
+	FolderID   *int64 // Move into this folder; 0 to take out of any folder
+	Pinned     *bool
+	AddTags    []string // Normalized with NormalizeTag
+	RemoveTags []string
+}
+
+// OrganizeChats applies the change to each of the chats, for the user only,
+// in a single transaction. The caller checks the user can access the chats.
+func (s *ChatService) OrganizeChats(userID int64, chatIDs []int64, org ChatOrganization) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		var folderID interface{}
+		if org.FolderID != nil && *org.FolderID != 0 {
+			var exists bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM chat_folders WHERE id = ? AND user_id = ?)`, *org.FolderID, userID).Scan(&exists)
+			if err != nil {
+				return fmt.Errorf("failed to get folder: %w", err)
+			}
+			if !exists {
+				return ErrFolderNotFound
+			}
+			folderID = *org.FolderID
+		}
+
+		for _, chatID := range chatIDs {
+			if org.FolderID != nil {
+				_, err := tx.Exec(`
+					INSERT INTO chat_user_settings (chat_id, user_id, folder_id) VALUES (?, ?, ?)
+					ON CONFLICT (chat_id, user_id) DO UPDATE SET folder_id = excluded.folder_id
+				`, chatID, userID, folderID)
+				if err != nil {
+					return fmt.Errorf("failed to move chat %d: %w", chatID, err)
+				}
+			}
+			if org.Pinned != nil {
+				_, err := tx.Exec(`
+					INSERT INTO chat_user_settings (chat_id, user_id, is_pinned) VALUES (?, ?, ?)
+					ON CONFLICT (chat_id, user_id) DO UPDATE SET is_pinned = excluded.is_pinned
+				`, chatID, userID, *org.Pinned)
+				if err != nil {
+					return fmt.Errorf("failed to pin chat %d: %w", chatID, err)
+				}
+			}
+			for _, tag := range org.AddTags {
+				_, err := tx.Exec(`INSERT OR IGNORE INTO chat_tags (chat_id, user_id, tag) VALUES (?, ?, ?)`, chatID, userID, tag)
+				if err != nil {
+					return fmt.Errorf("failed to tag chat %d: %w", chatID, err)
+				}
+			}
+			for _, tag := range org.RemoveTags {
+				_, err := tx.Exec(`DELETE FROM chat_tags WHERE chat_id = ? AND user_id = ? AND tag = ?`, chatID, userID, tag)
+				if err != nil {
+					return fmt.Errorf("failed to untag chat %d: %w", chatID, err)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// GetUserTags lists the tags the user has put on chats, with how many chats
+// carry each
+func (s *ChatService) GetUserTags(userID int64) ([]TagCount, error) {
+	rows, err := s.DB.Query(`
+		SELECT tag, COUNT(*) FROM chat_tags WHERE user_id = ? GROUP BY tag ORDER BY tag
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tags: %w", err)
+	}
+	defer rows.Close()
+
+	tags := []TagCount{}
+	for rows.Next() {
+		var t TagCount
+		if err := rows.Scan(&t.Tag, &t.Chats); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tags: %w", err)
+	}
+	return tags, nil
+}
+
+// GetChatFolders lists the user's folders by name
+func (s *ChatService) GetChatFolders(userID int64) ([]ChatFolder, error) {
+	rows, err := s.DB.Query(`
+		SELECT f.id, f.user_id, f.name, f.created_at, f.updated_at,
+		       (SELECT COUNT(*) FROM chat_user_settings cs WHERE cs.folder_id = f.id)
+		FROM chat_folders f
+		WHERE f.user_id = ?
+		ORDER BY f.name COLLATE NOCASE
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer rows.Close()
+
+	folders := []ChatFolder{}
+	for rows.Next() {
+		var f ChatFolder
+		if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.CreatedAt, &f.UpdatedAt, &f.Chats); err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating folders: %w", err)
+	}
+	return folders, nil
+}
+
+// getChatFolder returns one of the user's folders
+func (s *ChatService) getChatFolder(folderID, userID int64) (*ChatFolder, error) {
+	var f ChatFolder
+	err := s.DB.QueryRow(`
+		SELECT f.id, f.user_id, f.name, f.created_at, f.updated_at,
+		       (SELECT COUNT(*) FROM chat_user_settings cs WHERE cs.folder_id = f.id)
+		FROM chat_folders f
+		WHERE f.id = ? AND f.user_id = ?
+	`, folderID, userID).Scan(&f.ID, &f.UserID, &f.Name, &f.CreatedAt, &f.UpdatedAt, &f.Chats)
+	if err == sql.ErrNoRows {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+	return &f, nil
+}
+
+// CreateChatFolder creates a folder for the user
+func (s *ChatService) CreateChatFolder(userID int64, name string) (*ChatFolder, error) {
+	result, err := s.DB.Exec(`INSERT INTO chat_folders (user_id, name) VALUES (?, ?)`, userID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: chat_folders.user_id, chat_folders.name") {
+			return nil, ErrFolderExists
+		}
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+	folderID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder ID: %w", err)
+	}
+	return s.getChatFolder(folderID, userID)
+}
+
+// RenameChatFolder renames one of the user's folders
+func (s *ChatService) RenameChatFolder(folderID, userID int64, name string) (*ChatFolder, error) {
+	result, err := s.DB.Exec(`
+		UPDATE chat_folders SET name = ?, updated_at = ? WHERE id = ? AND user_id = ?
+	`, name, time.Now(), folderID, userID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: chat_folders.user_id, chat_folders.name") {
+			return nil, ErrFolderExists
+		}
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, ErrFolderNotFound
+	}
+	return s.getChatFolder(folderID, userID)
+}
+
+// DeleteChatFolder deletes one of the user's folders. Its chats are kept and
+// are no longer in a folder.
+func (s *ChatService) DeleteChatFolder(folderID, userID int64) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(`UPDATE chat_user_settings SET folder_id = NULL WHERE folder_id = ? AND user_id = ?`, folderID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to empty folder: %w", err)
+		}
+		result, err := tx.Exec(`DELETE FROM chat_folders WHERE id = ? AND user_id = ?`, folderID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete folder: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return ErrFolderNotFound
+		}
+		return nil
+	})
+}
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ImportedChat is a conversation read from an export file, ready to be
+// created as a chat. Messages are ordered so that parents come before their
+// replies.
+type ImportedChat struct {
+	Title     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Messages  []ImportedMessage
+	ActiveRef string // Ref of the last message of the active branch (the last message if empty)
+
+	// Rolling summary from a CyberAI export; ThroughMessageID is ignored in
+	// favour of SummaryThroughRef
+	Summary           *ChatSummary
+	SummaryThroughRef string
+}
+
+// ImportedMessage is a message read from an export file. Ref and ParentRef
+// are the IDs it had in the export, used to rebuild the conversation.
+type ImportedMessage struct {
+	Ref        string
+	ParentRef  string // Empty for the first message
+	Role       string
+	Content    string
+	Reasoning  string
+	ModelName  string // Name or provider model ID of the model that wrote it, matched against local models
+	TokensUsed int
+	CreatedAt  time.Time
+}
+
+// ImportReport describes the outcome of an import: the chats created and
+// everything in the file that was left out.
+type ImportReport struct {
+	Format   string             `json:"format"` // "chatgpt", "claude" or "cyberai"
+	Imported []ImportedChatInfo `json:"imported"`
+	Skipped  []ImportSkip       `json:"skipped"`
+}
+
+// ImportedChatInfo is a chat created by an import
+type ImportedChatInfo struct {
+	ChatID   int64  `json:"chat_id"`
+	Title    string `json:"title"`
+	Messages int    `json:"messages"`
+}
+
+// ImportSkip is part of an export that was not imported, and why
+type ImportSkip struct {
+	Conversation string `json:"conversation"`   // Title of the conversation it belongs to
+	Item         string `json:"item,omitempty"` // What was skipped within it; empty if the whole conversation was
+	Reason       string `json:"reason"`
+}
+
+// Skip records that an item (or, with an empty item, a whole conversation)
+// was not imported
+func (r *ImportReport) Skip(conversation, item, reason string) {
+	r.Skipped = append(r.Skipped, ImportSkip{Conversation: conversation, Item: item, Reason: reason})
+}
+
+// ImportChat creates a chat for the user from an imported conversation,
+// keeping its timestamps and branches. Assistant messages are linked to a
+// local model with the same name or provider model ID, if there is one.
+func (s *ChatService) ImportChat(userID int64, imported *ImportedChat) (*Chat, error) {
+	if len(imported.Messages) == 0 {
+		return nil, fmt.Errorf("conversation has no messages")
+	}
+
+	title := strings.TrimSpace(imported.Title)
+	if title == "" {
+		title = "Imported Chat"
+	}
+	createdAt := importTime(imported.CreatedAt, imported.Messages[0].CreatedAt)
+	updatedAt := importTime(imported.UpdatedAt, imported.Messages[len(imported.Messages)-1].CreatedAt)
+
+	chat := Chat{Title: title, UserID: userID, IsActive: true, CreatedAt: createdAt, UpdatedAt: updatedAt}
+	modelIDs := make(map[string]*int64)
+
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`
+			INSERT INTO chats (user_id, title, is_active, created_at, updated_at)
+			VALUES (?, ?, 1, ?, ?)
+		`, userID, title, createdAt, updatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to create chat: %w", err)
+		}
+		chat.ID, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("failed to get chat ID: %w", err)
+		}
+
+		ids := make(map[string]int64, len(imported.Messages))
+		var lastID int64
+		for _, msg := range imported.Messages {
+			var parentID *int64
+			if msg.ParentRef != "" {
+				id, ok := ids[msg.ParentRef]
+				if !ok {
+					return fmt.Errorf("message %s replies to unknown message %s", msg.Ref, msg.ParentRef)
+				}
+				parentID = &id
+			}
+
+			var messageUserID int64
+			var modelID *int64
+			if msg.Role == "user" {
+				messageUserID = userID
+			} else if msg.ModelName != "" {
+				if _, looked := modelIDs[msg.ModelName]; !looked {
+					modelIDs[msg.ModelName], err = lookupModelID(tx, msg.ModelName)
+					if err != nil {
+						return err
+					}
+				}
+				modelID = modelIDs[msg.ModelName]
+			}
+
+			result, err := tx.Exec(`
+				INSERT INTO messages (chat_id, user_id, role, content, reasoning, parent_id, model_id, tokens_used, created_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`, chat.ID, messageUserID, msg.Role, msg.Content, msg.Reasoning, parentID, modelID,
+				msg.TokensUsed, importTime(msg.CreatedAt, createdAt))
+			if err != nil {
+				return fmt.Errorf("failed to add message: %w", err)
+			}
+			lastID, err = result.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("failed to get message ID: %w", err)
+			}
+			if msg.Ref != "" {
+				ids[msg.Ref] = lastID
+			}
+		}
+
+		activeID := lastID
+		if id, ok := ids[imported.ActiveRef]; ok {
+			activeID = id
+		}
+		chat.ActiveMessageID = &activeID
+		if _, err := tx.Exec(`UPDATE chats SET active_message_id = ? WHERE id = ?`, activeID, chat.ID); err != nil {
+			return fmt.Errorf("failed to set active message: %w", err)
+		}
+
+		if imported.Summary != nil {
+			if throughID, ok := ids[imported.SummaryThroughRef]; ok {
+				_, err := tx.Exec(`
+					INSERT INTO chat_summaries (chat_id, content, through_message_id, is_edited, created_at, updated_at)
+					VALUES (?, ?, ?, ?, ?, ?)
+				`, chat.ID, imported.Summary.Content, throughID, imported.Summary.IsEdited,
+					importTime(imported.Summary.CreatedAt, updatedAt), importTime(imported.Summary.UpdatedAt, updatedAt))
+				if err != nil {
+					return fmt.Errorf("failed to import chat summary: %w", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+// lookupModelID finds a local model by display name or provider model ID,
+// preferring active models. It returns nil if there is none.
+func lookupModelID(tx *sql.Tx, name string) (*int64, error) {
+	var id int64
+	err := tx.QueryRow(`
+		SELECT id FROM models
+		WHERE name = ? OR model_id = ?
+		ORDER BY is_active DESC, id ASC
+		LIMIT 1
+	`, name, name).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up model %q: %w", name, err)
+	}
+	return &id, nil
+}
+
+// importTime returns t in UTC, or fallback if t is unknown
+func importTime(t, fallback time.Time) time.Time {
+	if t.IsZero() {
+		t = fallback
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return t.UTC()
+}
@@ -0,0 +1,593 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxImportFileSize is the largest JSON file that is imported, on its own or
+// in an archive
+const MaxImportFileSize = 256 << 20
+
+// ErrUnknownImportFormat is returned for files that are not a supported export
+var ErrUnknownImportFormat = errors.New("not a ChatGPT, Claude or CyberAI export")
+
+// ParseChatImport reads the conversations in an export: ChatGPT's or
+// Claude's conversations.json (or the zip archive containing it), or a
+// CyberAI JSON export (one chat, or the zip archive of a bulk export).
+// Branched ChatGPT and Claude conversations are flattened to the branch that
+// was showing; CyberAI exports keep every branch. What cannot be imported is
+// listed in the report.
+func ParseChatImport(file io.ReaderAt, size int64) ([]ImportedChat, *ImportReport, error) {
+	report := &ImportReport{Imported: []ImportedChatInfo{}, Skipped: []ImportSkip{}}
+	magic := make([]byte, 4)
+	if _, err := file.ReadAt(magic, 0); err != nil || !bytes.Equal(magic, []byte("PK\x03\x04")) {
+		if size > MaxImportFileSize {
+			return nil, nil, fmt.Errorf("file is larger than %d MB", MaxImportFileSize>>20)
+		}
+		data, err := io.ReadAll(io.NewSectionReader(file, 0, size))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		chats, err := parseImportJSON(data, report)
+		return chats, report, err
+	}
+
+	archive, err := zip.NewReader(file, size)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open zip archive: %w", err)
+	}
+
+	// ChatGPT and Claude archives hold everything in conversations.json,
+	// next to files that are not imported
+	for _, entry := range archive.File {
+		if path.Base(entry.Name) == "conversations.json" {
+			content, err := readImportFile(entry)
+			if err != nil {
+				return nil, nil, err
+			}
+			chats, err := parseImportJSON(content, report)
+			return chats, report, err
+		}
+	}
+
+	// A CyberAI archive holds one file per chat
+	var chats []ImportedChat
+	for _, entry := range archive.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		if path.Ext(entry.Name) != ".json" {
+			report.Skip(entry.Name, "", "only JSON exports can be imported")
+			continue
+		}
+		content, err := readImportFile(entry)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileChats, err := parseImportJSON(content, report)
+		if err != nil {
+			report.Skip(entry.Name, "", err.Error())
+			continue
+		}
+		chats = append(chats, fileChats...)
+	}
+	if report.Format == "" {
+		return nil, nil, ErrUnknownImportFormat
+	}
+	return chats, report, nil
+}
+
+func readImportFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > MaxImportFileSize {
+		return nil, fmt.Errorf("%s is larger than %d MB", file.Name, MaxImportFileSize>>20)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(io.LimitReader(reader, MaxImportFileSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+	}
+	return content, nil
+}
+
+// parseImportJSON recognises the export format from its structure
+func parseImportJSON(data []byte, report *ImportReport) ([]ImportedChat, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, ErrUnknownImportFormat
+	}
+
+	var probe struct {
+		Format       string          `json:"format"`
+		Mapping      json.RawMessage `json:"mapping"`
+		ChatMessages json.RawMessage `json:"chat_messages"`
+	}
+	switch data[0] {
+	case '{':
+		if err := json.Unmarshal(data, &probe); err != nil || probe.Format != ChatExportFormat {
+			return nil, ErrUnknownImportFormat
+		}
+		var export ChatExport
+		if err := json.Unmarshal(data, &export); err != nil {
+			return nil, fmt.Errorf("invalid CyberAI export: %w", err)
+		}
+		if err := setImportFormat(report, "cyberai"); err != nil {
+			return nil, err
+		}
+		return parseCyberAIExport([]ChatExport{export}, report), nil
+	case '[':
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, ErrUnknownImportFormat
+		}
+		if len(items) == 0 {
+			return nil, nil
+		}
+		if err := json.Unmarshal(items[0], &probe); err != nil {
+			return nil, ErrUnknownImportFormat
+		}
+		switch {
+		case probe.Mapping != nil:
+			var conversations []chatGPTConversation
+			if err := json.Unmarshal(data, &conversations); err != nil {
+				return nil, fmt.Errorf("invalid ChatGPT export: %w", err)
+			}
+			if err := setImportFormat(report, "chatgpt"); err != nil {
+				return nil, err
+			}
+			return parseChatGPTExport(conversations, report), nil
+		case probe.ChatMessages != nil:
+			var conversations []claudeConversation
+			if err := json.Unmarshal(data, &conversations); err != nil {
+				return nil, fmt.Errorf("invalid Claude export: %w", err)
+			}
+			if err := setImportFormat(report, "claude"); err != nil {
+				return nil, err
+			}
+			return parseClaudeExport(conversations, report), nil
+		case probe.Format == ChatExportFormat:
+			var exports []ChatExport
+			if err := json.Unmarshal(data, &exports); err != nil {
+				return nil, fmt.Errorf("invalid CyberAI export: %w", err)
+			}
+			if err := setImportFormat(report, "cyberai"); err != nil {
+				return nil, err
+			}
+			return parseCyberAIExport(exports, report), nil
+		}
+	}
+	return nil, ErrUnknownImportFormat
+}
+
+// setImportFormat records the format of the export, before its conversations
+// are parsed so that a file in another format adds nothing to the report
+func setImportFormat(report *ImportReport, format string) error {
+	if report.Format != "" && report.Format != format {
+		return fmt.Errorf("%s export mixed with %s export", format, report.Format)
+	}
+	report.Format = format
+	return nil
+}
+
+// skipCounter counts the items of a conversation that are not imported, so
+// that the report lists "12 tool messages" rather than twelve entries
+type skipCounter struct {
+	items  []string
+	counts map[string]int
+	reason map[string]string
+}
+
+func (c *skipCounter) add(item, reason string) {
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+		c.reason = make(map[string]string)
+	}
+	if c.counts[item] == 0 {
+		c.items = append(c.items, item)
+		c.reason[item] = reason
+	}
+	c.counts[item]++
+}
+
+func (c *skipCounter) report(report *ImportReport, conversation string) {
+	for _, item := range c.items {
+		label := item
+		if n := c.counts[item]; n > 1 {
+			label = fmt.Sprintf("%d × %s", n, item)
+		}
+		report.Skip(conversation, label, c.reason[item])
+	}
+}
+
+// importTitle names a conversation in the report
+func importTitle(title string) string {
+	if strings.TrimSpace(title) == "" {
+		return "Untitled"
+	}
+	return title
+}
+
+// --- ChatGPT ---
+
+type chatGPTConversation struct {
+	Title       string                 `json:"title"`
+	CreateTime  float64                `json:"create_time"`
+	UpdateTime  float64                `json:"update_time"`
+	Mapping     map[string]chatGPTNode `json:"mapping"`
+	CurrentNode string                 `json:"current_node"`
+}
+
+type chatGPTNode struct {
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	ID     string `json:"id"`
+	Author struct {
+		Role string `json:"role"`
+		Name string `json:"name"`
+	} `json:"author"`
+	CreateTime float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"`
+		Thoughts    []struct {
+			Summary string `json:"summary"`
+			Content string `json:"content"`
+		} `json:"thoughts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug    string `json:"model_slug"`
+		IsHidden     bool   `json:"is_visually_hidden_from_conversation"`
+		IsUserSystem bool   `json:"is_user_system_message"`
+	} `json:"metadata"`
+}
+
+func parseChatGPTExport(conversations []chatGPTConversation, report *ImportReport) []ImportedChat {
+	var chats []ImportedChat
+	for _, conversation := range conversations {
+		title := importTitle(conversation.Title)
+		chat := ImportedChat{
+			Title:     conversation.Title,
+			CreatedAt: unixTime(conversation.CreateTime),
+			UpdatedAt: unixTime(conversation.UpdateTime),
+		}
+
+		var skipped skipCounter
+		var reasoning []string // Thoughts shown before the next reply
+		var parentRef string
+		for _, node := range chatGPTMainPath(conversation) {
+			msg := node.Message
+			if msg == nil || msg.Metadata.IsHidden || msg.Metadata.IsUserSystem {
+				continue
+			}
+
+			switch msg.Content.ContentType {
+			case "thoughts":
+				for _, thought := range msg.Content.Thoughts {
+					reasoning = append(reasoning, strings.TrimSpace(thought.Summary+"\n"+thought.Content))
+				}
+				continue
+			case "reasoning_recap", "user_editable_context", "model_editable_context":
+				continue // Shown by ChatGPT in place of reasoning, or hidden settings
+			case "text", "multimodal_text":
+			default:
+				skipped.add(msg.Content.ContentType+" message", "only text messages are imported")
+				continue
+			}
+
+			var parts []string
+			for _, raw := range msg.Content.Parts {
+				var part string
+				if err := json.Unmarshal(raw, &part); err != nil {
+					skipped.add("image or file", "attachments are not imported")
+					continue
+				}
+				parts = append(parts, part)
+			}
+			content := strings.TrimSpace(strings.Join(parts, "\n"))
+			if content == "" {
+				continue
+			}
+
+			role := msg.Author.Role
+			if role != "user" && role != "assistant" {
+				skipped.add(role+" message", "only user and assistant messages are imported")
+				continue
+			}
+
+			imported := ImportedMessage{
+				Ref:       msg.ID,
+				ParentRef: parentRef,
+				Role:      role,
+				Content:   content,
+				CreatedAt: unixTime(msg.CreateTime),
+			}
+			if role == "assistant" {
+				imported.ModelName = msg.Metadata.ModelSlug
+				imported.Reasoning = strings.Join(reasoning, "\n\n")
+				reasoning = nil
+			}
+			chat.Messages = append(chat.Messages, imported)
+			parentRef = msg.ID
+		}
+
+		skipped.report(report, title)
+		if len(chat.Messages) == 0 {
+			report.Skip(title, "", "no text messages")
+			continue
+		}
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
+// chatGPTMainPath returns the nodes of the branch ChatGPT was showing, from
+// the root down to current_node (or down the newest replies, if unset)
+func chatGPTMainPath(conversation chatGPTConversation) []chatGPTNode {
+	leaf := conversation.CurrentNode
+	if _, ok := conversation.Mapping[leaf]; !ok {
+		// Start from the root and follow the last reply at each step
+		leaf = ""
+		for id, node := range conversation.Mapping {
+			if node.Parent == "" {
+				leaf = id
+				break
+			}
+		}
+		for seen := 0; leaf != "" && seen < len(conversation.Mapping); seen++ {
+			children := conversation.Mapping[leaf].Children
+			if len(children) == 0 {
+				break
+			}
+			leaf = children[len(children)-1]
+		}
+	}
+
+	var path []chatGPTNode
+	for id := leaf; id != "" && len(path) <= len(conversation.Mapping); {
+		node, ok := conversation.Mapping[id]
+		if !ok {
+			break
+		}
+		path = append(path, node)
+		id = node.Parent
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+func unixTime(seconds float64) time.Time {
+	if seconds <= 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
+
+// --- Claude ---
+
+type claudeConversation struct {
+	UUID         string          `json:"uuid"`
+	Name         string          `json:"name"`
+	CreatedAt    string          `json:"created_at"`
+	UpdatedAt    string          `json:"updated_at"`
+	ChatMessages []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	UUID              string `json:"uuid"`
+	ParentMessageUUID string `json:"parent_message_uuid"`
+	Sender            string `json:"sender"`
+	Text              string `json:"text"`
+	CreatedAt         string `json:"created_at"`
+	Content           []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+	} `json:"content"`
+	Attachments []struct {
+		FileName string `json:"file_name"`
+	} `json:"attachments"`
+	Files []struct {
+		FileName string `json:"file_name"`
+	} `json:"files"`
+}
+
+func parseClaudeExport(conversations []claudeConversation, report *ImportReport) []ImportedChat {
+	var chats []ImportedChat
+	for _, conversation := range conversations {
+		title := importTitle(conversation.Name)
+		chat := ImportedChat{
+			Title:     conversation.Name,
+			CreatedAt: parseImportTime(conversation.CreatedAt),
+			UpdatedAt: parseImportTime(conversation.UpdatedAt),
+		}
+
+		var skipped skipCounter
+		var parentRef string
+		for i, msg := range claudeMainPath(conversation.ChatMessages) {
+			var role string
+			switch msg.Sender {
+			case "human":
+				role = "user"
+			case "assistant":
+				role = "assistant"
+			default:
+				skipped.add(msg.Sender+" message", "only user and assistant messages are imported")
+				continue
+			}
+
+			content, reasoning := msg.Text, ""
+			if len(msg.Content) > 0 {
+				var texts, thoughts []string
+				for _, block := range msg.Content {
+					switch block.Type {
+					case "text":
+						texts = append(texts, block.Text)
+					case "thinking":
+						thoughts = append(thoughts, block.Thinking)
+					default:
+						skipped.add(block.Type+" block", "only text is imported")
+					}
+				}
+				content = strings.Join(texts, "\n\n")
+				reasoning = strings.TrimSpace(strings.Join(thoughts, "\n\n"))
+			}
+			for _, attachment := range msg.Attachments {
+				skipped.add("attachment "+attachment.FileName, "attachments are not imported")
+			}
+			for _, file := range msg.Files {
+				skipped.add("file "+file.FileName, "attachments are not imported")
+			}
+
+			content = strings.TrimSpace(content)
+			if content == "" {
+				continue
+			}
+			ref := msg.UUID
+			if ref == "" {
+				ref = strconv.Itoa(i)
+			}
+			chat.Messages = append(chat.Messages, ImportedMessage{
+				Ref:       ref,
+				ParentRef: parentRef,
+				Role:      role,
+				Content:   content,
+				Reasoning: reasoning,
+				CreatedAt: parseImportTime(msg.CreatedAt),
+			})
+			parentRef = ref
+		}
+
+		skipped.report(report, title)
+		if len(chat.Messages) == 0 {
+			report.Skip(title, "", "no text messages")
+			continue
+		}
+		chats = append(chats, chat)
+	}
+	return chats
+}
+
+// claudeMainPath returns the messages of the branch ending with the last
+// message. Older exports list only that branch, without parent links.
+func claudeMainPath(messages []claudeMessage) []claudeMessage {
+	byUUID := make(map[string]claudeMessage, len(messages))
+	linked := false
+	for _, msg := range messages {
+		byUUID[msg.UUID] = msg
+		if msg.ParentMessageUUID != "" {
+			linked = true
+		}
+	}
+	if !linked || len(messages) == 0 {
+		return messages
+	}
+
+	var path []claudeMessage
+	for msg, ok := messages[len(messages)-1], true; ok && len(path) <= len(messages); msg, ok = byUUID[msg.ParentMessageUUID] {
+		path = append(path, msg)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// parseImportTime reads an RFC 3339 timestamp, returning the zero time if it
+// is missing or malformed
+func parseImportTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// --- CyberAI ---
+
+func parseCyberAIExport(exports []ChatExport, report *ImportReport) []ImportedChat {
+	var chats []ImportedChat
+	for _, export := range exports {
+		title := importTitle(export.Chat.Title)
+		if export.Version > ChatExportVersion {
+			report.Skip(title, "", fmt.Sprintf("export version %d is newer than this server supports", export.Version))
+			continue
+		}
+
+		// Parents have lower IDs than their replies
+		messages := append([]ExportedMessage(nil), export.Messages...)
+		sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+		chat := ImportedChat{
+			Title:     export.Chat.Title,
+			CreatedAt: export.Chat.CreatedAt,
+			UpdatedAt: export.Chat.UpdatedAt,
+		}
+		if export.Chat.ActiveMessageID != nil {
+			chat.ActiveRef = strconv.FormatInt(*export.Chat.ActiveMessageID, 10)
+		}
+		if export.Summary != nil {
+			chat.Summary = export.Summary
+			chat.SummaryThroughRef = strconv.FormatInt(export.Summary.ThroughMessageID, 10)
+		}
+
+		var skipped skipCounter
+		known := make(map[int64]bool, len(messages))
+		for _, msg := range messages {
+			switch msg.Role {
+			case "user", "assistant", "system":
+			default:
+				skipped.add(msg.Role+" message", "unknown role")
+				continue
+			}
+			var parentRef string
+			if msg.ParentID != nil {
+				if !known[*msg.ParentID] {
+					skipped.add("message without its parent", "the message it replies to is missing")
+					continue
+				}
+				parentRef = strconv.FormatInt(*msg.ParentID, 10)
+			}
+			known[msg.ID] = true
+			chat.Messages = append(chat.Messages, ImportedMessage{
+				Ref:        strconv.FormatInt(msg.ID, 10),
+				ParentRef:  parentRef,
+				Role:       msg.Role,
+				Content:    msg.Content,
+				Reasoning:  msg.Reasoning,
+				ModelName:  msg.ModelName,
+				TokensUsed: msg.TokensUsed,
+				CreatedAt:  msg.CreatedAt,
+			})
+		}
+
+		skipped.report(report, title)
+		if len(chat.Messages) == 0 {
+			report.Skip(title, "", "no messages")
+			continue
+		}
+		chats = append(chats, chat)
+	}
+	return chats
+}
@@ -0,0 +1,381 @@
+package models
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// parseImport parses an export held in memory
+func parseImport(t *testing.T, data []byte) ([]ImportedChat, *ImportReport, error) {
+	t.Helper()
+	return ParseChatImport(bytes.NewReader(data), int64(len(data)))
+}
+
+// parseFixture parses an export in testdata/import, which must succeed
+func parseFixture(t *testing.T, name string) ([]ImportedChat, *ImportReport) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "import", name))
+	if err != nil {
+		t.Fatalf("reading %s: %v", name, err)
+	}
+	chats, report, err := parseImport(t, data)
+	if err != nil {
+		t.Fatalf("ParseChatImport(%s): %v", name, err)
+	}
+	return chats, report
+}
+
+// outline describes the messages of a chat as "parent>ref role: content"
+func outline(chat ImportedChat) []string {
+	lines := make([]string, len(chat.Messages))
+	for i, msg := range chat.Messages {
+		lines[i] = fmt.Sprintf("%s>%s %s: %s", msg.ParentRef, msg.Ref, msg.Role, msg.Content)
+	}
+	return lines
+}
+
+// zipArchive returns a zip archive of the files, in order
+func zipArchive(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := archive.Create(file[0])
+		if err != nil {
+			t.Fatalf("zip Create: %v", err)
+		}
+		if _, err := w.Write([]byte(file[1])); err != nil {
+			t.Fatalf("zip Write: %v", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("zip Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func checkImport(t *testing.T, chats []ImportedChat, report *ImportReport, format string, want [][]string, skipped []ImportSkip) {
+	t.Helper()
+	if report.Format != format {
+		t.Errorf("format = %q, want %q", report.Format, format)
+	}
+	if len(chats) != len(want) {
+		t.Fatalf("imported %d chats, want %d", len(chats), len(want))
+	}
+	for i, chat := range chats {
+		if got := outline(chat); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("chat %d (%s) messages:\n%s\nwant:\n%s", i, chat.Title, strings.Join(got, "\n"), strings.Join(want[i], "\n"))
+		}
+	}
+	if !reflect.DeepEqual(report.Skipped, skipped) {
+		t.Errorf("skipped = %+v\nwant %+v", report.Skipped, skipped)
+	}
+}
+
+func TestParseChatGPTExport(t *testing.T) {
+	chats, report := parseFixture(t, "chatgpt.json")
+	checkImport(t, chats, report, "chatgpt", [][]string{
+		{
+			// The branch ending at current_node, not the newer regenerated reply
+			">u1 user: What is Go?",
+			"u1>a1 assistant: A programming language.",
+		},
+		{
+			// Without current_node, the newest reply at each branch
+			">u1 user: Search the web",
+			"u1>new assistant: Second summary",
+		},
+	}, []ImportSkip{
+		{"Go questions", "image or file", "attachments are not imported"},
+		{"Untitled", "code message", "only text messages are imported"},
+		{"Untitled", "2 × tool message", "only user and assistant messages are imported"},
+		{"Only code", "execution_output message", "only text messages are imported"},
+		{"Only code", "", "no text messages"},
+	})
+
+	chat := chats[0]
+	if want := time.Unix(1700000000, 5e8).UTC(); !chat.CreatedAt.Equal(want) {
+		t.Errorf("created at %v, want %v", chat.CreatedAt, want)
+	}
+	if reply := chat.Messages[1]; reply.ModelName != "gpt-4o" || reply.Reasoning != "Recall\nGo is a language." {
+		t.Errorf("reply has model %q and reasoning %q", reply.ModelName, reply.Reasoning)
+	}
+	if chat.ActiveRef != "" {
+		t.Errorf("active ref = %q, want the last message", chat.ActiveRef)
+	}
+}
+
+func TestParseClaudeExport(t *testing.T) {
+	chats, report := parseFixture(t, "claude.json")
+	checkImport(t, chats, report, "claude", [][]string{
+		{
+			// The branch ending with the last message, after the edit
+			">m1 user: Write a poem",
+			"m1>m2 assistant: Roses are red.",
+			"m2>m3b user: Make it rhyme",
+			"m3b>m4b assistant: Roses are red, I rhyme instead.",
+		},
+		{
+			// Older exports have neither parent links nor UUIDs
+			">0 user: Hi",
+			"0>1 assistant: Hello!",
+		},
+	}, []ImportSkip{
+		{"Poem", "attachment style.txt", "attachments are not imported"},
+		{"Poem", "2 × file a.png", "attachments are not imported"},
+		{"Poem", "tool_use block", "only text is imported"},
+		{"Poem", "tool_result block", "only text is imported"},
+		{"Untitled", "", "no text messages"},
+	})
+
+	if reasoning := chats[0].Messages[1].Reasoning; reasoning != "Short and sweet." {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if want := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC); !chats[1].CreatedAt.Equal(want) {
+		t.Errorf("created at %v, want %v", chats[1].CreatedAt, want)
+	}
+	if !chats[1].UpdatedAt.IsZero() {
+		t.Errorf("updated at %v, want unknown", chats[1].UpdatedAt)
+	}
+}
+
+func TestParseCyberAIExport(t *testing.T) {
+	chats, report := parseFixture(t, "cyberai.json")
+	checkImport(t, chats, report, "cyberai", [][]string{
+		{
+			// Every branch, parents first
+			">10 user: Hello",
+			"10>11 assistant: Hi",
+			"11>12 user: Bye",
+			"10>13 assistant: Hi there",
+		},
+	}, []ImportSkip{
+		{"Branched", "tool message", "unknown role"},
+		{"Branched", "message without its parent", "the message it replies to is missing"},
+		{"From the future", "", "export version 99 is newer than this server supports"},
+	})
+
+	chat := chats[0]
+	if chat.ActiveRef != "12" {
+		t.Errorf("active ref = %q, want 12", chat.ActiveRef)
+	}
+	if chat.Summary == nil || chat.Summary.Content != "Greetings were exchanged." || chat.SummaryThroughRef != "11" {
+		t.Errorf("summary = %+v through %q", chat.Summary, chat.SummaryThroughRef)
+	}
+	if reply := chat.Messages[1]; reply.ModelName != "gpt-4o" || reply.Reasoning != "Be brief." || reply.TokensUsed != 3 {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestParseChatImportArchives(t *testing.T) {
+	chatGPT, err := os.ReadFile(filepath.Join("testdata", "import", "chatgpt.json"))
+	if err != nil {
+		t.Fatalf("reading chatgpt.json: %v", err)
+	}
+	single := `{"format": "cyberai-chat", "version": 1, "chat": {"title": "One"}, "messages": [{"id": 1, "role": "user", "content": "Hi"}]}`
+
+	t.Run("ChatGPT", func(t *testing.T) {
+		chats, report, err := parseImport(t, zipArchive(t,
+			[2]string{"file-abc.png", "PNG"},
+			[2]string{"export/conversations.json", string(chatGPT)},
+		))
+		if err != nil {
+			t.Fatalf("ParseChatImport: %v", err)
+		}
+		if report.Format != "chatgpt" || len(chats) != 2 {
+			t.Errorf("imported %d %s chats, want 2 chatgpt", len(chats), report.Format)
+		}
+	})
+
+	t.Run("CyberAI", func(t *testing.T) {
+		chats, report, err := parseImport(t, zipArchive(t,
+			[2]string{"chats/", ""},
+			[2]string{"chats/one.json", single},
+			[2]string{"README.txt", "Exported chats"},
+			[2]string{"broken.json", `{"format": "cyberai-chat"`},
+			[2]string{"other.json", string(chatGPT)},
+		))
+		if err != nil {
+			t.Fatalf("ParseChatImport: %v", err)
+		}
+		checkImport(t, chats, report, "cyberai", [][]string{{">1 user: Hi"}}, []ImportSkip{
+			{"README.txt", "", "only JSON exports can be imported"},
+			{"broken.json", "", ErrUnknownImportFormat.Error()},
+			{"other.json", "", "chatgpt export mixed with cyberai export"},
+		})
+	})
+
+	t.Run("nothing to import", func(t *testing.T) {
+		_, _, err := parseImport(t, zipArchive(t, [2]string{"README.txt", "Exported chats"}))
+		if !errors.Is(err, ErrUnknownImportFormat) {
+			t.Errorf("error = %v, want %v", err, ErrUnknownImportFormat)
+		}
+	})
+}
+
+func TestParseChatImportMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string // Empty if parsing succeeds without chats
+	}{
+		{"empty", "", ErrUnknownImportFormat.Error()},
+		{"whitespace", " \n", ErrUnknownImportFormat.Error()},
+		{"text", "Hello", ErrUnknownImportFormat.Error()},
+		{"other JSON object", `{"title": "x"}`, ErrUnknownImportFormat.Error()},
+		{"array of numbers", `[1, 2]`, ErrUnknownImportFormat.Error()},
+		{"array of other objects", `[{"title": "x"}]`, ErrUnknownImportFormat.Error()},
+		{"truncated", `[{"title": "x", "mapping": {`, ErrUnknownImportFormat.Error()},
+		{"truncated zip", "PK\x03\x04\x00\x00", "failed to open zip archive"},
+		{"invalid ChatGPT export", `[{"mapping": {}}, {"mapping": 3}]`, "invalid ChatGPT export"},
+		{"invalid Claude export", `[{"chat_messages": "x"}]`, "invalid Claude export"},
+		{"invalid CyberAI export", `{"format": "cyberai-chat", "messages": 3}`, "invalid CyberAI export"},
+		{"no conversations", `[]`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chats, _, err := parseImport(t, []byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil || len(chats) != 0 {
+					t.Errorf("got %d chats and error %v, want neither", len(chats), err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseChatGPTPartialExport(t *testing.T) {
+	// A conversation cut short: the current node's parent is missing
+	data := `[{"title": "Partial", "current_node": "a", "mapping": {
+		"a": {"message": {"id": "a", "author": {"role": "assistant"}, "content": {"content_type": "text", "parts": ["Answer"]}},
+		      "parent": "gone", "children": []}}}]`
+	chats, report, err := parseImport(t, []byte(data))
+	if err != nil {
+		t.Fatalf("ParseChatImport: %v", err)
+	}
+	checkImport(t, chats, report, "chatgpt", [][]string{{">a assistant: Answer"}}, []ImportSkip{})
+}
+
+// branchOutline describes every message of an export by its content and
+// that of its parent, which survive an import unlike the IDs
+func branchOutline(export *ChatExport) []string {
+	contents := make(map[int64]string, len(export.Messages))
+	for _, msg := range export.Messages {
+		contents[msg.ID] = msg.Content
+	}
+	lines := make([]string, len(export.Messages))
+	for i, msg := range export.Messages {
+		var parent string
+		if msg.ParentID != nil {
+			parent = contents[*msg.ParentID]
+		}
+		lines[i] = fmt.Sprintf("%s>%s %s: %s", parent, msg.Content, msg.Role, msg.Reasoning)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// adminUserID is the default admin user, created with the database
+const adminUserID = 1
+
+func TestCyberAIExportRoundTrip(t *testing.T) {
+	database, err := db.New(filepath.Join(t.TempDir(), "cyberai.db"))
+	if err != nil {
+		t.Fatalf("db.New: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	chats := NewChatService(database, nil)
+
+	chat, err := chats.CreateChat(adminUserID, "Round trip", "")
+	if err != nil {
+		t.Fatalf("CreateChat: %v", err)
+	}
+	add := func(role, content, reasoning string, parentID *int64) int64 {
+		msg := &Message{ChatID: chat.ID, Role: role, Content: content, Reasoning: reasoning}
+		if role == "user" {
+			msg.UserID = adminUserID
+		}
+		if err := chats.AddBranchMessage(msg, parentID); err != nil {
+			t.Fatalf("AddBranchMessage: %v", err)
+		}
+		return msg.ID
+	}
+	hello := add("user", "Hello", "", nil)
+	hi := add("assistant", "Hi", "Be brief.", &hello)
+	bye := add("user", "Bye", "", &hi)
+	add("assistant", "Goodbye", "", &bye)
+	add("assistant", "Hi there", "", &hello) // Regenerated reply, on a branch of its own
+	if err := chats.SetActiveMessage(chat.ID, bye); err != nil {
+		t.Fatalf("SetActiveMessage: %v", err)
+	}
+	if err := chats.SaveChatSummary(&ChatSummary{ChatID: chat.ID, Content: "Greetings.", ThroughMessageID: hi}); err != nil {
+		t.Fatalf("SaveChatSummary: %v", err)
+	}
+
+	export, err := chats.ExportChat(chat.ID)
+	if err != nil {
+		t.Fatalf("ExportChat: %v", err)
+	}
+	data, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	parsed, report, err := parseImport(t, data)
+	if err != nil {
+		t.Fatalf("ParseChatImport: %v", err)
+	}
+	if len(parsed) != 1 || len(report.Skipped) != 0 {
+		t.Fatalf("parsed %d chats, skipping %+v", len(parsed), report.Skipped)
+	}
+	imported, err := chats.ImportChat(adminUserID, &parsed[0])
+	if err != nil {
+		t.Fatalf("ImportChat: %v", err)
+	}
+	reexport, err := chats.ExportChat(imported.ID)
+	if err != nil {
+		t.Fatalf("ExportChat of the import: %v", err)
+	}
+
+	if reexport.Chat.Title != export.Chat.Title {
+		t.Errorf("title = %q, want %q", reexport.Chat.Title, export.Chat.Title)
+	}
+	if got, want := branchOutline(reexport), branchOutline(export); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	var branch []string
+	for _, msg := range reexport.ActiveBranch() {
+		branch = append(branch, msg.Content)
+	}
+	if want := []string{"Hello", "Hi", "Bye"}; !reflect.DeepEqual(branch, want) {
+		t.Errorf("active branch = %q, want %q", branch, want)
+	}
+
+	summary := reexport.Summary
+	if summary == nil || summary.Content != "Greetings." {
+		t.Fatalf("summary = %+v, want it imported", summary)
+	}
+	through, err := chats.GetMessage(summary.ThroughMessageID)
+	if err != nil || through.ChatID != imported.ID || through.Content != "Hi" {
+		t.Errorf("summary covers %+v (%v), want the imported reply", through, err)
+	}
+}
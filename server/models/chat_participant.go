@@ -0,0 +1,208 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Roles a user can have in a chat, from least to most access. Viewers can
+// read the chat and follow responses live; contributors can also post,
+// regenerate and edit messages and switch branches; only the owner can
+// rename, delete or share the chat and manage its participants.
+const (
+	ChatRoleViewer      = "viewer"
+	ChatRoleContributor = "contributor"
+	ChatRoleOwner       = "owner"
+)
+
+var chatRoleRanks = map[string]int{
+	ChatRoleViewer:      1,
+	ChatRoleContributor: 2,
+	ChatRoleOwner:       3,
+}
+
+// ErrParticipantNotFound is returned when the user is not a participant of the chat
+var ErrParticipantNotFound = errors.New("participant not found")
+
+// ErrUserNotFound is returned when inviting a username that does not exist or is inactive
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrInviteOwner is returned when the owner of a chat is invited to it
+var ErrInviteOwner = errors.New("the owner of a chat cannot be invited to it")
+
+// ChatRoleAllows reports whether role grants at least the access of required
+func ChatRoleAllows(role, required string) bool {
+	return chatRoleRanks[role] > 0 && chatRoleRanks[role] >= chatRoleRanks[required]
+}
+
+// ValidParticipantRole reports whether role can be given to an invited user
+func ValidParticipantRole(role string) bool {
+	return role == ChatRoleViewer || role == ChatRoleContributor
+}
+
+// ChatParticipant is a user with access to a chat, including its owner
+type ChatParticipant struct {
+	ChatID    int64     `json:"chat_id"`
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	InvitedBy int64     `json:"invited_by,omitempty"` // 0 for the owner
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetChatRole returns the user's role in the chat, or "" if they have no
+// access to it
+func (s *ChatService) GetChatRole(chatID, userID int64) (string, error) {
+	var ownerID int64
+	var role sql.NullString
+	err := s.DB.QueryRow(`
+		SELECT c.user_id, p.role
+		FROM chats c
+		LEFT JOIN chat_participants p ON p.chat_id = c.id AND p.user_id = ?
+		WHERE c.id = ?
+	`, userID, chatID).Scan(&ownerID, &role)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("chat not found: %d", chatID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get chat role: %w", err)
+	}
+	if ownerID == userID {
+		return ChatRoleOwner, nil
+	}
+	return role.String, nil
+}
+
+// GetChatParticipants lists the owner of the chat followed by the users it
+// is shared with, in the order they were invited
+func (s *ChatService) GetChatParticipants(chatID int64) ([]ChatParticipant, error) {
+	rows, err := s.DB.Query(`
+		SELECT c.id, u.id, u.username, 'owner', 0, c.created_at, 0
+		FROM chats c
+		JOIN users u ON u.id = c.user_id
+		WHERE c.id = ?
+		UNION ALL
+		SELECT p.chat_id, u.id, u.username, p.role, p.invited_by, p.created_at, 1
+		FROM chat_participants p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.chat_id = ?
+		ORDER BY 7, 6
+	`, chatID, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat participants: %w", err)
+	}
+	defer rows.Close()
+
+	participants := []ChatParticipant{}
+	for rows.Next() {
+		var p ChatParticipant
+		var invited int
+		if err := rows.Scan(&p.ChatID, &p.UserID, &p.Username, &p.Role, &p.InvitedBy, &p.CreatedAt, &invited); err != nil {
+			return nil, fmt.Errorf("failed to scan chat participant: %w", err)
+		}
+		participants = append(participants, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat participants: %w", err)
+	}
+	return participants, nil
+}
+
+// AddChatParticipant shares the chat with an active user, found by username,
+// or changes their role if it is already shared with them
+func (s *ChatService) AddChatParticipant(chatID int64, username, role string, invitedBy int64) (*ChatParticipant, error) {
+	if !ValidParticipantRole(role) {
+		return nil, fmt.Errorf("invalid participant role: %q", role)
+	}
+
+	p := ChatParticipant{ChatID: chatID, Role: role, InvitedBy: invitedBy}
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		err := tx.QueryRow(`SELECT id, username FROM users WHERE username = ? AND is_active = 1`, username).Scan(&p.UserID, &p.Username)
+		if err == sql.ErrNoRows {
+			return ErrUserNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up user: %w", err)
+		}
+
+		var ownerID int64
+		if err := tx.QueryRow(`SELECT user_id FROM chats WHERE id = ?`, chatID).Scan(&ownerID); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("chat not found: %d", chatID)
+			}
+			return fmt.Errorf("failed to get chat: %w", err)
+		}
+		if ownerID == p.UserID {
+			return ErrInviteOwner
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO chat_participants (chat_id, user_id, role, invited_by)
+			VALUES (?, ?, ?, ?)
+			ON CONFLICT (chat_id, user_id) DO UPDATE SET role = excluded.role
+		`, chatID, p.UserID, role, invitedBy)
+		if err != nil {
+			return fmt.Errorf("failed to add chat participant: %w", err)
+		}
+		return tx.QueryRow(`
+			SELECT invited_by, created_at FROM chat_participants WHERE chat_id = ? AND user_id = ?
+		`, chatID, p.UserID).Scan(&p.InvitedBy, &p.CreatedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// RemoveChatParticipant stops sharing the chat with the user, forgetting how
+// they had organised it. Their messages stay in the chat.
+func (s *ChatService) RemoveChatParticipant(chatID, userID int64) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM chat_participants WHERE chat_id = ? AND user_id = ?`, chatID, userID)
+		if err != nil {
+			return fmt.Errorf("failed to remove chat participant: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return ErrParticipantNotFound
+		}
+		if _, err := tx.Exec(`DELETE FROM chat_user_settings WHERE chat_id = ? AND user_id = ?`, chatID, userID); err != nil {
+			return fmt.Errorf("failed to delete chat settings: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM chat_tags WHERE chat_id = ? AND user_id = ?`, chatID, userID); err != nil {
+			return fmt.Errorf("failed to delete chat tags: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetOwnedChatAudience returns, for each user with access to a chat owned by
+// ownerID (the owner included), the IDs of those chats
+func (s *ChatService) GetOwnedChatAudience(ownerID int64) (map[int64][]int64, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id FROM chats WHERE user_id = ?
+		UNION ALL
+		SELECT p.chat_id, p.user_id
+		FROM chat_participants p
+		JOIN chats c ON c.id = p.chat_id
+		WHERE c.user_id = ?
+	`, ownerID, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat audience: %w", err)
+	}
+	defer rows.Close()
+
+	audience := make(map[int64][]int64)
+	for rows.Next() {
+		var chatID, userID int64
+		if err := rows.Scan(&chatID, &userID); err != nil {
+			return nil, fmt.Errorf("failed to scan chat audience: %w", err)
+		}
+		audience[userID] = append(audience[userID], chatID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat audience: %w", err)
+	}
+	return audience, nil
+}
@@ -0,0 +1,180 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Limits for chat search results
+const (
+	DefaultSearchLimit    = 20  // Chats returned when no limit is given
+	MaxSearchLimit        = 100 // Most chats returned by one search
+	maxMatchesPerChat     = 3   // Message snippets returned per chat
+	searchSnippetTokens   = 16  // Approximate length of a snippet in tokens
+	searchCandidateFactor = 10  // Message matches fetched per chat requested, before grouping
+)
+
+// Markers passed to highlight() and snippet(). They are private-use
+// characters so they survive HTML escaping and never occur in messages.
+const (
+	searchMarkOpen  = "\uE000"
+	searchMarkClose = "\uE001"
+)
+
+// ChatSearchResult is a chat matching a search, with the matching messages.
+type ChatSearchResult struct {
+	ChatID         int64                `json:"chat_id"`
+	UserID         int64                `json:"user_id"`
+	Title          string               `json:"title"`
+	TitleHighlight string               `json:"title_highlight,omitempty"` // HTML-escaped title with <mark> around matches, if the title matched
+	UpdatedAt      time.Time            `json:"updated_at"`
+	Matches        []MessageSearchMatch `json:"matches,omitempty"`
+
+	rank float64 // Best bm25 rank of the chat's matches (lower is better)
+}
+
+// MessageSearchMatch is a message matching a search. MessageID is the anchor
+// to scroll to (and to activate, if it is not on the chat's active branch).
+type MessageSearchMatch struct {
+	MessageID int64     `json:"message_id"`
+	Role      string    `json:"role"`
+	Snippet   string    `json:"snippet"` // HTML-escaped excerpt with <mark> around matches
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SearchChats finds the chats of a user whose title or messages match the
+// query, best matches first. A userID of 0 searches every user's chats (for
+// admins only). The query is a list of words; all must match, and the last
+// may be a prefix.
+func (s *ChatService) SearchChats(userID int64, query string, limit int) ([]ChatSearchResult, error) {
+	match := ftsQuery(query)
+	if match == "" {
+		return []ChatSearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	if limit > MaxSearchLimit {
+		limit = MaxSearchLimit
+	}
+
+	results := make(map[int64]*ChatSearchResult)
+
+	// Chats whose title matches
+	rows, err := s.DB.Query(`
+		SELECT c.id, c.user_id, c.title, c.updated_at,
+		       highlight(chats_fts, 0, ?, ?), bm25(chats_fts)
+		FROM chats_fts
+		JOIN chats c ON c.id = chats_fts.rowid
+		WHERE chats_fts MATCH ? AND (? = 0 OR c.user_id = ?)
+		ORDER BY bm25(chats_fts)
+		LIMIT ?
+	`, searchMarkOpen, searchMarkClose, match, userID, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chat titles: %w", err)
+	}
+	for rows.Next() {
+		var result ChatSearchResult
+		var highlighted string
+		if err := rows.Scan(&result.ChatID, &result.UserID, &result.Title, &result.UpdatedAt, &highlighted, &result.rank); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan chat search result: %w", err)
+		}
+		result.TitleHighlight = highlightHTML(highlighted)
+		results[result.ChatID] = &result
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat search results: %w", err)
+	}
+
+	// Messages that match, grouped by chat
+	rows, err = s.DB.Query(`
+		SELECT m.id, m.role, m.created_at,
+		       snippet(messages_fts, 0, ?, ?, ?, ?), bm25(messages_fts),
+		       c.id, c.user_id, c.title, c.updated_at
+		FROM messages_fts
+		JOIN messages m ON m.id = messages_fts.rowid
+		JOIN chats c ON c.id = m.chat_id
+		WHERE messages_fts MATCH ? AND m.role != 'system' AND (? = 0 OR c.user_id = ?)
+		ORDER BY bm25(messages_fts)
+		LIMIT ?
+	`, searchMarkOpen, searchMarkClose, "…", searchSnippetTokens,
+		match, userID, userID, limit*searchCandidateFactor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var m MessageSearchMatch
+		var snippet string
+		var rank float64
+		var chat ChatSearchResult
+		if err := rows.Scan(&m.MessageID, &m.Role, &m.CreatedAt, &snippet, &rank,
+			&chat.ChatID, &chat.UserID, &chat.Title, &chat.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan message search result: %w", err)
+		}
+		m.Snippet = highlightHTML(snippet)
+
+		result, ok := results[chat.ChatID]
+		if !ok {
+			chat.rank = rank
+			result = &chat
+			results[chat.ChatID] = result
+		} else if rank < result.rank {
+			result.rank = rank
+		}
+		if len(result.Matches) < maxMatchesPerChat {
+			result.Matches = append(result.Matches, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating message search results: %w", err)
+	}
+
+	ranked := make([]ChatSearchResult, 0, len(results))
+	for _, result := range results {
+		ranked = append(ranked, *result)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].rank != ranked[j].rank {
+			return ranked[i].rank < ranked[j].rank
+		}
+		return ranked[i].UpdatedAt.After(ranked[j].UpdatedAt)
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked, nil
+}
+
+// ftsQuery turns user input into an FTS5 query: every word quoted (so
+// operators and punctuation are matched literally), all required, and the
+// last one matched as a prefix so results appear while typing.
+func ftsQuery(query string) string {
+	words := strings.Fields(query)
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.Trim(word, `"`)
+		if word == "" {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	terms[len(terms)-1] += "*"
+	return strings.Join(terms, " ")
+}
+
+// highlightHTML escapes text marked by highlight() or snippet() and turns the
+// markers into <mark> tags.
+func highlightHTML(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, searchMarkOpen, "<mark>")
+	return strings.ReplaceAll(text, searchMarkClose, "</mark>")
+}
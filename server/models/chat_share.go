@@ -0,0 +1,199 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Reasons a share link cannot be viewed
+var (
+	ErrShareNotFound      = errors.New("share not found")
+	ErrShareRevoked       = errors.New("share link has been revoked")
+	ErrShareExpired       = errors.New("share link has expired")
+	ErrShareLoginRequired = errors.New("share link requires login")
+)
+
+// ChatShare is a read-only link to a snapshot of a chat. Anyone with the
+// token can view the snapshot (only logged-in users, if RequireLogin), until
+// the link expires or the owner revokes it; later changes to the chat are not
+// shown.
+type ChatShare struct {
+	ID           int64      `json:"id"`
+	Token        string     `json:"token"`
+	ChatID       int64      `json:"chat_id"`
+	UserID       int64      `json:"user_id"` // Owner who shared the chat
+	RequireLogin bool       `json:"require_login"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Messages     int        `json:"messages"` // Number of messages in the snapshot
+
+	Snapshot *ChatExport `json:"-"` // Only loaded by GetChatShareByToken
+}
+
+// CheckAccess reports whether the share can be viewed now by the user
+// (0 if not logged in). It is independent of chat ownership: viewers only
+// ever see the snapshot.
+func (s *ChatShare) CheckAccess(userID int64, now time.Time) error {
+	if s.RevokedAt != nil {
+		return ErrShareRevoked
+	}
+	if s.ExpiresAt != nil && !now.Before(*s.ExpiresAt) {
+		return ErrShareExpired
+	}
+	if s.RequireLogin && userID == 0 {
+		return ErrShareLoginRequired
+	}
+	return nil
+}
+
+// CreateChatShare snapshots the active branch of a chat and creates a share
+// link to it
+func (s *ChatService) CreateChatShare(chatID, userID int64, requireLogin bool, expiresAt *time.Time) (*ChatShare, error) {
+	export, err := s.ExportChat(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep only what is shown in the chat: the active branch, without the
+	// summary, alternative versions or who the messages belong to
+	messages := export.ActiveBranch()
+	for i := range messages {
+		messages[i].UserID = 0
+		messages[i].SiblingIDs = nil
+	}
+	export.Messages = messages
+	export.Summary = nil
+	snapshot, err := json.Marshal(export)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode chat snapshot: %w", err)
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt != nil {
+		utc := expiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	share := ChatShare{
+		Token:        token,
+		ChatID:       chatID,
+		UserID:       userID,
+		RequireLogin: requireLogin,
+		ExpiresAt:    expiresAt,
+		CreatedAt:    export.ExportedAt,
+		Messages:     len(messages),
+	}
+	result, err := s.DB.Exec(`
+		INSERT INTO chat_shares (token, chat_id, user_id, snapshot, require_login, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, share.Token, chatID, userID, string(snapshot), requireLogin, expiresAt, share.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chat share: %w", err)
+	}
+	share.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat share ID: %w", err)
+	}
+	return &share, nil
+}
+
+// GetChatShares lists the share links of a chat, newest first, including
+// revoked and expired ones
+func (s *ChatService) GetChatShares(chatID int64) ([]ChatShare, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, token, chat_id, user_id, require_login, expires_at, revoked_at, created_at,
+		       COALESCE(json_array_length(snapshot, '$.messages'), 0)
+		FROM chat_shares
+		WHERE chat_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chat shares: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []ChatShare{}
+	for rows.Next() {
+		var share ChatShare
+		if err := scanChatShare(rows, &share); err != nil {
+			return nil, err
+		}
+		shares = append(shares, share)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chat shares: %w", err)
+	}
+	return shares, nil
+}
+
+// GetChatShareByToken loads a share link and its snapshot. It does not check
+// whether the share can be viewed; see CheckAccess.
+func (s *ChatService) GetChatShareByToken(token string) (*ChatShare, error) {
+	var share ChatShare
+	var snapshot string
+	err := s.DB.QueryRow(`
+		SELECT id, token, chat_id, user_id, require_login, expires_at, revoked_at, created_at,
+		       COALESCE(json_array_length(snapshot, '$.messages'), 0), snapshot
+		FROM chat_shares
+		WHERE token = ?
+	`, token).Scan(
+		&share.ID, &share.Token, &share.ChatID, &share.UserID, &share.RequireLogin,
+		&share.ExpiresAt, &share.RevokedAt, &share.CreatedAt, &share.Messages, &snapshot,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat share: %w", err)
+	}
+
+	share.Snapshot = &ChatExport{}
+	if err := json.Unmarshal([]byte(snapshot), share.Snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode chat snapshot: %w", err)
+	}
+	return &share, nil
+}
+
+// RevokeChatShare revokes a share link of a chat. Revoking it again has no
+// effect.
+func (s *ChatService) RevokeChatShare(chatID, shareID int64) error {
+	result, err := s.DB.Exec(`
+		UPDATE chat_shares SET revoked_at = COALESCE(revoked_at, ?)
+		WHERE id = ? AND chat_id = ?
+	`, time.Now().UTC(), shareID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke chat share: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrShareNotFound
+	}
+	return nil
+}
+
+func scanChatShare(row interface{ Scan(...interface{}) error }, share *ChatShare) error {
+	if err := row.Scan(
+		&share.ID, &share.Token, &share.ChatID, &share.UserID, &share.RequireLogin,
+		&share.ExpiresAt, &share.RevokedAt, &share.CreatedAt, &share.Messages,
+	); err != nil {
+		return fmt.Errorf("failed to scan chat share: %w", err)
+	}
+	return nil
+}
+
+// newShareToken returns a random URL-safe token with 256 bits of entropy
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,283 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ExternalIdentity is a user as an identity provider describes them at login
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string // Preferred username; the email's local part if empty
+	FirstName     string
+	LastName      string
+	Groups        []string // Groups the user is a member of, for role mappings
+}
+
+// RoleMapping maps a group of an identity provider or directory to a role name
+type RoleMapping struct {
+	Group string
+	Role  string
+}
+
+// ExternalLoginOptions control how a login with an external identity maps to
+// a local user
+type ExternalLoginOptions struct {
+	Provision bool  // Create a user for identities not linked to one yet
+	RoleID    int64 // Role of provisioned users, and of every user if SyncRole is set
+	SyncRole  bool  // Set the role of existing users to RoleID at every login
+	// KeepRoleOf is a username whose role is never synced, so that the
+	// break-glass admin cannot be demoted by the identity provider
+	KeepRoleOf string
+	// LinkUsername links an identity seen for the first time to the user with
+	// the same username, for directories whose usernames are authoritative.
+	// Neither the username nor the email then links to a user with a local
+	// password or an admin role, so that a directory entry cannot take over
+	// a local account.
+	LinkUsername bool
+	// UpdateProfile updates the user's email and name from the identity at
+	// every login
+	UpdateProfile bool
+}
+
+// ErrUserNotProvisioned is returned when an identity is not linked to a user
+// and provisioning is off
+var ErrUserNotProvisioned = errors.New("no user is linked to this identity")
+
+// ErrAccountInactive is returned when the user linked to an identity is deactivated
+var ErrAccountInactive = errors.New("account is inactive")
+
+// ErrLocalAccountConflict is returned when a directory identity would link to
+// a local account that the directory must not take over
+var ErrLocalAccountConflict = errors.New("a local account with a password or an admin role has the same username or email")
+
+// ErrInvalidIdentity is returned when an identity lacks what is needed to
+// provision a user, or its email belongs to another user
+var ErrInvalidIdentity = errors.New("invalid identity")
+
+// RoleIDForGroups returns the ID of the role for a member of the groups: that
+// of the first mapping whose group is among them, or of the default role
+func (s *UserService) RoleIDForGroups(mappings []RoleMapping, groups []string, defaultRole string) (int64, error) {
+	roles, err := s.GetAllRoles()
+	if err != nil {
+		return 0, err
+	}
+	roleIDs := make(map[string]int64, len(roles))
+	for _, role := range roles {
+		roleIDs[role.Name] = role.ID
+	}
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[group] = true
+	}
+
+	for _, mapping := range mappings {
+		if !member[mapping.Group] {
+			continue
+		}
+		if id, ok := roleIDs[mapping.Role]; ok {
+			return id, nil
+		}
+		log.Printf("Group %q maps to unknown role %q, ignoring", mapping.Group, mapping.Role)
+	}
+	if id, ok := roleIDs[defaultRole]; ok {
+		return id, nil
+	}
+	return 0, fmt.Errorf("default role %q does not exist", defaultRole)
+}
+
+// LoginExternal returns the user linked to the identity, linking it first to
+// the user with the same username (if opts.LinkUsername is set) or verified
+// email, or provisioning a new user, and records the login
+func (s *UserService) LoginExternal(identity ExternalIdentity, opts ExternalLoginOptions) (*User, error) {
+	if identity.Issuer == "" || identity.Subject == "" {
+		return nil, fmt.Errorf("%w: issuer and subject are required", ErrInvalidIdentity)
+	}
+	identity.Email = strings.TrimSpace(identity.Email)
+
+	var userID int64
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		now := time.Now()
+		err := tx.QueryRow(`
+			SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?
+		`, identity.Issuer, identity.Subject).Scan(&userID)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to look up identity: %w", err)
+		}
+
+		if err == sql.ErrNoRows {
+			if opts.LinkUsername && identity.Username != "" {
+				err = tx.QueryRow(`SELECT id FROM users WHERE username = ?`, identity.Username).Scan(&userID)
+				if err != nil && err != sql.ErrNoRows {
+					return fmt.Errorf("failed to look up user by username: %w", err)
+				}
+			}
+			// Link to the user with the same email, but only if the identity
+			// provider vouches for it
+			if userID == 0 && identity.Email != "" && identity.EmailVerified {
+				err = tx.QueryRow(`SELECT id FROM users WHERE email = ? COLLATE NOCASE`, identity.Email).Scan(&userID)
+				if err != nil && err != sql.ErrNoRows {
+					return fmt.Errorf("failed to look up user by email: %w", err)
+				}
+			}
+			if userID != 0 && opts.LinkUsername {
+				if err := checkDirectoryLink(tx, userID); err != nil {
+					return err
+				}
+			}
+			if userID == 0 {
+				if !opts.Provision {
+					return ErrUserNotProvisioned
+				}
+				if userID, err = provisionExternalUser(tx, identity, opts.RoleID); err != nil {
+					return err
+				}
+			}
+			if _, err := tx.Exec(`
+				INSERT INTO user_identities (user_id, issuer, subject, created_at) VALUES (?, ?, ?, ?)
+			`, userID, identity.Issuer, identity.Subject, now); err != nil {
+				return fmt.Errorf("failed to link identity: %w", err)
+			}
+		}
+
+		var username, email string
+		var firstName, lastName sql.NullString
+		var isActive bool
+		if err := tx.QueryRow(`
+			SELECT username, email, first_name, last_name, is_active FROM users WHERE id = ?
+		`, userID).Scan(&username, &email, &firstName, &lastName, &isActive); err != nil {
+			return fmt.Errorf("failed to get linked user: %w", err)
+		}
+		if !isActive {
+			return ErrAccountInactive
+		}
+		if opts.UpdateProfile {
+			if err := updateExternalProfile(tx, userID, identity, email, firstName.String, lastName.String); err != nil {
+				return err
+			}
+		}
+		if opts.SyncRole && opts.RoleID > 0 && username != opts.KeepRoleOf {
+			if _, err := tx.Exec(`UPDATE users SET role_id = ?, updated_at = ? WHERE id = ? AND role_id != ?`,
+				opts.RoleID, now, userID, opts.RoleID); err != nil {
+				return fmt.Errorf("failed to sync user role: %w", err)
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE users SET last_login = ? WHERE id = ?`, now, userID); err != nil {
+			return fmt.Errorf("failed to update last login: %w", err)
+		}
+		if _, err := tx.Exec(`
+			UPDATE user_identities SET last_login = ? WHERE issuer = ? AND subject = ?
+		`, now, identity.Issuer, identity.Subject); err != nil {
+			return fmt.Errorf("failed to update identity login: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUserByID(userID)
+}
+
+// checkDirectoryLink returns ErrLocalAccountConflict if the user has a local
+// password or an admin role. Such accounts keep their local login rather
+// than being handed to whoever has the same name in the directory.
+func checkDirectoryLink(tx *sql.Tx, userID int64) error {
+	var passwordHash string
+	var permissions Permissions
+	if err := tx.QueryRow(`
+		SELECT u.password_hash, r.permissions
+		FROM users u LEFT JOIN roles r ON r.id = u.role_id
+		WHERE u.id = ?
+	`, userID).Scan(&passwordHash, &permissions); err != nil {
+		return fmt.Errorf("failed to check user %d: %w", userID, err)
+	}
+	if passwordHash != "" || permissions.HasAny(AdminPermissions...) {
+		return ErrLocalAccountConflict
+	}
+	return nil
+}
+
+// updateExternalProfile updates the user's email and name to those of the
+// identity where they differ and are set. An email address another user has
+// is left alone.
+func updateExternalProfile(tx *sql.Tx, userID int64, identity ExternalIdentity, email, firstName, lastName string) error {
+	now := time.Now()
+	if (identity.FirstName != "" && identity.FirstName != firstName) || (identity.LastName != "" && identity.LastName != lastName) {
+		if _, err := tx.Exec(`
+			UPDATE users SET first_name = COALESCE(NULLIF(?, ''), first_name), last_name = COALESCE(NULLIF(?, ''), last_name), updated_at = ?
+			WHERE id = ?
+		`, identity.FirstName, identity.LastName, now, userID); err != nil {
+			return fmt.Errorf("failed to update user name: %w", err)
+		}
+	}
+	if identity.Email != "" && !strings.EqualFold(identity.Email, email) {
+		result, err := tx.Exec(`
+			UPDATE users SET email = ?, updated_at = ?
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM users WHERE email = ? COLLATE NOCASE AND id != ?)
+		`, identity.Email, now, userID, identity.Email, userID)
+		if err != nil {
+			return fmt.Errorf("failed to update user email: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			log.Printf("Not updating the email of user %d to %s: another user has it", userID, identity.Email)
+		}
+	}
+	return nil
+}
+
+// provisionExternalUser creates a user for an identity. The user has no
+// password, so they can only log in through the identity provider until an
+// admin sets one.
+func provisionExternalUser(tx *sql.Tx, identity ExternalIdentity, roleID int64) (int64, error) {
+	if identity.Email == "" {
+		return 0, fmt.Errorf("%w: the identity has no email address", ErrInvalidIdentity)
+	}
+	var taken bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = ? COLLATE NOCASE)`, identity.Email).Scan(&taken); err != nil {
+		return 0, fmt.Errorf("failed to check email: %w", err)
+	}
+	if taken {
+		// Only verified emails link to existing users (see LoginExternal)
+		return 0, fmt.Errorf("%w: the email address %s is unverified and already in use", ErrInvalidIdentity, identity.Email)
+	}
+
+	base := strings.TrimSpace(identity.Username)
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	username := base
+	for i := 2; ; i++ {
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)`, username).Scan(&taken); err != nil {
+			return 0, fmt.Errorf("failed to check username: %w", err)
+		}
+		if !taken {
+			break
+		}
+		username = fmt.Sprintf("%s%d", base, i)
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO users (
+			username, email, password_hash, first_name, last_name,
+			role_id, is_active, created_at, updated_at
+		)
+		VALUES (?, ?, '', ?, ?, ?, TRUE, ?, ?)
+	`, username, identity.Email, identity.FirstName, identity.LastName, roleID, now, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to provision user: %w", err)
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user ID: %w", err)
+	}
+	return userID, nil
+}
@@ -0,0 +1,397 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// MCPTransport is the transport used to reach an MCP server
+type MCPTransport string
+
+const (
+	MCPTransportStdio MCPTransport = "stdio" // Local subprocess speaking JSON-RPC over stdin/stdout
+	MCPTransportHTTP  MCPTransport = "http"  // Streamable HTTP endpoint
+)
+
+// MCPServer represents an admin-registered Model Context Protocol server
+type MCPServer struct {
+	ID           int64             `json:"id"`
+	Name         string            `json:"name"`
+	Transport    MCPTransport      `json:"transport"`
+	Command      string            `json:"command,omitempty"`
+	Args         []string          `json:"args,omitempty"`
+	Env          map[string]string `json:"env,omitempty"` // May hold secrets, strip before responding
+	URL          string            `json:"url,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"` // May hold secrets, strip before responding
+	IsActive     bool              `json:"is_active"`
+	LastSyncedAt *time.Time        `json:"last_synced_at,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at"`
+
+	// Optional fields for API responses
+	Tools []MCPTool `json:"tools,omitempty"`
+}
+
+// MCPTool is a tool discovered on an MCP server
+type MCPTool struct {
+	ID          int64                  `json:"id"`
+	ServerID    int64                  `json:"server_id"`
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+// ToolInvocation records a single tool call made while answering in a chat
+type ToolInvocation struct {
+	ID         int64     `json:"id"`
+	ChatID     int64     `json:"chat_id"`
+	UserID     int64     `json:"user_id"`
+	AgentID    *int64    `json:"agent_id,omitempty"`
+	ServerID   *int64    `json:"server_id,omitempty"`
+	ToolName   string    `json:"tool_name"`
+	Arguments  string    `json:"arguments"`
+	Result     string    `json:"result"`
+	IsError    bool      `json:"is_error"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// MCPService handles database operations for MCP servers, tools and invocations
+type MCPService struct {
+	DB *db.DB
+}
+
+// NewMCPService creates a new MCPService
+func NewMCPService(database *db.DB) *MCPService {
+	return &MCPService{DB: database}
+}
+
+// encodeJSONField marshals v to a JSON string, storing NULL for empty values
+func encodeJSONField(v interface{}) (sql.NullString, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	s := string(data)
+	if s == "null" || s == "{}" || s == "[]" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: s, Valid: true}, nil
+}
+
+// Validate checks that the server has the fields its transport requires
+func (m *MCPServer) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	switch m.Transport {
+	case MCPTransportStdio:
+		if m.Command == "" {
+			return fmt.Errorf("command is required for stdio transport")
+		}
+	case MCPTransportHTTP:
+		if m.URL == "" {
+			return fmt.Errorf("url is required for http transport")
+		}
+	default:
+		return fmt.Errorf("invalid transport '%s' (must be 'stdio' or 'http')", m.Transport)
+	}
+	return nil
+}
+
+// CreateServer adds a new MCP server
+func (s *MCPService) CreateServer(server *MCPServer) error {
+	args, err := encodeJSONField(server.Args)
+	if err != nil {
+		return fmt.Errorf("failed to encode args: %w", err)
+	}
+	env, err := encodeJSONField(server.Env)
+	if err != nil {
+		return fmt.Errorf("failed to encode env: %w", err)
+	}
+	headers, err := encodeJSONField(server.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %w", err)
+	}
+
+	now := time.Now()
+	result, err := s.DB.Exec(`
+		INSERT INTO mcp_servers (name, transport, command, args, env, url, headers, is_active, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, server.Name, server.Transport, server.Command, args, env, server.URL, headers, server.IsActive, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert MCP server: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for MCP server: %w", err)
+	}
+	server.ID = id
+	server.CreatedAt = now
+	server.UpdatedAt = now
+	return nil
+}
+
+const mcpServerColumns = `id, name, transport, command, args, env, url, headers, is_active, last_synced_at, created_at, updated_at`
+
+func scanMCPServer(scanner interface{ Scan(...interface{}) error }) (*MCPServer, error) {
+	var m MCPServer
+	var command, args, env, url, headers sql.NullString
+	var lastSynced sql.NullTime
+	if err := scanner.Scan(&m.ID, &m.Name, &m.Transport, &command, &args, &env, &url, &headers,
+		&m.IsActive, &lastSynced, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	m.Command = command.String
+	m.URL = url.String
+	if lastSynced.Valid {
+		m.LastSyncedAt = &lastSynced.Time
+	}
+	if args.Valid {
+		if err := json.Unmarshal([]byte(args.String), &m.Args); err != nil {
+			return nil, fmt.Errorf("failed to parse args for MCP server %d: %w", m.ID, err)
+		}
+	}
+	if env.Valid {
+		if err := json.Unmarshal([]byte(env.String), &m.Env); err != nil {
+			return nil, fmt.Errorf("failed to parse env for MCP server %d: %w", m.ID, err)
+		}
+	}
+	if headers.Valid {
+		if err := json.Unmarshal([]byte(headers.String), &m.Headers); err != nil {
+			return nil, fmt.Errorf("failed to parse headers for MCP server %d: %w", m.ID, err)
+		}
+	}
+	return &m, nil
+}
+
+// GetServer retrieves an MCP server by ID, including its secrets
+func (s *MCPService) GetServer(id int64) (*MCPServer, error) {
+	row := s.DB.QueryRow(`SELECT `+mcpServerColumns+` FROM mcp_servers WHERE id = ?`, id)
+	server, err := scanMCPServer(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("MCP server not found: %d", id)
+		}
+		return nil, fmt.Errorf("failed to get MCP server: %w", err)
+	}
+	return server, nil
+}
+
+// ListServers retrieves all MCP servers
+func (s *MCPService) ListServers(activeOnly bool) ([]MCPServer, error) {
+	query := `SELECT ` + mcpServerColumns + ` FROM mcp_servers`
+	if activeOnly {
+		query += ` WHERE is_active = 1`
+	}
+	query += ` ORDER BY name ASC`
+
+	rows, err := s.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MCP servers: %w", err)
+	}
+	defer rows.Close()
+
+	var servers []MCPServer
+	for rows.Next() {
+		server, err := scanMCPServer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan MCP server: %w", err)
+		}
+		servers = append(servers, *server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating MCP servers: %w", err)
+	}
+	return servers, nil
+}
+
+// UpdateServer updates an MCP server. Env and Headers are only replaced when
+// non-nil so that secrets stripped from API responses are not wiped.
+func (s *MCPService) UpdateServer(server *MCPServer) error {
+	args, err := encodeJSONField(server.Args)
+	if err != nil {
+		return fmt.Errorf("failed to encode args: %w", err)
+	}
+
+	now := time.Now()
+	query := "UPDATE mcp_servers SET name = ?, transport = ?, command = ?, args = ?, url = ?, is_active = ?, updated_at = ?"
+	queryArgs := []interface{}{server.Name, server.Transport, server.Command, args, server.URL, server.IsActive, now}
+
+	if server.Env != nil {
+		env, err := encodeJSONField(server.Env)
+		if err != nil {
+			return fmt.Errorf("failed to encode env: %w", err)
+		}
+		query += ", env = ?"
+		queryArgs = append(queryArgs, env)
+	}
+	if server.Headers != nil {
+		headers, err := encodeJSONField(server.Headers)
+		if err != nil {
+			return fmt.Errorf("failed to encode headers: %w", err)
+		}
+		query += ", headers = ?"
+		queryArgs = append(queryArgs, headers)
+	}
+
+	query += " WHERE id = ?"
+	queryArgs = append(queryArgs, server.ID)
+
+	result, err := s.DB.Exec(query, queryArgs...)
+	if err != nil {
+		return fmt.Errorf("failed to update MCP server %d: %w", server.ID, err)
+	}
+	rowsAffected, _ := result.RowsAffected()
+	if rowsAffected == 0 {
+		return fmt.Errorf("MCP server not found: %d", server.ID)
+	}
+	server.UpdatedAt = now
+	return nil
+}
+
+// DeleteServer removes an MCP server and its discovered tools
+func (s *MCPService) DeleteServer(id int64) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM mcp_tools WHERE server_id = ?`, id); err != nil {
+			return fmt.Errorf("failed to delete tools for MCP server %d: %w", id, err)
+		}
+		result, err := tx.Exec(`DELETE FROM mcp_servers WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("failed to delete MCP server %d: %w", id, err)
+		}
+		rowsAffected, _ := result.RowsAffected()
+		if rowsAffected == 0 {
+			return fmt.Errorf("MCP server not found: %d", id)
+		}
+		return nil
+	})
+}
+
+// ReplaceServerTools replaces the stored tool list for a server with the
+// freshly discovered one and stamps last_synced_at.
+func (s *MCPService) ReplaceServerTools(serverID int64, tools []MCPTool) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM mcp_tools WHERE server_id = ?`, serverID); err != nil {
+			return fmt.Errorf("failed to clear tools for MCP server %d: %w", serverID, err)
+		}
+
+		stmt, err := tx.Prepare(`INSERT INTO mcp_tools (server_id, name, description, input_schema) VALUES (?, ?, ?, ?)`)
+		if err != nil {
+			return fmt.Errorf("failed to prepare tool insert: %w", err)
+		}
+		defer stmt.Close()
+
+		for _, tool := range tools {
+			schema, err := encodeJSONField(tool.InputSchema)
+			if err != nil {
+				return fmt.Errorf("failed to encode input schema for tool %s: %w", tool.Name, err)
+			}
+			if _, err := stmt.Exec(serverID, tool.Name, tool.Description, schema); err != nil {
+				return fmt.Errorf("failed to insert tool %s: %w", tool.Name, err)
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE mcp_servers SET last_synced_at = ? WHERE id = ?`, time.Now(), serverID); err != nil {
+			return fmt.Errorf("failed to update last_synced_at for MCP server %d: %w", serverID, err)
+		}
+		return nil
+	})
+}
+
+// ListServerTools retrieves the tools discovered on a server
+func (s *MCPService) ListServerTools(serverID int64) ([]MCPTool, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, server_id, name, description, input_schema, created_at
+		FROM mcp_tools
+		WHERE server_id = ?
+		ORDER BY name ASC
+	`, serverID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query MCP tools: %w", err)
+	}
+	defer rows.Close()
+
+	var tools []MCPTool
+	for rows.Next() {
+		var t MCPTool
+		var description, schema sql.NullString
+		if err := rows.Scan(&t.ID, &t.ServerID, &t.Name, &description, &schema, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan MCP tool: %w", err)
+		}
+		t.Description = description.String
+		if schema.Valid {
+			if err := json.Unmarshal([]byte(schema.String), &t.InputSchema); err != nil {
+				return nil, fmt.Errorf("failed to parse input schema for tool %s: %w", t.Name, err)
+			}
+		}
+		tools = append(tools, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating MCP tools: %w", err)
+	}
+	return tools, nil
+}
+
+// LogToolInvocation records a tool call
+func (s *MCPService) LogToolInvocation(inv *ToolInvocation) error {
+	now := time.Now()
+	result, err := s.DB.Exec(`
+		INSERT INTO tool_invocations (chat_id, user_id, agent_id, server_id, tool_name, arguments, result, is_error, duration_ms, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.ChatID, inv.UserID, inv.AgentID, inv.ServerID, inv.ToolName, inv.Arguments, inv.Result, inv.IsError, inv.DurationMs, now)
+	if err != nil {
+		return fmt.Errorf("failed to log tool invocation: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("failed to get last insert ID for tool invocation: %w", err)
+	}
+	inv.ID = id
+	inv.CreatedAt = now
+	return nil
+}
+
+// GetChatToolInvocations retrieves the tool calls made in a chat, oldest first
+func (s *MCPService) GetChatToolInvocations(chatID int64) ([]ToolInvocation, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, chat_id, user_id, agent_id, server_id, tool_name, arguments, result, is_error, duration_ms, created_at
+		FROM tool_invocations
+		WHERE chat_id = ?
+		ORDER BY created_at ASC, id ASC
+	`, chatID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tool invocations: %w", err)
+	}
+	defer rows.Close()
+
+	var invocations []ToolInvocation
+	for rows.Next() {
+		var inv ToolInvocation
+		var agentID, serverID sql.NullInt64
+		var args, res sql.NullString
+		if err := rows.Scan(&inv.ID, &inv.ChatID, &inv.UserID, &agentID, &serverID, &inv.ToolName,
+			&args, &res, &inv.IsError, &inv.DurationMs, &inv.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan tool invocation: %w", err)
+		}
+		if agentID.Valid {
+			inv.AgentID = &agentID.Int64
+		}
+		if serverID.Valid {
+			inv.ServerID = &serverID.Int64
+		}
+		inv.Arguments = args.String
+		inv.Result = res.String
+		invocations = append(invocations, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tool invocations: %w", err)
+	}
+	return invocations, nil
+}
@@ -435,24 +435,42 @@ func (s *ModelService) UpdateModel(model *Model) error {
 	return nil
 }
 
-// DeleteModel removes a model by ID
+// DeleteModel removes a model by ID, along with who it was granted to
 func (s *ModelService) DeleteModel(id int64) error {
-	query := "DELETE FROM models WHERE id = ?"
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		if err := detachModels(tx, "id = ?", id); err != nil {
+			return err
+		}
 
-	result, err := s.DB.Exec(query, id)
-	if err != nil {
-		return err
-	}
+		result, err := tx.Exec("DELETE FROM models WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
 
-	if rowsAffected == 0 {
-		return fmt.Errorf("model with ID %d not found", id)
-	}
+		if rowsAffected == 0 {
+			return fmt.Errorf("model with ID %d not found", id)
+		}
 
+		return nil
+	})
+}
+
+// detachModels removes the grants of the models matching condition and
+// clears them as project defaults, before they are deleted. Foreign keys are
+// not enforced, so their ON DELETE clauses do not do it.
+func detachModels(tx *sql.Tx, condition string, arg any) error {
+	models := `SELECT id FROM models WHERE ` + condition
+	if _, err := tx.Exec(`DELETE FROM model_grants WHERE model_id IN (`+models+`)`, arg); err != nil {
+		return fmt.Errorf("failed to delete model grants: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE projects SET default_model_id = NULL WHERE default_model_id IN (`+models+`)`, arg); err != nil {
+		return fmt.Errorf("failed to clear project default models: %w", err)
+	}
 	return nil
 }
 
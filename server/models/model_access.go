@@ -0,0 +1,177 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrModelNotFound is returned when there is no model with the given ID
+var ErrModelNotFound = errors.New("model not found")
+
+// ErrInvalidModelGrant is returned when a model is granted to a role or user that does not exist
+var ErrInvalidModelGrant = errors.New("invalid model grant")
+
+// ModelAccess lists who can use a model: every user if AllowAll is set,
+// otherwise the users with one of RoleIDs and the users in UserIDs. Users
+// whose role has every permission (PermAll) can use every active model.
+type ModelAccess struct {
+	ModelID  int64   `json:"model_id"`
+	AllowAll bool    `json:"allow_all"`
+	RoleIDs  []int64 `json:"role_ids"`
+	UserIDs  []int64 `json:"user_ids"`
+}
+
+// modelUsableBy is the condition under which the user u (joined with their
+// role r) can use the model m
+const modelUsableBy = `
+	m.is_active = 1 AND (
+		m.allow_all = 1
+		OR json_extract(r.permissions, '$.all') = 1
+		OR EXISTS (
+			SELECT 1 FROM model_grants g
+			WHERE g.model_id = m.id AND (g.user_id = u.id OR g.role_id = u.role_id)
+		)
+	)`
+
+// GetModelAccess returns who can use the model
+func (s *ModelService) GetModelAccess(modelID int64) (*ModelAccess, error) {
+	access := ModelAccess{ModelID: modelID, RoleIDs: []int64{}, UserIDs: []int64{}}
+	err := s.DB.QueryRow(`SELECT allow_all FROM models WHERE id = ?`, modelID).Scan(&access.AllowAll)
+	if err == sql.ErrNoRows {
+		return nil, ErrModelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get model access: %w", err)
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT role_id, user_id FROM model_grants WHERE model_id = ? ORDER BY role_id, user_id
+	`, modelID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model grants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roleID, userID sql.NullInt64
+		if err := rows.Scan(&roleID, &userID); err != nil {
+			return nil, fmt.Errorf("failed to scan model grant: %w", err)
+		}
+		if roleID.Valid {
+			access.RoleIDs = append(access.RoleIDs, roleID.Int64)
+		} else {
+			access.UserIDs = append(access.UserIDs, userID.Int64)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating model grants: %w", err)
+	}
+	return &access, nil
+}
+
+// SetModelAccess replaces who can use access.ModelID
+func (s *ModelService) SetModelAccess(access *ModelAccess) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`UPDATE models SET allow_all = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, access.AllowAll, access.ModelID)
+		if err != nil {
+			return fmt.Errorf("failed to update model access: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return ErrModelNotFound
+		}
+		if _, err := tx.Exec(`DELETE FROM model_grants WHERE model_id = ?`, access.ModelID); err != nil {
+			return fmt.Errorf("failed to clear model grants: %w", err)
+		}
+
+		for _, roleID := range access.RoleIDs {
+			if err := insertModelGrant(tx, access.ModelID, "role", roleID); err != nil {
+				return err
+			}
+		}
+		for _, userID := range access.UserIDs {
+			if err := insertModelGrant(tx, access.ModelID, "user", userID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insertModelGrant grants the model to one role or user (kind "role" or
+// "user"), ignoring duplicates
+func insertModelGrant(tx *sql.Tx, modelID int64, kind string, id int64) error {
+	table, column := "roles", "role_id"
+	if kind == "user" {
+		table, column = "users", "user_id"
+	}
+
+	var exists bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE id = ?)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check model grant: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: no %s with ID %d", ErrInvalidModelGrant, kind, id)
+	}
+	_, err := tx.Exec(`INSERT OR IGNORE INTO model_grants (model_id, `+column+`) VALUES (?, ?)`, modelID, id)
+	if err != nil {
+		return fmt.Errorf("failed to add model grant: %w", err)
+	}
+	return nil
+}
+
+// CanUseModel reports whether the user can use the model: it must be active
+// and open to them
+func (s *ModelService) CanUseModel(userID, modelID int64) (bool, error) {
+	var usable bool
+	err := s.DB.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM models m, users u
+			LEFT JOIN roles r ON r.id = u.role_id
+			WHERE m.id = ? AND u.id = ? AND `+modelUsableBy+`
+		)
+	`, modelID, userID).Scan(&usable)
+	if err != nil {
+		return false, fmt.Errorf("failed to check model access: %w", err)
+	}
+	return usable, nil
+}
+
+// GetUserFacingModelsForUser retrieves the active models the user can use,
+// formatted for user display
+func (s *ModelService) GetUserFacingModelsForUser(userID int64) ([]UserFacingModel, error) {
+	rows, err := s.DB.Query(`
+		SELECT m.id
+		FROM models m, users u
+		LEFT JOIN roles r ON r.id = u.role_id
+		WHERE u.id = ? AND `+modelUsableBy, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usable models: %w", err)
+	}
+	defer rows.Close()
+
+	usable := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan usable model: %w", err)
+		}
+		usable[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usable models: %w", err)
+	}
+
+	activeModels, err := s.GetActiveUserFacingModels()
+	if err != nil {
+		return nil, err
+	}
+	userModels := make([]UserFacingModel, 0, len(usable))
+	for _, m := range activeModels {
+		if usable[m.ID] {
+			userModels = append(userModels, m)
+		}
+	}
+	return userModels, nil
+}
@@ -0,0 +1,237 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// Limits on a user's custom instructions and memory, which are sent with
+// every request in their chats
+const (
+	MaxCustomInstructionsLength = 4000
+	MaxMemoryLength             = 500
+	MaxMemories                 = 100
+)
+
+// ErrMemoryNotFound is returned when the user has no such memory
+var ErrMemoryNotFound = errors.New("memory not found")
+
+// ErrTooManyMemories is returned when the user already has MaxMemories memories
+var ErrTooManyMemories = fmt.Errorf("at most %d memories can be saved", MaxMemories)
+
+// CustomInstructions is what a user wants every model to know about them and
+// how they want to be answered, e.g. "I'm an SRE, prefer Go, be terse"
+type CustomInstructions struct {
+	CustomInstructions string     `json:"custom_instructions"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"` // Absent if never set
+}
+
+// UserMemory is one fact the user asked the models to remember about them
+type UserMemory struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProfileService handles users' custom instructions and memory
+type ProfileService struct {
+	DB *db.DB
+}
+
+// NewProfileService creates a new ProfileService
+func NewProfileService(database *db.DB) *ProfileService {
+	return &ProfileService{DB: database}
+}
+
+// GetCustomInstructions returns the user's custom instructions, empty if
+// they have none
+func (s *ProfileService) GetCustomInstructions(userID int64) (*CustomInstructions, error) {
+	var ci CustomInstructions
+	var updatedAt time.Time
+	err := s.DB.QueryRow(`
+		SELECT custom_instructions, updated_at FROM user_profiles WHERE user_id = ?
+	`, userID).Scan(&ci.CustomInstructions, &updatedAt)
+	if err == sql.ErrNoRows {
+		return &ci, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get custom instructions: %w", err)
+	}
+	ci.UpdatedAt = &updatedAt
+	return &ci, nil
+}
+
+// SetCustomInstructions replaces the user's custom instructions; empty
+// instructions clear them
+func (s *ProfileService) SetCustomInstructions(userID int64, instructions string) (*CustomInstructions, error) {
+	_, err := s.DB.Exec(`
+		INSERT INTO user_profiles (user_id, custom_instructions, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET custom_instructions = excluded.custom_instructions, updated_at = excluded.updated_at
+	`, userID, instructions, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to set custom instructions: %w", err)
+	}
+	return s.GetCustomInstructions(userID)
+}
+
+// ListMemories returns the user's memories, oldest first
+func (s *ProfileService) ListMemories(userID int64) ([]UserMemory, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, content, created_at, updated_at
+		FROM user_memories
+		WHERE user_id = ?
+		ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query memories: %w", err)
+	}
+	defer rows.Close()
+
+	memories := []UserMemory{}
+	for rows.Next() {
+		var m UserMemory
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Content, &m.CreatedAt, &m.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan memory: %w", err)
+		}
+		memories = append(memories, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating memories: %w", err)
+	}
+	return memories, nil
+}
+
+// getMemory returns one of the user's memories
+func (s *ProfileService) getMemory(memoryID, userID int64) (*UserMemory, error) {
+	var m UserMemory
+	err := s.DB.QueryRow(`
+		SELECT id, user_id, content, created_at, updated_at
+		FROM user_memories
+		WHERE id = ? AND user_id = ?
+	`, memoryID, userID).Scan(&m.ID, &m.UserID, &m.Content, &m.CreatedAt, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrMemoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory: %w", err)
+	}
+	return &m, nil
+}
+
+// AddMemory saves a new memory for m.UserID
+func (s *ProfileService) AddMemory(m *UserMemory) error {
+	var id int64
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM user_memories WHERE user_id = ?`, m.UserID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count memories: %w", err)
+		}
+		if count >= MaxMemories {
+			return ErrTooManyMemories
+		}
+
+		now := time.Now()
+		result, err := tx.Exec(`
+			INSERT INTO user_memories (user_id, content, created_at, updated_at) VALUES (?, ?, ?, ?)
+		`, m.UserID, m.Content, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to add memory: %w", err)
+		}
+		if id, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get memory ID: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	saved, err := s.getMemory(id, m.UserID)
+	if err != nil {
+		return err
+	}
+	*m = *saved
+	return nil
+}
+
+// UpdateMemory replaces the content of one of the user's memories
+func (s *ProfileService) UpdateMemory(m *UserMemory) error {
+	result, err := s.DB.Exec(`
+		UPDATE user_memories SET content = ?, updated_at = ? WHERE id = ? AND user_id = ?
+	`, m.Content, time.Now(), m.ID, m.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update memory: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrMemoryNotFound
+	}
+
+	saved, err := s.getMemory(m.ID, m.UserID)
+	if err != nil {
+		return err
+	}
+	*m = *saved
+	return nil
+}
+
+// DeleteMemory deletes one of the user's memories
+func (s *ProfileService) DeleteMemory(memoryID, userID int64) error {
+	result, err := s.DB.Exec(`DELETE FROM user_memories WHERE id = ? AND user_id = ?`, memoryID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete memory: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrMemoryNotFound
+	}
+	return nil
+}
+
+// ClearMemories deletes all of the user's memories
+func (s *ProfileService) ClearMemories(userID int64) error {
+	if _, err := s.DB.Exec(`DELETE FROM user_memories WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to clear memories: %w", err)
+	}
+	return nil
+}
+
+// GetChatPersonalization returns the custom instructions and memories that
+// apply to a response in the chat: those of the author of the message being
+// answered (the chat's owner if authorID is 0). Every participant of a shared
+// chat sees its responses, so memories are left out of chats with
+// participants; instructions only steer how the author is answered.
+func (s *ProfileService) GetChatPersonalization(chatID, authorID int64) (string, []UserMemory, error) {
+	var ownerID int64
+	var participants int
+	err := s.DB.QueryRow(`
+		SELECT user_id, (SELECT COUNT(*) FROM chat_participants WHERE chat_id = chats.id)
+		FROM chats WHERE id = ?
+	`, chatID).Scan(&ownerID, &participants)
+	if err == sql.ErrNoRows {
+		return "", nil, fmt.Errorf("chat not found: %d", chatID)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get chat owner: %w", err)
+	}
+	if authorID == 0 {
+		authorID = ownerID
+	}
+
+	ci, err := s.GetCustomInstructions(authorID)
+	if err != nil {
+		return "", nil, err
+	}
+	if participants > 0 {
+		return ci.CustomInstructions, nil, nil
+	}
+	memories, err := s.ListMemories(authorID)
+	if err != nil {
+		return "", nil, err
+	}
+	return ci.CustomInstructions, memories, nil
+}
@@ -0,0 +1,441 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// Limits on the reference files of a project. Files are text and are sent
+// with every request in the project's chats, so they are kept small.
+const (
+	MaxProjectFileSize = 512 << 10
+	MaxProjectFiles    = 20
+)
+
+// ErrProjectNotFound is returned when the project does not exist or the user cannot see it
+var ErrProjectNotFound = errors.New("project not found")
+
+// ErrProjectFileNotFound is returned when the project has no such file
+var ErrProjectFileNotFound = errors.New("project file not found")
+
+// ErrInvalidProjectDefault is returned when the default model is not an
+// active model, or the default agent is neither the owner's nor public
+var ErrInvalidProjectDefault = errors.New("invalid default model or agent")
+
+// ErrTooManyProjectFiles is returned when a project already has MaxProjectFiles files
+var ErrTooManyProjectFiles = fmt.Errorf("a project can have at most %d files", MaxProjectFiles)
+
+// Project is a named workspace owning a set of chats. Its instructions and
+// reference files are added to the context of every chat in it, and its
+// default model and agent answer messages that do not name one.
+//
+// A shared project can be seen and used by every user with the same role as
+// its owner: they can put their own chats in it and read its files. Only the
+// owner can change or delete it. Chats stay private to their owners.
+type Project struct {
+	ID             int64         `json:"id"`
+	UserID         int64         `json:"user_id"`  // Owner
+	Username       string        `json:"username"` // Owner's username
+	Name           string        `json:"name"`
+	Description    string        `json:"description"`
+	Instructions   string        `json:"instructions"`
+	DefaultModelID *int64        `json:"default_model_id,omitempty"`
+	DefaultAgentID *int64        `json:"default_agent_id,omitempty"`
+	IsShared       bool          `json:"is_shared"` // Shared with the users in the owner's role
+	Chats          int           `json:"chats"`     // Number of the caller's chats in the project
+	Files          []ProjectFile `json:"files,omitempty"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// ProjectFile is a text file attached to a project for reference
+type ProjectFile struct {
+	ID        int64     `json:"id"`
+	ProjectID int64     `json:"project_id"`
+	Name      string    `json:"name"`
+	Size      int       `json:"size"`              // In bytes
+	Content   string    `json:"content,omitempty"` // Only included when a single file is fetched
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ProjectService handles projects and their files
+type ProjectService struct {
+	DB *db.DB
+}
+
+// NewProjectService creates a new ProjectService
+func NewProjectService(database *db.DB) *ProjectService {
+	return &ProjectService{DB: database}
+}
+
+// projectColumns lists the project columns read by scanProject. The query
+// must join the owner as o and take the viewing user's ID as its first
+// parameter (for the chat count).
+const projectColumns = `p.id, p.user_id, o.username, p.name, p.description, p.instructions,
+		       p.default_model_id, p.default_agent_id, p.is_shared, p.created_at, p.updated_at,
+		       (SELECT COUNT(*) FROM chats c WHERE c.project_id = p.id AND c.user_id = ?)`
+
+// projectVisibleTo restricts a query joining the owner as o to the projects
+// the user owns or that are shared with their role. It takes the user's ID
+// twice.
+const projectVisibleTo = `(p.user_id = ? OR (p.is_shared = 1 AND o.role_id = (SELECT role_id FROM users WHERE id = ?)))`
+
+// scanProject scans a row selected with projectColumns
+func scanProject(row interface{ Scan(...interface{}) error }, p *Project) error {
+	var defaultModelID, defaultAgentID sql.NullInt64
+	if err := row.Scan(
+		&p.ID, &p.UserID, &p.Username, &p.Name, &p.Description, &p.Instructions,
+		&defaultModelID, &defaultAgentID, &p.IsShared, &p.CreatedAt, &p.UpdatedAt, &p.Chats,
+	); err != nil {
+		return err
+	}
+	p.DefaultModelID, p.DefaultAgentID = nil, nil
+	if defaultModelID.Valid {
+		p.DefaultModelID = &defaultModelID.Int64
+	}
+	if defaultAgentID.Valid {
+		p.DefaultAgentID = &defaultAgentID.Int64
+	}
+	return nil
+}
+
+// ListProjects returns the projects the user owns or that are shared with
+// their role, by name
+func (s *ProjectService) ListProjects(userID int64) ([]Project, error) {
+	rows, err := s.DB.Query(`
+		SELECT `+projectColumns+`
+		FROM projects p
+		JOIN users o ON o.id = p.user_id
+		WHERE `+projectVisibleTo+`
+		ORDER BY p.name COLLATE NOCASE
+	`, userID, userID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query projects: %w", err)
+	}
+	defer rows.Close()
+
+	projects := []Project{}
+	for rows.Next() {
+		var p Project
+		if err := scanProject(rows, &p); err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating projects: %w", err)
+	}
+	return projects, nil
+}
+
+// GetProject returns a project the user can see, with its files (without
+// their content). It returns ErrProjectNotFound for projects they cannot see.
+func (s *ProjectService) GetProject(projectID, userID int64) (*Project, error) {
+	var p Project
+	err := scanProject(s.DB.QueryRow(`
+		SELECT `+projectColumns+`
+		FROM projects p
+		JOIN users o ON o.id = p.user_id
+		WHERE p.id = ? AND `+projectVisibleTo+`
+	`, userID, projectID, userID, userID), &p)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project: %w", err)
+	}
+
+	if p.Files, err = s.getProjectFiles(projectID, false); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetChatProject returns the project of a chat, with the content of its
+// files, or nil if the chat is in no project. A project that is no longer
+// shared with the chat's owner is ignored.
+func (s *ProjectService) GetChatProject(chatID int64) (*Project, error) {
+	var ownerID int64
+	var projectID sql.NullInt64
+	err := s.DB.QueryRow(`SELECT user_id, project_id FROM chats WHERE id = ?`, chatID).Scan(&ownerID, &projectID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("chat not found: %d", chatID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat project: %w", err)
+	}
+	if !projectID.Valid {
+		return nil, nil
+	}
+
+	project, err := s.GetProject(projectID.Int64, ownerID)
+	if errors.Is(err, ErrProjectNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if project.Files, err = s.getProjectFiles(project.ID, true); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// GetChatDefaults returns the default model and agent of the chat's project,
+// if it is in one the chat's owner can still see. Without a default model,
+// the default agent's model is returned.
+func (s *ProjectService) GetChatDefaults(chatID int64) (modelID int64, agentID *int64, err error) {
+	return scanProjectDefaults(s.DB.QueryRow(`
+		SELECT p.default_model_id, p.default_agent_id, a.model_id
+		FROM chats c
+		JOIN projects p ON p.id = c.project_id
+		JOIN users o ON o.id = p.user_id
+		LEFT JOIN agents a ON a.id = p.default_agent_id
+		WHERE c.id = ?
+		  AND (p.user_id = c.user_id OR (p.is_shared = 1 AND o.role_id = (SELECT role_id FROM users WHERE id = c.user_id)))
+	`, chatID))
+}
+
+// GetProjectDefaults returns the default model and agent of a project like
+// GetChatDefaults. The caller checks the project is visible.
+func (s *ProjectService) GetProjectDefaults(projectID int64) (modelID int64, agentID *int64, err error) {
+	return scanProjectDefaults(s.DB.QueryRow(`
+		SELECT p.default_model_id, p.default_agent_id, a.model_id
+		FROM projects p
+		LEFT JOIN agents a ON a.id = p.default_agent_id
+		WHERE p.id = ?
+	`, projectID))
+}
+
+// scanProjectDefaults scans the default model, default agent and the agent's
+// model of a project; no row means no defaults
+func scanProjectDefaults(row *sql.Row) (modelID int64, agentID *int64, err error) {
+	var defaultModelID, defaultAgentID, agentModelID sql.NullInt64
+	err = row.Scan(&defaultModelID, &defaultAgentID, &agentModelID)
+	if err == sql.ErrNoRows {
+		return 0, nil, nil
+	}
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to get project defaults: %w", err)
+	}
+	if defaultAgentID.Valid {
+		agentID = &defaultAgentID.Int64
+	}
+	switch {
+	case defaultModelID.Valid:
+		modelID = defaultModelID.Int64
+	case agentModelID.Valid:
+		modelID = agentModelID.Int64
+	}
+	return modelID, agentID, nil
+}
+
+// validateDefaults checks the default model is active and the default agent
+// belongs to the project's owner or is public
+func (s *ProjectService) validateDefaults(p *Project) error {
+	if p.DefaultModelID != nil {
+		var ok bool
+		err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM models WHERE id = ? AND is_active = 1)`, *p.DefaultModelID).Scan(&ok)
+		if err != nil {
+			return fmt.Errorf("failed to check default model: %w", err)
+		}
+		if !ok {
+			return ErrInvalidProjectDefault
+		}
+	}
+	if p.DefaultAgentID != nil {
+		var ok bool
+		err := s.DB.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM agents WHERE id = ? AND is_active = 1 AND (user_id = ? OR is_public = 1))
+		`, *p.DefaultAgentID, p.UserID).Scan(&ok)
+		if err != nil {
+			return fmt.Errorf("failed to check default agent: %w", err)
+		}
+		if !ok {
+			return ErrInvalidProjectDefault
+		}
+	}
+	return nil
+}
+
+// CreateProject creates a project owned by the user
+func (s *ProjectService) CreateProject(p *Project) error {
+	if err := s.validateDefaults(p); err != nil {
+		return err
+	}
+	result, err := s.DB.Exec(`
+		INSERT INTO projects (user_id, name, description, instructions, default_model_id, default_agent_id, is_shared)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, p.UserID, p.Name, p.Description, p.Instructions, p.DefaultModelID, p.DefaultAgentID, p.IsShared)
+	if err != nil {
+		return fmt.Errorf("failed to create project: %w", err)
+	}
+	if p.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get project ID: %w", err)
+	}
+
+	created, err := s.GetProject(p.ID, p.UserID)
+	if err != nil {
+		return err
+	}
+	*p = *created
+	return nil
+}
+
+// UpdateProject saves the name, description, instructions, defaults and
+// sharing of a project. Only its owner (p.UserID) can update it.
+func (s *ProjectService) UpdateProject(p *Project) error {
+	if err := s.validateDefaults(p); err != nil {
+		return err
+	}
+	result, err := s.DB.Exec(`
+		UPDATE projects
+		SET name = ?, description = ?, instructions = ?, default_model_id = ?, default_agent_id = ?,
+		    is_shared = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?
+	`, p.Name, p.Description, p.Instructions, p.DefaultModelID, p.DefaultAgentID, p.IsShared, time.Now(), p.ID, p.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to update project: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrProjectNotFound
+	}
+
+	updated, err := s.GetProject(p.ID, p.UserID)
+	if err != nil {
+		return err
+	}
+	*p = *updated
+	return nil
+}
+
+// DeleteProject deletes a project owned by the user and its files. Its
+// chats, including other users' chats in a shared project, are kept and are
+// no longer in a project.
+func (s *ProjectService) DeleteProject(projectID, userID int64) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM projects WHERE id = ? AND user_id = ?)`, projectID, userID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to get project: %w", err)
+		}
+		if !exists {
+			return ErrProjectNotFound
+		}
+
+		if _, err := tx.Exec(`UPDATE chats SET project_id = NULL WHERE project_id = ?`, projectID); err != nil {
+			return fmt.Errorf("failed to remove chats from project: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM project_files WHERE project_id = ?`, projectID); err != nil {
+			return fmt.Errorf("failed to delete project files: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM projects WHERE id = ?`, projectID); err != nil {
+			return fmt.Errorf("failed to delete project: %w", err)
+		}
+		return nil
+	})
+}
+
+// SetChatProject moves a chat into a project, or out of any project if
+// projectID is nil. The caller checks the user may use the project.
+func (s *ProjectService) SetChatProject(chatID int64, projectID *int64) error {
+	_, err := s.DB.Exec(`UPDATE chats SET project_id = ? WHERE id = ?`, projectID, chatID)
+	if err != nil {
+		return fmt.Errorf("failed to set chat project: %w", err)
+	}
+	return nil
+}
+
+// getProjectFiles lists the files of a project by name
+func (s *ProjectService) getProjectFiles(projectID int64, withContent bool) ([]ProjectFile, error) {
+	content := "''"
+	if withContent {
+		content = "content"
+	}
+	rows, err := s.DB.Query(`
+		SELECT id, project_id, name, size, `+content+`, created_at
+		FROM project_files
+		WHERE project_id = ?
+		ORDER BY name COLLATE NOCASE, id
+	`, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project files: %w", err)
+	}
+	defer rows.Close()
+
+	files := []ProjectFile{}
+	for rows.Next() {
+		var f ProjectFile
+		if err := rows.Scan(&f.ID, &f.ProjectID, &f.Name, &f.Size, &f.Content, &f.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan project file: %w", err)
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating project files: %w", err)
+	}
+	return files, nil
+}
+
+// GetProjectFile returns a file of the project, with its content
+func (s *ProjectService) GetProjectFile(projectID, fileID int64) (*ProjectFile, error) {
+	var f ProjectFile
+	err := s.DB.QueryRow(`
+		SELECT id, project_id, name, size, content, created_at
+		FROM project_files
+		WHERE id = ? AND project_id = ?
+	`, fileID, projectID).Scan(&f.ID, &f.ProjectID, &f.Name, &f.Size, &f.Content, &f.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project file: %w", err)
+	}
+	return &f, nil
+}
+
+// AddProjectFile attaches a text file to the project. The caller checks its
+// size and that it is text.
+func (s *ProjectService) AddProjectFile(f *ProjectFile) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM project_files WHERE project_id = ?`, f.ProjectID).Scan(&count); err != nil {
+			return fmt.Errorf("failed to count project files: %w", err)
+		}
+		if count >= MaxProjectFiles {
+			return ErrTooManyProjectFiles
+		}
+
+		f.Size = len(f.Content)
+		result, err := tx.Exec(`
+			INSERT INTO project_files (project_id, name, size, content) VALUES (?, ?, ?, ?)
+		`, f.ProjectID, f.Name, f.Size, f.Content)
+		if err != nil {
+			return fmt.Errorf("failed to add project file: %w", err)
+		}
+		if f.ID, err = result.LastInsertId(); err != nil {
+			return fmt.Errorf("failed to get project file ID: %w", err)
+		}
+		if err := tx.QueryRow(`SELECT created_at FROM project_files WHERE id = ?`, f.ID).Scan(&f.CreatedAt); err != nil {
+			return fmt.Errorf("failed to get project file: %w", err)
+		}
+		_, err = tx.Exec(`UPDATE projects SET updated_at = ? WHERE id = ?`, time.Now(), f.ProjectID)
+		return err
+	})
+}
+
+// DeleteProjectFile removes a file from the project
+func (s *ProjectService) DeleteProjectFile(projectID, fileID int64) error {
+	result, err := s.DB.Exec(`DELETE FROM project_files WHERE id = ? AND project_id = ?`, fileID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to delete project file: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrProjectFileNotFound
+	}
+	return nil
+}
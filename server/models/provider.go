@@ -252,6 +252,10 @@ func (s *ProviderService) DeleteProvider(id int64) error {
 	}()
 
 	// 1. Delete associated models
+	err = detachModels(tx, "provider_id = ?", id)
+	if err != nil {
+		return err
+	}
 	modelsQuery := `DELETE FROM models WHERE provider_id = ?`
 	_, err = tx.Exec(modelsQuery, id)
 	if err != nil {
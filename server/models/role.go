@@ -0,0 +1,255 @@
+package models
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Permissions a role can grant. PermAll grants every permission.
+const (
+	PermAll                = "all"
+	PermManageUsers        = "manage_users"         // Users, roles, and searching every user's chats
+	PermManageProviders    = "manage_providers"     // Providers, their model sync, and MCP servers
+	PermManageModels       = "manage_models"        // Models and who can use them
+	PermViewUsage          = "view_usage"           // Token usage of every user
+	PermCreatePublicAgents = "create_public_agents" // Agents every user can see
+	PermUseAPITokens       = "use_api_tokens"       // Creating and authenticating with API tokens
+	PermExportChats        = "export_chats"         // Exporting one's chats
+)
+
+// PermissionDescriptions describes every permission a role can grant
+var PermissionDescriptions = map[string]string{
+	PermAll:                "Every permission, including those added later",
+	PermManageUsers:        "Manage users and roles, and search every user's chats",
+	PermManageProviders:    "Manage providers, sync their models, and manage MCP servers",
+	PermManageModels:       "Manage models and who can use them",
+	PermViewUsage:          "View the token usage of every user",
+	PermCreatePublicAgents: "Make agents public",
+	PermUseAPITokens:       "Create API tokens and use them to call the API",
+	PermExportChats:        "Export chats",
+}
+
+// AdminPermissions are the permissions that give access to the admin area
+var AdminPermissions = []string{PermManageUsers, PermManageProviders, PermManageModels, PermViewUsage}
+
+// Built-in roles, which cannot be deleted. The admin role always keeps every
+// permission, so that someone can still manage roles.
+const (
+	AdminRoleID = 1
+	UserRoleID  = 2
+)
+
+// ErrRoleNotFound is returned when there is no role with the given ID
+var ErrRoleNotFound = errors.New("role not found")
+
+// ErrRoleExists is returned when a role with the same name already exists
+var ErrRoleExists = errors.New("a role with that name already exists")
+
+// ErrRoleInUse is returned when deleting a role that users still have
+var ErrRoleInUse = errors.New("the role is assigned to users")
+
+// ErrBuiltinRole is returned when deleting a built-in role, or taking the
+// admin role's name or permissions away
+var ErrBuiltinRole = errors.New("built-in roles cannot be deleted, and the admin role cannot be renamed or lose permissions")
+
+// ErrInvalidRole is returned for a role without a name or with unknown permissions
+var ErrInvalidRole = errors.New("invalid role")
+
+// ErrPermissionDenied is returned when the user's role does not grant a permission
+var ErrPermissionDenied = errors.New("permission denied")
+
+// Permissions is the set of permissions a role grants, stored as a JSON
+// object in roles.permissions, e.g. {"manage_users": true}
+type Permissions map[string]bool
+
+// Has reports whether the permissions include permission
+func (p Permissions) Has(permission string) bool {
+	return p[PermAll] || p[permission]
+}
+
+// HasAny reports whether the permissions include one of permissions
+func (p Permissions) HasAny(permissions ...string) bool {
+	for _, permission := range permissions {
+		if p.Has(permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// Scan implements the sql.Scanner interface. Values that are not true
+// (including those of older formats) grant nothing.
+func (p *Permissions) Scan(value interface{}) error {
+	*p = make(Permissions)
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("failed to unmarshal Permissions value")
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	for name, granted := range raw {
+		if granted == true {
+			(*p)[name] = true
+		}
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface
+func (p Permissions) Value() (driver.Value, error) {
+	if p == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// validateRole checks the role's name and that it only grants known permissions
+func validateRole(role *Role) error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRole)
+	}
+	var unknown []string
+	for name, granted := range role.Permissions {
+		if _, ok := PermissionDescriptions[name]; !ok {
+			unknown = append(unknown, name)
+		} else if !granted {
+			delete(role.Permissions, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%w: unknown permissions: %s", ErrInvalidRole, strings.Join(unknown, ", "))
+	}
+	if role.Permissions == nil {
+		role.Permissions = Permissions{}
+	}
+	return nil
+}
+
+// CreateRole creates a new role
+func (s *UserService) CreateRole(role *Role) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	now := time.Now()
+	result, err := s.DB.Exec(`
+		INSERT INTO roles (name, description, permissions, require_two_factor, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, role.Name, role.Description, role.Permissions, role.RequireTwoFactor, now, now)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: roles.name") {
+			return ErrRoleExists
+		}
+		return fmt.Errorf("failed to create role: %w", err)
+	}
+	if role.ID, err = result.LastInsertId(); err != nil {
+		return fmt.Errorf("failed to get role ID: %w", err)
+	}
+	role.CreatedAt, role.UpdatedAt = now, now
+	return nil
+}
+
+// UpdateRole replaces the name, description, permissions and two-factor
+// requirement of a role
+func (s *UserService) UpdateRole(role *Role) error {
+	if err := validateRole(role); err != nil {
+		return err
+	}
+	if role.ID == AdminRoleID {
+		existing, err := s.GetRole(role.ID)
+		if err != nil {
+			return err
+		}
+		if role.Name != existing.Name || !role.Permissions.Has(PermAll) {
+			return ErrBuiltinRole
+		}
+	}
+
+	result, err := s.DB.Exec(`
+		UPDATE roles SET name = ?, description = ?, permissions = ?, require_two_factor = ?, updated_at = ? WHERE id = ?
+	`, role.Name, role.Description, role.Permissions, role.RequireTwoFactor, time.Now(), role.ID)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: roles.name") {
+			return ErrRoleExists
+		}
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrRoleNotFound
+	}
+	return nil
+}
+
+// DeleteRole deletes a role no user has
+func (s *UserService) DeleteRole(roleID int64) error {
+	if roleID == AdminRoleID || roleID == UserRoleID {
+		return ErrBuiltinRole
+	}
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		var users int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM users WHERE role_id = ?`, roleID).Scan(&users); err != nil {
+			return fmt.Errorf("failed to count users of role: %w", err)
+		}
+		if users > 0 {
+			return ErrRoleInUse
+		}
+		if _, err := tx.Exec(`DELETE FROM model_grants WHERE role_id = ?`, roleID); err != nil {
+			return fmt.Errorf("failed to delete model grants of role: %w", err)
+		}
+		result, err := tx.Exec(`DELETE FROM roles WHERE id = ?`, roleID)
+		if err != nil {
+			return fmt.Errorf("failed to delete role: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return ErrRoleNotFound
+		}
+		return nil
+	})
+}
+
+// GetUserPermissions returns the permissions of the user's role
+func (s *UserService) GetUserPermissions(userID int64) (Permissions, error) {
+	var permissions Permissions
+	err := s.DB.QueryRow(`
+		SELECT r.permissions FROM users u JOIN roles r ON r.id = u.role_id WHERE u.id = ?
+	`, userID).Scan(&permissions)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found: %d", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user permissions: %w", err)
+	}
+	return permissions, nil
+}
+
+// HasPermission reports whether the user's role grants one of permissions
+func (s *UserService) HasPermission(userID int64, permissions ...string) (bool, error) {
+	granted, err := s.GetUserPermissions(userID)
+	if err != nil {
+		return false, err
+	}
+	return granted.HasAny(permissions...), nil
+}
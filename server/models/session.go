@@ -0,0 +1,221 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/db"
+)
+
+// ErrSessionNotFound is returned when revoking a session that does not exist
+// or belongs to someone else
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval is how often the last seen time, IP address and user
+// agent of a session are updated, so that requests do not all write
+const sessionTouchInterval = time.Minute
+
+// Session is a login session, as listed to its user and admins
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	IPAddress  string    `json:"ip_address"` // Of the latest request
+	UserAgent  string    `json:"user_agent"` // Of the latest request
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session of the request listing it
+}
+
+// StoredSession is a session as the session store loads it
+type StoredSession struct {
+	ID         int64
+	UserID     int64 // 0 until the login completes
+	Data       string
+	LastSeenAt time.Time
+}
+
+// SessionService stores login sessions, looked up by the hash of the token in
+// the session cookie
+type SessionService struct {
+	DB *db.DB
+}
+
+// NewSessionService creates a new session service
+func NewSessionService(database *db.DB) *SessionService {
+	return &SessionService{DB: database}
+}
+
+// HashSessionToken returns the hash a session token is stored as
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateSession stores a new session, returning its ID. Expired sessions are
+// deleted along the way.
+func (s *SessionService) CreateSession(token string, userID int64, data, ipAddress, userAgent string, expiresAt time.Time) (int64, error) {
+	now := time.Now()
+	if _, err := s.DB.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now); err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	result, err := s.DB.Exec(`
+		INSERT INTO sessions (token_hash, user_id, data, ip_address, user_agent, created_at, last_seen_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, HashSessionToken(token), nullableID(userID), data, ipAddress, userAgent, now, now, expiresAt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create session: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// GetSessionByToken returns the session of a token, or nil if there is none,
+// it expired, or its user was deactivated
+func (s *SessionService) GetSessionByToken(token string) (*StoredSession, error) {
+	var session StoredSession
+	var userID sql.NullInt64
+	var expiresAt time.Time
+	err := s.DB.QueryRow(`
+		SELECT s.id, s.user_id, s.data, s.last_seen_at, s.expires_at
+		FROM sessions s LEFT JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND (s.user_id IS NULL OR u.is_active)
+	`, HashSessionToken(token)).Scan(&session.ID, &userID, &session.Data, &session.LastSeenAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if time.Now().After(expiresAt) {
+		return nil, nil
+	}
+	session.UserID = userID.Int64
+	return &session, nil
+}
+
+// GetSessionUserID returns the user of a token's session (0 until the login
+// completes), and whether the session still exists
+func (s *SessionService) GetSessionUserID(token string) (int64, bool, error) {
+	var userID sql.NullInt64
+	err := s.DB.QueryRow(`SELECT user_id FROM sessions WHERE token_hash = ?`, HashSessionToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get session: %w", err)
+	}
+	return userID.Int64, true, nil
+}
+
+// UpdateSession replaces the data of a token's session and extends it
+func (s *SessionService) UpdateSession(token, data string, expiresAt time.Time) error {
+	if _, err := s.DB.Exec(`
+		UPDATE sessions SET data = ?, expires_at = ? WHERE token_hash = ?
+	`, data, expiresAt, HashSessionToken(token)); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	return nil
+}
+
+// TouchSession records a request of the session, at most once per
+// sessionTouchInterval
+func (s *SessionService) TouchSession(session *StoredSession, ipAddress, userAgent string) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	if _, err := s.DB.Exec(`
+		UPDATE sessions SET last_seen_at = ?, ip_address = ?, user_agent = ? WHERE id = ?
+	`, now, ipAddress, userAgent, session.ID); err != nil {
+		return fmt.Errorf("failed to update session: %w", err)
+	}
+	session.LastSeenAt = now
+	return nil
+}
+
+// DeleteSession deletes a token's session, e.g. at logout
+func (s *SessionService) DeleteSession(token string) error {
+	if _, err := s.DB.Exec(`DELETE FROM sessions WHERE token_hash = ?`, HashSessionToken(token)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// ListUserSessions returns the unexpired sessions of a user, most recently
+// used first, marking the session of currentToken (if any) as the current one
+func (s *SessionService) ListUserSessions(userID int64, currentToken string) ([]Session, error) {
+	rows, err := s.DB.Query(`
+		SELECT id, user_id, ip_address, user_agent, created_at, last_seen_at, expires_at,
+		       token_hash = ?
+		FROM sessions
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC, id DESC
+	`, HashSessionToken(currentToken), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.IPAddress, &session.UserAgent,
+			&session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &session.Current); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		if now.After(session.ExpiresAt) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// DeleteUserSession revokes one of the user's sessions, returning the hash of
+// its token
+func (s *SessionService) DeleteUserSession(userID, sessionID int64) (string, error) {
+	var tokenHash string
+	err := s.DB.QueryRow(`
+		DELETE FROM sessions WHERE id = ? AND user_id = ? RETURNING token_hash
+	`, sessionID, userID).Scan(&tokenHash)
+	if err == sql.ErrNoRows {
+		return "", ErrSessionNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete session: %w", err)
+	}
+	return tokenHash, nil
+}
+
+// DeleteUserSessions revokes the user's sessions except that of exceptToken
+// ("" for none), returning how many were revoked
+func (s *SessionService) DeleteUserSessions(userID int64, exceptToken string) (int64, error) {
+	result, err := s.DB.Exec(`
+		DELETE FROM sessions WHERE user_id = ? AND token_hash != ?
+	`, userID, HashSessionToken(exceptToken))
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// deleteUserSessions logs the user out everywhere, e.g. when they are
+// deactivated or their password changes
+func deleteUserSessions(database *db.DB, userID int64) error {
+	if _, err := database.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID); err != nil {
+		return fmt.Errorf("failed to delete sessions of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func nullableID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
@@ -0,0 +1,316 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ramborogers/cyberai/server/utils"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time
+const RecoveryCodeCount = 10
+
+const (
+	// MaxTwoFactorFailures is how many wrong codes in a row lock a user's
+	// second factor
+	MaxTwoFactorFailures = 5
+	// TwoFactorLockout is how long the second factor stays locked
+	TwoFactorLockout = 15 * time.Minute
+)
+
+// ErrTwoFactorEnabled is returned when enrolling a user who already has
+// two-factor authentication
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+
+// ErrTwoFactorNotEnabled is returned when verifying a code of a user without
+// two-factor authentication, or confirming an enrollment never started
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+// ErrInvalidTwoFactorCode is returned for a wrong, expired or reused code
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+// ErrTwoFactorLocked is returned while a user's second factor is locked after
+// too many wrong codes, without checking the code. The wrong code that locks
+// it returns both this and ErrInvalidTwoFactorCode.
+var ErrTwoFactorLocked = errors.New("too many invalid two-factor codes, try again later")
+
+// ErrTwoFactorRequired is returned when disabling two-factor authentication
+// for a user whose role requires it
+var ErrTwoFactorRequired = errors.New("two-factor authentication is required for your role")
+
+// TwoFactorStatus is a user's two-factor authentication settings
+type TwoFactorStatus struct {
+	Enabled                bool `json:"enabled"`
+	Required               bool `json:"required"` // By the user's role
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+// GetTwoFactorStatus returns the user's two-factor authentication settings
+func (s *UserService) GetTwoFactorStatus(userID int64) (*TwoFactorStatus, error) {
+	var status TwoFactorStatus
+	err := s.DB.QueryRow(`
+		SELECT EXISTS (SELECT 1 FROM user_two_factor WHERE user_id = u.id AND enabled),
+		       r.require_two_factor,
+		       (SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = u.id AND used_at IS NULL)
+		FROM users u JOIN roles r ON r.id = u.role_id
+		WHERE u.id = ?
+	`, userID).Scan(&status.Enabled, &status.Required, &status.RecoveryCodesRemaining)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user not found: %d", userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get two-factor status: %w", err)
+	}
+	return &status, nil
+}
+
+// StartTwoFactorEnrollment generates a new TOTP secret for the user, which
+// takes effect once they confirm a code with EnableTwoFactor
+func (s *UserService) StartTwoFactorEnrollment(userID int64) (string, error) {
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	// Replaces an unconfirmed secret, never an enabled one
+	result, err := s.DB.Exec(`
+		INSERT INTO user_two_factor (user_id, secret, enabled, created_at) VALUES (?, ?, FALSE, ?)
+		ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at, last_used_step = 0
+		WHERE NOT user_two_factor.enabled
+	`, userID, secret, time.Now())
+	if err != nil {
+		return "", fmt.Errorf("failed to start two-factor enrollment: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return "", ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor enables two-factor authentication once the user confirms a
+// code of the secret from StartTwoFactorEnrollment, and returns their
+// recovery codes
+func (s *UserService) EnableTwoFactor(userID int64, code string) ([]string, error) {
+	var codes []string
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		var secret string
+		var enabled bool
+		var lockedUntil sql.NullTime
+		err := tx.QueryRow(`
+			SELECT secret, enabled, locked_until FROM user_two_factor WHERE user_id = ?
+		`, userID).Scan(&secret, &enabled, &lockedUntil)
+		if err == sql.ErrNoRows {
+			return ErrTwoFactorNotEnabled
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get two-factor secret: %w", err)
+		}
+		if enabled {
+			return ErrTwoFactorEnabled
+		}
+		now := time.Now()
+		if isLocked(lockedUntil, now) {
+			return ErrTwoFactorLocked
+		}
+		step := utils.ValidateTOTP(secret, code, now)
+		if step == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		if _, err := tx.Exec(`
+			UPDATE user_two_factor SET enabled = TRUE, enabled_at = ?, last_used_step = ?, failed_attempts = 0
+			WHERE user_id = ?
+		`, now, step, userID); err != nil {
+			return fmt.Errorf("failed to enable two-factor authentication: %w", err)
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		return nil, s.twoFactorFailed(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifyTwoFactorCode checks a TOTP code of a user with two-factor
+// authentication. Each code is accepted once.
+func (s *UserService) VerifyTwoFactorCode(userID int64, code string) error {
+	var secret string
+	var lastUsedStep int64
+	var lockedUntil sql.NullTime
+	err := s.DB.QueryRow(`
+		SELECT secret, last_used_step, locked_until FROM user_two_factor WHERE user_id = ? AND enabled
+	`, userID).Scan(&secret, &lastUsedStep, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return ErrTwoFactorNotEnabled
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get two-factor secret: %w", err)
+	}
+
+	now := time.Now()
+	if isLocked(lockedUntil, now) {
+		return ErrTwoFactorLocked
+	}
+	step := utils.ValidateTOTP(secret, code, now)
+	if step == 0 || step <= lastUsedStep {
+		return s.twoFactorFailed(userID)
+	}
+	// Only one of concurrent requests with the same code gets through
+	result, err := s.DB.Exec(`
+		UPDATE user_two_factor SET last_used_step = ?, failed_attempts = 0 WHERE user_id = ? AND last_used_step < ?
+	`, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("failed to record two-factor code: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// UseRecoveryCode checks a recovery code of a user with two-factor
+// authentication and uses it up, returning how many remain
+func (s *UserService) UseRecoveryCode(userID int64, code string) (int, error) {
+	var remaining int
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		var enabled bool
+		var lockedUntil sql.NullTime
+		err := tx.QueryRow(`
+			SELECT enabled, locked_until FROM user_two_factor WHERE user_id = ?
+		`, userID).Scan(&enabled, &lockedUntil)
+		if err == sql.ErrNoRows || (err == nil && !enabled) {
+			return ErrTwoFactorNotEnabled
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get two-factor status: %w", err)
+		}
+		now := time.Now()
+		if isLocked(lockedUntil, now) {
+			return ErrTwoFactorLocked
+		}
+		result, err := tx.Exec(`
+			UPDATE user_recovery_codes SET used_at = ? WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+		`, now, userID, hashRecoveryCode(code))
+		if err != nil {
+			return fmt.Errorf("failed to use recovery code: %w", err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		if _, err := tx.Exec(`UPDATE user_two_factor SET failed_attempts = 0 WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to reset failed two-factor codes: %w", err)
+		}
+		return tx.QueryRow(`
+			SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL
+		`, userID).Scan(&remaining)
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		return 0, s.twoFactorFailed(userID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return remaining, nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with
+// two-factor authentication
+func (s *UserService) RegenerateRecoveryCodes(userID int64) ([]string, error) {
+	var codes []string
+	err := s.DB.Transaction(func(tx *sql.Tx) error {
+		var enabled bool
+		err := tx.QueryRow(`SELECT enabled FROM user_two_factor WHERE user_id = ?`, userID).Scan(&enabled)
+		if err == sql.ErrNoRows || (err == nil && !enabled) {
+			return ErrTwoFactorNotEnabled
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get two-factor status: %w", err)
+		}
+		codes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes the user's TOTP secret and recovery codes
+func (s *UserService) DisableTwoFactor(userID int64) error {
+	return s.DB.Transaction(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM user_two_factor WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// isLocked reports whether a second factor locked until lockedUntil is locked at now
+func isLocked(lockedUntil sql.NullTime, now time.Time) bool {
+	return lockedUntil.Valid && now.Before(lockedUntil.Time)
+}
+
+// twoFactorFailed counts a wrong code of the user, locking their second
+// factor for TwoFactorLockout after MaxTwoFactorFailures in a row. It returns
+// ErrInvalidTwoFactorCode, also wrapping ErrTwoFactorLocked if the code
+// locked it.
+func (s *UserService) twoFactorFailed(userID int64) error {
+	var failures int
+	err := s.DB.QueryRow(`
+		UPDATE user_two_factor SET failed_attempts = failed_attempts + 1 WHERE user_id = ?
+		RETURNING failed_attempts
+	`, userID).Scan(&failures)
+	if err != nil {
+		return fmt.Errorf("failed to count failed two-factor code: %w", err)
+	}
+	if failures < MaxTwoFactorFailures {
+		return ErrInvalidTwoFactorCode
+	}
+	if _, err := s.DB.Exec(`
+		UPDATE user_two_factor SET failed_attempts = 0, locked_until = ? WHERE user_id = ?
+	`, time.Now().Add(TwoFactorLockout), userID); err != nil {
+		return fmt.Errorf("failed to lock two-factor authentication: %w", err)
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidTwoFactorCode, ErrTwoFactorLocked)
+}
+
+// replaceRecoveryCodes generates new recovery codes for the user, replacing
+// the old ones, and returns them. Only their hashes are stored.
+func replaceRecoveryCodes(tx *sql.Tx, userID int64) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM user_recovery_codes WHERE user_id = ?`, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	now := time.Now()
+	codes := make([]string, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO user_recovery_codes (user_id, code_hash, created_at) VALUES (?, ?, ?)
+		`, userID, hashRecoveryCode(code), now); err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode hashes a recovery code as typed: case, spaces and dashes
+// do not matter. The codes are random enough not to need a slow hash.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
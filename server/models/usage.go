@@ -0,0 +1,82 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// UsageSummary is the token usage of one user with one model
+type UsageSummary struct {
+	UserID           int64  `json:"user_id"`
+	Username         string `json:"username"`
+	ModelID          int64  `json:"model_id"`
+	ModelName        string `json:"model_name"`
+	Responses        int    `json:"responses"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	TotalTokens      int    `json:"total_tokens"`
+}
+
+// usageTimeLayout is how SQLite's CURRENT_TIMESTAMP (UTC) stores the time
+// usage was recorded
+const usageTimeLayout = "2006-01-02 15:04:05"
+
+// UsageFilter narrows a usage summary; zero values match everything
+type UsageFilter struct {
+	UserID int64
+	Since  time.Time
+	Until  time.Time
+}
+
+// GetUsageSummary returns the recorded token usage per user and model, the
+// largest first
+func (s *ChatService) GetUsageSummary(filter UsageFilter) ([]UsageSummary, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.UserID > 0 {
+		conditions = append(conditions, "s.user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "s.created_at >= ?")
+		args = append(args, filter.Since.UTC().Format(usageTimeLayout))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "s.created_at < ?")
+		args = append(args, filter.Until.UTC().Format(usageTimeLayout))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	rows, err := s.DB.Query(`
+		SELECT s.user_id, COALESCE(u.username, ''), s.model_id, COALESCE(m.name, ''),
+		       COUNT(*), SUM(s.prompt_tokens), SUM(s.completion_tokens), SUM(s.total_tokens)
+		FROM usage_statistics s
+		LEFT JOIN users u ON u.id = s.user_id
+		LEFT JOIN models m ON m.id = s.model_id
+		`+where+`
+		GROUP BY s.user_id, s.model_id
+		ORDER BY SUM(s.total_tokens) DESC, s.user_id, s.model_id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []UsageSummary{}
+	for rows.Next() {
+		var u UsageSummary
+		if err := rows.Scan(&u.UserID, &u.Username, &u.ModelID, &u.ModelName,
+			&u.Responses, &u.PromptTokens, &u.CompletionTokens, &u.TotalTokens); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		summaries = append(summaries, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage: %w", err)
+	}
+	return summaries, nil
+}
@@ -13,33 +13,68 @@ import (
 
 // Role represents a user role for permission management
 type Role struct {
-	ID          int64     `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description,omitempty"`
-	Permissions string    `json:"permissions,omitempty"` // JSON string
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int64       `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Permissions Permissions `json:"permissions"` // Stored as a JSON object
+	// RequireTwoFactor makes users of the role enroll in two-factor
+	// authentication before they can log in with a password
+	RequireTwoFactor bool      `json:"require_two_factor"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 // User represents a user in the system
 type User struct {
-	ID           int64      `json:"id"`
-	Username     string     `json:"username"`
-	Email        string     `json:"email"`
-	FirstName    string     `json:"first_name,omitempty"`
-	LastName     string     `json:"last_name,omitempty"`
-	PasswordHash string     `json:"-"` // Don't expose in JSON
-	RoleID       int64      `json:"role_id"`
-	Role         *Role      `json:"role,omitempty"`
-	IsActive     bool       `json:"is_active"`
-	LastLogin    *time.Time `json:"last_login,omitempty"` // Use pointer for nullable field
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID           int64  `json:"id"`
+	Username     string `json:"username"`
+	Email        string `json:"email"`
+	FirstName    string `json:"first_name,omitempty"`
+	LastName     string `json:"last_name,omitempty"`
+	PasswordHash string `json:"-"` // Don't expose in JSON
+	RoleID       int64  `json:"role_id"`
+	Role         *Role  `json:"role,omitempty"`
+	IsActive     bool   `json:"is_active"`
+	// TwoFactorEnabled is set by GetUserByID and GetAllUsers
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	LastLogin        *time.Time `json:"last_login,omitempty"` // Use pointer for nullable field
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
 }
 
 // UserService handles user-related operations
 type UserService struct {
 	DB *db.DB
+	// Directory, if set, is asked first by Authenticate, with local accounts
+	// as the fallback
+	Directory *DirectoryLogin
+}
+
+// ErrInvalidCredentials is returned when a username and password do not match
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// PasswordBackend checks usernames and passwords against an external
+// directory, such as LDAP
+type PasswordBackend interface {
+	// Issuer identifies the directory in the identities it returns
+	Issuer() string
+	// AuthenticatePassword returns the user's identity if the directory
+	// accepts the password, an error wrapping ErrInvalidCredentials if it
+	// rejects it, and another error if the directory cannot be asked
+	AuthenticatePassword(username, password string) (*ExternalIdentity, error)
+}
+
+// DirectoryLogin configures how Authenticate logs users in through a
+// PasswordBackend. Directory users are created at their first login, or
+// linked to the local user with the same username if that user has no
+// password and no admin role, and their email and name follow the directory.
+type DirectoryLogin struct {
+	Backend      PasswordBackend
+	RoleMappings []RoleMapping // If set, roles follow the directory's groups at every login
+	DefaultRole  string        // Role when no mapping matches
+	// BreakGlassUsername is a user who always logs in with their local
+	// password, without asking the directory, and whose role is never synced
+	BreakGlassUsername string
 }
 
 // NewUserService creates a new UserService
@@ -47,8 +82,21 @@ func NewUserService(database *db.DB) *UserService {
 	return &UserService{DB: database}
 }
 
-// Authenticate validates a username and password, returning the user if valid
+// Authenticate validates a username and password, returning the user if
+// valid. With a Directory, the directory is asked first (except for the
+// break-glass user); local passwords are the fallback, except for users
+// linked to the directory.
 func (s *UserService) Authenticate(username, password string) (*User, error) {
+	if s.Directory != nil && username != s.Directory.BreakGlassUsername {
+		user, err := s.authenticateDirectory(username, password)
+		if err == nil || errors.Is(err, ErrAccountInactive) {
+			return user, err
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Printf("Directory login failed for user '%s', trying the local account: %v", username, err)
+		}
+	}
+
 	var user User
 	var passwordHash string
 	var firstName, lastName sql.NullString
@@ -68,19 +116,33 @@ func (s *UserService) Authenticate(username, password string) (*User, error) {
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("invalid username or password")
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("database error: %w", err)
 	}
 
 	// Check if the user is active
 	if !user.IsActive {
-		return nil, errors.New("account is inactive")
+		return nil, ErrAccountInactive
+	}
+
+	// Users of the directory log in with their directory password, so that
+	// disabling them there takes effect here
+	if s.Directory != nil && username != s.Directory.BreakGlassUsername {
+		var linked bool
+		if err := s.DB.QueryRow(`
+			SELECT EXISTS (SELECT 1 FROM user_identities WHERE user_id = ? AND issuer = ?)
+		`, user.ID, s.Directory.Backend.Issuer()).Scan(&linked); err != nil {
+			return nil, fmt.Errorf("database error: %w", err)
+		}
+		if linked {
+			return nil, ErrInvalidCredentials
+		}
 	}
 
 	// Verify password
 	if !utils.CheckPassword(password, passwordHash) {
-		return nil, errors.New("invalid username or password")
+		return nil, ErrInvalidCredentials
 	}
 
 	// Handle nullable fields
@@ -115,6 +177,27 @@ func (s *UserService) Authenticate(username, password string) (*User, error) {
 	return &user, nil
 }
 
+// authenticateDirectory logs the user in through the directory, creating or
+// updating their local user
+func (s *UserService) authenticateDirectory(username, password string) (*User, error) {
+	identity, err := s.Directory.Backend.AuthenticatePassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+	roleID, err := s.RoleIDForGroups(s.Directory.RoleMappings, identity.Groups, s.Directory.DefaultRole)
+	if err != nil {
+		return nil, err
+	}
+	return s.LoginExternal(*identity, ExternalLoginOptions{
+		Provision:     true,
+		RoleID:        roleID,
+		SyncRole:      len(s.Directory.RoleMappings) > 0,
+		KeepRoleOf:    s.Directory.BreakGlassUsername,
+		LinkUsername:  true,
+		UpdateProfile: true,
+	})
+}
+
 // CreateUser creates a new user
 func (s *UserService) CreateUser(user *User, password string) error {
 	// Hash the password
@@ -165,12 +248,14 @@ func (s *UserService) GetUserByID(userID int64) (*User, error) {
 
 	err := s.DB.QueryRow(`
 		SELECT u.id, u.username, u.email, u.first_name, u.last_name,
-		       u.role_id, u.is_active, u.last_login, u.created_at, u.updated_at
+		       u.role_id, u.is_active, u.last_login, u.created_at, u.updated_at,
+		       EXISTS (SELECT 1 FROM user_two_factor t WHERE t.user_id = u.id AND t.enabled)
 		FROM users u
 		WHERE u.id = ?
 	`, userID).Scan(
 		&user.ID, &user.Username, &user.Email, &firstName, &lastName,
 		&user.RoleID, &user.IsActive, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
+		&user.TwoFactorEnabled,
 	)
 
 	if err != nil {
@@ -289,7 +374,8 @@ func (s *UserService) GetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// UpdateUser updates an existing user
+// UpdateUser updates an existing user. Deactivating a user ends their
+// sessions.
 func (s *UserService) UpdateUser(user *User) error {
 	_, err := s.DB.Exec(`
 		UPDATE users
@@ -305,10 +391,13 @@ func (s *UserService) UpdateUser(user *User) error {
 		return fmt.Errorf("failed to update user: %w", err)
 	}
 
+	if !user.IsActive {
+		return deleteUserSessions(s.DB, user.ID)
+	}
 	return nil
 }
 
-// ChangePassword changes a user's password
+// ChangePassword changes a user's password, ending their sessions
 func (s *UserService) ChangePassword(userID int64, oldPassword, newPassword string) error {
 	var currentHash string
 
@@ -343,10 +432,11 @@ func (s *UserService) ChangePassword(userID int64, oldPassword, newPassword stri
 		return fmt.Errorf("failed to update password: %w", err)
 	}
 
-	return nil
+	return deleteUserSessions(s.DB, userID)
 }
 
-// ResetPassword generates a new random password for a user
+// ResetPassword generates a new random password for a user, ending their
+// sessions
 func (s *UserService) ResetPassword(userID int64) (string, error) {
 	// Generate a new random password
 	newPassword, err := utils.GenerateRandomPassword(12)
@@ -371,6 +461,9 @@ func (s *UserService) ResetPassword(userID int64) (string, error) {
 		return "", fmt.Errorf("failed to reset password: %w", err)
 	}
 
+	if err := deleteUserSessions(s.DB, userID); err != nil {
+		return "", err
+	}
 	return newPassword, nil
 }
 
@@ -381,7 +474,8 @@ func (s *UserService) GetAllUsers(activeOnly bool) ([]User, error) {
 
 	query = `
 		SELECT u.id, u.username, u.email, u.first_name, u.last_name,
-		       u.role_id, u.is_active, u.last_login, u.created_at, u.updated_at
+		       u.role_id, u.is_active, u.last_login, u.created_at, u.updated_at,
+		       EXISTS (SELECT 1 FROM user_two_factor t WHERE t.user_id = u.id AND t.enabled)
 		FROM users u
 	`
 
@@ -406,6 +500,7 @@ func (s *UserService) GetAllUsers(activeOnly bool) ([]User, error) {
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.Email, &firstName, &lastName,
 			&user.RoleID, &user.IsActive, &lastLogin, &user.CreatedAt, &user.UpdatedAt,
+			&user.TwoFactorEnabled,
 		)
 
 		if err != nil {
@@ -445,17 +540,17 @@ func (s *UserService) GetRole(roleID int64) (*Role, error) {
 	var role Role
 
 	err := s.DB.QueryRow(`
-		SELECT id, name, description, permissions, created_at, updated_at
+		SELECT id, name, COALESCE(description, ''), permissions, require_two_factor, created_at, updated_at
 		FROM roles
 		WHERE id = ?
 	`, roleID).Scan(
-		&role.ID, &role.Name, &role.Description, &role.Permissions,
+		&role.ID, &role.Name, &role.Description, &role.Permissions, &role.RequireTwoFactor,
 		&role.CreatedAt, &role.UpdatedAt,
 	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("role not found: %d", roleID)
+			return nil, fmt.Errorf("%w: %d", ErrRoleNotFound, roleID)
 		}
 		return nil, fmt.Errorf("database error: %w", err)
 	}
@@ -466,7 +561,7 @@ func (s *UserService) GetRole(roleID int64) (*Role, error) {
 // GetAllRoles retrieves all roles
 func (s *UserService) GetAllRoles() ([]Role, error) {
 	rows, err := s.DB.Query(`
-		SELECT id, name, description, permissions, created_at, updated_at
+		SELECT id, name, COALESCE(description, ''), permissions, require_two_factor, created_at, updated_at
 		FROM roles
 		ORDER BY id ASC
 	`)
@@ -480,7 +575,7 @@ func (s *UserService) GetAllRoles() ([]Role, error) {
 	for rows.Next() {
 		var role Role
 		if err := rows.Scan(
-			&role.ID, &role.Name, &role.Description, &role.Permissions,
+			&role.ID, &role.Name, &role.Description, &role.Permissions, &role.RequireTwoFactor,
 			&role.CreatedAt, &role.UpdatedAt,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan role: %w", err)
@@ -571,6 +666,7 @@ func (s *UserService) GetUserRole(userID int64) (string, error) {
 }
 
 // SetUserPassword forcefully sets a new password for a given user ID (admin action).
+// The user's sessions end.
 func (s *UserService) SetUserPassword(userID int64, newPassword string) error {
 	// Validate password strength (basic length check)
 	if len(newPassword) < 8 {
@@ -607,5 +703,5 @@ func (s *UserService) SetUserPassword(userID int64, newPassword string) error {
 	}
 
 	log.Printf("Password hash updated successfully for user ID: %d", userID)
-	return nil
+	return deleteUserSessions(s.DB, userID)
 }
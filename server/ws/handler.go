@@ -34,9 +34,12 @@ type MessagePayload struct {
 	UserID     int64     `json:"user_id"`
 	Role       string    `json:"role"` // "user", "assistant", "system"
 	Content    string    `json:"content"`
+	Reasoning  string    `json:"reasoning,omitempty"` // Model's thinking, shown separately from content
 	ModelID    *int64    `json:"model_id,omitempty"`
 	AgentID    *int64    `json:"agent_id,omitempty"`
 	TokensUsed int       `json:"tokens_used,omitempty"`
+	ParentID   *int64    `json:"parent_id,omitempty"`
+	SiblingIDs []int64   `json:"sibling_ids,omitempty"` // Alternatives to this message, including itself
 	CreatedAt  time.Time `json:"created_at"`
 }
 
@@ -80,12 +83,26 @@ const (
 	MsgTypeSystem           = "system"
 	MsgTypeUserMessage      = "user_message"      // Confirms user message saved, provides ID
 	MsgTypeAssistantChunk   = "assistant_chunk"   // Streamed chunk of assistant response
+	MsgTypeReasoningChunk   = "reasoning_chunk"   // Streamed chunk of the model's reasoning (uses chunk_payload)
 	MsgTypeAssistantMessage = "assistant_message" // Complete assistant message (after streaming/saving)
 	MsgTypeRemoveMessage    = "remove_message"    // Request to remove a message (e.g., during regen)
-	MsgTypeModelList        = "model_list"        // Send updated model list (if needed dynamically)
-	MsgTypeChatList         = "chat_list"         // Send updated chat list (if needed dynamically)
+	MsgTypeContextTruncated = "context_truncated" // Older messages were left out of the model's context (uses data)
+	MsgTypeModelList        = "model_list"        // The models the user can now use, after an admin change (uses model_list_payload)
+	MsgTypeChatList         = "chat_list"         // Chats created or changed (chat_list_payload) or removed (data.removed_chat_ids)
 )
 
+// Control messages sent by clients over the WebSocket (as {"type": ..., "chat_id": ...})
+const (
+	ClientMsgSubscribe   = "subscribe"   // Receive live updates of a chat the user can access
+	ClientMsgUnsubscribe = "unsubscribe" // Stop receiving updates of a chat
+)
+
+// clientMessage is a control message from a client
+type clientMessage struct {
+	Type   string `json:"type"`
+	ChatID int64  `json:"chat_id"`
+}
+
 // Base Message structure for WebSocket communication
 type Message struct {
 	Type      string    `json:"type"` // Message type (e.g., "error", "assistant_chunk")
@@ -129,6 +146,7 @@ type ContentPayload struct {
 type ChunkPayload struct {
 	ChatID    int64  `json:"chat_id"`
 	MessageID *int64 `json:"message_id,omitempty"` // ID of the assistant message being generated (sent once?)
+	ParentID  *int64 `json:"parent_id,omitempty"`  // Message being answered; side-by-side responses share it
 	ModelID   *int64 `json:"model_id,omitempty"`   // ID of the model generating the response
 	Content   string `json:"content"`              // The chunk of text
 	IsFinal   bool   `json:"is_final,omitempty"`   // Flag if this is the last chunk (optional)
@@ -147,6 +165,11 @@ type Client struct {
 	send chan Message
 	// User ID associated with this client connection
 	userID int64
+	// Login session the client connected with, identified by the hash of its
+	// token (see models.HashSessionToken)
+	session string
+	// Chats this client is subscribed to (guarded by the hub's mutex)
+	chats map[int64]bool
 }
 
 // Hub manages client connections and message routing.
@@ -154,6 +177,15 @@ type Hub struct {
 	// Map of User ID -> Set of clients for that user
 	clientsByUserID map[int64]map[*Client]bool
 
+	// Map of Chat ID -> Set of clients subscribed to that chat
+	clientsByChatID map[int64]map[*Client]bool
+
+	// Subscription changes from clients, and participants losing access
+	subscriptions chan subscription
+
+	// canAccessChat decides whether a user may subscribe to a chat
+	canAccessChat func(userID, chatID int64) bool
+
 	// DEPRECATED: Global broadcast channel (use SendToUser or implement chat-specific channels)
 	// broadcast chan Message
 
@@ -166,16 +198,45 @@ type Hub struct {
 	// Unregister requests from clients
 	unregister chan *Client
 
+	// Clients to be disconnected, e.g. after their user is logged out
+	// everywhere
+	disconnect chan disconnection
+
 	// Mutex for concurrent access to clientsByUserID map
 	mu sync.RWMutex // Use RWMutex for better read performance
 }
 
-// TargetedMessage wraps a Message with the target User ID.
+// TargetedMessage wraps a Message with its recipients: every client of
+// UserID and every client subscribed to ChatID (each client once). Either
+// may be 0.
 type TargetedMessage struct {
 	UserID  int64
+	ChatID  int64
 	Message Message
 }
 
+// disconnection selects clients of a user to disconnect: all of them, those
+// of one session, or all but those of one session
+type disconnection struct {
+	userID  int64
+	session string // "" for every session
+	except  bool   // Keep the clients of session, disconnecting the others
+}
+
+// matches reports whether the client is to be disconnected
+func (d disconnection) matches(client *Client) bool {
+	return d.session == "" || (client.session == d.session) != d.except
+}
+
+// subscription adds or removes chat subscriptions: of one client, or of
+// every client of a user if client is nil
+type subscription struct {
+	client    *Client
+	userID    int64
+	chatID    int64
+	subscribe bool
+}
+
 // NewHub creates a new hub
 func NewHub() *Hub {
 	return &Hub{
@@ -183,10 +244,37 @@ func NewHub() *Hub {
 		sendToUser:      make(chan TargetedMessage, 256), // Buffered channel
 		register:        make(chan *Client),
 		unregister:      make(chan *Client),
+		disconnect:      make(chan disconnection, 16),
 		clientsByUserID: make(map[int64]map[*Client]bool),
+		clientsByChatID: make(map[int64]map[*Client]bool),
+		subscriptions:   make(chan subscription, 64),
 	}
 }
 
+// SetChatAuthorizer sets the check run when a client subscribes to a chat.
+// Without one, clients cannot subscribe to chats. Must be called before the
+// server accepts WebSocket connections.
+func (h *Hub) SetChatAuthorizer(canAccessChat func(userID, chatID int64) bool) {
+	h.canAccessChat = canAccessChat
+}
+
+// SendToChat queues a message for every client subscribed to the chat, and
+// every client of userID (0 for none) whether subscribed or not, so the user
+// who caused it always hears back.
+func (h *Hub) SendToChat(chatID, userID int64, message Message) {
+	select {
+	case h.sendToUser <- TargetedMessage{UserID: userID, ChatID: chatID, Message: message}:
+	default:
+		log.Printf("Warning: sendToUser channel full for chat %d. Message dropped: %s", chatID, message.Type)
+	}
+}
+
+// UnsubscribeUser removes every subscription of the user's clients to the
+// chat, e.g. after they lose access to it.
+func (h *Hub) UnsubscribeUser(chatID, userID int64) {
+	h.subscriptions <- subscription{userID: userID, chatID: chatID}
+}
+
 // SendToUser queues a message to be sent to all clients associated with a specific user ID.
 func (h *Hub) SendToUser(userID int64, message interface{}) {
 	// Convert interface{} to Message type if needed
@@ -219,6 +307,35 @@ func (h *Hub) SendToUser(userID int64, message interface{}) {
 	}
 }
 
+// DisconnectUser closes every connection of the user, whose sessions ended.
+// Their pages reconnect, which fails without a session.
+func (h *Hub) DisconnectUser(userID int64) {
+	h.disconnect <- disconnection{userID: userID}
+}
+
+// DisconnectSession closes the connections of one of the user's sessions,
+// which was revoked
+func (h *Hub) DisconnectSession(userID int64, session string) {
+	h.disconnect <- disconnection{userID: userID, session: session}
+}
+
+// DisconnectOtherSessions closes the connections of the user's sessions but
+// one, after the others were revoked
+func (h *Hub) DisconnectOtherSessions(userID int64, session string) {
+	h.disconnect <- disconnection{userID: userID, session: session, except: true}
+}
+
+// ConnectedUserIDs returns the IDs of the users with at least one connected client.
+func (h *Hub) ConnectedUserIDs() []int64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	userIDs := make([]int64, 0, len(h.clientsByUserID))
+	for userID := range h.clientsByUserID {
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
 // Run starts the hub's main processing loop.
 func (h *Hub) Run() {
 	log.Println("WebSocket Hub started.")
@@ -239,6 +356,9 @@ func (h *Hub) Run() {
 			if userClients, ok := h.clientsByUserID[client.userID]; ok {
 				if _, clientExists := userClients[client]; clientExists {
 					delete(userClients, client)
+					for chatID := range client.chats {
+						h.removeChatClient(chatID, client)
+					}
 					close(client.send) // Close the client's send channel
 					log.Printf("Client send channel closed (User ID: %d)", client.userID)
 
@@ -252,22 +372,56 @@ func (h *Hub) Run() {
 			h.mu.Unlock()
 			log.Printf("Client disconnected (User ID: %d). Remaining clients for user: %d", client.userID, len(h.clientsByUserID[client.userID]))
 
+		case d := <-h.disconnect:
+			h.mu.Lock()
+			userClients := h.clientsByUserID[d.userID]
+			disconnected := 0
+			for client := range userClients {
+				if !d.matches(client) {
+					continue
+				}
+				for chatID := range client.chats {
+					h.removeChatClient(chatID, client)
+				}
+				delete(userClients, client)
+				close(client.send) // writePump closes the connection
+				disconnected++
+			}
+			if len(userClients) == 0 {
+				delete(h.clientsByUserID, d.userID)
+			}
+			h.mu.Unlock()
+			log.Printf("Disconnected %d clients of User ID %d", disconnected, d.userID)
+
+		case sub := <-h.subscriptions:
+			h.mu.Lock()
+			if sub.client != nil {
+				// Ignore clients that disconnected in the meantime
+				if _, connected := h.clientsByUserID[sub.client.userID][sub.client]; connected {
+					if sub.subscribe {
+						h.addChatClient(sub.chatID, sub.client)
+					} else {
+						h.removeChatClient(sub.chatID, sub.client)
+					}
+				}
+			} else {
+				for client := range h.clientsByUserID[sub.userID] {
+					h.removeChatClient(sub.chatID, client)
+				}
+			}
+			h.mu.Unlock()
+
 		case targetedMsg := <-h.sendToUser:
 			h.mu.RLock() // Lock for reading
-			if userClients, ok := h.clientsByUserID[targetedMsg.UserID]; ok {
-				// Send to all clients registered for this user ID
-				// log.Printf("Sending message type '%s' to user %d (%d clients)", targetedMsg.Message.Type, targetedMsg.UserID, len(userClients)) // Commented out to reduce log noise
-				for client := range userClients {
-					select {
-					case client.send <- targetedMsg.Message:
-						// Message successfully queued for this client
-					default:
-						// Should not happen often with buffered channel, but log if it does
-						log.Printf("Warning: Client send channel full for user %d. Dropping message type '%s' for one client.", targetedMsg.UserID, targetedMsg.Message.Type)
-						// Optionally unregister the client here if their channel is consistently full?
-						// close(client.send)
-						// delete(userClients, client)
-					}
+			// Send to all clients registered for this user ID
+			// log.Printf("Sending message type '%s' to user %d (%d clients)", targetedMsg.Message.Type, targetedMsg.UserID, len(userClients)) // Commented out to reduce log noise
+			for client := range h.clientsByUserID[targetedMsg.UserID] {
+				client.queue(targetedMsg.Message)
+			}
+			// And to the chat's subscribers, skipping clients already sent it
+			for client := range h.clientsByChatID[targetedMsg.ChatID] {
+				if client.userID != targetedMsg.UserID {
+					client.queue(targetedMsg.Message)
 				}
 			}
 			h.mu.RUnlock()
@@ -290,8 +444,41 @@ func (h *Hub) Run() {
 	}
 }
 
-// ServeWS handles WebSocket requests from clients, performing authentication first.
-func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
+// addChatClient subscribes a client to a chat. The caller holds h.mu.
+func (h *Hub) addChatClient(chatID int64, client *Client) {
+	if _, ok := h.clientsByChatID[chatID]; !ok {
+		h.clientsByChatID[chatID] = make(map[*Client]bool)
+	}
+	h.clientsByChatID[chatID][client] = true
+	client.chats[chatID] = true
+}
+
+// removeChatClient unsubscribes a client from a chat. The caller holds h.mu.
+func (h *Hub) removeChatClient(chatID int64, client *Client) {
+	if chatClients, ok := h.clientsByChatID[chatID]; ok {
+		delete(chatClients, client)
+		if len(chatClients) == 0 {
+			delete(h.clientsByChatID, chatID)
+		}
+	}
+	delete(client.chats, chatID)
+}
+
+// queue queues a message for the client without blocking the hub
+func (c *Client) queue(message Message) {
+	select {
+	case c.send <- message:
+		// Message successfully queued for this client
+	default:
+		// Should not happen often with buffered channel, but log if it does
+		log.Printf("Warning: Client send channel full for user %d. Dropping message type '%s' for one client.", c.userID, message.Type)
+	}
+}
+
+// ServeWS handles WebSocket requests from clients, performing authentication
+// first. session identifies the client's login session, so that revoking it
+// closes the connection (see Hub.DisconnectSession).
+func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request, session string) {
 	// --- Authentication Check ---
 	userID := middleware.GetUserIDFromContext(r.Context())
 	if userID == 0 {
@@ -310,12 +497,13 @@ func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 
 	// Create new client
 	client := &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan Message, 256),
-		userID: int64(userID),
+		hub:     hub,
+		conn:    conn,
+		send:    make(chan Message, 256),
+		userID:  int64(userID),
+		session: session,
+		chats:   make(map[int64]bool),
 	}
-	client.hub.register <- client
 
 	// Send welcome message
 	welcomeMsg := Message{
@@ -325,7 +513,9 @@ func ServeWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 			Content: fmt.Sprintf("Connected to CyberAI chat server (User ID: %d)", userID),
 		},
 	}
-	client.send <- welcomeMsg // Send directly to client's channel, hub not needed for initial message
+	// Queued directly before registering, once the hub may close the channel
+	client.send <- welcomeMsg
+	client.hub.register <- client
 
 	// Start goroutines for reading and writing
 	go client.readPump()
@@ -362,17 +552,14 @@ func (c *Client) readPump() {
 		// Handle different message types
 		switch messageType {
 		case websocket.TextMessage:
-			// We generally expect the client to send commands/messages via HTTP POST,
-			// not raw WebSocket messages, unless a specific protocol is defined.
-			log.Printf("Received unexpected WebSocket text message from User ID %d: %s", c.userID, string(messageBytes))
-			// Optionally parse and handle specific control messages if needed in the future
-			// var msg Message
-			// if err := json.Unmarshal(messageBytes, &msg); err == nil {
-			// 	 switch msg.Type {
-			// 	 case "ping_custom": // Example
-			// 		 // Handle custom ping
-			// 	 }
-			// }
+			// Chat messages are sent via HTTP POST; the socket only carries
+			// control messages such as chat subscriptions.
+			var msg clientMessage
+			if err := json.Unmarshal(messageBytes, &msg); err != nil {
+				log.Printf("Received unexpected WebSocket text message from User ID %d: %s", c.userID, string(messageBytes))
+				continue
+			}
+			c.handleControlMessage(msg)
 
 		case websocket.BinaryMessage:
 			log.Printf("Received unexpected WebSocket binary message from User ID %d", c.userID)
@@ -396,6 +583,28 @@ func (c *Client) readPump() {
 	}
 }
 
+// handleControlMessage applies a control message from the client
+func (c *Client) handleControlMessage(msg clientMessage) {
+	switch msg.Type {
+	case ClientMsgSubscribe:
+		if c.hub.canAccessChat == nil || !c.hub.canAccessChat(c.userID, msg.ChatID) {
+			log.Printf("WebSocket: User ID %d denied subscription to chat %d", c.userID, msg.ChatID)
+			chatID := msg.ChatID
+			c.hub.SendToUser(c.userID, Message{
+				Type:         MsgTypeError,
+				Timestamp:    time.Now(),
+				ErrorPayload: &ErrorPayload{Message: "You do not have access to this chat", Code: http.StatusForbidden, ChatID: &chatID},
+			})
+			return
+		}
+		c.hub.subscriptions <- subscription{client: c, chatID: msg.ChatID, subscribe: true}
+	case ClientMsgUnsubscribe:
+		c.hub.subscriptions <- subscription{client: c, chatID: msg.ChatID}
+	default:
+		log.Printf("Received unknown WebSocket control message type %q from User ID %d", msg.Type, c.userID)
+	}
+}
+
 // writePump pumps messages from the client's send channel to the WebSocket connection.
 func (c *Client) writePump() {
 	ticker := time.NewTicker(pingPeriod)
@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+// connect registers a client of the user's session with the hub
+func connect(h *Hub, userID int64, session string) *Client {
+	client := &Client{hub: h, send: make(chan Message, 1), userID: userID, session: session, chats: make(map[int64]bool)}
+	h.register <- client
+	return client
+}
+
+// closed reports whether the hub closed the client's send channel, which
+// closes its connection
+func closed(client *Client) bool {
+	select {
+	case _, ok := <-client.send:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestHubDisconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		disconnect func(h *Hub)
+		closed     map[string]bool // By session of user 1
+	}{
+		{
+			name:       "user",
+			disconnect: func(h *Hub) { h.DisconnectUser(1) },
+			closed:     map[string]bool{"a": true, "b": true},
+		},
+		{
+			name:       "session",
+			disconnect: func(h *Hub) { h.DisconnectSession(1, "a") },
+			closed:     map[string]bool{"a": true, "b": false},
+		},
+		{
+			name:       "other sessions",
+			disconnect: func(h *Hub) { h.DisconnectOtherSessions(1, "a") },
+			closed:     map[string]bool{"a": false, "b": true},
+		},
+		{
+			name:       "unknown session",
+			disconnect: func(h *Hub) { h.DisconnectSession(1, "c") },
+			closed:     map[string]bool{"a": false, "b": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHub()
+			go h.Run()
+
+			clients := map[string][]*Client{
+				"a": {connect(h, 1, "a"), connect(h, 1, "a")},
+				"b": {connect(h, 1, "b")},
+			}
+			other := connect(h, 2, "a") // Another user's session with the same hash
+			tt.disconnect(h)
+			// Once the hub took the request, registering another client waits
+			// until it is done with it
+			for deadline := time.Now().Add(time.Second); len(h.disconnect) > 0; {
+				if time.Now().After(deadline) {
+					t.Fatal("the hub did not take the disconnection")
+				}
+				time.Sleep(time.Millisecond)
+			}
+			connect(h, 3, "d")
+
+			for session, sessionClients := range clients {
+				for _, client := range sessionClients {
+					if got := closed(client); got != tt.closed[session] {
+						t.Errorf("client of session %s closed = %v, want %v", session, got, tt.closed[session])
+					}
+				}
+			}
+			if closed(other) {
+				t.Error("closed a client of another user")
+			}
+
+			// The user stays connected while a client is left
+			wantConnected := !tt.closed["a"] || !tt.closed["b"]
+			connected := false
+			for _, userID := range h.ConnectedUserIDs() {
+				connected = connected || userID == 1
+			}
+			if connected != wantConnected {
+				t.Errorf("user connected = %v, want %v", connected, wantConnected)
+			}
+		})
+	}
+}
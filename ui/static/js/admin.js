@@ -85,6 +85,12 @@ document.addEventListener('DOMContentLoaded', function() {
 
     // DOM Elements - Roles Tab
     const roleList = document.getElementById('role-list');
+    const addRoleBtn = document.getElementById('add-role-btn');
+
+    // DOM Elements - Audit Log Tab
+    const auditList = document.getElementById('audit-list');
+    const auditEventFilter = document.getElementById('audit-event-filter');
+    const refreshAuditBtn = document.getElementById('refresh-audit-btn');
 
     // --- NEW: DOM Elements - Providers Tab ---
     const providersListElement = document.getElementById('provider-list');
@@ -209,16 +215,30 @@ document.addEventListener('DOMContentLoaded', function() {
     if (providerTypeSelect) {
         providerTypeSelect.addEventListener('change', toggleProviderConditionalFields);
     }
+    if (addRoleBtn) {
+        addRoleBtn.addEventListener('click', () => editRole(null));
+    }
+    if (refreshAuditBtn) {
+        refreshAuditBtn.addEventListener('click', loadAuditLog);
+    }
+    if (auditEventFilter) {
+        auditEventFilter.addEventListener('change', loadAuditLog);
+    }
 
     // Initial Load - Use Promise.all to wait for all loads before hiding main indicator
     function initialLoad() {
         showLoading(); // Show main loader
+        // Sections the user's role does not allow answer 403 and stay empty
+        const allowed = promise => promise.catch(error => {
+            if (error.status !== 403) throw error;
+        });
         Promise.all([
             // Modify loading functions to return their fetch promise
-            loadProvidersPromise(),
-            loadModelsPromise(),
-            loadUsersPromise(),
-            loadRolesPromise()
+            allowed(loadProvidersPromise()),
+            allowed(loadModelsPromise()),
+            allowed(loadUsersPromise()),
+            allowed(loadRolesPromise()),
+            allowed(loadAuditLogPromise())
         ])
         .then(() => {
             console.log("Initial data load complete.");
@@ -240,7 +260,7 @@ document.addEventListener('DOMContentLoaded', function() {
         // No showLoading/hideLoading here, handled by initialLoad
         return fetch('/api/admin/providers')
             .then(response => {
-                if (!response.ok) throw new Error('Failed to load providers');
+                if (!response.ok) throw Object.assign(new Error('Failed to load providers'), { status: response.status });
                 return response.json();
             })
             .then(providers => {
@@ -257,7 +277,7 @@ document.addEventListener('DOMContentLoaded', function() {
         return fetch('/api/admin/models')
             .then(response => {
                 if (!response.ok) {
-                    throw new Error('Failed to load models');
+                    throw Object.assign(new Error('Failed to load models'), { status: response.status });
                 }
                 return response.json();
             })
@@ -272,7 +292,7 @@ document.addEventListener('DOMContentLoaded', function() {
         return fetch('/api/admin/users')
             .then(response => {
                 if (!response.ok) {
-                    throw new Error('Failed to load users');
+                    throw Object.assign(new Error('Failed to load users'), { status: response.status });
                 }
                 return response.json();
             })
@@ -288,7 +308,7 @@ document.addEventListener('DOMContentLoaded', function() {
         return fetch('/api/admin/roles')
             .then(response => {
                 if (!response.ok) {
-                    throw new Error('Failed to load roles');
+                    throw Object.assign(new Error('Failed to load roles'), { status: response.status });
                 }
                 return response.json();
             })
@@ -299,6 +319,24 @@ document.addEventListener('DOMContentLoaded', function() {
             }); // Catch is handled by Promise.all
     }
 
+    function loadAuditLogPromise() {
+        // No showLoading/hideLoading here
+        const params = new URLSearchParams();
+        if (auditEventFilter && auditEventFilter.value) {
+            params.set('event', auditEventFilter.value);
+        }
+        return fetch(`/api/admin/audit-log?${params}`)
+            .then(response => {
+                if (!response.ok) {
+                    throw Object.assign(new Error('Failed to load audit log'), { status: response.status });
+                }
+                return response.json();
+            })
+            .then(entries => {
+                renderAuditLog(Array.isArray(entries) ? entries : []);
+            }); // Catch is handled by Promise.all
+    }
+
     // --- Call Initial Load ---
     initialLoad();
 
@@ -404,6 +442,7 @@ document.addEventListener('DOMContentLoaded', function() {
                         ${model.is_active ? 'Disable' : 'Enable'}
                     </button>
                     <button class="cyber-btn" data-action="edit" data-id="${model.id}">Edit</button>
+                    <button class="cyber-btn" data-action="access" data-id="${model.id}">Access</button>
                     <button class="cyber-btn danger" data-action="delete" data-id="${model.id}">Delete</button>
                 </div>
             `;
@@ -412,6 +451,7 @@ document.addEventListener('DOMContentLoaded', function() {
             // Add event listeners
             card.querySelector('[data-action="edit"]').addEventListener('click', () => editModel(model.id));
             card.querySelector('[data-action="delete"]').addEventListener('click', () => deleteModel(model.id));
+            card.querySelector('[data-action="access"]').addEventListener('click', () => editModelAccess(model.id, model.name));
             const toggleBtn = card.querySelector('[data-action="toggle"]');
             if (toggleBtn) {
                 toggleBtn.addEventListener('click', (event) => {
@@ -656,6 +696,67 @@ document.addEventListener('DOMContentLoaded', function() {
         openModelModal('edit', modelId);
     }
 
+    // Choose who can use a model: every user, or the roles and users named
+    // (admins can always use active models)
+    function editModelAccess(modelId, modelName) {
+        showLoading();
+        Promise.all([
+            fetch(`/api/admin/models/${modelId}/access`).then(r => { if (!r.ok) throw new Error('Failed to load model access'); return r.json(); }),
+            fetch('/api/admin/roles').then(r => { if (!r.ok) throw new Error('Failed to load roles'); return r.json(); }),
+            fetch('/api/admin/users').then(r => { if (!r.ok) throw new Error('Failed to load users'); return r.json(); })
+        ])
+            .then(([access, roles, users]) => {
+                hideLoading();
+                const allowAll = confirm(`Let every user use ${modelName}?\n\nOK: every user. Cancel: only the roles and users you choose next.`);
+                let roleIds = access.role_ids;
+                let userIds = access.user_ids;
+                if (!allowAll) {
+                    const roleNames = prompt('Roles that can use this model (comma-separated names):',
+                        roles.filter(r => roleIds.includes(r.id)).map(r => r.name).join(', '));
+                    if (roleNames === null) return;
+                    const usernames = prompt('Users that can use this model (comma-separated usernames):',
+                        users.filter(u => userIds.includes(u.id)).map(u => u.username).join(', '));
+                    if (usernames === null) return;
+
+                    const names = value => value.split(',').map(n => n.trim()).filter(n => n !== '');
+                    const unknown = [];
+                    roleIds = names(roleNames).map(name => {
+                        const role = roles.find(r => r.name === name);
+                        if (!role) unknown.push(`role "${name}"`);
+                        return role ? role.id : 0;
+                    });
+                    userIds = names(usernames).map(name => {
+                        const user = users.find(u => u.username === name);
+                        if (!user) unknown.push(`user "${name}"`);
+                        return user ? user.id : 0;
+                    });
+                    if (unknown.length > 0) {
+                        showError(`Unknown ${unknown.join(', ')}`);
+                        return;
+                    }
+                }
+
+                showLoading();
+                return fetch(`/api/admin/models/${modelId}/access`, {
+                    method: 'PUT',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ allow_all: allowAll, role_ids: roleIds, user_ids: userIds })
+                })
+                    .then(response => {
+                        if (!response.ok) {
+                            return response.text().then(text => { throw new Error(text || 'Failed to update model access'); });
+                        }
+                        showSuccess(allowAll ? `${modelName} is open to every user` : `Access to ${modelName} updated`);
+                    });
+            })
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
     function deleteModel(modelId) {
         openConfirmModal('Are you sure you want to delete this model?', 'delete', modelId);
     }
@@ -833,9 +934,12 @@ document.addEventListener('DOMContentLoaded', function() {
                     <p>Email: <span>${escapeHtml(user.email)}</span></p>
                     <p>Name: <span>${escapeHtml(user.first_name || '')} ${escapeHtml(user.last_name || '')}</span></p>
                     <p>Status: <span class="status-badge ${user.is_active ? 'active' : 'inactive'}">${user.is_active ? 'Active' : 'Inactive'}</span></p>
+                    <p>2FA: <span>${user.two_factor_enabled ? 'Enabled' : 'Off'}</span></p>
                 </div>
                 <div class="user-card-actions">
                     <button class="cyber-btn" data-action="edit-user" data-id="${user.id}">Edit</button>
+                    ${user.two_factor_enabled ? `<button class="cyber-btn" data-action="reset-2fa" data-id="${user.id}">Reset 2FA</button>` : ''}
+                    <button class="cyber-btn" data-action="force-logout" data-id="${user.id}">Log Out</button>
                     <button class="cyber-btn danger" data-action="delete-user" data-id="${user.id}">Delete</button>
                 </div>
             `;
@@ -850,9 +954,62 @@ document.addEventListener('DOMContentLoaded', function() {
             card.querySelector('[data-action="delete-user"]').addEventListener('click', () => {
                 deleteUser(user.id);
             });
+
+            card.querySelector('[data-action="force-logout"]').addEventListener('click', () => {
+                forceLogoutUser(user);
+            });
+
+            const resetTwoFactorBtn = card.querySelector('[data-action="reset-2fa"]');
+            if (resetTwoFactorBtn) {
+                resetTwoFactorBtn.addEventListener('click', () => resetUserTwoFactor(user));
+            }
         });
     }
 
+    // End every session of a user, e.g. after their device was stolen
+    function forceLogoutUser(user) {
+        if (!confirm(`Log ${user.username} out of every session?`)) return;
+        showLoading();
+        fetch(`/api/admin/users/${user.id}/sessions`, { method: 'DELETE' })
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => { throw new Error(text || 'Failed to log out user'); });
+                }
+                return response.json();
+            })
+            .then(result => {
+                showSuccess(`${user.username} was logged out of ${result.revoked} session(s)`);
+            })
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
+    // Remove a user's authenticator and recovery codes, e.g. after they lost
+    // their device. They enroll again at their next login if their role
+    // requires it.
+    function resetUserTwoFactor(user) {
+        if (!confirm(`Reset two-factor authentication for ${user.username}?`)) return;
+        showLoading();
+        fetch(`/api/admin/users/${user.id}/2fa`, { method: 'DELETE' })
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => { throw new Error(text || 'Failed to reset two-factor authentication'); });
+                }
+                showSuccess('Two-factor authentication reset');
+                loadUsers();
+            })
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
     function populateRoleFilter(users) {
         // Get unique roles from users
         const roleSet = new Set();
@@ -1276,6 +1433,43 @@ document.addEventListener('DOMContentLoaded', function() {
         }
     }
 
+    // --- Audit Log Tab Functions ---
+    function loadAuditLog() {
+        showLoading();
+        loadAuditLogPromise()
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
+    function renderAuditLog(entries) {
+        if (entries.length === 0) {
+            auditList.innerHTML = '<div class="no-results">No audit events found.</div>';
+            return;
+        }
+
+        const rows = entries.map(entry => `
+            <tr>
+                <td>${escapeHtml(new Date(entry.created_at).toLocaleString())}</td>
+                <td>${escapeHtml(entry.username || '(deleted)')}</td>
+                <td>${escapeHtml(entry.event)}</td>
+                <td>${escapeHtml(entry.details || '')}</td>
+                <td>${escapeHtml(entry.ip_address || '')}</td>
+            </tr>
+        `).join('');
+        auditList.innerHTML = `
+            <table class="audit-table">
+                <thead>
+                    <tr><th>Time</th><th>User</th><th>Event</th><th>Details</th><th>IP Address</th></tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+        `;
+    }
+
     // --- Roles Tab Functions ---
     function loadRoles() {
         showLoading(); // Use general loader
@@ -1313,23 +1507,102 @@ document.addEventListener('DOMContentLoaded', function() {
             card.dataset.id = role.id;
             card.dataset.name = role.name;
 
+            const permissions = Object.keys(role.permissions || {}).sort();
             card.innerHTML = `
                 <h3>${escapeHtml(role.name)}</h3>
                 <p>${escapeHtml(role.description || 'No description')}</p>
+                <p class="role-permissions">Permissions: ${escapeHtml(permissions.join(', ') || 'none')}</p>
+                <p>Two-factor authentication: <span>${role.require_two_factor ? 'Required' : 'Optional'}</span></p>
                 <div class="role-users">
                     <div class="role-users-title">Users with this role:</div>
                     <div class="role-users-loading">Loading users...</div>
                     <div class="role-users-list" id="role-users-${role.id}"></div>
                 </div>
+                <div class="model-actions">
+                    <button class="cyber-btn" data-action="edit">Edit</button>
+                    <button class="cyber-btn danger" data-action="delete">Delete</button>
+                </div>
             `;
 
             roleList.appendChild(card);
+            card.querySelector('[data-action="edit"]').addEventListener('click', () => editRole(role));
+            card.querySelector('[data-action="delete"]').addEventListener('click', () => deleteRole(role));
 
             // Load users for this role
             loadUsersForRole(role.id);
         });
     }
 
+    // Create a role (role is null) or change a role's name, description,
+    // permissions and whether it requires two-factor authentication, asking
+    // for them in turn
+    function editRole(role) {
+        fetch('/api/admin/permissions')
+            .then(response => {
+                if (!response.ok) throw new Error('Failed to load permissions');
+                return response.json();
+            })
+            .then(available => {
+                const name = prompt('Role name:', role ? role.name : '');
+                if (name === null) return;
+                const description = prompt('Description:', role ? role.description || '' : '');
+                if (description === null) return;
+                const current = Object.keys((role && role.permissions) || {}).sort();
+                const listed = available.map(p => `${p.name}: ${p.description}`).join('\n');
+                const entered = prompt(`Permissions (comma-separated):\n\n${listed}`, current.join(', '));
+                if (entered === null) return;
+
+                const permissions = {};
+                entered.split(',').map(p => p.trim()).filter(Boolean).forEach(p => { permissions[p] = true; });
+                const requireTwoFactor = confirm(`Require two-factor authentication for users of this role?\n\n` +
+                    `OK to require it, Cancel to leave it optional.` +
+                    (role && role.require_two_factor ? ' It is currently required.' : ''));
+                showLoading();
+                return fetch(role ? `/api/admin/roles/${role.id}` : '/api/admin/roles', {
+                    method: role ? 'PUT' : 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({
+                        name: name,
+                        description: description,
+                        permissions: permissions,
+                        require_two_factor: requireTwoFactor
+                    })
+                })
+                    .then(response => {
+                        if (!response.ok) {
+                            return response.text().then(text => { throw new Error(text || 'Failed to save role'); });
+                        }
+                        showSuccess(role ? 'Role updated successfully' : 'Role created successfully');
+                        loadRoles();
+                    });
+            })
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
+    function deleteRole(role) {
+        if (!confirm(`Delete the role ${role.name}?`)) return;
+        showLoading();
+        fetch(`/api/admin/roles/${role.id}`, { method: 'DELETE' })
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => { throw new Error(text || 'Failed to delete role'); });
+                }
+                showSuccess('Role deleted successfully');
+                loadRoles();
+            })
+            .catch(error => {
+                showError(error.message);
+            })
+            .finally(() => {
+                hideLoading();
+            });
+    }
+
     function loadUsersForRole(roleId) {
         // Don't show main loader here, just update inline
         const loadingIndicator = document.querySelector(`#role-users-${roleId}`)?.previousElementSibling;
@@ -58,10 +58,16 @@ api.fetchModels = async function() {
     }
 };
 
-// Fetch existing chats from the API
+// Fetch existing chats from the API, filtered by the sidebar folder, project and tag
 api.fetchChats = async function() {
     try {
-        const response = await fetch('/api/chats');
+        const params = new URLSearchParams();
+        if (chatListFilter.folderId) params.set('folder_id', chatListFilter.folderId);
+        if (chatListFilter.projectId) params.set('project_id', chatListFilter.projectId);
+        if (chatListFilter.tag) params.set('tag', chatListFilter.tag);
+        const query = params.toString();
+
+        const response = await fetch(query ? `${CHATS_ENDPOINT}?${query}` : CHATS_ENDPOINT);
         if (!response.ok) {
             throw new Error(`HTTP error ${response.status}`);
         }
@@ -70,11 +76,13 @@ api.fetchChats = async function() {
         // Update global state
         chatsList = fetchedChats;
         ui.renderChatsList(chatsList);
+        ui.renderChatFilters();
 
         // If no current chat ID is set OR the current chat ID no longer exists,
-        // load the first chat or create a new one.
+        // load the first chat or create a new one. A filtered list may just
+        // be hiding the current chat, so keep it open.
         const currentChatExists = chatsList.some(chat => chat.id === currentChatId);
-        if (!currentChatId || !currentChatExists) {
+        if (!currentChatId || (!currentChatExists && !query)) {
              if (chatsList.length > 0) {
                  console.log("No active chat or previous chat deleted, loading first chat:", chatsList[0].id);
                 api.loadChat(chatsList[0].id);
@@ -92,6 +100,45 @@ api.fetchChats = async function() {
     }
 };
 
+// Search the user's chats; an empty query goes back to the chat list
+api.searchChats = async function(query) {
+    query = (query || '').trim();
+    if (!query) {
+        ui.renderSearchResults(null);
+        return;
+    }
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/search?q=${encodeURIComponent(query)}`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const results = await response.json();
+        // Ignore results for a query the user has since changed
+        if (chatSearchInput && chatSearchInput.value.trim() !== query) return;
+        ui.renderSearchResults(results);
+    } catch (error) {
+        console.error('Error searching chats:', error);
+        ui.showNotification(`Error searching chats: ${error.message}`, 'error');
+    }
+};
+
+// Open a chat from the search results and scroll to the matching message,
+// switching to its branch if it is not on the one currently shown
+api.openSearchResult = async function(chatId, messageId) {
+    await api.loadChat(chatId);
+    if (!messageId || currentChatId !== chatId) return;
+
+    if (!document.getElementById(`message-${messageId}`)) {
+        await api.switchMessageVersion(messageId);
+    }
+    const messageElement = document.getElementById(`message-${messageId}`);
+    if (messageElement) {
+        messageElement.scrollIntoView({ behavior: 'smooth', block: 'center' });
+        messageElement.classList.add('search-target');
+        setTimeout(() => messageElement.classList.remove('search-target'), 2000);
+    }
+};
+
 // Load a specific chat by ID
 api.loadChat = async function(chatId) {
     if (!chatId || chatId === currentChatId) {
@@ -100,6 +147,7 @@ api.loadChat = async function(chatId) {
          document.querySelectorAll('.chat-item').forEach(item => {
             item.classList.toggle('active', item.dataset.chatId == chatId);
         });
+         websocket.watchChat(chatId || null);
          return; // Don't reload if already active
     }
      console.log(`Loading chat: ${chatId}`);
@@ -119,6 +167,8 @@ api.loadChat = async function(chatId) {
 
         const chat = await response.json();
         currentChatId = chat.id; // Update global state
+        websocket.watchChat(chat.id); // Follow other participants' messages live
+        api.setChatRole(chat);
 
         // Update chat title in UI
         if (chatTitle) { // chatTitle is global DOM element
@@ -155,6 +205,8 @@ api.loadChat = async function(chatId) {
 api.prepareNewChat = function() {
     console.log("Preparing new chat state...");
     currentChatId = null; // Indicate a new, unsaved chat
+    websocket.watchChat(null);
+    api.setChatRole(null);
 
     // Update chat title UI
     if (chatTitle) {
@@ -229,6 +281,11 @@ api.updateChatTitle = async function(chatId, newTitle) {
     }
 };
 
+// The model_ids field for a side-by-side comparison, if one is set up
+api.compareModelIDs = function() {
+    return compareModels.length > 0 ? { model_ids: [activeModel, ...compareModels] } : {};
+};
+
 // Send a message or create a new chat with the first message
 api.sendMessage = async function() {
     if (!messageInput) {
@@ -274,10 +331,15 @@ api.sendMessage = async function() {
             requestBody = {
                 first_message: {
                     content: firstMessageContent,
-                    model_id: activeModel
+                    model_id: activeModel,
+                    ...api.compareModelIDs()
                 }
                 // No title field - backend will use first_message content
             };
+            // New chats go into the project the list is showing
+            if (chatListFilter.projectId && chatListFilter.projectId !== 'none') {
+                requestBody.project_id = Number(chatListFilter.projectId);
+            }
 
             response = await fetch('/api/chats', {
                 method: 'POST',
@@ -327,7 +389,8 @@ api.sendMessage = async function() {
             console.log(`[API] Sending message to existing chat ${currentChatId} using model ${activeModel}:`, firstMessageContent);
             requestBody = {
                 content: firstMessageContent,
-                model_id: activeModel
+                model_id: activeModel,
+                ...api.compareModelIDs()
             };
 
             response = await fetch(`/api/chats/${currentChatId}/messages`, {
@@ -416,6 +479,728 @@ api.regenerateLastMessage = async function() {
     }
 };
 
+// Regenerate a specific assistant message; the new response is kept as another version of it
+api.regenerateMessage = async function(messageId) {
+    if (!currentChatId || !messageId) return;
+
+    ui.showThinkingIndicator(true);
+    try {
+        const response = await fetch(`/api/chats/${currentChatId}/messages/regenerate`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ model_id: activeModel, message_id: messageId })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || `HTTP error ${response.status}`);
+        }
+        // Removal of the old version and the new response arrive over the WebSocket
+    } catch (error) {
+        console.error('Error regenerating message:', error);
+        ui.showNotification(`Error regenerating: ${error.message}`, 'error');
+        ui.showThinkingIndicator(false);
+    }
+};
+
+// Edit an earlier prompt; the edit starts a new branch of the conversation from that point
+api.editMessage = async function(messageId, currentContent) {
+    if (!currentChatId || !messageId) return;
+
+    const newContent = prompt('Edit your message:', currentContent);
+    if (newContent === null || newContent.trim() === '' || newContent === currentContent) {
+        return; // Cancelled or unchanged
+    }
+
+    ui.showThinkingIndicator(true);
+    try {
+        const response = await fetch(`/api/chats/${currentChatId}/messages/${messageId}/edit`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ content: newContent, model_id: activeModel })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || `HTTP error ${response.status}`);
+        }
+        // Messages after the edit point are removed over the WebSocket
+        ui.renderMessage(await response.json());
+    } catch (error) {
+        console.error('Error editing message:', error);
+        ui.showNotification(`Error editing message: ${error.message}`, 'error');
+        ui.showThinkingIndicator(false);
+    }
+};
+
+// Switch the chat to another version of a message and show the branch it starts
+api.switchMessageVersion = async function(messageId) {
+    if (!currentChatId || !messageId) return;
+    try {
+        const response = await fetch(`/api/chats/${currentChatId}/messages/${messageId}/activate`, {
+            method: 'POST'
+        });
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const chat = await response.json();
+        ui.clearChatHistory();
+        (chat.messages || []).forEach(message => ui.renderMessage(message));
+    } catch (error) {
+        console.error('Error switching message version:', error);
+        ui.showNotification(`Error switching version: ${error.message}`, 'error');
+    }
+};
+
+// Ask which format to export in; returns null if cancelled or invalid
+api.promptExportFormat = function() {
+    const format = (prompt('Export format (md, json or html):', 'md') || '').trim();
+    if (!format) return null;
+    if (!['md', 'json', 'html'].includes(format)) {
+        ui.showNotification('Export format must be md, json or html.', 'error');
+        return null;
+    }
+    return format;
+};
+
+// Download a chat. The server sends it as an attachment, so the page stays put.
+api.exportChat = function(chatId) {
+    if (!chatId) {
+        ui.showNotification('Nothing to export yet.', 'info');
+        return;
+    }
+    const format = api.promptExportFormat();
+    if (format) {
+        window.location.href = `${CHATS_ENDPOINT}/${chatId}/export?format=${format}`;
+    }
+};
+
+// Download all of the user's chats as a zip archive
+api.exportAllChats = function() {
+    const format = api.promptExportFormat();
+    if (format) {
+        window.location.href = `${CHATS_ENDPOINT}/export?format=${format}`;
+    }
+};
+
+// Import chats from a ChatGPT, Claude or CyberAI export and report what was left out
+api.importChats = async function(file) {
+    const formData = new FormData();
+    formData.append('file', file);
+    ui.showNotification(`Importing ${file.name}...`, 'info');
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/import`, {
+            method: 'POST',
+            body: formData
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        const report = await response.json();
+        ui.showNotification(`Imported ${report.imported.length} chats.`, 'success');
+        if (report.skipped.length > 0) {
+            const lines = report.skipped.map(s => `- ${s.conversation}${s.item ? ': ' + s.item : ''} (${s.reason})`);
+            ui.addSystemMessage(`Import skipped ${report.skipped.length} items:\n${lines.join('\n')}`, 'info');
+        }
+        await api.fetchChats();
+    } catch (error) {
+        console.error('Error importing chats:', error);
+        ui.showNotification(`Error importing chats: ${error.message}`, 'error');
+    }
+};
+
+// Remember the caller's role and the participants of a loaded chat (null for
+// a new chat). Viewers of a shared chat cannot post.
+api.setChatRole = function(chat) {
+    currentChatRole = chat?.role || null;
+    chatParticipants = {};
+    (chat?.participants || []).forEach(p => { chatParticipants[p.user_id] = p.username; });
+    const readOnly = currentChatRole === 'viewer';
+    if (messageInput) {
+        messageInput.disabled = readOnly;
+        messageInput.placeholder = readOnly ? 'This chat was shared with you read-only' : '> Type your message or command here...';
+    }
+    if (sendButton) {
+        sendButton.disabled = readOnly;
+    }
+};
+
+// Show who a chat is shared with. The owner can invite users or remove
+// them; other participants can leave the chat.
+api.manageParticipants = async function(chatId) {
+    if (!chatId) {
+        ui.showNotification('Send a message first to create the chat.', 'info');
+        return;
+    }
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/${chatId}/participants`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const participants = await response.json();
+        const list = participants.map(p => `- ${p.username} (${p.role})`).join('\n');
+
+        if (currentChatRole) {
+            if (confirm(`Participants:\n${list}\n\nOK leaves this chat.`)) {
+                await api.leaveChat(chatId);
+            }
+            return;
+        }
+
+        const input = prompt(`Participants:\n${list}\n\nEnter a username to invite as a contributor, add " viewer" to invite read-only, or prefix with "-" to remove:`, '');
+        if (!input || !input.trim()) return;
+        const [name, role = 'contributor'] = input.trim().split(/\s+/);
+        if (name.startsWith('-')) {
+            const participant = participants.find(p => p.username === name.slice(1) && p.role !== 'owner');
+            if (!participant) {
+                ui.showNotification(`${name.slice(1)} is not a participant of this chat.`, 'error');
+                return;
+            }
+            const removed = await fetch(`${CHATS_ENDPOINT}/${chatId}/participants/${participant.user_id}`, { method: 'DELETE' });
+            if (!removed.ok) {
+                throw new Error((await removed.text()) || `HTTP error ${removed.status}`);
+            }
+            ui.showNotification(`Removed ${participant.username} from this chat.`, 'success');
+            return;
+        }
+
+        const added = await fetch(`${CHATS_ENDPOINT}/${chatId}/participants`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ username: name, role: role })
+        });
+        if (!added.ok) {
+            throw new Error((await added.text()) || `HTTP error ${added.status}`);
+        }
+        const participant = await added.json();
+        chatParticipants[participant.user_id] = participant.username;
+        ui.showNotification(`Shared this chat with ${participant.username} as ${participant.role}.`, 'success');
+    } catch (error) {
+        console.error('Error managing participants:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+    }
+};
+
+// Leave a chat another user shared with us
+api.leaveChat = async function(chatId) {
+    if (!currentUser) return;
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/${chatId}/participants/${currentUser.id}`, { method: 'DELETE' });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        ui.showNotification('You left the chat.', 'success');
+        if (chatId === currentChatId) {
+            api.prepareNewChat();
+        }
+        await api.fetchChats();
+    } catch (error) {
+        console.error('Error leaving chat:', error);
+        ui.showNotification(`Error leaving chat: ${error.message}`, 'error');
+    }
+};
+
+// Fetch the user's chat folders for the sidebar filter
+api.fetchFolders = async function() {
+    try {
+        const response = await fetch(`${API_BASE}/folders`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        chatFolders = await response.json();
+        // Stop filtering by a folder that no longer exists
+        if (chatListFilter.folderId && chatListFilter.folderId !== 'none' &&
+            !chatFolders.some(f => String(f.id) === chatListFilter.folderId)) {
+            chatListFilter.folderId = '';
+            api.fetchChats();
+        }
+        ui.renderChatFilters();
+        return chatFolders;
+    } catch (error) {
+        console.error('Error fetching folders:', error);
+        ui.showNotification(`Error loading folders: ${error.message}`, 'error');
+        return [];
+    }
+};
+
+// Create a folder, returning it, or null if cancelled or it failed
+api.createFolder = async function(name) {
+    if (name === undefined) {
+        name = prompt('New folder name:', '');
+    }
+    if (!name || !name.trim()) return null;
+    try {
+        const response = await fetch(`${API_BASE}/folders`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name: name.trim() })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        const folder = await response.json();
+        await api.fetchFolders();
+        return folder;
+    } catch (error) {
+        console.error('Error creating folder:', error);
+        ui.showNotification(`Error creating folder: ${error.message}`, 'error');
+        return null;
+    }
+};
+
+// Move chats to a folder (0 for none), pin or unpin them, or add and remove
+// tags; see POST /api/chats/organize
+api.organizeChats = async function(chatIds, changes) {
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/organize`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ chat_ids: chatIds, ...changes })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        await api.fetchChats();
+        if (changes.folder_id !== undefined) {
+            await api.fetchFolders(); // Folder chat counts changed
+        }
+        return true;
+    } catch (error) {
+        console.error('Error organizing chats:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+        return false;
+    }
+};
+
+// Pin a chat to the top of the list, or unpin it
+api.togglePin = function(chat) {
+    return api.organizeChats([chat.id], { pinned: !chat.is_pinned });
+};
+
+// Ask for the folder and tags of a chat and save them
+api.organizeChat = async function(chatId) {
+    if (!chatId) {
+        ui.showNotification('Send a message first to create the chat.', 'info');
+        return;
+    }
+    const chat = chatsList.find(c => c.id === chatId) || {};
+    const currentFolder = chatFolders.find(f => f.id === chat.folder_id);
+
+    const folderNames = chatFolders.map(f => f.name).join(', ') || 'none yet';
+    const folderName = prompt(`Folder for this chat (${folderNames}). A new name creates the folder; leave empty for none:`, currentFolder ? currentFolder.name : '');
+    if (folderName === null) return;
+    const tagsInput = prompt('Tags, separated by commas:', (chat.tags || []).join(', '));
+    if (tagsInput === null) return;
+
+    const changes = {};
+    const name = folderName.trim();
+    if (name !== (currentFolder ? currentFolder.name : '')) {
+        let folder = chatFolders.find(f => f.name.toLowerCase() === name.toLowerCase());
+        if (name && !folder) {
+            folder = await api.createFolder(name);
+            if (!folder) return;
+        }
+        changes.folder_id = folder ? folder.id : 0;
+    }
+
+    const oldTags = chat.tags || [];
+    const newTags = tagsInput.split(',').map(t => t.trim().toLowerCase()).filter(t => t);
+    const addTags = newTags.filter(t => !oldTags.includes(t));
+    const removeTags = oldTags.filter(t => !newTags.includes(t));
+    if (addTags.length > 0) changes.add_tags = addTags;
+    if (removeTags.length > 0) changes.remove_tags = removeTags;
+
+    if (Object.keys(changes).length > 0 && await api.organizeChats([chatId], changes)) {
+        ui.showNotification('Chat organized.', 'success');
+    }
+};
+
+// Edit the custom instructions and memory sent with every chat of the user
+api.editPersonalization = async function() {
+    try {
+        const response = await fetch(`${API_BASE}/user/me/instructions`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const current = await response.json();
+        const instructions = prompt('Custom instructions for every chat (what models should know about you and how to answer):', current.custom_instructions);
+        if (instructions !== null && instructions.trim() !== current.custom_instructions) {
+            const saveResponse = await fetch(`${API_BASE}/user/me/instructions`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ custom_instructions: instructions })
+            });
+            if (!saveResponse.ok) {
+                throw new Error((await saveResponse.text()) || `HTTP error ${saveResponse.status}`);
+            }
+            ui.showNotification('Custom instructions saved.', 'success');
+        }
+
+        // Add and forget memories until the user is done
+        for (;;) {
+            const memoriesResponse = await fetch(`${API_BASE}/user/me/memories`);
+            if (!memoriesResponse.ok) {
+                throw new Error(`HTTP error ${memoriesResponse.status}`);
+            }
+            const memories = await memoriesResponse.json();
+            const list = memories.map((m, i) => `${i + 1}. ${m.content}`).join('\n') || '(nothing yet)';
+            const input = prompt(`Memory:\n${list}\n\nType something to remember, -N to forget number N, or leave empty to finish:`, '');
+            if (input === null || !input.trim()) return;
+
+            const forget = input.trim().match(/^-(\d+)$/);
+            let changeResponse;
+            if (forget) {
+                const memory = memories[Number(forget[1]) - 1];
+                if (!memory) continue;
+                changeResponse = await fetch(`${API_BASE}/user/me/memories/${memory.id}`, { method: 'DELETE' });
+            } else {
+                changeResponse = await fetch(`${API_BASE}/user/me/memories`, {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    body: JSON.stringify({ content: input.trim() })
+                });
+            }
+            if (!changeResponse.ok) {
+                throw new Error((await changeResponse.text()) || `HTTP error ${changeResponse.status}`);
+            }
+        }
+    } catch (error) {
+        console.error('Error editing personalization:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+    }
+};
+
+// Show the user's recovery codes, which the server does not show again
+function showRecoveryCodes(codes) {
+    prompt('Save these recovery codes somewhere safe. Each logs you in once if you lose your authenticator app:', codes.join('  '));
+}
+
+// Set up, or change, two-factor authentication
+api.editTwoFactor = async function() {
+    // Errors of the 2FA routes are plain text
+    const request = async (method, path, body) => {
+        const response = await fetch(`${API_BASE}/user/me/2fa${path}`, {
+            method,
+            headers: body ? { 'Content-Type': 'application/json' } : {},
+            body: body ? JSON.stringify(body) : undefined
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()).trim() || `HTTP error ${response.status}`);
+        }
+        return response.status === 204 ? null : response.json();
+    };
+    try {
+        const status = await request('GET', '');
+        if (!status.enabled) {
+            const intro = status.required
+                ? 'Your role requires two-factor authentication. Set it up now?'
+                : 'Protect your account with codes from an authenticator app when you log in. Set up two-factor authentication?';
+            if (!confirm(intro)) return;
+            const enrollment = await request('POST', '/enroll');
+            const code = await ui.showTwoFactorEnrollment(enrollment);
+            if (!code) return;
+            const result = await request('POST', '/enable', { code });
+            ui.showNotification('Two-factor authentication enabled.', 'success');
+            showRecoveryCodes(result.recovery_codes);
+            return;
+        }
+
+        const choice = prompt(
+            `Two-factor authentication is on, with ${status.recovery_codes_remaining} recovery codes left.\n\n` +
+            `Type "codes" for new recovery codes${status.required ? '' : ', or "off" to turn it off'}:`, '');
+        if (choice === null || !choice.trim()) return;
+        const action = choice.trim().toLowerCase();
+        if (action !== 'codes' && (action !== 'off' || status.required)) {
+            ui.showNotification(status.required ? 'Your role requires two-factor authentication.' : 'Type "codes" or "off".', 'error');
+            return;
+        }
+        const code = prompt('Enter a code from your authenticator app (or a recovery code):', '');
+        if (!code || !code.trim()) return;
+        // Recovery codes have letters; app codes are digits
+        const body = /^\d+$/.test(code.trim()) ? { code: code.trim() } : { recovery_code: code.trim() };
+        if (action === 'codes') {
+            const result = await request('POST', '/recovery-codes', body);
+            showRecoveryCodes(result.recovery_codes);
+        } else {
+            await request('DELETE', '', body);
+            ui.showNotification('Two-factor authentication turned off.', 'success');
+        }
+    } catch (error) {
+        console.error('Error editing two-factor authentication:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+    }
+};
+
+// List where the user is logged in, and log out of other sessions until the
+// user is done
+api.manageSessions = async function() {
+    try {
+        for (;;) {
+            const response = await fetch(`${API_BASE}/user/me/sessions`);
+            if (!response.ok) {
+                throw new Error(`HTTP error ${response.status}`);
+            }
+            const sessions = await response.json();
+            const list = sessions.map((s, i) =>
+                `${i + 1}. ${s.current ? '(this session) ' : ''}${s.ip_address || 'unknown IP'}, ` +
+                `last seen ${new Date(s.last_seen_at).toLocaleString()}\n   ${s.user_agent || 'unknown browser'}`
+            ).join('\n');
+            const input = prompt(`Active sessions:\n${list}\n\nType -N to log out session N, "all" to log out all other sessions, or leave empty to finish:`, '');
+            if (input === null || !input.trim()) return;
+
+            const command = input.trim().toLowerCase();
+            const revoke = command.match(/^-(\d+)$/);
+            let revokeResponse;
+            if (command === 'all') {
+                revokeResponse = await fetch(`${API_BASE}/user/me/sessions`, { method: 'DELETE' });
+            } else if (revoke) {
+                const session = sessions[Number(revoke[1]) - 1];
+                if (!session) continue;
+                if (session.current && !confirm('This is the session you are using. Log out?')) continue;
+                revokeResponse = await fetch(`${API_BASE}/user/me/sessions/${session.id}`, { method: 'DELETE' });
+                if (revokeResponse.ok && session.current) {
+                    window.location.href = '/login';
+                    return;
+                }
+            } else {
+                continue;
+            }
+            if (!revokeResponse.ok) {
+                throw new Error((await revokeResponse.text()) || `HTTP error ${revokeResponse.status}`);
+            }
+        }
+    } catch (error) {
+        console.error('Error managing sessions:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+    }
+};
+
+// Fetch the user's projects, and those shared with them, for the sidebar filter
+api.fetchProjects = async function() {
+    try {
+        const response = await fetch(`${API_BASE}/projects`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        chatProjects = await response.json();
+        // Stop filtering by a project that no longer exists
+        if (chatListFilter.projectId && chatListFilter.projectId !== 'none' &&
+            !chatProjects.some(p => String(p.id) === chatListFilter.projectId)) {
+            chatListFilter.projectId = '';
+            api.fetchChats();
+        }
+        ui.renderChatFilters();
+        return chatProjects;
+    } catch (error) {
+        console.error('Error fetching projects:', error);
+        ui.showNotification(`Error loading projects: ${error.message}`, 'error');
+        return [];
+    }
+};
+
+// Create a project, asking for its name and instructions, and show its chats.
+// Returns the project, or null if cancelled or it failed.
+api.createProject = async function(name) {
+    if (name === undefined) {
+        name = prompt('New project name:', '');
+    }
+    if (!name || !name.trim()) return null;
+    const instructions = prompt('Instructions for every chat in the project (optional):', '');
+    if (instructions === null) return null;
+    const isShared = confirm('Share this project with the users in your role?');
+    try {
+        const response = await fetch(`${API_BASE}/projects`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                name: name.trim(),
+                instructions: instructions,
+                default_model_id: activeModel || undefined,
+                is_shared: isShared
+            })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        const project = await response.json();
+        chatListFilter.projectId = String(project.id);
+        await api.fetchProjects();
+        api.fetchChats();
+        return project;
+    } catch (error) {
+        console.error('Error creating project:', error);
+        ui.showNotification(`Error creating project: ${error.message}`, 'error');
+        return null;
+    }
+};
+
+// Attach a text file picked by the user to a project
+api.addProjectFile = function(projectId) {
+    const input = document.createElement('input');
+    input.type = 'file';
+    input.accept = '.txt,.md,.csv,.json,.yaml,.yml,.xml,.html,.log,text/*';
+    input.addEventListener('change', async () => {
+        if (!input.files.length) return;
+        const formData = new FormData();
+        formData.append('file', input.files[0]);
+        try {
+            const response = await fetch(`${API_BASE}/projects/${projectId}/files`, {
+                method: 'POST',
+                body: formData
+            });
+            if (!response.ok) {
+                throw new Error((await response.text()) || `HTTP error ${response.status}`);
+            }
+            const file = await response.json();
+            ui.showNotification(`Attached ${file.name} to the project.`, 'success');
+        } catch (error) {
+            console.error('Error attaching project file:', error);
+            ui.showNotification(`Error attaching file: ${error.message}`, 'error');
+        }
+    });
+    input.click();
+};
+
+// Ask for the project of a chat and move it there
+api.moveChatToProject = async function(chatId) {
+    if (!chatId) {
+        ui.showNotification('Send a message first to create the chat.', 'info');
+        return;
+    }
+    const chat = chatsList.find(c => c.id === chatId) || {};
+    const currentProject = chatProjects.find(p => p.id === chat.project_id);
+
+    const projectNames = chatProjects.map(p => p.name).join(', ') || 'none yet';
+    const projectName = prompt(`Project for this chat (${projectNames}). A new name creates the project; leave empty for none:`, currentProject ? currentProject.name : '');
+    if (projectName === null) return;
+    const name = projectName.trim();
+    if (name === (currentProject ? currentProject.name : '')) return;
+
+    let project = chatProjects.find(p => p.name.toLowerCase() === name.toLowerCase());
+    if (name && !project) {
+        project = await api.createProject(name);
+        if (!project) return;
+    }
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/${chatId}/project`, {
+            method: 'PUT',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ project_id: project ? project.id : null })
+        });
+        if (!response.ok) {
+            throw new Error((await response.text()) || `HTTP error ${response.status}`);
+        }
+        await api.fetchProjects(); // Project chat counts changed
+        await api.fetchChats();
+        ui.showNotification(project ? `Chat moved to ${project.name}.` : 'Chat removed from its project.', 'success');
+    } catch (error) {
+        console.error('Error moving chat to project:', error);
+        ui.showNotification(`Error: ${error.message}`, 'error');
+    }
+};
+
+// Share a read-only snapshot of the chat, or revoke the links already shared
+api.shareChat = async function(chatId) {
+    if (!chatId) {
+        ui.showNotification('Nothing to share yet.', 'info');
+        return;
+    }
+    try {
+        const response = await fetch(`${CHATS_ENDPOINT}/${chatId}/shares`);
+        if (!response.ok) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const now = new Date();
+        const active = (await response.json()).filter(s => !s.revoked_at && (!s.expires_at || new Date(s.expires_at) > now));
+        if (active.length > 0) {
+            const lines = active.map(s => `${location.origin}${s.url}${s.expires_at ? ' (expires ' + new Date(s.expires_at).toLocaleString() + ')' : ''}`);
+            if (confirm(`This chat is shared:\n${lines.join('\n')}\n\nOK revokes these links, Cancel creates a new one.`)) {
+                await Promise.all(active.map(s => api.revokeChatShare(chatId, s.id)));
+                ui.showNotification(`Revoked ${active.length} share link${active.length > 1 ? 's' : ''}.`, 'success');
+                return;
+            }
+        }
+
+        const days = prompt('Link expires after how many days? (leave blank to never expire)', '');
+        if (days === null) return;
+        const body = { require_login: confirm('Only allow logged-in users to open the link?') };
+        if (days.trim() !== '') {
+            const n = Number(days);
+            if (!(n > 0)) {
+                ui.showNotification('Expiry must be a positive number of days.', 'error');
+                return;
+            }
+            body.expires_at = new Date(Date.now() + n * 86400000).toISOString();
+        }
+
+        const created = await fetch(`${CHATS_ENDPOINT}/${chatId}/shares`, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        if (!created.ok) {
+            throw new Error((await created.text()) || `HTTP error ${created.status}`);
+        }
+        const share = await created.json();
+        const link = `${location.origin}${share.url}`;
+        try {
+            await navigator.clipboard.writeText(link);
+            ui.showNotification('Share link copied to clipboard.', 'success');
+        } catch (clipboardError) {
+            prompt('Share link:', link);
+        }
+    } catch (error) {
+        console.error('Error sharing chat:', error);
+        ui.showNotification(`Error sharing chat: ${error.message}`, 'error');
+    }
+};
+
+// Revoke a share link of a chat
+api.revokeChatShare = async function(chatId, shareId) {
+    const response = await fetch(`${CHATS_ENDPOINT}/${chatId}/shares/${shareId}`, { method: 'DELETE' });
+    if (!response.ok) {
+        throw new Error(`HTTP error ${response.status}`);
+    }
+};
+
+// View and (as the chat's owner) edit the rolling summary that replaces older
+// messages in the model's context
+api.editChatSummary = async function(chatId) {
+    if (!chatId) return;
+    try {
+        const response = await fetch(`/api/chats/${chatId}/summary`);
+        if (!response.ok && response.status !== 404) {
+            throw new Error(`HTTP error ${response.status}`);
+        }
+        const summary = response.ok ? await response.json() : null;
+
+        // Only the owner can change the summary of a shared chat
+        if (currentChatRole) {
+            alert(summary ? `Summary of earlier messages:\n\n${summary.content}` : 'This chat has no summary yet.');
+            return;
+        }
+
+        const promptText = summary
+            ? 'Summary of earlier messages (clear it to summarise again from the start):'
+            : 'This chat has no summary yet. Enter one to add it to the model\'s context:';
+        const newContent = prompt(promptText, summary?.content || '');
+        if (newContent === null || newContent === (summary?.content || '')) {
+            return; // Cancelled or unchanged
+        }
+
+        const update = newContent.trim() === ''
+            ? await fetch(`/api/chats/${chatId}/summary`, { method: 'DELETE' })
+            : await fetch(`/api/chats/${chatId}/summary`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ content: newContent })
+            });
+        if (!update.ok) {
+            throw new Error(`HTTP error ${update.status}`);
+        }
+        ui.showNotification(newContent.trim() === '' ? 'Chat summary removed' : 'Chat summary saved');
+    } catch (error) {
+        console.error('Error editing chat summary:', error);
+        ui.showNotification(`Error editing chat summary: ${error.message}`, 'error');
+    }
+};
+
 // Delete a chat (Trigger confirmation UI)
 api.deleteChat = function(chatId, chatTitle) {
     if (!chatId) return;
@@ -6,7 +6,13 @@ let currentChatId = null;
 let modelsList = []; // Populated by api.js, Used by ui.js, api.js, chat.js
 let chatsList = [];  // Populated by api.js, Used by api.js
 let activeModel = null; // Updated by api.js, chat.js, Used by api.js, ui.js
+let compareModels = []; // Extra models answering side by side with the active one (chat.js, api.js, ui.js)
 let currentUser = null; // Populated by api.js, Used by ui.js
+let currentChatRole = null; // Caller's role in the current chat if it was shared with them ('viewer' or 'contributor')
+let chatParticipants = {}; // User ID -> username for the current chat, if shared (api.js, ui.js)
+let chatFolders = []; // The user's chat folders (api.js, ui.js)
+let chatProjects = []; // The user's projects and those shared with them (api.js, ui.js)
+let chatListFilter = { folderId: '', projectId: '', tag: '' }; // Folder, project ('none' for neither) and tag the chat list is filtered by (api.js, ui.js)
 let isInsideThinkBlock = false; // WebSocket message handling state (websocket.js)
 
 // --- DOM Element References ---
@@ -20,6 +26,20 @@ const chatsListContainer = document.getElementById('chats-list');
 const newChatButton = document.getElementById('new-chat-button');
 const chatTitle = document.getElementById('chat-title');
 const regenerateButton = document.getElementById('regenerate-button');
+const summaryButton = document.getElementById('summary-button');
+const exportButton = document.getElementById('export-button');
+const shareButton = document.getElementById('share-button');
+const participantsButton = document.getElementById('participants-button');
+const organizeButton = document.getElementById('organize-button');
+const projectButton = document.getElementById('project-button');
+const chatFolderFilter = document.getElementById('chat-folder-filter');
+const chatProjectFilter = document.getElementById('chat-project-filter');
+const chatTagFilter = document.getElementById('chat-tag-filter');
+const exportChatsButton = document.getElementById('export-chats-button');
+const importChatsButton = document.getElementById('import-chats-button');
+const importChatsInput = document.getElementById('import-chats-input');
+const chatSearchInput = document.getElementById('chat-search-input');
+const chatSearchResults = document.getElementById('chat-search-results');
 const userNameElement = document.querySelector('.user-name');
 const userRoleElement = document.querySelector('.user-role');
 const userAvatarElement = document.querySelector('.user-avatar');
@@ -37,6 +57,7 @@ chat.selectModel = function(modelId) {
     ui.showThinkingIndicator(true); // Show indicator immediately
 
     activeModel = modelId; // Update global state
+    compareModels = compareModels.filter(id => id !== modelId);
     localStorage.setItem('activeModelId', modelId); // Persist selection
 
     // Update UI
@@ -55,6 +76,95 @@ chat.selectModel = function(modelId) {
     setTimeout(() => ui.showThinkingIndicator(false), 500); // Hide after 500ms
 }
 
+/**
+ * Adds a model to (or removes it from) the models that answer side by side
+ * with the active model.
+ * @param {number} modelId - The ID of the model to toggle.
+ */
+chat.toggleCompareModel = function(modelId) {
+    if (modelId === activeModel) return; // Always answers
+
+    const index = compareModels.indexOf(modelId);
+    if (index >= 0) {
+        compareModels.splice(index, 1);
+    } else if (compareModels.length >= 3) {
+        ui.showNotification('At most 4 models can be compared at once.', 'error');
+        return;
+    } else {
+        compareModels.push(modelId);
+    }
+    ui.updateActiveModelUI();
+
+    const names = [activeModel, ...compareModels].map(id => modelsList.find(m => m.id == id)?.name || id);
+    ui.showNotification(compareModels.length > 0 ? `Comparing: ${names.join(', ')}` : 'Comparison off', 'info');
+}
+
+/**
+ * Applies chat list changes pushed by the server (chat_list WebSocket
+ * messages): chats created, renamed or titled in another tab or by their
+ * owner, and chats deleted. Chats not in the current list are fetched again,
+ * so the sidebar filters still apply.
+ */
+chat.updateChatsList = function(updatedChats, removedChatIds = []) {
+    let unknownChat = false;
+    updatedChats.forEach(updated => {
+        const existing = chatsList.find(c => c.id === updated.id);
+        if (!existing) {
+            unknownChat = true;
+            return;
+        }
+        existing.title = updated.title;
+        existing.updated_at = updated.updated_at;
+        if (updated.id === currentChatId && chatTitle) {
+            chatTitle.textContent = updated.title;
+        }
+    });
+
+    if (removedChatIds.length > 0) {
+        chatsList = chatsList.filter(c => !removedChatIds.includes(c.id));
+        if (removedChatIds.includes(currentChatId)) {
+            ui.showNotification('This chat was deleted.', 'info');
+            api.prepareNewChat();
+        }
+    }
+
+    if (unknownChat) {
+        api.fetchChats();
+    }
+}
+
+/**
+ * Returns the chats shown in the sidebar.
+ */
+chat.getChatsList = function() {
+    return chatsList;
+}
+
+/**
+ * Replaces the models the user can use with those pushed by the server
+ * (model_list WebSocket messages), e.g. after an admin activated a model.
+ * Selected models that are gone are dropped.
+ */
+chat.updateModelsList = function(updatedModels) {
+    modelsList = updatedModels;
+    compareModels = compareModels.filter(id => modelsList.some(m => m.id === id));
+    if (!modelsList.some(m => m.id === activeModel)) {
+        activeModel = modelsList.length > 0 ? modelsList[0].id : null;
+        if (activeModel) {
+            localStorage.setItem('activeModelId', activeModel);
+            ui.showNotification(`Your model is no longer available. Switched to: ${modelsList[0].name}`, 'info');
+        }
+    }
+    ui.updateActiveModelUI();
+}
+
+/**
+ * Returns the models the user can use.
+ */
+chat.getModelsList = function() {
+    return modelsList;
+}
+
 /**
  * Initiates the process for starting a new chat.
  */
@@ -84,6 +194,80 @@ chat.initChat = function() {
     if (regenerateButton) {
         regenerateButton.addEventListener('click', api.regenerateLastMessage);
     }
+    if (summaryButton) {
+        summaryButton.addEventListener('click', () => api.editChatSummary(currentChatId));
+    }
+    if (exportButton) {
+        exportButton.addEventListener('click', () => api.exportChat(currentChatId));
+    }
+    if (organizeButton) {
+        organizeButton.addEventListener('click', () => api.organizeChat(currentChatId));
+    }
+    if (chatFolderFilter) {
+        chatFolderFilter.addEventListener('change', function() {
+            if (this.value === 'new') {
+                this.value = chatListFilter.folderId;
+                api.createFolder();
+                return;
+            }
+            chatListFilter.folderId = this.value;
+            api.fetchChats();
+        });
+    }
+    if (projectButton) {
+        projectButton.addEventListener('click', () => api.moveChatToProject(currentChatId));
+    }
+    if (chatProjectFilter) {
+        chatProjectFilter.addEventListener('change', function() {
+            if (this.value === 'new') {
+                this.value = chatListFilter.projectId;
+                api.createProject();
+                return;
+            }
+            if (this.value === 'file') {
+                this.value = chatListFilter.projectId;
+                api.addProjectFile(chatListFilter.projectId);
+                return;
+            }
+            chatListFilter.projectId = this.value;
+            api.fetchChats();
+        });
+    }
+    if (chatTagFilter) {
+        chatTagFilter.addEventListener('click', () => ui.filterChatsByTag(''));
+    }
+    if (participantsButton) {
+        participantsButton.addEventListener('click', () => api.manageParticipants(currentChatId));
+    }
+    if (shareButton) {
+        shareButton.addEventListener('click', () => api.shareChat(currentChatId));
+    }
+    if (exportChatsButton) {
+        exportChatsButton.addEventListener('click', api.exportAllChats);
+    }
+    if (importChatsButton && importChatsInput) {
+        importChatsButton.addEventListener('click', () => importChatsInput.click());
+        importChatsInput.addEventListener('change', function() {
+            if (this.files.length > 0) {
+                api.importChats(this.files[0]);
+            }
+            this.value = ''; // Allow importing the same file again
+        });
+    }
+    if (chatSearchInput) {
+        // Search once typing pauses; an empty box shows the chat list again
+        let searchTimer = null;
+        chatSearchInput.addEventListener('input', function() {
+            clearTimeout(searchTimer);
+            searchTimer = setTimeout(() => api.searchChats(this.value), 300);
+        });
+        chatSearchInput.addEventListener('keyup', function(event) {
+            if (event.key === 'Escape') {
+                this.value = '';
+                api.searchChats('');
+            }
+        });
+    }
     if (chatTitle) {
         chatTitle.addEventListener('dblclick', function() {
             const currentTitle = this.textContent;
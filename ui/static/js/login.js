@@ -5,6 +5,173 @@ document.addEventListener('DOMContentLoaded', () => {
     const passwordInput = document.getElementById('password');
     const submitButton = loginForm.querySelector('button[type="submit"]');
 
+    function showError(text) {
+        errorMessage.textContent = text;
+        errorMessage.style.display = 'block';
+        errorMessage.style.animation = 'none'; // Reset animation
+        void errorMessage.offsetWidth; // Trigger reflow
+        errorMessage.style.animation = 'shake 0.5s';
+    }
+
+    // Errors from single sign-on come back in the query string
+    const ssoError = new URLSearchParams(window.location.search).get('error');
+    if (ssoError) {
+        showError(ssoError);
+    }
+
+    // Offer single sign-on if configured; when password login is restricted to
+    // the break-glass admin, hide the password form behind a link
+    let ssoConfigured = false;
+    fetch('/auth/config')
+        .then(response => response.ok ? response.json() : null)
+        .then(config => {
+            if (!config) return;
+            ssoConfigured = Boolean(config.sso);
+            if (twoFactorStep) return; // Already on the second step
+            if (config.sso) {
+                const ssoButton = document.getElementById('sso-login-btn');
+                ssoButton.href = config.sso.login_url;
+                ssoButton.textContent = `Sign in with ${config.sso.name}`;
+                document.getElementById('sso-login').style.display = 'block';
+            }
+            if (!config.password_login) {
+                const toggle = document.getElementById('password-login-toggle');
+                loginForm.style.display = 'none';
+                toggle.style.display = 'block';
+                toggle.addEventListener('click', () => {
+                    loginForm.style.display = 'block';
+                    toggle.style.display = 'none';
+                    usernameInput.focus();
+                });
+            }
+        })
+        .catch(error => console.warn('Could not load login options:', error));
+
+    // --- Two-factor authentication ---
+    const twoFactorForm = document.getElementById('two-factor-form');
+    const twoFactorCode = document.getElementById('two-factor-code');
+    const twoFactorCodeLabel = document.getElementById('two-factor-code-label');
+    const twoFactorError = document.getElementById('two-factor-error');
+    const recoveryToggle = document.getElementById('recovery-code-toggle');
+    const recoveryCodesStep = document.getElementById('recovery-codes-step');
+    let twoFactorStep = null; // 'verify' or 'enroll'
+    let useRecoveryCode = false;
+
+    function showTwoFactorError(text) {
+        twoFactorError.textContent = text;
+        twoFactorError.style.display = 'block';
+        twoFactorError.style.animation = 'none';
+        void twoFactorError.offsetWidth;
+        twoFactorError.style.animation = 'shake 0.5s';
+    }
+
+    // Back to the password form, e.g. when the pending login expired
+    function restartLogin(text) {
+        twoFactorForm.style.display = 'none';
+        loginForm.style.display = 'block';
+        if (ssoConfigured) {
+            document.getElementById('sso-login').style.display = 'block';
+        }
+        submitButton.disabled = false;
+        submitButton.textContent = 'Login';
+        passwordInput.value = '';
+        showError(text);
+    }
+
+    async function startTwoFactor(step) {
+        twoFactorStep = step;
+        loginForm.style.display = 'none';
+        document.getElementById('sso-login').style.display = 'none';
+        document.getElementById('password-login-toggle').style.display = 'none';
+        twoFactorForm.style.display = 'block';
+        recoveryToggle.style.display = step === 'verify' ? 'block' : 'none';
+
+        if (step === 'enroll') {
+            const response = await fetch('/auth/2fa/enroll', { method: 'POST' });
+            if (!response.ok) {
+                restartLogin((await response.text()).trim() || 'Could not start two-factor enrollment.');
+                return;
+            }
+            const enrollment = await response.json();
+            const qrContainer = document.getElementById('two-factor-qr');
+            if (window.qrcode) {
+                const qr = qrcode(0, 'M');
+                qr.addData(enrollment.otpauth_uri);
+                qr.make();
+                qrContainer.innerHTML = qr.createImgTag(4);
+            }
+            document.getElementById('two-factor-secret').textContent = enrollment.secret;
+            document.getElementById('two-factor-enroll').style.display = 'block';
+        }
+        twoFactorCode.focus();
+    }
+
+    // Single sign-on sends users who need a second factor back here
+    const ssoTwoFactor = new URLSearchParams(window.location.search).get('two_factor');
+    if (ssoTwoFactor === 'verify' || ssoTwoFactor === 'enroll') {
+        history.replaceState(null, '', '/login');
+        startTwoFactor(ssoTwoFactor);
+    }
+
+    recoveryToggle.addEventListener('click', () => {
+        useRecoveryCode = !useRecoveryCode;
+        twoFactorCodeLabel.textContent = useRecoveryCode ? 'Recovery code:' : 'Authentication code:';
+        twoFactorCode.inputMode = useRecoveryCode ? 'text' : 'numeric';
+        recoveryToggle.textContent = useRecoveryCode ? 'Use an authentication code' : 'Use a recovery code';
+        twoFactorCode.value = '';
+        twoFactorCode.focus();
+    });
+
+    twoFactorForm.addEventListener('submit', async (e) => {
+        e.preventDefault();
+        twoFactorError.style.display = 'none';
+        const code = twoFactorCode.value.trim();
+        const url = twoFactorStep === 'enroll' ? '/auth/2fa/enroll/confirm' : '/auth/2fa/verify';
+        const body = useRecoveryCode ? { recovery_code: code } : { code };
+        try {
+            const response = await fetch(url, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(body),
+            });
+            const text = await response.text();
+            if (!response.ok) {
+                twoFactorCode.value = '';
+                if (text.includes('log in again')) {
+                    restartLogin(text.replace(/^Unauthorized: /, '').trim());
+                } else {
+                    showTwoFactorError(text.trim() || 'Verification failed.');
+                    twoFactorCode.focus();
+                }
+                return;
+            }
+            const data = JSON.parse(text);
+            if (data.recovery_codes) {
+                // Just enrolled: show the recovery codes once before going on
+                twoFactorForm.style.display = 'none';
+                const list = document.getElementById('recovery-codes');
+                list.replaceChildren(...data.recovery_codes.map(c => {
+                    const el = document.createElement('span');
+                    el.textContent = c;
+                    return el;
+                }));
+                recoveryCodesStep.style.display = 'block';
+                return;
+            }
+            if (data.recovery_codes_remaining !== undefined) {
+                alert(`You have ${data.recovery_codes_remaining} recovery codes left. You can get new ones in your security settings.`);
+            }
+            window.location.href = '/';
+        } catch (error) {
+            console.error('Two-factor request error:', error);
+            showTwoFactorError('An error occurred, please try again.');
+        }
+    });
+
+    document.getElementById('recovery-codes-done').addEventListener('click', () => {
+        window.location.href = '/';
+    });
+
     if (loginForm) {
         loginForm.addEventListener('submit', async (e) => {
             e.preventDefault(); // Prevent default form submission
@@ -25,6 +192,12 @@ document.addEventListener('DOMContentLoaded', () => {
                 });
 
                 if (response.ok) {
+                    const data = await response.json().catch(() => ({}));
+                    if (data.two_factor) {
+                        // The password was right, but a second step is needed
+                        startTwoFactor(data.two_factor);
+                        return;
+                    }
                     // Login successful, redirect to the main application page
                     console.log('Login successful, redirecting...');
                     window.location.href = '/'; // Redirect to root
@@ -40,6 +213,9 @@ document.addEventListener('DOMContentLoaded', () => {
                             // Use default error if response body is not JSON or empty
                             console.warn('Could not parse error response:', jsonError);
                         }
+                    } else if (response.status === 403) {
+                        // Password login is disabled for this user
+                        errorText = (await response.text()).trim() || errorText;
                     } else {
                          errorText = `Login failed (Status: ${response.status})`;
                     }
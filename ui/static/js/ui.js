@@ -52,8 +52,20 @@ ui.renderModelsList = function(models) {
             modelItem.classList.add('active');
         }
 
+        if (compareModels.includes(model.id)) {
+            modelItem.classList.add('compare');
+        }
+
         // Add click handler (calls function assumed to be in chat.js)
-        modelItem.addEventListener('click', () => chat.selectModel(model.id));
+        // Ctrl/Cmd+click adds the model to a side-by-side comparison
+        modelItem.title = 'Ctrl+click to compare side by side';
+        modelItem.addEventListener('click', (e) => {
+            if (e.ctrlKey || e.metaKey) {
+                chat.toggleCompareModel(model.id);
+            } else {
+                chat.selectModel(model.id);
+            }
+        });
 
         // Add to container
         modelsListContainer.appendChild(modelItem);
@@ -96,6 +108,7 @@ ui.updateActiveModelUI = function() {
         } else {
             item.classList.remove('active');
         }
+        item.classList.toggle('compare', compareModels.includes(parseInt(item.dataset.modelId, 10)));
     });
     ui.updateActiveModelIndicator(); // Update any header indicator too
 }
@@ -134,13 +147,52 @@ ui.renderChatsList = function(chats) {
         titleWrapper.addEventListener('click', () => loadChat(chat.id));
         chatItem.appendChild(titleWrapper);
 
+        // Chats shared with us are marked, and can be left but not deleted
+        if (chat.role) {
+            chatItem.classList.add('shared-chat');
+            titleWrapper.title = `Shared with you (${chat.role})`;
+        }
+
+        // Tags filter the list when clicked
+        (chat.tags || []).forEach(tag => {
+            const tagChip = document.createElement('span');
+            tagChip.classList.add('chat-tag');
+            tagChip.textContent = tag;
+            tagChip.title = `Show chats tagged "${tag}"`;
+            tagChip.addEventListener('click', (e) => {
+                e.stopPropagation();
+                ui.filterChatsByTag(tag);
+            });
+            chatItem.appendChild(tagChip);
+        });
+
+        // Add pin button
+        const pinBtn = document.createElement('span');
+        pinBtn.classList.add('chat-pin-btn');
+        pinBtn.innerHTML = '&#9650;'; // ▲ symbol
+        pinBtn.title = chat.is_pinned ? 'Unpin chat' : 'Pin chat to the top';
+        pinBtn.addEventListener('click', (e) => {
+            e.stopPropagation();
+            api.togglePin(chat);
+        });
+        chatItem.appendChild(pinBtn);
+        if (chat.is_pinned) {
+            chatItem.classList.add('pinned');
+        }
+
         // Add delete button
         const deleteBtn = document.createElement('span');
         deleteBtn.classList.add('chat-delete-btn');
         deleteBtn.innerHTML = '&times;'; // × symbol
-        deleteBtn.title = 'Delete chat';
+        deleteBtn.title = chat.role ? 'Leave chat' : 'Delete chat';
         deleteBtn.addEventListener('click', (e) => {
             e.stopPropagation(); // Prevent triggering chat selection
+            if (chat.role) {
+                if (confirm(`Leave the shared chat "${chat.title || 'Untitled Chat'}"?`)) {
+                    api.leaveChat(chat.id);
+                }
+                return;
+            }
             // Calls function assumed to be in api.js or chat.js
             ui.showConfirmationDialog(
                 'Delete Chat?',
@@ -155,8 +207,120 @@ ui.renderChatsList = function(chats) {
             chatItem.classList.add('active');
         }
 
-        // Add to container right after the "New Chat" button
-        chatsListContainer.insertBefore(chatItem, newChatBtn.nextSibling);
+        // Add to container in the order the server sorted them (pinned first)
+        chatsListContainer.appendChild(chatItem);
+    });
+}
+
+// Show the user's folders and projects and the current tag in the chat list filters
+ui.renderChatFilters = function() {
+    if (chatFolderFilter) {
+        chatFolderFilter.innerHTML = '';
+        const options = [
+            { value: '', label: 'All chats' },
+            { value: 'none', label: 'Not in a folder' },
+            ...chatFolders.map(f => ({ value: String(f.id), label: `${f.name} (${f.chats})` })),
+            { value: 'new', label: '+ New folder...' }
+        ];
+        options.forEach(({ value, label }) => {
+            const option = document.createElement('option');
+            option.value = value;
+            option.textContent = label;
+            chatFolderFilter.appendChild(option);
+        });
+        chatFolderFilter.value = chatListFilter.folderId;
+    }
+    if (chatProjectFilter) {
+        chatProjectFilter.innerHTML = '';
+        const selected = chatProjects.find(p => String(p.id) === chatListFilter.projectId);
+        const options = [
+            { value: '', label: 'All projects' },
+            { value: 'none', label: 'Not in a project' },
+            ...chatProjects.map(p => ({
+                value: String(p.id),
+                label: `${p.name}${p.is_shared && currentUser && p.user_id !== currentUser.id ? ` (${p.username})` : ''} (${p.chats})`
+            })),
+            { value: 'new', label: '+ New project...' }
+        ];
+        // Owners can attach files to the project they are looking at
+        if (selected && currentUser && selected.user_id === currentUser.id) {
+            options.push({ value: 'file', label: '+ Attach file to project...' });
+        }
+        options.forEach(({ value, label }) => {
+            const option = document.createElement('option');
+            option.value = value;
+            option.textContent = label;
+            chatProjectFilter.appendChild(option);
+        });
+        chatProjectFilter.value = chatListFilter.projectId;
+    }
+    if (chatTagFilter) {
+        chatTagFilter.textContent = chatListFilter.tag ? `${chatListFilter.tag} \u00d7` : '';
+        chatTagFilter.style.display = chatListFilter.tag ? '' : 'none';
+    }
+}
+
+// Filter the chat list by a tag, or show all tags again when tag is empty
+ui.filterChatsByTag = function(tag) {
+    chatListFilter.tag = tag;
+    api.fetchChats();
+}
+
+// Show chat search results in place of the chat list, or the chat list
+// again when results is null. Titles and snippets arrive HTML-escaped from
+// the server with <mark> around the matched words.
+ui.renderSearchResults = function(results) {
+    if (!chatSearchResults || !chatsListContainer) return;
+
+    chatSearchResults.innerHTML = '';
+    if (results === null) {
+        chatSearchResults.style.display = 'none';
+        chatsListContainer.style.display = '';
+        return;
+    }
+    chatSearchResults.style.display = '';
+    chatsListContainer.style.display = 'none';
+
+    if (results.length === 0) {
+        const empty = document.createElement('div');
+        empty.classList.add('search-result');
+        empty.textContent = 'No matching chats';
+        chatSearchResults.appendChild(empty);
+        return;
+    }
+
+    results.forEach(result => {
+        const item = document.createElement('div');
+        item.classList.add('search-result');
+
+        const title = document.createElement('div');
+        title.classList.add('search-result-title');
+        if (result.title_highlight) {
+            title.innerHTML = result.title_highlight;
+        } else {
+            title.textContent = result.title || 'Untitled Chat';
+        }
+        title.addEventListener('click', () => api.openSearchResult(result.chat_id, null));
+        item.appendChild(title);
+
+        (result.matches || []).forEach(match => {
+            const matchElement = document.createElement('div');
+            matchElement.classList.add('search-result-match');
+
+            const role = document.createElement('span');
+            role.classList.add('search-result-role');
+            role.textContent = match.role === 'user' ? 'You:' : 'AI:';
+            matchElement.appendChild(role);
+
+            const snippet = document.createElement('span');
+            snippet.innerHTML = match.snippet;
+            matchElement.appendChild(snippet);
+
+            matchElement.addEventListener('click', () => api.openSearchResult(result.chat_id, match.message_id));
+            item.appendChild(matchElement);
+        });
+
+        chatSearchResults.appendChild(item);
     });
 }
 
@@ -268,6 +432,14 @@ ui.createMessageElement = function(type, message_id, model_id = null) {
             });
         };
         footerElement.appendChild(copyMdButton);
+
+        // Regenerate this response as a new version
+        const regenerateButton = document.createElement('button');
+        regenerateButton.classList.add('regenerate-message-btn', 'action-btn');
+        regenerateButton.title = 'Regenerate this response';
+        regenerateButton.innerHTML = '<svg xmlns="http://www.w3.org/2000/svg" width="12" height="12" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><polyline points="23 4 23 10 17 10"></polyline><path d="M20.49 15a9 9 0 1 1-2.12-9.36L23 10"></path></svg>';
+        regenerateButton.onclick = () => api.regenerateMessage(ui.messageIdFromElement(messageWrapper));
+        footerElement.appendChild(regenerateButton);
     }
     // Add elements specific to user messages
     else if (type === 'user') {
@@ -296,6 +468,14 @@ ui.createMessageElement = function(type, message_id, model_id = null) {
             });
         };
         footerElement.appendChild(copyPromptButton);
+
+        // Edit the prompt, branching the conversation from this point
+        const editButton = document.createElement('button');
+        editButton.classList.add('edit-message-btn', 'action-btn');
+        editButton.title = 'Edit prompt';
+        editButton.innerHTML = '<svg xmlns="http://www.w3.org/2000/svg" width="12" height="12" viewBox="0 0 24 24" fill="none" stroke="currentColor" stroke-width="2" stroke-linecap="round" stroke-linejoin="round"><path d="M12 20h9"></path><path d="M16.5 3.5a2.121 2.121 0 0 1 3 3L7 19l-4 1 1-4L16.5 3.5z"></path></svg>';
+        editButton.onclick = () => api.editMessage(ui.messageIdFromElement(messageWrapper), messageWrapper.dataset.rawContent || '');
+        footerElement.appendChild(editButton);
     }
 
     // Add footer to message wrapper
@@ -304,6 +484,76 @@ ui.createMessageElement = function(type, message_id, model_id = null) {
     return messageWrapper;
 }
 
+// Get the server message ID of a message element (null for unsaved messages)
+ui.messageIdFromElement = function(messageWrapper) {
+    const id = parseInt((messageWrapper.id || '').replace('message-', ''), 10);
+    return isNaN(id) ? null : id;
+}
+
+// Show "< i/n >" controls for switching between alternative versions of a message
+ui.renderSiblingNav = function(messageWrapper, message) {
+    const footerElement = messageWrapper.querySelector('.message-footer');
+    if (!footerElement) return;
+    footerElement.querySelector('.sibling-nav')?.remove();
+
+    const siblings = message.sibling_ids || [];
+    const index = siblings.indexOf(message.id);
+    if (siblings.length < 2 || index < 0) return;
+    if (messageWrapper.closest('.compare-group')) return; // Alternatives are shown side by side
+
+    const nav = document.createElement('span');
+    nav.classList.add('sibling-nav');
+
+    const prevButton = document.createElement('button');
+    prevButton.classList.add('action-btn');
+    prevButton.title = 'Previous version';
+    prevButton.textContent = '\u2039';
+    prevButton.disabled = index === 0;
+    prevButton.onclick = () => api.switchMessageVersion(siblings[index - 1]);
+
+    const position = document.createElement('span');
+    position.textContent = `${index + 1}/${siblings.length}`;
+    position.title = `Version ${index + 1} of ${siblings.length}`;
+
+    const nextButton = document.createElement('button');
+    nextButton.classList.add('action-btn');
+    nextButton.title = 'Next version';
+    nextButton.textContent = '\u203a';
+    nextButton.disabled = index === siblings.length - 1;
+    nextButton.onclick = () => api.switchMessageVersion(siblings[index + 1]);
+
+    nav.append(prevButton, position, nextButton);
+    footerElement.insertBefore(nav, footerElement.firstChild);
+}
+
+// Start a row of side-by-side responses to a message (one lane per model)
+ui.createCompareGroup = function(parentId) {
+    if (!chatHistory || document.getElementById(`compare-${parentId}`)) return;
+    const group = document.createElement('div');
+    group.classList.add('compare-group');
+    group.id = `compare-${parentId}`;
+    chatHistory.appendChild(group);
+}
+
+// Add a streamed bot message to the chat history, in its lane if it is one of
+// several responses being compared
+ui.placeBotMessage = function(messageElement, parentId) {
+    const group = parentId ? document.getElementById(`compare-${parentId}`) : null;
+    if (!group) {
+        if (chatHistory) chatHistory.appendChild(messageElement);
+        return;
+    }
+    group.appendChild(messageElement);
+
+    // Let the user pick which reply the conversation continues from
+    const chooseButton = document.createElement('button');
+    chooseButton.classList.add('choose-reply-btn', 'action-btn');
+    chooseButton.title = 'Continue the conversation with this reply';
+    chooseButton.textContent = 'Continue with this';
+    chooseButton.onclick = () => api.switchMessageVersion(ui.messageIdFromElement(messageElement));
+    messageElement.querySelector('.message-footer')?.appendChild(chooseButton);
+}
+
 // Render a single message object into the chat history
 ui.renderMessage = function(message) {
     // Find or create the message element
@@ -324,6 +574,19 @@ ui.renderMessage = function(message) {
     // Set the raw content attribute, which the copy button will use
     messageWrapper.dataset.rawContent = message.content || '';
 
+    // Render stored reasoning in a collapsed thinking box
+    if (message.role === 'assistant' && message.reasoning) {
+        const thinkingContentEl = ui.ensureThinkingBoxExists(messageWrapper);
+        const thinkingElement = messageWrapper.querySelector('.thinking-content');
+        try {
+            thinkingContentEl.innerHTML = marked.parse(message.reasoning);
+        } catch (error) {
+            console.error('Error parsing thinking markdown:', error);
+            thinkingContentEl.textContent = message.reasoning;
+        }
+        thinkingElement.classList.add('collapsed');
+    }
+
     // Update content using marked
     if (contentElement) {
         try {
@@ -356,6 +619,11 @@ ui.renderMessage = function(message) {
          }
 
 
+        // In shared chats, name the author of other participants' messages
+        if (message.role === 'user' && message.user_id && message.user_id !== currentUser?.id) {
+            timestampElement.appendChild(document.createTextNode(` - ${chatParticipants[message.user_id] || 'User ' + message.user_id}`));
+        }
+
         // If it's a bot message and has a model_id, add/update the model info
         if (message.role === 'assistant' && message.model_id) {
              timestampElement.appendChild(document.createTextNode(' - ')); // Add separator
@@ -374,6 +642,8 @@ ui.renderMessage = function(message) {
         }
     }
 
+    ui.renderSiblingNav(messageWrapper, message);
+
     // Apply syntax highlighting to code blocks within the newly rendered content
     contentElement.querySelectorAll('pre code').forEach((block) => {
          try {
@@ -500,6 +770,9 @@ ui.ensureThinkingBoxExists = function(messageElement) {
         const thinkingLabel = document.createElement('div');
         thinkingLabel.classList.add('thinking-label');
         thinkingLabel.innerHTML = '<span class="thinking-icon">⚙️</span> AI Thinking Process';
+        thinkingLabel.title = 'Show/hide reasoning';
+        // Clicking the label collapses or expands the reasoning
+        thinkingLabel.onclick = () => thinkingElement.classList.toggle('collapsed');
         thinkingElement.appendChild(thinkingLabel);
         const thinkingContentEl = document.createElement('div');
         thinkingContentEl.classList.add('thinking-content-text');
@@ -646,6 +919,80 @@ ui.showConfirmationDialog = function(title, message, onConfirm) {
     });
 }
 
+/**
+ * Shows the QR code and key of a two-factor enrollment and asks for a code
+ * from the authenticator app.
+ * @param {{secret: string, otpauth_uri: string}} enrollment - From POST /api/user/me/2fa/enroll.
+ * @returns {Promise<string|null>} The code, or null if cancelled.
+ */
+ui.showTwoFactorEnrollment = function(enrollment) {
+    return new Promise(resolve => {
+        const dialog = document.createElement('div');
+        dialog.classList.add('delete-confirmation');
+        const content = document.createElement('div');
+        content.classList.add('delete-confirmation-content');
+
+        const title = document.createElement('div');
+        title.classList.add('delete-title');
+        title.textContent = 'Set up two-factor authentication';
+        const message = document.createElement('div');
+        message.classList.add('delete-message');
+        message.textContent = 'Scan this code with an authenticator app, or enter the key by hand, then type the code it shows:';
+
+        const qrContainer = document.createElement('div');
+        qrContainer.style.textAlign = 'center';
+        if (window.qrcode) {
+            const qr = qrcode(0, 'M');
+            qr.addData(enrollment.otpauth_uri);
+            qr.make();
+            qrContainer.innerHTML = qr.createImgTag(4);
+            qrContainer.firstChild.style.background = '#fff';
+            qrContainer.firstChild.style.padding = '8px';
+        }
+        const secret = document.createElement('div');
+        secret.classList.add('delete-message');
+        secret.style.fontFamily = "'Courier New', monospace";
+        secret.style.wordBreak = 'break-all';
+        secret.textContent = enrollment.secret;
+
+        const input = document.createElement('input');
+        input.type = 'text';
+        input.inputMode = 'numeric';
+        input.autocomplete = 'one-time-code';
+        input.placeholder = '123456';
+        input.classList.add('chat-search-input');
+        input.style.marginBottom = '15px';
+
+        const close = (value) => {
+            dialog.classList.remove('visible');
+            setTimeout(() => dialog.remove(), 300);
+            resolve(value);
+        };
+        const actions = document.createElement('div');
+        actions.classList.add('delete-actions');
+        const cancelBtn = document.createElement('button');
+        cancelBtn.classList.add('cancel-btn');
+        cancelBtn.textContent = 'Cancel';
+        cancelBtn.onclick = () => close(null);
+        const confirmBtn = document.createElement('button');
+        confirmBtn.classList.add('delete-btn');
+        confirmBtn.textContent = 'Enable';
+        confirmBtn.onclick = () => close(input.value.trim());
+        input.addEventListener('keydown', e => {
+            if (e.key === 'Enter') close(input.value.trim());
+        });
+
+        actions.append(cancelBtn, confirmBtn);
+        content.append(title, message, qrContainer, secret, input, actions);
+        dialog.appendChild(content);
+        document.body.appendChild(dialog);
+        requestAnimationFrame(() => requestAnimationFrame(() => {
+            dialog.classList.add('visible');
+            input.focus();
+        }));
+    });
+};
+
 // Remove the old function (or keep as a wrapper if preferred)
 /*
 function showDeleteConfirmation(chatId, chatTitle) {
@@ -859,6 +1206,9 @@ window.addEventListener('resize', ui.initMobileToggles);
 
 ui.setupEventListeners = function() {
     const logoutButton = document.getElementById('logout-button');
+    const personalizeButton = document.getElementById('personalize-button');
+    const securityButton = document.getElementById('security-button');
+    const sessionsButton = document.getElementById('sessions-button');
     const purgeChatsButton = document.getElementById('purge-chats-button');
     const newChatButton = document.getElementById('new-chat-button');
 
@@ -886,6 +1236,18 @@ ui.setupEventListeners = function() {
         });
     }
 
+    if (personalizeButton) {
+        personalizeButton.addEventListener('click', api.editPersonalization);
+    }
+
+    if (securityButton) {
+        securityButton.addEventListener('click', api.editTwoFactor);
+    }
+
+    if (sessionsButton) {
+        sessionsButton.addEventListener('click', api.manageSessions);
+    }
+
     if (purgeChatsButton) {
         purgeChatsButton.addEventListener('click', () => {
              ui.showConfirmationDialog(
@@ -27,6 +27,9 @@ let ws = null;
 // chat.getChatsList();
 // chat.getModelsList();
 
+// Chat this client receives live updates of (see websocket.watchChat)
+let watchedChatId = null;
+
 // Connect to WebSocket server
 websocket.connect = function() {
     const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
@@ -43,10 +46,16 @@ websocket.connect = function() {
         ws.onopen = function() {
             console.log('Connected to server');
             console.log("[System WS] Connection established. CyberAI terminal ready.");
+            // Subscriptions do not survive reconnects
+            if (watchedChatId) {
+                websocket.send({ type: 'subscribe', chat_id: watchedChatId });
+            }
             // Fetch initial data after connection
             api.fetchModels().then(() => {
                 api.fetchChats(); // fetchChats will handle loading or creating a chat
             });
+            api.fetchFolders();
+            api.fetchProjects();
         };
 
         ws.onmessage = function(event) {
@@ -81,6 +90,26 @@ websocket.connect = function() {
     }
 };
 
+// Send a control message to the server, if connected
+websocket.send = function(message) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify(message));
+    }
+};
+
+// Follow a chat live, so messages and responses from other participants of a
+// shared chat show up as they happen. Pass null to stop following.
+websocket.watchChat = function(chatId) {
+    if (chatId === watchedChatId) return;
+    if (watchedChatId) {
+        websocket.send({ type: 'unsubscribe', chat_id: watchedChatId });
+    }
+    watchedChatId = chatId;
+    if (chatId) {
+        websocket.send({ type: 'subscribe', chat_id: chatId });
+    }
+};
+
 // Handle different types of WebSocket messages
 websocket.handleWebSocketMessage = function(message) {
     console.log('WebSocket message received:', message);
@@ -96,6 +125,10 @@ websocket.handleWebSocketMessage = function(message) {
             break;
         case 'status':
             console.log('Status Update:', message.status_payload?.message);
+            // Responses from several models are about to stream side by side
+            if (message.data?.model_ids && message.data.chat_id === currentChatId) {
+                ui.createCompareGroup(message.data.message_id);
+            }
             // Optionally, update a status area in the UI or use a notification
             ui.showThinkingIndicator(true); // Show/keep indicator during status updates
             break;
@@ -108,20 +141,19 @@ websocket.handleWebSocketMessage = function(message) {
             ui.showThinkingIndicator(false); // Hide indicator on error
             break;
         case 'user_message':
-            // Update the UI for the confirmed user message (e.g., replace temp ID)
+            // Another participant posted in a shared chat; our own messages
+            // are already shown by the request that sent them
             const userMsg = message.message_payload;
-            if (userMsg) {
-                // Find the temporary message if it exists
-                const tempMsgElement = document.getElementById('message-temp-user');
-                if (tempMsgElement) {
-                    tempMsgElement.id = `message-${userMsg.id}`;
-                    // Optionally update other attributes if needed
-                } else {
-                    // If the message wasn't optimistically rendered, render it now
+            if (!userMsg) {
+                console.warn('Received user_message without payload.');
+            } else if (userMsg.chat_id === currentChatId && userMsg.user_id !== currentUser?.id) {
+                if (userMsg.sibling_ids) {
+                    // An edit replaces the original on the active branch
+                    userMsg.sibling_ids.forEach(id => document.getElementById(`message-${id}`)?.remove());
+                }
+                if (!document.getElementById(`message-${userMsg.id}`)) {
                     ui.renderMessage(userMsg);
                 }
-            } else {
-                console.warn('Received user_message confirmation without payload.');
             }
             break;
         case 'assistant_message':
@@ -151,6 +183,25 @@ websocket.handleWebSocketMessage = function(message) {
                 console.warn('Received assistant_chunk without payload.');
             }
             break;
+        case 'reasoning_chunk':
+            // Handle a chunk of the model's reasoning (shown in the thinking box)
+            if (message.chunk_payload) {
+                websocket.handleReasoningChunk(message.chunk_payload);
+            } else {
+                console.warn('Received reasoning_chunk without payload.');
+            }
+            break;
+        case 'context_truncated':
+            // Older messages were left out to fit the model's context window
+            const contextInfo = message.data?.context;
+            if (contextInfo && message.data.chat_id === currentChatId) {
+                const dropped = contextInfo.dropped_messages;
+                ui.addSystemMessage(contextInfo.over_budget
+                    ? 'This message is longer than the model\'s context window allows; the response may be incomplete.'
+                    : `${dropped} older message${dropped === 1 ? ' was' : 's were'} left out to fit the model's context window.`, 'warning');
+            }
+            ui.showThinkingIndicator(true); // Generation is still in progress
+            break;
         case 'remove_message':
             // Remove a message from the UI (e.g., during regeneration)
             const removePayload = message.remove_payload;
@@ -166,9 +217,10 @@ websocket.handleWebSocketMessage = function(message) {
             break;
         case 'chat_list':
             // Update the chat list in the sidebar
-            const chatListPayload = message.chat_list_payload;
-            if (chatListPayload) {
-                chat.updateChatsList(chatListPayload); // Call function in chat.js
+            const chatListPayload = message.chat_list_payload || [];
+            const removedChatIds = (message.data && message.data.removed_chat_ids) || [];
+            if (chatListPayload.length > 0 || removedChatIds.length > 0) {
+                chat.updateChatsList(chatListPayload, removedChatIds); // Call function in chat.js
                 ui.renderChatsList(chat.getChatsList()); // Re-render UI
             } else {
                 console.warn('Received chat_list without payload.');
@@ -176,13 +228,9 @@ websocket.handleWebSocketMessage = function(message) {
             break;
         case 'model_list':
             // Update the model list in the sidebar
-            const modelListPayload = message.model_list_payload;
-            if (modelListPayload) {
-                chat.updateModelsList(modelListPayload); // Call function in chat.js
-                ui.renderModelsList(chat.getModelsList()); // Re-render UI
-            } else {
-                console.warn('Received model_list without payload.');
-            }
+            // (no payload: no model can be used any more)
+            chat.updateModelsList(message.model_list_payload || []); // Call function in chat.js
+            ui.renderModelsList(chat.getModelsList()); // Re-render UI
             break;
         default:
             console.warn('Unhandled WebSocket message type:', message.type);
@@ -192,7 +240,7 @@ websocket.handleWebSocketMessage = function(message) {
 // Handle streaming chunks of assistant responses
 websocket.handleAssistantChunk = function(payload) {
     console.log(`[WS] handleAssistantChunk START - MsgID: ${payload.message_id}, Final: ${payload.is_final}, Content:`, JSON.stringify(payload.content));
-    const { chat_id, message_id, content, is_final, model_id } = payload;
+    const { chat_id, message_id, parent_id, content, is_final, model_id } = payload;
 
     if (currentChatId !== chat_id) {
          console.warn(`Received chunk for inactive chat ${chat_id}, current is ${currentChatId}. Ignoring.`);
@@ -214,7 +262,7 @@ websocket.handleAssistantChunk = function(payload) {
         // Use the UI function to create the element
         messageElement = ui.createMessageElement('bot', message_id, model_id);
         contentElement = messageElement.querySelector('.content');
-        if (chatHistory) chatHistory.appendChild(messageElement);
+        ui.placeBotMessage(messageElement, parent_id);
         // Initialize raw content dataset for the whole message
         messageElement.dataset.rawContent = '';
         // Initialize raw content storage for the visible part
@@ -328,6 +376,43 @@ websocket.handleAssistantChunk = function(payload) {
     }
 };
 
+// Handle streaming chunks of the model's reasoning. The server sends reasoning
+// separately from the answer, so it goes straight into the thinking box.
+websocket.handleReasoningChunk = function(payload) {
+    const { chat_id, message_id, parent_id, content, model_id } = payload;
+
+    if (currentChatId !== chat_id) {
+        return; // Ignore chunks for non-active chats
+    }
+
+    ui.showThinkingIndicator(false);
+
+    let messageElement = document.getElementById(`message-${message_id}`);
+    if (!messageElement) {
+        messageElement = ui.createMessageElement('bot', message_id, model_id);
+        messageElement.dataset.rawContent = '';
+        const contentElement = messageElement.querySelector('.content');
+        if (contentElement) { contentElement._rawContent = ''; }
+        ui.placeBotMessage(messageElement, parent_id);
+    }
+
+    const thinkingContentEl = ui.ensureThinkingBoxExists(messageElement);
+    const thinkingElement = messageElement.querySelector('.thinking-content');
+    if (!thinkingContentEl || !thinkingElement) return;
+
+    thinkingElement._rawThinkingContent = (thinkingElement._rawThinkingContent || '') + content;
+    try {
+        thinkingContentEl.innerHTML = marked.parse(thinkingElement._rawThinkingContent);
+    } catch (error) {
+        console.error('Error parsing thinking markdown:', error);
+        thinkingContentEl.textContent = thinkingElement._rawThinkingContent;
+    }
+
+    if (chatHistory && chatHistory.scrollHeight - chatHistory.scrollTop <= chatHistory.clientHeight + 150) {
+        requestAnimationFrame(() => { chatHistory.scrollTop = chatHistory.scrollHeight; });
+    }
+};
+
 // Helper function to update timestamp and handle final state actions
 // Was previously in ui.js
 websocket.updateTimestampAndFinalState = function(messageElement, contentElement, thinkingElement, is_final) {